@@ -0,0 +1,63 @@
+package carcommand
+
+import (
+	"fmt"
+)
+
+const ChannelsPerBoard = 16
+const MaxBoards = 4
+
+// BoardConfig is one PWM board, servos pick a board by its index in ServoControllerConfig.Boards
+type BoardConfig struct {
+	Driver    string
+	Address   byte
+	I2CDevice string
+	Frequency float32 //PWM frequency in Hz, 0 uses the driver default
+	Device    string  //pwmchip directory or gpiochip device for the sysfspwm and gpiochip drivers
+	Lines     []int   //gpio line offsets for the gpiochip driver, channel n drives Lines[n]
+}
+
+// Boards from the config, without any a single board is made from the top level driver settings
+func (c ServoControllerConfig) getBoards() []BoardConfig {
+	if len(c.Boards) > 0 {
+		return c.Boards
+	}
+	return []BoardConfig{{
+		Driver:    c.Driver,
+		Address:   c.Address,
+		I2CDevice: c.I2CDevice,
+		Frequency: c.Frequency,
+		Device:    c.Device,
+		Lines:     c.Lines,
+	}}
+}
+
+func validateBoards(boards []BoardConfig) error {
+	if len(boards) > MaxBoards {
+		return fmt.Errorf("too many boards (%d), max is %d", len(boards), MaxBoards)
+	}
+	type busAddress struct {
+		device  string
+		address byte
+	}
+	used := make(map[busAddress]int, len(boards))
+	for i, board := range boards {
+		if board.Frequency < 0 {
+			return fmt.Errorf("board %d frequency can't be negative", i)
+		}
+		if board.Driver == DriverGPIO {
+			if len(board.Lines) == 0 || len(board.Lines) > ChannelsPerBoard {
+				return fmt.Errorf("board %d needs 1-%d gpio lines, has %d", i, ChannelsPerBoard, len(board.Lines))
+			}
+		}
+		if board.Driver != DriverPCA9685 && board.Driver != "" {
+			continue //Only i2c boards share a bus
+		}
+		key := busAddress{device: board.I2CDevice, address: board.Address}
+		if other, found := used[key]; found {
+			return fmt.Errorf("board %d uses the same address as board %d (%s 0x%x)", i, other, board.I2CDevice, board.Address)
+		}
+		used[key] = i
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package carcommand
+
+import (
+	"testing"
+)
+
+func TestMultipleBoards(t *testing.T) {
+	esc := testServoConfig("esc", TypeESC, 0)
+	steer := testServoConfig("steer", TypeServo, 0)
+	steer.Board = 1
+	carCommand := NewCarCommand(CarCommandConfig{
+		RefreshRate: 60,
+		ServoControllerConfig: ServoControllerConfig{
+			Boards: []BoardConfig{{Driver: DriverSim}, {Driver: DriverSim}},
+		},
+		ServoConfigs: []ServoConfig{esc, steer},
+	})
+	err := carCommand.Init()
+	if err != nil {
+		t.Fatalf("failed init: %s", err)
+	}
+	board0 := carCommand.servoController.drivers[0].(*SimDriver)
+	board1 := carCommand.servoController.drivers[1].(*SimDriver)
+
+	err = carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"steer": {Value: 255}}})
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+	assertPulse(t, board0, 0, 1498)
+	assertPulse(t, board1, 0, 2000)
+
+	duplicate := testServoConfig("pan", TypeServo, 0)
+	duplicate.Board = 1
+	if carCommand.servoController.AddServo(duplicate) == nil {
+		t.Errorf("expected duplicate board channel to be rejected")
+	}
+	missingBoard := testServoConfig("tilt", TypeServo, 1)
+	missingBoard.Board = 2
+	if carCommand.servoController.AddServo(missingBoard) == nil {
+		t.Errorf("expected servo on a missing board to be rejected")
+	}
+}
+
+func TestValidateBoards(t *testing.T) {
+	tests := map[string]struct {
+		boards []BoardConfig
+		valid  bool
+	}{
+		"two_addresses": {
+			boards: []BoardConfig{{Address: 0x40, I2CDevice: "/dev/i2c-1"}, {Address: 0x41, I2CDevice: "/dev/i2c-1"}},
+			valid:  true,
+		},
+		"same_address_other_bus": {
+			boards: []BoardConfig{{Address: 0x40, I2CDevice: "/dev/i2c-1"}, {Address: 0x40, I2CDevice: "/dev/i2c-3"}},
+			valid:  true,
+		},
+		"same_address": {
+			boards: []BoardConfig{{Address: 0x40, I2CDevice: "/dev/i2c-1"}, {Address: 0x40, I2CDevice: "/dev/i2c-1"}},
+		},
+		"pwm_and_gpio": {
+			boards: []BoardConfig{{Driver: DriverSysfsPWM}, {Driver: DriverGPIO, Lines: []int{17, 27}}},
+			valid:  true,
+		},
+		"gpio_without_lines": {
+			boards: []BoardConfig{{Driver: DriverGPIO}},
+		},
+		"too_many": {
+			boards: make([]BoardConfig, MaxBoards+1),
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := validateBoards(tc.boards)
+			if tc.valid && err != nil {
+				t.Errorf("expected valid boards, got %s", err)
+			} else if !tc.valid && err == nil {
+				t.Errorf("expected invalid boards")
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package carcommand
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+const DefaultCalibrationFile = "calibration.json"
+
+// Nudging can't take the pulse past these, wider than any servo we drive
+const CalibrationMinPulse = 500
+const CalibrationMaxPulse = 2500
+
+const CalibrateStart = "start"
+const CalibrateNudge = "nudge" //Value is how many microseconds to move the pulse, negative moves it down
+const CalibrateMark = "mark"   //Point says which of min, center or max is at the current pulse
+const CalibrateSave = "save"
+const CalibrateStop = "stop"
+const CalibrateStatus = "status"
+
+const CalibrationPointMin = "min"
+const CalibrationPointCenter = "center"
+const CalibrationPointMax = "max"
+
+// Calibration is a servo's measured pulse range and center, saved ones override the env config on start
+type Calibration struct {
+	MinPulse  float32 `json:"min_pulse"`
+	MaxPulse  float32 `json:"max_pulse"`
+	MidOffset int     `json:"mid_offset"`
+}
+
+// CalibrationRequest is one step of a calibration from the server, the result is sent back on Reply
+type CalibrationRequest struct {
+	Action string                 `json:"action"`
+	Servo  string                 `json:"servo"`
+	Value  float32                `json:"value"`
+	Point  string                 `json:"point"`
+	Reply  chan CalibrationResult `json:"-"`
+}
+
+type CalibrationResult struct {
+	Status CalibrationStatus
+	Err    error
+}
+
+// CalibrationStatus is the servo being calibrated and the points marked so far, unmarked points are 0
+type CalibrationStatus struct {
+	Active      bool    `json:"active"`
+	Servo       string  `json:"servo"`
+	Pulse       float32 `json:"pulse"`
+	MinPulse    float32 `json:"min_pulse"`
+	CenterPulse float32 `json:"center_pulse"`
+	MaxPulse    float32 `json:"max_pulse"`
+}
+
+func (c Calibration) Apply(cfg ServoConfig) ServoConfig {
+	cfg.MinPulse = c.MinPulse
+	cfg.MaxPulse = c.MaxPulse
+	cfg.MidOffset = c.MidOffset
+	return cfg
+}
+
+// A missing file isn't an error, nothing has been calibrated yet
+func LoadCalibrations(path string) (map[string]Calibration, error) {
+	calibrations := make(map[string]Calibration)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return calibrations, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading calibration file - %w", err)
+	}
+	err = json.Unmarshal(data, &calibrations)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing calibration file - %w", err)
+	}
+	return calibrations, nil
+}
+
+// Writes to a temp file first so a power cut mid save doesn't lose the old calibrations
+func SaveCalibrations(path string, calibrations map[string]Calibration) error {
+	data, err := json.MarshalIndent(calibrations, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed encoding calibrations - %w", err)
+	}
+	tempPath := path + ".tmp"
+	err = os.WriteFile(tempPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed writing calibration file - %w", err)
+	}
+	err = os.Rename(tempPath, path)
+	if err != nil {
+		return fmt.Errorf("failed replacing calibration file - %w", err)
+	}
+	return nil
+}
+
+func (c *CarCommand) DoCalibration(request CalibrationRequest) CalibrationResult {
+	var err error
+	if c.estop.active && request.Action != CalibrateStatus {
+		return CalibrationResult{
+			Status: c.servoController.calibration,
+			Err:    fmt.Errorf("estop latched, calibration %s rejected", request.Action),
+		}
+	}
+	switch request.Action {
+	case CalibrateStart:
+		err = c.servoController.StartCalibration(request.Servo)
+		if err == nil {
+			c.sendEvent(EventCalibration, fmt.Sprintf("%s calibration started", request.Servo))
+		}
+	case CalibrateNudge:
+		err = c.servoController.NudgeCalibration(request.Value)
+	case CalibrateMark:
+		err = c.servoController.MarkCalibration(request.Point)
+	case CalibrateSave:
+		err = c.saveCalibration()
+	case CalibrateStop:
+		name := c.servoController.calibration.Servo
+		err = c.servoController.StopCalibration()
+		if err == nil {
+			c.sendEvent(EventCalibration, fmt.Sprintf("%s calibration stopped", name))
+		}
+	case CalibrateStatus:
+	default:
+		err = fmt.Errorf("unsupported calibration action (%s)", request.Action)
+	}
+	return CalibrationResult{
+		Status: c.servoController.calibration,
+		Err:    err,
+	}
+}
+
+// Adds the servo's calibration to the file and starts using it right away
+func (c *CarCommand) saveCalibration() error {
+	if c.config.CalibrationFile == "" {
+		return fmt.Errorf("no calibration file configured")
+	}
+	name := c.servoController.calibration.Servo
+	calibration, err := c.servoController.markedCalibration()
+	if err != nil {
+		return err
+	}
+
+	calibrations, err := LoadCalibrations(c.config.CalibrationFile)
+	if err != nil {
+		return err
+	}
+	calibrations[name] = calibration
+	err = SaveCalibrations(c.config.CalibrationFile, calibrations)
+	if err != nil {
+		return err
+	}
+
+	c.servoController.servos[name].config = calibration.Apply(c.servoController.servos[name].config)
+	c.sendEvent(EventCalibration, fmt.Sprintf("%s calibration saved", name))
+	return nil
+}
+
+// Takes the servo away from driving commands and holds it at its current center
+func (s *ServoController) StartCalibration(name string) error {
+	if s.calibration.Active && s.calibration.Servo != name {
+		return fmt.Errorf("already calibrating %s", s.calibration.Servo)
+	}
+	servo, found := s.servos[name]
+	if !found {
+		return fmt.Errorf("servo %s not found", name)
+	}
+	s.calibration = CalibrationStatus{
+		Active: true,
+		Servo:  name,
+	}
+	return s.writeCalibrationPulse(servo.centerPulse())
+}
+
+func (s *ServoController) NudgeCalibration(microseconds float32) error {
+	if !s.calibration.Active {
+		return fmt.Errorf("not calibrating")
+	}
+	return s.writeCalibrationPulse(s.calibration.Pulse + microseconds)
+}
+
+func (s *ServoController) MarkCalibration(point string) error {
+	if !s.calibration.Active {
+		return fmt.Errorf("not calibrating")
+	}
+	switch point {
+	case CalibrationPointMin:
+		s.calibration.MinPulse = s.calibration.Pulse
+	case CalibrationPointCenter:
+		if s.servos[s.calibration.Servo].config.Type == TypeESC {
+			return fmt.Errorf("esc neutral is the middle of its range, mark min and max instead")
+		}
+		s.calibration.CenterPulse = s.calibration.Pulse
+	case CalibrationPointMax:
+		s.calibration.MaxPulse = s.calibration.Pulse
+	default:
+		return fmt.Errorf("unsupported calibration point (%s)", point)
+	}
+	return nil
+}
+
+// Hands the servo back to driving commands, it sits in failsafe until the next one
+func (s *ServoController) StopCalibration() error {
+	if !s.calibration.Active {
+		return nil
+	}
+	servo := s.servos[s.calibration.Servo]
+	s.calibration = CalibrationStatus{}
+	err := servo.SetFailsafe()
+	if err != nil {
+		return fmt.Errorf("error setting %s servo to failsafe: %w", servo.config.Name, err)
+	}
+	_, err = s.Flush()
+	return err
+}
+
+// True while the servo is being calibrated, driving commands to it are ignored
+func (s *ServoController) calibrating(name string) bool {
+	return s.calibration.Active && s.calibration.Servo == name
+}
+
+// Points that weren't marked keep the servo's current setting, the center stays at the same pulse if only the ends moved
+func (s *ServoController) markedCalibration() (Calibration, error) {
+	if !s.calibration.Active {
+		return Calibration{}, fmt.Errorf("not calibrating")
+	}
+	servo := s.servos[s.calibration.Servo]
+	calibration := Calibration{
+		MinPulse: servo.config.MinPulse,
+		MaxPulse: servo.config.MaxPulse,
+	}
+	if s.calibration.MinPulse > 0 {
+		calibration.MinPulse = s.calibration.MinPulse
+	}
+	if s.calibration.MaxPulse > 0 {
+		calibration.MaxPulse = s.calibration.MaxPulse
+	}
+	if calibration.MinPulse >= calibration.MaxPulse {
+		return Calibration{}, fmt.Errorf("min pulse %.0f must be below max pulse %.0f", calibration.MinPulse, calibration.MaxPulse)
+	}
+
+	if servo.config.Type != TypeESC { //Escs don't use a mid offset, neutral follows the ends
+		centerPulse := servo.centerPulse()
+		if s.calibration.CenterPulse > 0 {
+			centerPulse = s.calibration.CenterPulse
+		}
+		fraction := float64(centerPulse-calibration.MinPulse) / float64(calibration.MaxPulse-calibration.MinPulse)
+		calibration.MidOffset = int(math.Round(fraction*float64(servo.config.MaxValue))) - servo.config.MidValue
+	}
+
+	err := calibration.Apply(servo.config).Validate()
+	if err != nil {
+		return Calibration{}, fmt.Errorf("invalid calibration - %w", err)
+	}
+	return calibration, nil
+}
+
+func (s *ServoController) writeCalibrationPulse(pulse float32) error {
+	if pulse < CalibrationMinPulse {
+		pulse = CalibrationMinPulse
+	} else if pulse > CalibrationMaxPulse {
+		pulse = CalibrationMaxPulse
+	}
+	s.calibration.Pulse = pulse
+
+	servo := s.servos[s.calibration.Servo]
+	err := servo.driver.SetPulse(servo.config.Channel, pulse)
+	if err != nil {
+		return err
+	}
+	_, err = s.Flush()
+	return err
+}
+
+// Pulse the servo sits at when centered, with its mid offset
+func (s *Servo) centerPulse() float32 {
+	fraction := float32(s.config.MidValue+s.config.MidOffset) / float32(s.config.MaxValue)
+	return s.config.MinPulse + fraction*(s.config.MaxPulse-s.config.MinPulse)
+}
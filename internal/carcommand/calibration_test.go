@@ -0,0 +1,138 @@
+package carcommand
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func calibrate(t *testing.T, carCommand *CarCommand, request CalibrationRequest) CalibrationStatus {
+	t.Helper()
+	result := carCommand.DoCalibration(request)
+	if result.Err != nil {
+		t.Fatalf("failed calibration %s: %s", request.Action, result.Err)
+	}
+	return result.Status
+}
+
+func TestCalibration(t *testing.T) {
+	carCommand, driver := newSimCarCommand(t, testServoConfig("pan", TypeServo, 0), testServoConfig("steer", TypeServo, 1))
+	carCommand.config.CalibrationFile = filepath.Join(t.TempDir(), "calibration.json")
+
+	status := calibrate(t, carCommand, CalibrationRequest{Action: CalibrateStart, Servo: "steer"})
+	if !status.Active || status.Servo != "steer" {
+		t.Fatalf("expected steer calibration to be active, got %+v", status)
+	}
+	assertPulse(t, driver, 1, 1498)
+	assertEvent(t, carCommand, EventCalibration)
+
+	if carCommand.DoCalibration(CalibrationRequest{Action: CalibrateStart, Servo: "pan"}).Err == nil {
+		t.Errorf("expected a second servo calibration to be rejected")
+	}
+
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateNudge, Value: -400})
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateMark, Point: CalibrationPointMin})
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateNudge, Value: 800})
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateMark, Point: CalibrationPointMax})
+	status = calibrate(t, carCommand, CalibrationRequest{Action: CalibrateNudge, Value: -380})
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateMark, Point: CalibrationPointCenter})
+	assertPulse(t, driver, 1, 1518)
+	if math.Abs(float64(status.Pulse-1518)) > 0.5 {
+		t.Errorf("expected status pulse 1518, got %f", status.Pulse)
+	}
+
+	//Driving commands and failsafe leave the calibrating servo alone
+	err := carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"steer": {Value: 255}, "pan": {Value: 255}}})
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+	assertPulse(t, driver, 1, 1518)
+	assertPulse(t, driver, 0, 2000)
+	err = carCommand.servoController.Failsafe(0)
+	if err != nil {
+		t.Fatalf("failed failsafe: %s", err)
+	}
+	assertPulse(t, driver, 1, 1518)
+
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateSave})
+	calibrations, err := LoadCalibrations(carCommand.config.CalibrationFile)
+	if err != nil {
+		t.Fatalf("failed loading calibrations: %s", err)
+	}
+	saved := calibrations["steer"]
+	if math.Abs(float64(saved.MinPulse-1098)) > 0.5 || math.Abs(float64(saved.MaxPulse-1898)) > 0.5 || saved.MidOffset != 7 {
+		t.Errorf("unexpected saved calibration %+v", saved)
+	}
+
+	status = calibrate(t, carCommand, CalibrationRequest{Action: CalibrateStop})
+	if status.Active {
+		t.Errorf("expected calibration to stop")
+	}
+	assertPulse(t, driver, 1, 1518) //Failsafe center with the new calibration
+
+	err = carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"steer": {Value: 255}}})
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+	assertPulse(t, driver, 1, 1898)
+}
+
+func TestCalibrationErrors(t *testing.T) {
+	carCommand, _ := newSimCarCommand(t, testServoConfig("steer", TypeServo, 1))
+
+	tests := map[string]CalibrationRequest{
+		"nudge_without_start": {Action: CalibrateNudge, Value: 10},
+		"save_without_start":  {Action: CalibrateSave},
+		"unknown_servo":       {Action: CalibrateStart, Servo: "winch"},
+		"unknown_action":      {Action: "wiggle"},
+	}
+	for testName, request := range tests {
+		t.Run(testName, func(t *testing.T) {
+			if carCommand.DoCalibration(request).Err == nil {
+				t.Errorf("expected %s to fail", request.Action)
+			}
+		})
+	}
+
+	carCommand.config.CalibrationFile = filepath.Join(t.TempDir(), "calibration.json")
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateStart, Servo: "steer"})
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateMark, Point: CalibrationPointMin})
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateNudge, Value: -100})
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateMark, Point: CalibrationPointMax})
+	if carCommand.DoCalibration(CalibrationRequest{Action: CalibrateSave}).Err == nil {
+		t.Errorf("expected max below min to fail saving")
+	}
+}
+
+func TestEscCalibration(t *testing.T) {
+	carCommand, _ := newSimCarCommand(t, testServoConfig("esc", TypeESC, 0))
+	carCommand.config.CalibrationFile = filepath.Join(t.TempDir(), "calibration.json")
+
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateStart, Servo: "esc"})
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateNudge, Value: 40})
+	if carCommand.DoCalibration(CalibrationRequest{Action: CalibrateMark, Point: CalibrationPointCenter}).Err == nil {
+		t.Errorf("expected marking an esc center to be rejected")
+	}
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateNudge, Value: -440})
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateMark, Point: CalibrationPointMin})
+	calibrate(t, carCommand, CalibrationRequest{Action: CalibrateSave})
+
+	calibrations, err := LoadCalibrations(carCommand.config.CalibrationFile)
+	if err != nil {
+		t.Fatalf("failed loading calibrations: %s", err)
+	}
+	saved := calibrations["esc"]
+	if math.Abs(float64(saved.MinPulse-1098)) > 0.5 || saved.MidOffset != 0 {
+		t.Errorf("unexpected saved calibration %+v", saved)
+	}
+}
+
+func TestLoadMissingCalibrations(t *testing.T) {
+	calibrations, err := LoadCalibrations(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("expected missing file to load empty, got %s", err)
+	}
+	if len(calibrations) != 0 {
+		t.Errorf("expected no calibrations, got %+v", calibrations)
+	}
+}
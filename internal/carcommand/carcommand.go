@@ -2,38 +2,119 @@ package carcommand
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 )
 
 type CarCommand struct {
-	CommandChannel chan CommandGroup
+	CommandChannel     chan CommandGroup
+	ControlChannel     chan ControlCommand
+	CalibrationChannel chan CalibrationRequest
+	RecordingChannel   chan RecordingRequest
+	LimitChannel       chan OutputLimit
+	EventChannel       chan Event
 
 	config          CarCommandConfig
 	servoController *ServoController
+	mixer           *Mixer //nil when commands go straight to servos
+	tickDuration    time.Duration
+	lastGears       map[string]string
+	badGears        map[string]string //Last rejected gear by name, so a bad gear is logged once
+	recorder        *recorder         //nil when not recording
+	player          *player           //nil when not replaying
+	cruise          map[string]*cruiseControl
+	estop           estop
+	outputLimits    map[string]OutputLimit    //By source
+	yawRateSource   YawRateSource             //nil when there's no gyro
+	speedSources    map[string]SpeedSource    //By esc
+	driverLimits    DriverLimits              //From the last command, shift controls and speed hold use them too
+	distanceSources map[string]DistanceSource //By side
+	collision       collisionBrake
+
+	actuatorsOnline  bool
+	reconnectBackoff time.Duration
+	nextReconnect    time.Time
+
+	outputTotals     OutputStats //Summed since the last output report
+	outputFlushes    int
+	lastOutputReport time.Time
 }
 
 type CarCommandConfig struct {
 	RefreshRate           int
+	FailsafeTimeout       time.Duration
+	ReconnectMin          time.Duration //Backoff between output driver reconnects, doubles up to ReconnectMax
+	ReconnectMax          time.Duration
 	ServoControllerConfig ServoControllerConfig
 	ServoConfigs          []ServoConfig
+	Mixer                 MixerConfig
+	CalibrationFile       string //Where saved calibrations go, empty disables saving
+	RecordingDir          string
+	SpeedHold             SpeedHoldConfig
+	Collision             CollisionConfig
+	Inputs                map[string]string //Logical input of each command from the server's channel map, commands missing from it use CommandInput
 }
 
 type CommandGroup struct {
 	Commands map[string]Command
+	Limits   *DriverLimits //Profile of the driver that sent the commands, nil drives unlimited
 }
 
 type Command struct {
-	Value int
-	Gear  string
+	Value  int    `json:"value"`
+	Gear   string `json:"gear,omitempty"`
+	Cruise int    `json:"cruise,omitempty"` //Cruise control button, see CruiseToggle
 }
 
 func NewCarCommand(cfg CarCommandConfig) *CarCommand {
+	commandRate := 1000 / cfg.RefreshRate
+	if cfg.FailsafeTimeout <= 0 {
+		cfg.FailsafeTimeout = DefaultFailsafeTimeout
+	}
+	if cfg.RecordingDir == "" {
+		cfg.RecordingDir = DefaultRecordingDir
+	}
+	if cfg.ReconnectMin <= 0 {
+		cfg.ReconnectMin = DefaultReconnectMin
+	}
+	if cfg.ReconnectMax < cfg.ReconnectMin {
+		cfg.ReconnectMax = DefaultReconnectMax
+	}
+	if cfg.SpeedHold.Step <= 0 {
+		cfg.SpeedHold.Step = DefaultSpeedHoldStep
+	}
+	if cfg.SpeedHold.MaxThrottle <= 0 {
+		cfg.SpeedHold.MaxThrottle = DefaultSpeedHoldMaxThrottle
+	}
+	if cfg.Collision.Deceleration <= 0 {
+		cfg.Collision.Deceleration = DefaultCollisionDecel
+	}
+	if cfg.Collision.TopSpeed <= 0 {
+		cfg.Collision.TopSpeed = DefaultCollisionTopSpeed
+	}
 	carCommand := CarCommand{
-		CommandChannel:  make(chan CommandGroup, 5),
-		servoController: NewServoController(cfg.ServoControllerConfig),
-		config:          cfg,
+		tickDuration:       time.Duration(int64(time.Millisecond) * int64(commandRate)),
+		CommandChannel:     make(chan CommandGroup, 5),
+		ControlChannel:     make(chan ControlCommand, 5),
+		CalibrationChannel: make(chan CalibrationRequest, 5),
+		RecordingChannel:   make(chan RecordingRequest, 5),
+		LimitChannel:       make(chan OutputLimit, 5),
+		EventChannel:       make(chan Event, 10),
+		servoController:    NewServoController(cfg.ServoControllerConfig),
+		config:             cfg,
+		lastGears:          make(map[string]string),
+		badGears:           make(map[string]string),
+		cruise:             make(map[string]*cruiseControl),
+		outputLimits:       make(map[string]OutputLimit),
+		speedSources:       make(map[string]SpeedSource),
+		driverLimits:       unlimitedDriver,
+		distanceSources:    make(map[string]DistanceSource),
+		collision:          collisionBrake{enabled: true, states: make(map[string]int)},
+	}
+	if cfg.Mixer.Enabled() {
+		carCommand.mixer = NewMixer(cfg.Mixer, cfg.Inputs)
 	}
 	return &carCommand
 }
@@ -47,23 +128,112 @@ func (c *CarCommand) Init() error {
 
 	log.Printf("Adding Servos...\n\n")
 	for _, servoCfg := range c.config.ServoConfigs {
-		c.servoController.AddServo(servoCfg)
+		err = c.servoController.AddServo(servoCfg)
+		if err != nil {
+			return fmt.Errorf("failed adding servo %s - %w", servoCfg.Name, err)
+		}
+	}
+
+	if c.yawRateSource != nil {
+		c.servoController.SetYawRateSource(c.yawRateSource)
+	}
+	for name, source := range c.speedSources {
+		err = c.servoController.SetSpeedSource(name, source)
+		if err != nil {
+			return fmt.Errorf("failed setting speed source - %w", err)
+		}
+	}
+	if len(c.distanceSources) > 0 {
+		err = c.config.Collision.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid collision config - %w", err)
+		}
+	}
+	if c.config.SpeedHold.Enabled() {
+		err = c.config.SpeedHold.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid speed hold config - %w", err)
+		}
+	}
+
+	if c.mixer != nil {
+		err = c.config.Mixer.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid mixer config - %w", err)
+		}
+		err = c.mixer.Init(c.servoController)
+		if err != nil {
+			return fmt.Errorf("failed initializing mixer - %w", err)
+		}
+	}
+
+	c.actuatorsOnline = true
+	err = c.servoController.Reconnect()
+	if err != nil {
+		c.setOffline(err) //Keep the car up, the command loop keeps trying to reconnect
 	}
 	return nil
 }
 
+// Runs the command loop until the context is done, the loop is restarted with a backoff if it fails
 func (c *CarCommand) Start(ctx context.Context) error {
-	c.Init()
+	err := c.Init()
+	if err != nil {
+		c.sendEvent(EventActuatorsOffline, fmt.Sprintf("car command failed to start - %s", err.Error()))
+		return err
+	}
+
+	defer c.stopRecording() //Flushes whatever was recorded so far
+
+	backoff := c.config.ReconnectMin
+	for {
+		started := time.Now()
+		err = c.run(ctx)
+		if ctx.Err() != nil {
+			return err
+		}
+		if errors.Is(err, ErrChannelClosed) {
+			c.sendEvent(EventActuatorsOffline, err.Error())
+			return err
+		}
+
+		if time.Since(started) > c.config.ReconnectMax { //Ran fine for a while, so this is a new failure
+			backoff = c.config.ReconnectMin
+		}
+		log.Printf("warning: car command loop failed, restarting in %s - %s\n", backoff, err.Error())
+		c.sendEvent(EventActuatorsOffline, err.Error())
+		c.stopReplay("interrupted by the command loop restarting")
+		c.stopAllCruise("command loop restarting")
+		failsafeErr := c.servoController.Failsafe(0) //Nothing reads commands until the loop is back
+		if failsafeErr != nil {
+			log.Printf("warning: failed sending failsafe while restarting - %s\n", failsafeErr.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("car command stopped: %s", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > c.config.ReconnectMax {
+			backoff = c.config.ReconnectMax
+		}
+		log.Println("car command loop restarted")
+		c.sendEvent(EventActuatorsOnline, "command loop restarted")
+	}
+}
 
-	commandRate := 1000 / c.config.RefreshRate
-	commandDuration := time.Duration(int64(time.Millisecond) * int64(commandRate))
-	commandTicker := time.NewTicker(commandDuration)
+func (c *CarCommand) run(ctx context.Context) error {
+	commandTicker := time.NewTicker(c.tickDuration)
+	defer commandTicker.Stop()
 
-	limitCyclesWithoutCommand := 20
-	cyclesWithoutCommand := 0
+	lastCommandTime := time.Now()
+	failsafeStart := lastCommandTime
+	inFailsafe := true //No commands yet so start out in failsafe
 	gettingCommands := false
 
 	var latestCommand CommandGroup
+	var lastCommand CommandGroup //Kept so slew limited servos keep ramping between commands
 	for {
 		select {
 		case <-ctx.Done():
@@ -71,31 +241,130 @@ func (c *CarCommand) Start(ctx context.Context) error {
 
 		case command, ok := <-c.CommandChannel: //recieved command from client
 			if !ok {
-				return fmt.Errorf("car command channel stopped")
+				return fmt.Errorf("car command channel stopped - %w", ErrChannelClosed)
 			}
 			latestCommand = command //Use this command on next cycle
 
+		case control, ok := <-c.ControlChannel: //recieved control change from client
+			if !ok {
+				return fmt.Errorf("car control channel stopped - %w", ErrChannelClosed)
+			}
+			err := c.DoControl(control)
+			if err != nil {
+				log.Printf("error applying control (type: %s | servo: %s) - %s\n", control.Type, control.Servo, err.Error())
+			}
+
+		case request, ok := <-c.CalibrationChannel: //calibration step from the server
+			if !ok {
+				return fmt.Errorf("car calibration channel stopped - %w", ErrChannelClosed)
+			}
+			result := c.DoCalibration(request)
+			if result.Err != nil {
+				log.Printf("error calibrating (action: %s | servo: %s) - %s\n", request.Action, result.Status.Servo, result.Err.Error())
+				c.checkOutputError(result.Err) //Bus errors take the actuators offline, anything else only fails the request
+			}
+			select {
+			case request.Reply <- result:
+			default: //Nobody waiting for it
+			}
+
+		case request, ok := <-c.RecordingChannel: //record or replay request from the server
+			if !ok {
+				return fmt.Errorf("car recording channel stopped - %w", ErrChannelClosed)
+			}
+			result := c.DoRecording(request)
+			if result.Err != nil {
+				log.Printf("error with recording (action: %s | name: %s) - %s\n", request.Action, request.Name, result.Err.Error())
+			}
+			select {
+			case request.Reply <- result:
+			default: //Nobody waiting for it
+			}
+
+		case limit, ok := <-c.LimitChannel: //safety limit from a sensor
+			if !ok {
+				return fmt.Errorf("car limit channel stopped - %w", ErrChannelClosed)
+			}
+			err := c.setOutputLimit(limit)
+			if err != nil {
+				log.Printf("error applying output limit from %s - %s\n", limit.Source, err.Error())
+			}
+
 		case <-commandTicker.C: //time to send command
+			if !c.actuatorsOnline {
+				c.stopReplay("interrupted by actuators going offline")
+				c.stopAllCruise("actuators offline")
+				c.tryReconnect()
+				latestCommand.Commands = nil //Stale by the time we're back, wait for a fresh one
+				lastCommand.Commands = nil
+				continue
+			}
+
+			if c.estop.active {
+				latestCommand.Commands = nil //Nothing drives until an admin clears it
+				lastCommand.Commands = nil
+				inFailsafe = true //Stays in its last stage once cleared, until commands come back
+				failsafeStart = c.estop.start
+				err := c.checkOutputError(c.holdEstop())
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
 			if latestCommand.Commands != nil {
-				cyclesWithoutCommand = 0
+				lastCommandTime = time.Now()
+				if inFailsafe {
+					inFailsafe = false
+					if gettingCommands {
+						log.Printf("commands resumed after %s in failsafe\n", time.Since(failsafeStart))
+						c.sendEvent(EventFailsafeRecovered, "commands resumed")
+					}
+				}
 				gettingCommands = true
+				if c.player != nil && !c.liveInput(latestCommand) {
+					latestCommand.Commands = nil //Driver is hands off, the replay keeps driving
+				}
+			}
+
+			if latestCommand.Commands != nil {
+				c.stopReplay("interrupted by live input")
+				c.recordCommand(latestCommand)
 				err := c.DoCommand(latestCommand)
+				err = c.checkOutputError(err)
 				if err != nil {
 					return err
 				}
+				lastCommand = latestCommand
 				latestCommand.Commands = nil
-			} else {
-				cyclesWithoutCommand++
-				if cyclesWithoutCommand > limitCyclesWithoutCommand {
-					if gettingCommands {
-						gettingCommands = false
-						log.Printf("warning: stopped getting commands, start sendin neutral")
-					}
-					err := c.servoController.Neutral()
-					if err != nil {
-						return err
-					}
-					cyclesWithoutCommand = limitCyclesWithoutCommand //keep from overflowing
+			} else if time.Since(lastCommandTime) > c.config.FailsafeTimeout { //Replays need the driver connected too
+				c.stopReplay("interrupted by failsafe")
+				c.stopAllCruise("failsafe")
+				if !inFailsafe {
+					inFailsafe = true
+					failsafeStart = time.Now()
+					log.Printf("warning: stopped getting commands, start sending failsafe")
+					c.sendEvent(EventFailsafe, fmt.Sprintf("no commands for %s", c.config.FailsafeTimeout))
+				}
+				err := c.servoController.Failsafe(time.Since(failsafeStart))
+				c.reportOutputStats(c.servoController.OutputStats())
+				err = c.checkOutputError(err)
+				if err != nil {
+					return err
+				}
+				lastCommand.Commands = nil
+			} else if replayCommand, ok := c.nextReplayCommand(time.Now()); ok {
+				err := c.DoCommand(replayCommand)
+				err = c.checkOutputError(err)
+				if err != nil {
+					return err
+				}
+				lastCommand = replayCommand
+			} else if lastCommand.Commands != nil && c.servoController.Ramping() {
+				err := c.DoCommand(lastCommand)
+				err = c.checkOutputError(err)
+				if err != nil {
+					return err
 				}
 			}
 		}
@@ -103,16 +372,102 @@ func (c *CarCommand) Start(ctx context.Context) error {
 }
 
 func (c *CarCommand) DoCommand(commands CommandGroup) error {
+	err := c.setDriverLimits(commands.Limits)
+	if err != nil {
+		return fmt.Errorf("error applying driver limits - %w", err)
+	}
+	commands = c.applyCruise(commands)
+	commands = c.applyCollision(commands)
+	commands = c.applyOutputLimits(commands)
+	if c.mixer != nil {
+		commands = c.mixer.Mix(commands)
+	}
 	for i, command := range commands.Commands {
-		err := c.servoController.SendCommand(i, int(command.Value))
+		err := c.servoController.SendLimitedCommand(i, int(command.Value), c.tickDuration)
 		if err != nil {
-			return fmt.Errorf("error sending command (name: %s | command %d) - %w", err)
+			return fmt.Errorf("error sending command (name: %s | command %d) - %w", i, command.Value, err)
 		}
 
 		err = c.servoController.SetGear(i, command.Gear)
 		if err != nil {
-			return fmt.Errorf("error sending command (name: %s | command %d) - %w", err)
+			if c.badGears[i] != command.Gear { //Clients send their gear every command, only log when it changes
+				log.Printf("error setting gear (name: %s | gear %s) - %s\n", i, command.Gear, err.Error()) //Bad gear from the client shouldn't stop the car
+				c.badGears[i] = command.Gear
+			}
+		} else {
+			delete(c.badGears, i)
 		}
 	}
+
+	stats, err := c.servoController.Flush()
+	c.reportOutputStats(stats)
+	if err != nil {
+		return fmt.Errorf("error writing outputs - %w", err)
+	}
+	c.reportGearChanges()
 	return nil
 }
+
+// Warns about slow or failed flushes right away and logs a summary every OutputReportInterval
+func (c *CarCommand) reportOutputStats(stats OutputStats) {
+	if stats.Errors > 0 {
+		log.Printf("warning: %d of %d output writes failed\n", stats.Errors, stats.Writes)
+	}
+	if stats.Latency > c.tickDuration {
+		log.Printf("warning: output writes took %s, longer than the %s tick\n", stats.Latency, c.tickDuration)
+	}
+
+	c.outputFlushes++
+	c.outputTotals.Channels += stats.Channels
+	c.outputTotals.Writes += stats.Writes
+	c.outputTotals.Errors += stats.Errors
+	c.outputTotals.Latency += stats.Latency
+	if time.Since(c.lastOutputReport) < OutputReportInterval {
+		return
+	}
+	if c.outputTotals.Writes > 0 {
+		log.Printf("outputs: %d channel changes in %d writes over %d ticks, %d errors, avg write latency %s\n",
+			c.outputTotals.Channels, c.outputTotals.Writes, c.outputFlushes, c.outputTotals.Errors, c.outputTotals.Latency/time.Duration(c.outputTotals.Writes))
+	}
+	c.outputTotals = OutputStats{}
+	c.outputFlushes = 0
+	c.lastOutputReport = time.Now()
+}
+
+// Sends a gear event for each esc whose gear changed, automatic transmissions shift without being asked
+func (c *CarCommand) reportGearChanges() {
+	for name, gear := range c.servoController.Gears() {
+		if c.lastGears[name] != gear {
+			c.lastGears[name] = gear
+			c.sendEvent(EventGear, gear)
+		}
+	}
+}
+
+// Gives an esc a speed reading for automatic shifting and cruise speed hold.
+// Set before Start the esc is given it once it's made in Init.
+func (c *CarCommand) SetSpeedSource(name string, source SpeedSource) error {
+	c.speedSources[name] = source
+	if _, found := c.servoController.servos[name]; !found {
+		return nil
+	}
+	return c.servoController.SetSpeedSource(name, source)
+}
+
+// Gives servos with gyro assist a yaw rate reading, set it before Start since servos are made in Init
+func (c *CarCommand) SetYawRateSource(source YawRateSource) {
+	c.yawRateSource = source
+	c.servoController.SetYawRateSource(source)
+}
+
+func (c *CarCommand) reportGyro(name string) {
+	enabled, gain, found := c.servoController.Gyro(name)
+	if !found {
+		return
+	}
+	if !enabled {
+		c.sendEvent(EventGyro, fmt.Sprintf("%s off", name))
+		return
+	}
+	c.sendEvent(EventGyro, fmt.Sprintf("%s %d%%", name, gain))
+}
@@ -0,0 +1,255 @@
+package carcommand
+
+import (
+	"math"
+	"testing"
+)
+
+func newSimCarCommand(t *testing.T, servoCfgs ...ServoConfig) (*CarCommand, *SimDriver) {
+	t.Helper()
+	carCommand := NewCarCommand(CarCommandConfig{
+		RefreshRate: 60,
+		ServoControllerConfig: ServoControllerConfig{
+			Driver: DriverSim,
+		},
+		ServoConfigs: servoCfgs,
+	})
+	err := carCommand.Init()
+	if err != nil {
+		t.Fatalf("failed init: %s", err)
+	}
+	return carCommand, carCommand.servoController.drivers[0].(*SimDriver)
+}
+
+func testServoConfig(name string, servoType ServoType, channel int) ServoConfig {
+	return ServoConfig{
+		Name:      name,
+		Type:      servoType,
+		Channel:   channel,
+		MaxPulse:  2000,
+		MinPulse:  1000,
+		MaxValue:  255,
+		MidValue:  127,
+		MinValue:  0,
+		DeadZone:  1,
+		NumGears:  1,
+		Threshold: DefaultSwitchThreshold,
+	}
+}
+
+func assertPulse(t *testing.T, driver *SimDriver, channel int, expected float32) {
+	t.Helper()
+	pulse, ok := driver.LastPulse(channel)
+	if !ok {
+		t.Fatalf("no pulse written to channel %d", channel)
+	}
+	if math.Abs(float64(pulse-expected)) > 0.5 {
+		t.Errorf("channel %d pulse %f, expected %f", channel, pulse, expected)
+	}
+}
+
+func TestCommandPathWithSimDriver(t *testing.T) {
+	invertedSteer := testServoConfig("steer", "servo", 1)
+	invertedSteer.Inverted = true
+	offsetSteer := testServoConfig("steer", "servo", 1)
+	offsetSteer.MidOffset = 6
+	deadZoneSteer := testServoConfig("steer", "servo", 1)
+	deadZoneSteer.DeadZone = 10
+	sixGearEsc := testServoConfig("esc", "esc", 0)
+	sixGearEsc.NumGears = 6
+	deadZoneEsc := testServoConfig("esc", "esc", 0)
+	deadZoneEsc.DeadZone = 10
+
+	tests := map[string]struct {
+		servo   ServoConfig
+		command Command
+		pulse   float32
+	}{
+		"esc_full_throttle": {
+			servo:   testServoConfig("esc", "esc", 0),
+			command: Command{Value: 255, Gear: "1"},
+			pulse:   2000,
+		},
+		"esc_neutral_gear_ignores_throttle": {
+			servo:   testServoConfig("esc", "esc", 0),
+			command: Command{Value: 255, Gear: "N"},
+			pulse:   1498,
+		},
+		"esc_reverse": {
+			servo:   testServoConfig("esc", "esc", 0),
+			command: Command{Value: 0, Gear: "R"},
+			pulse:   1000,
+		},
+		"esc_first_of_six": {
+			servo:   sixGearEsc,
+			command: Command{Value: 255, Gear: "1"},
+			pulse:   1580,
+		},
+		"esc_ignores_dead_zone": {
+			servo:   deadZoneEsc,
+			command: Command{Value: 132, Gear: "1"},
+			pulse:   1517.6,
+		},
+		"steer_full_right": {
+			servo:   testServoConfig("steer", "servo", 1),
+			command: Command{Value: 255},
+			pulse:   2000,
+		},
+		"steer_inverted": {
+			servo:   invertedSteer,
+			command: Command{Value: 255},
+			pulse:   1000,
+		},
+		"steer_offset": {
+			servo:   offsetSteer,
+			command: Command{Value: 127},
+			pulse:   1521.5,
+		},
+		"steer_dead_zone": {
+			servo:   deadZoneSteer,
+			command: Command{Value: 132},
+			pulse:   1498,
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			carCommand, driver := newSimCarCommand(t, tc.servo)
+			//gear is applied after the value, so send twice to see the gear take effect
+			for i := 0; i < 2; i++ {
+				err := carCommand.DoCommand(CommandGroup{
+					Commands: map[string]Command{tc.servo.Name: tc.command},
+				})
+				if err != nil {
+					t.Fatalf("failed sending command: %s", err)
+				}
+			}
+			assertPulse(t, driver, tc.servo.Channel, tc.pulse)
+		})
+	}
+}
+
+func TestNeutralWithSimDriver(t *testing.T) {
+	carCommand, driver := newSimCarCommand(t, testServoConfig("esc", "esc", 0), testServoConfig("steer", "servo", 1))
+
+	err := carCommand.servoController.Neutral()
+	if err != nil {
+		t.Fatalf("failed setting neutral: %s", err)
+	}
+	assertPulse(t, driver, 0, 1498)
+	assertPulse(t, driver, 1, 1498)
+	if len(driver.Pulses(0)) != 1 {
+		t.Errorf("expected 1 pulse on channel 0, got %d", len(driver.Pulses(0)))
+	}
+}
+
+func TestSwitchTypes(t *testing.T) {
+	tests := map[string]struct {
+		servoType ServoType
+		inverted  bool
+		values    []int
+		pulses    []float32
+		failsafe  float32
+	}{
+		"toggle_latches_on_press": {
+			servoType: TypeToggle,
+			values:    []int{127, 255, 255, 127, 255, 127},
+			pulses:    []float32{1000, 2000, 2000, 2000, 1000, 1000},
+			failsafe:  1000,
+		},
+		"toggle_ignores_down": {
+			servoType: TypeToggle,
+			values:    []int{0, 127},
+			pulses:    []float32{1000, 1000},
+			failsafe:  1000,
+		},
+		"momentary_only_while_held": {
+			servoType: TypeMomentary,
+			values:    []int{255, 255, 127, 0},
+			pulses:    []float32{2000, 2000, 1000, 1000},
+			failsafe:  1000,
+		},
+		"tristate_steps_through_states": {
+			servoType: TypeTriState,
+			values:    []int{255, 127, 255, 127, 0, 127, 0, 0, 127, 0},
+			pulses:    []float32{2000, 2000, 2000, 2000, 1498, 1498, 1000, 1000, 1000, 1000},
+			failsafe:  1498,
+		},
+		"toggle_inverted_endpoints": {
+			servoType: TypeToggle,
+			inverted:  true,
+			values:    []int{127, 255, 127, 255},
+			pulses:    []float32{2000, 1000, 1000, 2000},
+			failsafe:  2000,
+		},
+		"tristate_inverted_endpoints": {
+			servoType: TypeTriState,
+			inverted:  true,
+			values:    []int{255, 127, 0, 127, 0},
+			pulses:    []float32{1000, 1000, 1498, 1498, 2000},
+			failsafe:  1498,
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			servoCfg := testServoConfig("aux", tc.servoType, 4)
+			servoCfg.Inverted = tc.inverted
+			carCommand, driver := newSimCarCommand(t, servoCfg)
+			for i, value := range tc.values {
+				err := carCommand.DoCommand(CommandGroup{
+					Commands: map[string]Command{"aux": {Value: value}},
+				})
+				if err != nil {
+					t.Fatalf("failed sending command: %s", err)
+				}
+				pulse, _ := driver.LastPulse(4)
+				if math.Abs(float64(pulse-tc.pulses[i])) > 0.5 {
+					t.Errorf("step %d: pulse %f, expected %f", i, pulse, tc.pulses[i])
+				}
+			}
+
+			err := carCommand.servoController.Failsafe(0)
+			if err != nil {
+				t.Fatalf("failed setting failsafe: %s", err)
+			}
+			assertPulse(t, driver, 4, tc.failsafe)
+		})
+	}
+}
+
+func TestServoConfigValidate(t *testing.T) {
+	badType := testServoConfig("bad", "winch", 0)
+	badChannel := testServoConfig("bad", TypeServo, ChannelsPerBoard)
+	badPulse := testServoConfig("bad", TypeServo, 0)
+	badPulse.MinPulse = 2500
+	noGears := testServoConfig("bad", TypeESC, 0)
+	noGears.NumGears = 0
+	badThreshold := testServoConfig("bad", TypeToggle, 0)
+	badThreshold.Threshold = 200
+
+	tests := map[string]struct {
+		servo ServoConfig
+		valid bool
+	}{
+		"servo":         {servo: testServoConfig("steer", TypeServo, 0), valid: true},
+		"esc":           {servo: testServoConfig("esc", TypeESC, 0), valid: true},
+		"toggle":        {servo: testServoConfig("lights", TypeToggle, 0), valid: true},
+		"unknown_type":  {servo: badType},
+		"bad_channel":   {servo: badChannel},
+		"bad_pulse":     {servo: badPulse},
+		"esc_no_gears":  {servo: noGears},
+		"bad_threshold": {servo: badThreshold},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := tc.servo.Validate()
+			if tc.valid && err != nil {
+				t.Errorf("expected valid config, got %s", err)
+			} else if !tc.valid && err == nil {
+				t.Errorf("expected invalid config")
+			}
+		})
+	}
+}
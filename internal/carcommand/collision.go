@@ -0,0 +1,210 @@
+package carcommand
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Where a distance sensor looks, front ones stop forward throttle and rear ones reverse
+const (
+	DistanceFront = "front"
+	DistanceRear  = "rear"
+)
+
+const DefaultCollisionMinDistance = 0.2 //Meters, throttle toward anything closer is blocked
+const DefaultCollisionReaction = 150 * time.Millisecond
+const DefaultCollisionDecel = 3.0    //Meters per second per second the car can brake at
+const DefaultCollisionTopSpeed = 5.0 //Meters per second at full throttle, guesses speed without a speed source
+
+// DistanceSource is anything that can report how far away the nearest obstacle is in meters, ok is false when there's no reading
+type DistanceSource interface {
+	Distance() (float64, bool)
+}
+
+// CollisionConfig sets how far ahead of an obstacle collision braking starts.
+// The car needs to stop in MinDistance plus how far it goes while reacting and braking.
+type CollisionConfig struct {
+	MinDistance  float64
+	ReactionTime time.Duration
+	Deceleration float64
+	TopSpeed     float64
+}
+
+// How much throttle collision braking allows toward a side
+const (
+	collisionClear = iota
+	collisionScaled
+	collisionBlocked
+)
+
+type collisionBrake struct {
+	enabled bool
+	states  map[string]int //By side, interventions are logged when these change
+}
+
+func (c CollisionConfig) Validate() error {
+	if c.MinDistance < 0 || c.ReactionTime < 0 {
+		return fmt.Errorf("collision min distance and reaction time can't be negative")
+	}
+	if c.Deceleration <= 0 || c.TopSpeed <= 0 {
+		return fmt.Errorf("collision deceleration and top speed must be more than 0 (%.2f | %.2f)", c.Deceleration, c.TopSpeed)
+	}
+	return nil
+}
+
+// Distance the car needs to stop from speed, obstacles closer than this get throttle cut
+func (c CollisionConfig) stoppingDistance(speed float64) float64 {
+	return c.MinDistance + speed*c.ReactionTime.Seconds() + speed*speed/(2*c.Deceleration)
+}
+
+// Throttle percent allowed toward an obstacle at distance, scales down to 0 at MinDistance
+func (c CollisionConfig) allowedThrottle(distance float64, speed float64) int {
+	if distance <= c.MinDistance {
+		return 0
+	}
+	stopping := c.stoppingDistance(speed)
+	if distance >= stopping {
+		return MaxThrottleLimit
+	}
+	return int(math.Round(MaxThrottleLimit * (distance - c.MinDistance) / (stopping - c.MinDistance)))
+}
+
+// Gives collision braking a distance reading for a side, set it before Start
+func (c *CarCommand) SetDistanceSource(side string, source DistanceSource) error {
+	if side != DistanceFront && side != DistanceRear {
+		return fmt.Errorf("unsupported distance side (%s)", side)
+	}
+	c.distanceSources[side] = source
+	return nil
+}
+
+func (c *CarCommand) setCollisionEnabled(enabled bool) {
+	c.collision.enabled = enabled
+	if !enabled {
+		c.collision.states = make(map[string]int)
+	}
+	state := "off"
+	if enabled {
+		state = "on"
+	}
+	log.Printf("collision braking %s\n", state)
+	c.sendEvent(EventCollision, state)
+}
+
+// Scales throttle commands heading toward a close obstacle, runs after cruise so a latched throttle is stopped too
+func (c *CarCommand) applyCollision(commands CommandGroup) CommandGroup {
+	if !c.collision.enabled || len(c.distanceSources) == 0 {
+		return commands
+	}
+
+	var limited map[string]Command //Copied on the first change, the caller keeps its commands
+	for name, command := range commands.Commands {
+		if c.commandInput(name) != InputThrottle {
+			continue
+		}
+		side := c.throttleSide(name, command)
+		for _, otherSide := range []string{DistanceFront, DistanceRear} {
+			if otherSide != side {
+				c.reportCollision(name, otherSide, MaxThrottleLimit, 0, 0) //Not heading that way anymore
+			}
+		}
+		source := c.distanceSources[side]
+		if source == nil {
+			continue
+		}
+		distance, ok := source.Distance()
+		if !ok {
+			c.reportCollision(name, side, MaxThrottleLimit, 0, 0) //A sensor that stopped answering can't stop the car
+			continue
+		}
+
+		speed := c.collisionSpeed(name, command)
+		allowed := c.config.Collision.allowedThrottle(distance, speed)
+		c.reportCollision(name, side, allowed, distance, speed)
+		if allowed >= MaxThrottleLimit {
+			continue
+		}
+		if limited == nil {
+			limited = make(map[string]Command, len(commands.Commands))
+			for otherName, otherCommand := range commands.Commands {
+				limited[otherName] = otherCommand
+			}
+		}
+		command.Value = MixInputMid + (command.Value-MixInputMid)*allowed/MaxThrottleLimit
+		limited[name] = command
+	}
+
+	if limited == nil {
+		return commands
+	}
+	return CommandGroup{Commands: limited}
+}
+
+// Side the throttle drives toward, empty when it isn't moving the car or only brakes
+func (c *CarCommand) throttleSide(name string, command Command) string {
+	if command.Value > MixInputMid {
+		return DistanceFront
+	}
+	if command.Value == MixInputMid {
+		return ""
+	}
+
+	servo, found := c.servoController.servos[name]
+	if !found {
+		return DistanceRear //Mixed throttles drive both ways
+	}
+	gear := command.Gear
+	if gear == "" {
+		gear = servo.Gear()
+	}
+	mode := servo.config.Esc.Mode
+	if mode == "" {
+		mode = DefaultEscMode
+	}
+	if gear == ReverseKey || (mode == EscModeFR && gear != NeutralKey) {
+		return DistanceRear
+	}
+	return "" //Braking, never cut a brake
+}
+
+// Measured speed when the esc has a speed source, otherwise a guess from how hard the throttle is pushed
+func (c *CarCommand) collisionSpeed(name string, command Command) float64 {
+	if source := c.speedSources[name]; source != nil {
+		speed, ok := source.Speed()
+		if ok {
+			return speed
+		}
+	}
+	throttle := math.Abs(float64(command.Value-MixInputMid)) / float64(MixInputMax-MixInputMid)
+	return throttle * c.config.Collision.TopSpeed
+}
+
+// Logs every intervention as it starts, tightens to a block and ends
+func (c *CarCommand) reportCollision(name string, side string, allowed int, distance float64, speed float64) {
+	state := collisionClear
+	switch {
+	case allowed == 0:
+		state = collisionBlocked
+	case allowed < MaxThrottleLimit:
+		state = collisionScaled
+	}
+	if c.collision.states[side] == state {
+		return
+	}
+	c.collision.states[side] = state
+
+	var message string
+	switch state {
+	case collisionBlocked:
+		c.stopCruise(name, "obstacle")
+		message = fmt.Sprintf("%s blocked - obstacle %.2fm at %.2fm/s", side, distance, speed)
+	case collisionScaled:
+		message = fmt.Sprintf("%s throttle %d%% - obstacle %.2fm at %.2fm/s", side, allowed, distance, speed)
+	default:
+		message = fmt.Sprintf("%s clear", side)
+	}
+	log.Printf("collision braking: %s\n", message)
+	c.sendEvent(EventCollision, message)
+}
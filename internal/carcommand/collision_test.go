@@ -0,0 +1,117 @@
+package carcommand
+
+import (
+	"testing"
+)
+
+type fakeDistanceSource struct {
+	distance float64
+	lost     bool
+}
+
+func (f *fakeDistanceSource) Distance() (float64, bool) {
+	return f.distance, !f.lost
+}
+
+func TestCollisionBraking(t *testing.T) {
+	tests := map[string]struct {
+		mode     EscMode
+		command  Command
+		front    float64
+		rear     float64
+		lost     bool
+		speed    float64 //0 guesses speed from the throttle
+		disabled bool
+		value    int
+	}{
+		"far":             {command: Command{Value: 255}, front: 2, speed: 2, value: 255},
+		"close":           {command: Command{Value: 255}, front: 0.7, speed: 2, value: 191},
+		"blocked":         {command: Command{Value: 255}, front: 0.1, speed: 2, value: MixInputMid},
+		"slow_close":      {command: Command{Value: 255}, front: 0.7, speed: 0.5, value: 255}, //Stops well short at that speed
+		"guessed_speed":   {command: Command{Value: 255}, front: 2.2, value: 191},
+		"rear_clear":      {command: Command{Value: 255}, front: 2, rear: 0.1, speed: 2, value: 255},
+		"reverse":         {command: Command{Value: 0, Gear: "1"}, front: 2, rear: 0.1, speed: 2, value: MixInputMid},
+		"brake":           {mode: EscModeFB, command: Command{Value: 0, Gear: "1"}, front: 0.1, rear: 0.1, speed: 2, value: 0},
+		"reverse_gear_fb": {mode: EscModeFB, command: Command{Value: 0, Gear: ReverseKey}, front: 2, rear: 0.1, speed: 2, value: MixInputMid},
+		"sensor_lost":     {command: Command{Value: 255}, front: 0.1, lost: true, speed: 2, value: 255},
+		"disabled":        {command: Command{Value: 255}, front: 0.1, speed: 2, disabled: true, value: 255},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			escCfg := testServoConfig("esc", TypeESC, 0)
+			escCfg.Esc = EscConfig{Mode: test.mode}
+			carCommand, _ := newSimCarCommand(t, escCfg)
+			carCommand.config.Collision = CollisionConfig{MinDistance: 0.2, Deceleration: 2, TopSpeed: 4}
+			carCommand.SetDistanceSource(DistanceFront, &fakeDistanceSource{distance: test.front, lost: test.lost})
+			carCommand.SetDistanceSource(DistanceRear, &fakeDistanceSource{distance: test.rear, lost: test.lost})
+			if test.speed > 0 {
+				carCommand.SetSpeedSource("esc", &fakeSpeedSource{speed: test.speed})
+			}
+			if test.disabled {
+				err := carCommand.DoControl(ControlCommand{Type: ControlCollision, Value: 0})
+				if err != nil {
+					t.Fatalf("failed turning collision braking off: %s", err)
+				}
+			}
+
+			commands := CommandGroup{Commands: map[string]Command{"esc": test.command, "steer": {Value: 20}}}
+			limited := carCommand.applyCollision(commands)
+			if value := limited.Commands["esc"].Value; value != test.value {
+				t.Errorf("expected throttle %d, got %d", test.value, value)
+			}
+			if limited.Commands["steer"].Value != 20 {
+				t.Errorf("expected steer to pass through")
+			}
+			if commands.Commands["esc"] != test.command {
+				t.Errorf("expected the caller's commands to be left alone")
+			}
+		})
+	}
+}
+
+func TestCollisionInterventionsReported(t *testing.T) {
+	carCommand, _ := newSimCarCommand(t, testServoConfig("esc", TypeESC, 0))
+	carCommand.config.Collision = CollisionConfig{MinDistance: 0.2, Deceleration: 2, TopSpeed: 4}
+	front := &fakeDistanceSource{distance: 0.7}
+	carCommand.SetDistanceSource(DistanceFront, front)
+	carCommand.SetSpeedSource("esc", &fakeSpeedSource{speed: 2})
+	drive := func(value int) {
+		carCommand.applyCollision(CommandGroup{Commands: map[string]Command{"esc": {Value: value}}})
+	}
+
+	carCommand.applyCruise(CommandGroup{Commands: map[string]Command{"esc": {Value: 200, Cruise: CruiseToggle}}})
+	assertEvent(t, carCommand, EventCruise)
+
+	drive(255)
+	drive(255)
+	event := <-carCommand.EventChannel
+	if expected := "front throttle 50% - obstacle 0.70m at 2.00m/s"; event.Type != EventCollision || event.Message != expected {
+		t.Errorf("expected collision event %s, got %+v", expected, event)
+	}
+	front.distance = 0.1
+	drive(255)
+	assertEvent(t, carCommand, EventCruise) //Blocked drops cruise
+	assertEvent(t, carCommand, EventCollision)
+	drive(MixInputMid)
+	assertEvent(t, carCommand, EventCollision) //Clear once the driver lets off
+	drive(MixInputMid)
+	if len(carCommand.EventChannel) != 0 {
+		t.Errorf("expected one event for each intervention, got %d more", len(carCommand.EventChannel))
+	}
+}
+
+func TestCollisionFindsThrottlesByInput(t *testing.T) {
+	carCommand, _ := newSimCarCommand(t, testServoConfig("drive", TypeESC, 0), testServoConfig("esc", TypeServo, 1))
+	carCommand.config.Inputs = map[string]string{"drive": InputThrottle, "esc": InputAux} //From the channel map
+	carCommand.config.Collision = CollisionConfig{MinDistance: 0.2, Deceleration: 2, TopSpeed: 4}
+	carCommand.SetDistanceSource(DistanceFront, &fakeDistanceSource{distance: 0.1})
+
+	limited := carCommand.applyCollision(CommandGroup{Commands: map[string]Command{"drive": {Value: 255}, "esc": {Value: 255}}})
+	if value := limited.Commands["drive"].Value; value != MixInputMid {
+		t.Errorf("expected drive to be blocked, got %d", value)
+	}
+	if value := limited.Commands["esc"].Value; value != 255 {
+		t.Errorf("expected esc to pass through since it isn't a throttle, got %d", value)
+	}
+}
@@ -0,0 +1,116 @@
+package carcommand
+
+import (
+	"fmt"
+)
+
+const ControlRate = "rate"   //Value is the index of the rate to use
+const ControlCurve = "curve" //Value of 0 turns the expo/curve off, anything else turns it on
+const ControlUpShift = "upshift"
+const ControlDownShift = "downshift"
+const ControlCruiseCancel = "cruise_cancel" //Drops out of cruise on every throttle, sent when a client disconnects
+const ControlGyro = "gyro"                  //Value of 0 turns the gyro assist off, anything else turns it on
+const ControlGyroGain = "gyro_gain"         //Value is the gain percent
+const ControlCruiseSpeed = "cruise_speed"   //Value is the speed to hold in cm/s, 0 drops out
+
+// Collision braking can only be switched by admins, the server drops it from everyone else
+const ControlCollision = "collision" //Value of 0 turns collision braking off, anything else turns it on
+
+// Estop controls come from the server, clearing is only for admins
+const ControlEstop = "estop" //Latches every actuator in failsafe and drops commands until cleared
+const ControlEstopClear = "estop_clear"
+
+// ControlCommand changes how the car responds instead of driving it
+type ControlCommand struct {
+	Type   string `json:"type"`
+	Servo  string `json:"servo"`
+	Value  int    `json:"value"`
+	Reason string `json:"reason,omitempty"` //Logged and sent out with estop events
+}
+
+// DriverLimits come from the server's limit profile of the driver sending commands, clients can't send them
+type DriverLimits struct {
+	MaxThrottle int  `json:"maxThrottle"` //Percent of driving throttle, speed hold included. Braking is never limited.
+	MaxGear     int  `json:"maxGear"`     //Highest forward gear, 0 allows all
+	NoReverse   bool `json:"noReverse"`
+}
+
+var unlimitedDriver = DriverLimits{MaxThrottle: MaxThrottleLimit}
+
+func (l DriverLimits) Validate() error {
+	if l.MaxThrottle < 0 || l.MaxThrottle > MaxThrottleLimit {
+		return fmt.Errorf("max throttle must be 0 to %d percent (%d)", MaxThrottleLimit, l.MaxThrottle)
+	}
+	if l.MaxGear < 0 {
+		return fmt.Errorf("max gear can't be negative (%d)", l.MaxGear)
+	}
+	return nil
+}
+
+// Applies the limits of the driver sending commands, gear limits apply to every esc until the next command changes them
+func (c *CarCommand) setDriverLimits(limits *DriverLimits) error {
+	driverLimits := unlimitedDriver
+	if limits != nil {
+		err := limits.Validate()
+		if err != nil {
+			return err
+		}
+		driverLimits = *limits
+	}
+	if driverLimits == c.driverLimits {
+		return nil
+	}
+	c.driverLimits = driverLimits
+	c.servoController.SetGearLimit(driverLimits.MaxGear, driverLimits.NoReverse)
+	c.reportGearChanges()
+	return nil
+}
+
+func (c *CarCommand) DoControl(control ControlCommand) error {
+	if c.estop.active && !allowedInEstop(control.Type) {
+		return fmt.Errorf("estop latched, %s rejected", control.Type)
+	}
+	switch control.Type {
+	case ControlRate:
+		return c.servoController.SetRate(control.Servo, control.Value)
+	case ControlCurve:
+		return c.servoController.SetCurveEnabled(control.Servo, control.Value != 0)
+	case ControlUpShift, ControlDownShift:
+		_, err := c.servoController.Shift(control.Servo, control.Type == ControlUpShift)
+		if err != nil {
+			return err
+		}
+		c.stopAllCruise("gear change")
+		c.reportGearChanges()
+		return nil
+	case ControlCruiseCancel:
+		c.stopAllCruise("cancelled")
+		return nil
+	case ControlGyro:
+		err := c.servoController.SetGyroEnabled(control.Servo, control.Value != 0)
+		if err != nil {
+			return err
+		}
+		c.reportGyro(control.Servo)
+		return nil
+	case ControlGyroGain:
+		err := c.servoController.SetGyroGain(control.Servo, control.Value)
+		if err != nil {
+			return err
+		}
+		c.reportGyro(control.Servo)
+		return nil
+	case ControlCruiseSpeed:
+		return c.setCruiseSpeed(control.Servo, float64(control.Value)/100)
+	case ControlCollision:
+		c.setCollisionEnabled(control.Value != 0)
+		return nil
+	case ControlEstop:
+		return c.latchEstop(control.Reason)
+	case ControlEstopClear:
+		c.clearEstop(control.Reason)
+		return nil
+	default:
+		return fmt.Errorf("unsupported control type (%s)", control.Type)
+	}
+}
@@ -2,47 +2,80 @@ package carcommand
 
 import (
 	"fmt"
-
-	"github.com/googolgl/go-i2c"
-	"github.com/googolgl/go-pca9685"
+	"time"
 )
 
-const MaxSupportedServos = 16
+const MaxSupportedServos = ChannelsPerBoard * MaxBoards
 
 type ServoController struct {
-	config          ServoControllerConfig
-	servoController *pca9685.PCA9685
-	servos          map[string]*Servo
+	config   ServoControllerConfig
+	drivers  []OutputDriver //One per board
+	outputs  []*outputStage //Servos write here, flushed to their board's driver once per tick
+	servos   map[string]*Servo
+	channels map[boardChannel]string //Servo using each board channel
+
+	calibration CalibrationStatus
 }
 
 type ServoControllerConfig struct {
+	Driver    string
 	Address   byte
 	I2CDevice string
+	Frequency float32
+	Device    string
+	Lines     []int
+	Boards    []BoardConfig //When empty a single board is made from the fields above
+}
+
+type boardChannel struct {
+	board   int
+	channel int
 }
 
 func NewServoController(cfg ServoControllerConfig) *ServoController {
 	return &ServoController{
-		config: cfg,
-		servos: make(map[string]*Servo, MaxSupportedServos),
+		config:   cfg,
+		servos:   make(map[string]*Servo, MaxSupportedServos),
+		channels: make(map[boardChannel]string, MaxSupportedServos),
 	}
 }
 
+// Creates an output driver for each board, Reconnect connects to them once the servos are added
 func (s *ServoController) Init() error {
-	i2c, err := i2c.New(s.config.Address, s.config.I2CDevice)
+	boards := s.config.getBoards()
+	err := validateBoards(boards)
 	if err != nil {
-		return fmt.Errorf("error starting i2c with address - %w", err)
+		return fmt.Errorf("invalid board config - %w", err)
 	}
 
-	s.servoController, err = pca9685.New(i2c, nil)
-	if err != nil {
-		return fmt.Errorf("error getting servo driver - %w", err)
+	for i, board := range boards {
+		driver, err := NewOutputDriver(board)
+		if err != nil {
+			return fmt.Errorf("error creating output driver for board %d - %w", i, err)
+		}
+		s.drivers = append(s.drivers, driver)
+		s.outputs = append(s.outputs, newOutputStage(driver))
 	}
 	return nil
 }
 
-func (s *ServoController) AddServo(cfg ServoConfig) {
-	newServo := NewServo(cfg, s.servoController)
+func (s *ServoController) AddServo(cfg ServoConfig) error {
+	err := cfg.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid servo config - %w", err)
+	}
+	if cfg.Board >= len(s.outputs) {
+		return fmt.Errorf("board %d not configured", cfg.Board)
+	}
+	key := boardChannel{board: cfg.Board, channel: cfg.Channel}
+	if other, found := s.channels[key]; found {
+		return fmt.Errorf("board %d channel %d already used by %s", cfg.Board, cfg.Channel, other)
+	}
+
+	newServo := NewServo(cfg, s.outputs[cfg.Board])
 	s.servos[cfg.Name] = newServo
+	s.channels[key] = cfg.Name
+	return nil
 }
 
 func (s *ServoController) SetGear(name string, gear string) error {
@@ -53,20 +86,199 @@ func (s *ServoController) SetGear(name string, gear string) error {
 	return servo.SetGear(gear)
 }
 
+// Shifts up or down one gear and returns the gear the servo ended up in
+func (s *ServoController) Shift(name string, up bool) (string, error) {
+	servo, found := s.servos[name]
+	if !found {
+		return "", fmt.Errorf("servo %s not found", name)
+	}
+	if up {
+		servo.UpShift()
+	} else {
+		servo.DownShift()
+	}
+	return servo.Gear(), nil
+}
+
+// Returns the current gear of every esc servo
+func (s *ServoController) Gears() map[string]string {
+	gears := make(map[string]string)
+	for name, servo := range s.servos {
+		if servo.config.Type == TypeESC {
+			gears[name] = servo.Gear()
+		}
+	}
+	return gears
+}
+
+// Applies the gear limit to every esc
+func (s *ServoController) SetGearLimit(maxGear int, noReverse bool) {
+	for _, servo := range s.servos {
+		servo.SetGearLimit(maxGear, noReverse)
+	}
+}
+
+func (s *ServoController) SetSpeedSource(name string, source SpeedSource) error {
+	servo, found := s.servos[name]
+	if !found {
+		return fmt.Errorf("servo %s not found", name)
+	}
+	servo.SetSpeedSource(source)
+	return nil
+}
+
+// Gives every servo with gyro assist a yaw rate reading to steer on
+func (s *ServoController) SetYawRateSource(source YawRateSource) {
+	for _, servo := range s.servos {
+		servo.SetYawRateSource(source)
+	}
+}
+
+func (s *ServoController) SetGyroGain(name string, gain int) error {
+	servo, found := s.servos[name]
+	if !found {
+		return fmt.Errorf("servo %s not found", name)
+	}
+	return servo.SetGyroGain(gain)
+}
+
+func (s *ServoController) SetGyroEnabled(name string, enabled bool) error {
+	servo, found := s.servos[name]
+	if !found {
+		return fmt.Errorf("servo %s not found", name)
+	}
+	return servo.SetGyroEnabled(enabled)
+}
+
+// Whether the servo's gyro assist is on and its gain, found is false if the servo has no gyro
+func (s *ServoController) Gyro(name string) (enabled bool, gain int, found bool) {
+	servo, found := s.servos[name]
+	if !found || !servo.config.Gyro.Enabled() {
+		return false, 0, false
+	}
+	return servo.gyro.enabled, servo.gyro.gain, true
+}
+
+func (s *ServoController) SetRate(name string, index int) error {
+	servo, found := s.servos[name]
+	if !found {
+		return fmt.Errorf("servo %s not found", name)
+	}
+	servo.SetRate(index)
+	return nil
+}
+
+func (s *ServoController) SetCurveEnabled(name string, enabled bool) error {
+	servo, found := s.servos[name]
+	if !found {
+		return fmt.Errorf("servo %s not found", name)
+	}
+	servo.SetCurveEnabled(enabled)
+	return nil
+}
+
 func (s *ServoController) SendCommand(name string, value int) error {
 	servo, found := s.servos[name]
 	if !found {
 		return fmt.Errorf("servo %s not found", name)
 	}
-	return servo.SetValue(value)
+	if s.calibrating(name) {
+		return nil //Locked out until calibration stops
+	}
+	err := servo.SetValue(value)
+	if err != nil {
+		return err
+	}
+	_, err = s.Flush()
+	return err
+}
+
+// Buffered, needs a Flush to reach the driver
+func (s *ServoController) SendLimitedCommand(name string, value int, tick time.Duration) error {
+	servo, found := s.servos[name]
+	if !found {
+		return fmt.Errorf("servo %s not found", name)
+	}
+	if s.calibrating(name) {
+		return nil //Locked out until calibration stops
+	}
+	return servo.SetLimitedValue(value, tick)
+}
+
+// True if any servo's slew limiter hasn't reached its target yet
+func (s *ServoController) Ramping() bool {
+	for name, servo := range s.servos {
+		if servo.Ramping() && !s.calibrating(name) {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *ServoController) Neutral() error {
-	for _, servo := range s.servos {
+	for name, servo := range s.servos {
+		if s.calibrating(name) {
+			continue
+		}
 		err := servo.SetNeutral()
 		if err != nil {
 			return fmt.Errorf("error setting %s servo to neutral: %w", servo.config.Name, err)
 		}
 	}
-	return nil
+	_, err := s.Flush()
+	return err
+}
+
+// Writes every servo value that changed since the last flush to its board
+func (s *ServoController) Flush() (OutputStats, error) {
+	var firstErr error
+	for i, output := range s.outputs {
+		_, err := output.flush()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("board %d - %w", i, err)
+		}
+	}
+	return s.OutputStats(), firstErr
+}
+
+// Stats from the most recent flush, summed across boards
+func (s *ServoController) OutputStats() OutputStats {
+	stats := OutputStats{}
+	for _, output := range s.outputs {
+		stats.Channels += output.stats.Channels
+		stats.Writes += output.stats.Writes
+		stats.Errors += output.stats.Errors
+		stats.Latency += output.stats.Latency
+	}
+	return stats
+}
+
+// Runs each servo's failsafe stage for how long the failsafe has been active
+func (s *ServoController) Failsafe(elapsed time.Duration) error {
+	for name, servo := range s.servos {
+		if s.calibrating(name) {
+			continue //Held at the calibration pulse, nothing is driving it
+		}
+		err := servo.ApplyFailsafe(elapsed)
+		if err != nil {
+			return fmt.Errorf("error setting %s servo to failsafe: %w", servo.config.Name, err)
+		}
+	}
+	_, err := s.Flush()
+	return err
+}
+
+// Like Failsafe, but every servo goes through ApplyEstop so nothing keeps driving the car
+func (s *ServoController) Estop(elapsed time.Duration) error {
+	for name, servo := range s.servos {
+		if s.calibrating(name) {
+			continue
+		}
+		err := servo.ApplyEstop(elapsed)
+		if err != nil {
+			return fmt.Errorf("error setting %s servo to estop: %w", servo.config.Name, err)
+		}
+	}
+	_, err := s.Flush()
+	return err
 }
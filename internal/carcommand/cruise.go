@@ -0,0 +1,159 @@
+package carcommand
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Values of Command.Cruise, buttons act when the value changes so holding one only acts once
+const (
+	CruiseNone   = 0
+	CruiseToggle = 1 //Latches the current throttle, or drops out if already cruising
+	CruiseUp     = 2
+	CruiseDown   = 3
+	CruiseCancel = 4
+)
+
+const CruiseStep = 5           //How far each nudge moves the latched throttle
+const CruiseBrakeDeadZone = 10 //Throttle this far below center counts as braking
+
+type cruiseControl struct {
+	active      bool
+	value       int    //Latched throttle, in command values
+	gear        string //Gear when latched, changing it drops out
+	lastInput   int
+	targetSpeed float64 //Speed hold target in meters per second, 0 holds the throttle instead
+	pid         pidController
+	lastHold    time.Time
+}
+
+// Holds the throttle of cruising commands at the latched value, the driver can still give it more.
+// Cruise works on the command before mixing so it holds whatever the throttle drives.
+func (c *CarCommand) applyCruise(commands CommandGroup) CommandGroup {
+	var cruised map[string]Command //Copied on the first change, the caller keeps its commands
+	for name, command := range commands.Commands {
+		cruise, found := c.cruise[name]
+		if !found {
+			if command.Cruise == CruiseNone {
+				continue
+			}
+			cruise = &cruiseControl{}
+			c.cruise[name] = cruise
+		}
+
+		value := c.updateCruise(name, cruise, command)
+		if value == command.Value {
+			continue
+		}
+		if cruised == nil {
+			cruised = make(map[string]Command, len(commands.Commands))
+			for otherName, otherCommand := range commands.Commands {
+				cruised[otherName] = otherCommand
+			}
+		}
+		command.Value = value
+		cruised[name] = command
+	}
+
+	if cruised == nil {
+		return commands
+	}
+	return CommandGroup{Commands: cruised}
+}
+
+// Returns the throttle value to use
+func (c *CarCommand) updateCruise(name string, cruise *cruiseControl, command Command) int {
+	pressed := command.Cruise != cruise.lastInput && command.Cruise != CruiseNone
+	cruise.lastInput = command.Cruise
+	if cruise.active && cruise.gear == "" {
+		cruise.gear = command.Gear //Started by a control, holds in the gear the driver is in
+	}
+
+	if !cruise.active {
+		if !pressed || command.Cruise != CruiseToggle {
+			return command.Value
+		}
+		if command.Value <= MixInputMid+CruiseBrakeDeadZone {
+			log.Printf("warning: %s cruise needs forward throttle to latch (%d)\n", name, command.Value)
+			return command.Value
+		}
+		cruise.active = true
+		cruise.value = command.Value
+		cruise.gear = command.Gear
+		cruise.targetSpeed = 0
+		c.latchSpeedHold(name, cruise)
+		c.sendEvent(EventCruise, cruise.message(name))
+	} else if command.Value < MixInputMid-CruiseBrakeDeadZone {
+		c.stopCruise(name, "brake")
+		return command.Value
+	} else if command.Gear != "" && command.Gear != cruise.gear {
+		c.stopCruise(name, "gear change")
+		return command.Value
+	} else if pressed {
+		switch command.Cruise {
+		case CruiseToggle, CruiseCancel:
+			c.stopCruise(name, "cancelled")
+			return command.Value
+		case CruiseUp:
+			if cruise.targetSpeed > 0 {
+				cruise.targetSpeed += c.config.SpeedHold.Step
+			} else {
+				cruise.value += CruiseStep
+				if cruise.value > MixInputMax {
+					cruise.value = MixInputMax
+				}
+			}
+			c.sendEvent(EventCruise, cruise.message(name))
+		case CruiseDown:
+			if cruise.targetSpeed > 0 {
+				cruise.targetSpeed -= c.config.SpeedHold.Step
+			} else {
+				cruise.value -= CruiseStep
+			}
+			if cruise.value <= MixInputMid && cruise.targetSpeed <= 0 {
+				c.stopCruise(name, "nudged to a stop")
+				return command.Value
+			}
+			c.sendEvent(EventCruise, cruise.message(name))
+		}
+	}
+
+	if cruise.targetSpeed > 0 {
+		value, ok := c.holdSpeed(name, cruise)
+		if !ok {
+			c.stopCruise(name, "no speed reading")
+			return command.Value
+		}
+		cruise.value = value
+	}
+
+	if command.Value > cruise.value {
+		return command.Value //Driver asking for more than cruise
+	}
+	return cruise.value
+}
+
+func (c *CarCommand) stopCruise(name string, reason string) {
+	cruise, found := c.cruise[name]
+	if !found || !cruise.active {
+		return
+	}
+	cruise.active = false
+	cruise.targetSpeed = 0
+	c.sendEvent(EventCruise, fmt.Sprintf("%s off - %s", name, reason))
+}
+
+func (cruise *cruiseControl) message(name string) string {
+	if cruise.targetSpeed > 0 {
+		return fmt.Sprintf("%s on %.2fm/s", name, cruise.targetSpeed)
+	}
+	return fmt.Sprintf("%s on %d", name, cruise.value)
+}
+
+// Drops every cruise, for failsafe and disconnects
+func (c *CarCommand) stopAllCruise(reason string) {
+	for name := range c.cruise {
+		c.stopCruise(name, reason)
+	}
+}
@@ -0,0 +1,178 @@
+package carcommand
+
+import (
+	"testing"
+)
+
+func TestCruiseControl(t *testing.T) {
+	carCommand, _ := newSimCarCommand(t, testServoConfig("esc", TypeESC, 0))
+
+	steps := []struct {
+		name    string
+		command Command
+		value   int
+		active  bool
+	}{
+		{name: "driving", command: Command{Value: 200}, value: 200},
+		{name: "latch", command: Command{Value: 200, Cruise: CruiseToggle}, value: 200, active: true},
+		{name: "toggle_held", command: Command{Value: MixInputMid, Cruise: CruiseToggle}, value: 200, active: true},
+		{name: "hands_off", command: Command{Value: MixInputMid}, value: 200, active: true},
+		{name: "nudge_up", command: Command{Value: MixInputMid, Cruise: CruiseUp}, value: 200 + CruiseStep, active: true},
+		{name: "nudge_held", command: Command{Value: MixInputMid, Cruise: CruiseUp}, value: 200 + CruiseStep, active: true},
+		{name: "driver_override", command: Command{Value: 240}, value: 240, active: true},
+		{name: "nudge_down", command: Command{Value: MixInputMid, Cruise: CruiseDown}, value: 200, active: true},
+		{name: "brake", command: Command{Value: 100}, value: 100},
+		{name: "latch_needs_throttle", command: Command{Value: MixInputMid, Cruise: CruiseToggle}, value: MixInputMid},
+		{name: "release", command: Command{Value: MixInputMid}, value: MixInputMid},
+		{name: "latch_in_gear", command: Command{Value: 180, Gear: "1", Cruise: CruiseToggle}, value: 180, active: true},
+		{name: "same_gear", command: Command{Value: MixInputMid, Gear: "1"}, value: 180, active: true},
+		{name: "gear_change", command: Command{Value: MixInputMid, Gear: "2"}, value: MixInputMid},
+	}
+
+	for _, step := range steps {
+		commands := CommandGroup{Commands: map[string]Command{"esc": step.command, "steer": {Value: 20}}}
+		cruised := carCommand.applyCruise(commands)
+		if value := cruised.Commands["esc"].Value; value != step.value {
+			t.Errorf("%s: expected throttle %d, got %d", step.name, step.value, value)
+		}
+		if cruised.Commands["steer"].Value != 20 {
+			t.Errorf("%s: expected steer to pass through", step.name)
+		}
+		if commands.Commands["esc"] != step.command {
+			t.Errorf("%s: expected the caller's commands to be left alone", step.name)
+		}
+		cruise, found := carCommand.cruise["esc"]
+		if active := found && cruise.active; active != step.active {
+			t.Errorf("%s: expected cruise active %t, got %t", step.name, step.active, active)
+		}
+	}
+}
+
+func TestCruiseDropsOut(t *testing.T) {
+	tests := map[string]func(carCommand *CarCommand){
+		"failsafe": func(carCommand *CarCommand) {
+			carCommand.stopAllCruise("failsafe")
+		},
+		"disconnect": func(carCommand *CarCommand) {
+			err := carCommand.DoControl(ControlCommand{Type: ControlCruiseCancel})
+			if err != nil {
+				t.Fatalf("failed cancelling cruise: %s", err)
+			}
+		},
+		"cancel_button": func(carCommand *CarCommand) {
+			carCommand.applyCruise(CommandGroup{Commands: map[string]Command{"esc": {Value: MixInputMid, Cruise: CruiseCancel}}})
+		},
+		"nudged_to_stop": func(carCommand *CarCommand) {
+			for i := 0; i < 10; i++ {
+				carCommand.applyCruise(CommandGroup{Commands: map[string]Command{"esc": {Value: MixInputMid, Cruise: CruiseDown}}})
+				carCommand.applyCruise(CommandGroup{Commands: map[string]Command{"esc": {Value: MixInputMid}}})
+			}
+		},
+	}
+
+	for testName, dropOut := range tests {
+		t.Run(testName, func(t *testing.T) {
+			carCommand, _ := newSimCarCommand(t, testServoConfig("esc", TypeESC, 0))
+			carCommand.applyCruise(CommandGroup{Commands: map[string]Command{"esc": {Value: 150, Cruise: CruiseToggle}}})
+			assertEvent(t, carCommand, EventCruise)
+
+			dropOut(carCommand)
+			if carCommand.cruise["esc"].active {
+				t.Fatalf("expected cruise to drop out")
+			}
+			cruised := carCommand.applyCruise(CommandGroup{Commands: map[string]Command{"esc": {Value: MixInputMid}}})
+			if cruised.Commands["esc"].Value != MixInputMid {
+				t.Errorf("expected throttle to return to the driver, got %d", cruised.Commands["esc"].Value)
+			}
+		})
+	}
+}
+
+func newSpeedHoldCarCommand(t *testing.T) (*CarCommand, *fakeSpeedSource) {
+	t.Helper()
+	carCommand, _ := newSimCarCommand(t, testServoConfig("esc", TypeESC, 0))
+	carCommand.config.SpeedHold = SpeedHoldConfig{PID: PIDConfig{Kp: 0.5, Ki: 0.5}, Step: 0.25, MaxThrottle: 100}
+	speed := &fakeSpeedSource{speed: 1}
+	err := carCommand.SetSpeedSource("esc", speed)
+	if err != nil {
+		t.Fatalf("failed setting speed source: %s", err)
+	}
+	return carCommand, speed
+}
+
+func assertCruiseEvent(t *testing.T, carCommand *CarCommand, value string) {
+	t.Helper()
+	select {
+	case event := <-carCommand.EventChannel:
+		if event.Type != EventCruise || event.Message != value {
+			t.Errorf("expected cruise event %s, got %+v", value, event)
+		}
+	default:
+		t.Errorf("expected cruise event %s, got none", value)
+	}
+}
+
+func TestCruiseSpeedHold(t *testing.T) {
+	carCommand, speed := newSpeedHoldCarCommand(t)
+	cruise := func(command Command) int {
+		return carCommand.applyCruise(CommandGroup{Commands: map[string]Command{"esc": command}}).Commands["esc"].Value
+	}
+
+	cruise(Command{Value: 191, Cruise: CruiseToggle})
+	assertCruiseEvent(t, carCommand, "esc on 1.00m/s")
+
+	speed.speed = 0.5 //Climbing
+	if value := cruise(Command{Value: MixInputMid}); value <= 191 {
+		t.Errorf("expected more throttle than latched to hold speed up a hill, got %d", value)
+	}
+
+	err := carCommand.setDriverLimits(&DriverLimits{MaxThrottle: 40})
+	if err != nil {
+		t.Fatalf("failed setting max throttle: %s", err)
+	}
+	assertGearEvent(t, carCommand, NeutralKey) //First gear report
+	if value := cruise(Command{Value: MixInputMid}); value != MixInputMid+51 {
+		t.Errorf("expected speed hold capped at the driver's 40%% (%d), got %d", MixInputMid+51, value)
+	}
+
+	cruise(Command{Value: MixInputMid, Cruise: CruiseUp})
+	assertCruiseEvent(t, carCommand, "esc on 1.25m/s")
+
+	speed.lost = true
+	if value := cruise(Command{Value: MixInputMid}); value != MixInputMid {
+		t.Errorf("expected the driver's throttle once speed is lost, got %d", value)
+	}
+	assertCruiseEvent(t, carCommand, "esc off - no speed reading")
+}
+
+func TestCruiseSpeedControl(t *testing.T) {
+	carCommand, speed := newSpeedHoldCarCommand(t)
+	err := carCommand.DoControl(ControlCommand{Type: ControlCruiseSpeed, Servo: "esc", Value: 150})
+	if err != nil {
+		t.Fatalf("failed setting cruise speed: %s", err)
+	}
+	assertCruiseEvent(t, carCommand, "esc on 1.50m/s")
+
+	speed.speed = 0
+	value := carCommand.applyCruise(CommandGroup{Commands: map[string]Command{"esc": {Value: MixInputMid, Gear: "1"}}}).Commands["esc"].Value
+	if value <= MixInputMid {
+		t.Errorf("expected throttle to get up to speed, got %d", value)
+	}
+	if carCommand.cruise["esc"].gear != "1" {
+		t.Errorf("expected cruise to hold the driver's gear, got %q", carCommand.cruise["esc"].gear)
+	}
+
+	err = carCommand.DoControl(ControlCommand{Type: ControlCruiseSpeed, Servo: "esc", Value: 0})
+	if err != nil {
+		t.Fatalf("failed stopping cruise: %s", err)
+	}
+	assertCruiseEvent(t, carCommand, "esc off - cancelled")
+
+	if carCommand.DoControl(ControlCommand{Type: ControlCruiseSpeed, Servo: "steer", Value: 100}) == nil {
+		t.Errorf("expected an error for a servo without a speed source")
+	}
+	carCommand.config.SpeedHold = SpeedHoldConfig{}
+	if carCommand.DoControl(ControlCommand{Type: ControlCruiseSpeed, Servo: "esc", Value: 100}) == nil {
+		t.Errorf("expected an error without speed hold configured")
+	}
+}
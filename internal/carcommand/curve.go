@@ -0,0 +1,168 @@
+package carcommand
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const MaxCurvePercent = 100
+
+// ResponseCurve shapes a servo's input before it is scaled, all values are a percent of travel from mid
+type ResponseCurve struct {
+	Expo   int          //0-100, softens the response around center
+	Rates  []int        //1-100, endpoint limits the driver can switch between at runtime
+	Points []CurvePoint //Optional lookup table, replaces expo when set
+}
+
+type CurvePoint struct {
+	In  int //-100 to 100
+	Out int //-100 to 100
+}
+
+func (c ResponseCurve) Validate() error {
+	if c.Expo < 0 || c.Expo > MaxCurvePercent {
+		return fmt.Errorf("expo out of range (%d)", c.Expo)
+	}
+	for _, rate := range c.Rates {
+		if rate < 1 || rate > MaxCurvePercent {
+			return fmt.Errorf("rate out of range (%d)", rate)
+		}
+	}
+	if len(c.Points) == 0 {
+		return nil
+	}
+	if len(c.Points) < 2 {
+		return fmt.Errorf("curve needs at least 2 points")
+	}
+	for i, point := range c.Points {
+		if point.In < -MaxCurvePercent || point.In > MaxCurvePercent || point.Out < -MaxCurvePercent || point.Out > MaxCurvePercent {
+			return fmt.Errorf("curve point out of range (%d:%d)", point.In, point.Out)
+		}
+		if i > 0 && point.In <= c.Points[i-1].In {
+			return fmt.Errorf("curve points must be in increasing order (%d)", point.In)
+		}
+	}
+	if c.Points[0].In != -MaxCurvePercent || c.Points[len(c.Points)-1].In != MaxCurvePercent {
+		return fmt.Errorf("curve must start at -%d and end at %d", MaxCurvePercent, MaxCurvePercent)
+	}
+	return nil
+}
+
+// Takes an input from -1 to 1 and returns the shaped output from -1 to 1
+func (c ResponseCurve) apply(input float64, rateIndex int, enabled bool) float64 {
+	output := input
+	if enabled {
+		if len(c.Points) > 0 {
+			output = c.lookup(input)
+		} else if c.Expo > 0 {
+			expo := float64(c.Expo) / MaxCurvePercent
+			output = expo*math.Pow(input, 3) + (1-expo)*input
+		}
+	}
+
+	if rateIndex >= 0 && rateIndex < len(c.Rates) {
+		output = output * float64(c.Rates[rateIndex]) / MaxCurvePercent
+	}
+	return output
+}
+
+// Linear interpolation between the two points surrounding the input
+func (c ResponseCurve) lookup(input float64) float64 {
+	percent := input * MaxCurvePercent
+	i := sort.Search(len(c.Points), func(i int) bool {
+		return float64(c.Points[i].In) >= percent
+	})
+	if i == 0 {
+		return float64(c.Points[0].Out) / MaxCurvePercent
+	}
+	if i == len(c.Points) {
+		return float64(c.Points[len(c.Points)-1].Out) / MaxCurvePercent
+	}
+	low := c.Points[i-1]
+	high := c.Points[i]
+	ratio := (percent - float64(low.In)) / float64(high.In-low.In)
+	return (float64(low.Out) + ratio*float64(high.Out-low.Out)) / MaxCurvePercent
+}
+
+// Parses a comma separated list of percents, used for rates and gear ratios (100,70,40)
+func ParsePercents(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	rates := make([]int, 0, len(parts))
+	for _, part := range parts {
+		rate, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid percent (%s) - %w", part, err)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+// Parses a comma separated list of in:out percent pairs (-100:-100,0:0,50:25,100:100)
+func ParseCurvePoints(value string) ([]CurvePoint, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	points := make([]CurvePoint, 0, len(parts))
+	for _, part := range parts {
+		pair := strings.Split(strings.TrimSpace(part), ":")
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid curve point (%s)", part)
+		}
+		in, err := strconv.Atoi(pair[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid curve point input (%s) - %w", part, err)
+		}
+		out, err := strconv.Atoi(pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid curve point output (%s) - %w", part, err)
+		}
+		points = append(points, CurvePoint{In: in, Out: out})
+	}
+	return points, nil
+}
+
+// Applies the servo's response curve to a value in its min to max range
+func (s *Servo) getValueWithCurve(value int) int {
+	mid := s.config.MidValue
+	input := 0.0
+	if value > mid {
+		input = float64(value-mid) / float64(s.config.MaxValue-mid)
+	} else if value < mid {
+		input = float64(value-mid) / float64(mid-s.config.MinValue)
+	}
+
+	output := s.config.Curve.apply(input, s.rateIndex, s.curveEnabled)
+	if output > 0 {
+		return mid + int(math.Round(output*float64(s.config.MaxValue-mid)))
+	} else if output < 0 {
+		return mid + int(math.Round(output*float64(mid-s.config.MinValue)))
+	}
+	return mid
+}
+
+// Selects which configured rate is used, wraps around so the client can cycle through them
+func (s *Servo) SetRate(index int) {
+	if len(s.config.Curve.Rates) == 0 {
+		return
+	}
+	if index < 0 {
+		index = 0
+	}
+	s.rateIndex = index % len(s.config.Curve.Rates)
+	log.Printf("%s rate set to %d%%\n", s.config.Name, s.config.Curve.Rates[s.rateIndex])
+}
+
+// Turns the expo or lookup table on or off, rates still apply
+func (s *Servo) SetCurveEnabled(enabled bool) {
+	s.curveEnabled = enabled
+	log.Printf("%s curve enabled: %t\n", s.config.Name, enabled)
+}
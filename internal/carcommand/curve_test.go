@@ -0,0 +1,101 @@
+package carcommand
+
+import (
+	"testing"
+)
+
+func TestServoCurve(t *testing.T) {
+	tests := map[string]struct {
+		curve        ResponseCurve
+		rateIndex    int
+		curveEnabled bool
+		value        int
+		expected     int
+	}{
+		"linear_passthrough": {
+			curveEnabled: true,
+			value:        200,
+			expected:     200,
+		},
+		"expo_softens_center": {
+			curve:        ResponseCurve{Expo: 50},
+			curveEnabled: true,
+			value:        191, //half throw
+			expected:     167, //0.5*0.125 + 0.5*0.5 = 0.3125 of throw
+		},
+		"expo_keeps_endpoints": {
+			curve:        ResponseCurve{Expo: 50},
+			curveEnabled: true,
+			value:        0,
+			expected:     0,
+		},
+		"expo_disabled": {
+			curve:        ResponseCurve{Expo: 50},
+			curveEnabled: false,
+			value:        191,
+			expected:     191,
+		},
+		"low_rate": {
+			curve:        ResponseCurve{Rates: []int{100, 50}},
+			rateIndex:    1,
+			curveEnabled: true,
+			value:        255,
+			expected:     191,
+		},
+		"rate_applies_with_curve_disabled": {
+			curve:        ResponseCurve{Expo: 50, Rates: []int{100, 50}},
+			rateIndex:    1,
+			curveEnabled: false,
+			value:        0,
+			expected:     63,
+		},
+		"lookup_table": {
+			curve:        ResponseCurve{Points: []CurvePoint{{In: -100, Out: -100}, {In: 0, Out: 0}, {In: 50, Out: 25}, {In: 100, Out: 100}}},
+			curveEnabled: true,
+			value:        191,
+			expected:     159,
+		},
+		"lookup_table_interpolates": {
+			curve:        ResponseCurve{Points: []CurvePoint{{In: -100, Out: -100}, {In: 0, Out: 0}, {In: 50, Out: 25}, {In: 100, Out: 100}}},
+			curveEnabled: true,
+			value:        223, //75%
+			expected:     207, //62.5%
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			servoCfg := testServoConfig("steer", TypeServo, 0)
+			servoCfg.Curve = tc.curve
+			servo := NewServo(servoCfg, NewSimDriver())
+			servo.rateIndex = tc.rateIndex
+			servo.curveEnabled = tc.curveEnabled
+
+			value := servo.getValueWithCurve(tc.value)
+			if value != tc.expected {
+				t.Errorf("got %d, expected %d", value, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseCurvePoints(t *testing.T) {
+	points, err := ParseCurvePoints("-100:-100, 0:0,50:25,100:100")
+	if err != nil {
+		t.Fatalf("failed parsing points: %s", err)
+	}
+	curve := ResponseCurve{Points: points}
+	if err := curve.Validate(); err != nil {
+		t.Errorf("expected valid curve, got %s", err)
+	}
+
+	_, err = ParseCurvePoints("0-0")
+	if err == nil {
+		t.Errorf("expected error parsing bad point")
+	}
+
+	curve = ResponseCurve{Points: []CurvePoint{{In: 0, Out: 0}, {In: 100, Out: 100}}}
+	if err := curve.Validate(); err == nil {
+		t.Errorf("expected curve that doesn't cover full travel to be invalid")
+	}
+}
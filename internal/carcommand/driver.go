@@ -0,0 +1,31 @@
+package carcommand
+
+import (
+	"fmt"
+)
+
+const DriverPCA9685 = "pca9685"
+const DriverSim = "sim"
+const DriverSysfsPWM = "sysfspwm"
+const DriverGPIO = "gpiochip"
+
+// OutputDriver is the hardware (or fake hardware) that servo pulses are written to
+type OutputDriver interface {
+	Init() error
+	SetPulse(channel int, pulse float32) error //pulse width in microseconds
+}
+
+func NewOutputDriver(cfg BoardConfig) (OutputDriver, error) {
+	switch cfg.Driver {
+	case DriverSim:
+		return NewSimDriver(), nil
+	case DriverPCA9685, "":
+		return NewPCA9685Driver(cfg.Address, cfg.I2CDevice, cfg.Frequency), nil
+	case DriverSysfsPWM:
+		return NewSysfsPWMDriver(cfg.Device, cfg.Frequency), nil
+	case DriverGPIO:
+		return NewGPIODriver(cfg.Device, cfg.Lines), nil
+	default:
+		return nil, fmt.Errorf("unsupported output driver (%s)", cfg.Driver)
+	}
+}
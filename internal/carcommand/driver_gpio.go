@@ -0,0 +1,85 @@
+package carcommand
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const DefaultGPIOChip = "/dev/gpiochip0"
+const DefaultGPIOThreshold = 1500 //Pulses at or above this many microseconds turn the line on
+
+// gpioLines is a set of requested output lines, set together in one call
+type gpioLines interface {
+	SetValues(values []byte) error
+	Close() error
+}
+
+// GPIODriver switches relays and other on/off outputs through the /dev/gpiochip character device,
+// channel n drives the nth configured line
+type GPIODriver struct {
+	device string
+	lines  []int
+	values []byte
+	handle gpioLines
+	open   func(device string, lines []int) (gpioLines, error) //Swapped out in tests
+}
+
+func NewGPIODriver(device string, lines []int) *GPIODriver {
+	if device == "" {
+		device = DefaultGPIOChip
+	}
+	return &GPIODriver{
+		device: device,
+		lines:  lines,
+		open:   openGPIOLines,
+	}
+}
+
+// Safe to call again after errors, the old line handle is released first
+func (d *GPIODriver) Init() error {
+	if d.handle != nil {
+		d.handle.Close()
+		d.handle = nil
+	}
+
+	handle, err := d.open(d.device, d.lines)
+	if err != nil {
+		return fmt.Errorf("failed requesting gpio lines %v on %s - %w", d.lines, d.device, err)
+	}
+	d.handle = handle
+	d.values = make([]byte, len(d.lines))
+	return nil
+}
+
+func (d *GPIODriver) SetPulse(channel int, pulse float32) error {
+	if d.handle == nil {
+		return fmt.Errorf("gpio not initialized")
+	}
+	if channel < 0 || channel >= len(d.lines) {
+		return fmt.Errorf("gpio channel out of range (%d)", channel)
+	}
+
+	d.values[channel] = 0
+	if pulse >= DefaultGPIOThreshold {
+		d.values[channel] = 1
+	}
+	return d.handle.SetValues(d.values)
+}
+
+// Parses a comma separated list of gpio line offsets (17,27,22)
+func ParseGPIOLines(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	lines := make([]int, 0, len(parts))
+	for _, part := range parts {
+		line, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || line < 0 {
+			return nil, fmt.Errorf("invalid gpio line (%s)", part)
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
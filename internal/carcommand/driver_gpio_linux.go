@@ -0,0 +1,79 @@
+//go:build linux
+
+package carcommand
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Line handle ioctls from linux/gpio.h (v1 ABI)
+const gpioHandlesMax = 64
+const gpioGetLineHandleIoctl = 0xc16cb403
+const gpioHandleSetLineValuesIoctl = 0xc040b409
+const gpioHandleRequestOutput = 1 << 1
+
+type gpioHandleRequest struct {
+	lineOffsets   [gpioHandlesMax]uint32
+	flags         uint32
+	defaultValues [gpioHandlesMax]uint8
+	consumerLabel [32]byte
+	lines         uint32
+	fd            int32
+}
+
+type gpioHandleData struct {
+	values [gpioHandlesMax]uint8
+}
+
+type gpioLineHandle struct {
+	file *os.File
+}
+
+// Requests the lines as outputs starting low
+func openGPIOLines(device string, lines []int) (gpioLines, error) {
+	if len(lines) == 0 || len(lines) > gpioHandlesMax {
+		return nil, fmt.Errorf("invalid number of gpio lines (%d)", len(lines))
+	}
+
+	chip, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer chip.Close()
+
+	request := gpioHandleRequest{
+		flags: gpioHandleRequestOutput,
+		lines: uint32(len(lines)),
+	}
+	for i, line := range lines {
+		request.lineOffsets[i] = uint32(line)
+	}
+	copy(request.consumerLabel[:], "goremotecontrol")
+
+	err = gpioIoctl(chip.Fd(), gpioGetLineHandleIoctl, unsafe.Pointer(&request))
+	if err != nil {
+		return nil, err
+	}
+	return &gpioLineHandle{file: os.NewFile(uintptr(request.fd), device)}, nil
+}
+
+func (h *gpioLineHandle) SetValues(values []byte) error {
+	data := gpioHandleData{}
+	copy(data.values[:], values)
+	return gpioIoctl(h.file.Fd(), gpioHandleSetLineValuesIoctl, unsafe.Pointer(&data))
+}
+
+func (h *gpioLineHandle) Close() error {
+	return h.file.Close()
+}
+
+func gpioIoctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package carcommand
+
+import (
+	"fmt"
+)
+
+func openGPIOLines(device string, lines []int) (gpioLines, error) {
+	return nil, fmt.Errorf("gpio character devices are only supported on linux")
+}
@@ -0,0 +1,92 @@
+package carcommand
+
+import (
+	"fmt"
+
+	"github.com/googolgl/go-i2c"
+	"github.com/googolgl/go-pca9685"
+)
+
+type PCA9685Driver struct {
+	address   byte
+	i2cDevice string
+	frequency float32
+	bus       *i2c.Options
+	pca       *pca9685.PCA9685
+}
+
+func NewPCA9685Driver(address byte, i2cDevice string, frequency float32) *PCA9685Driver {
+	return &PCA9685Driver{
+		address:   address,
+		i2cDevice: i2cDevice,
+		frequency: frequency,
+	}
+}
+
+// Safe to call again after bus errors, the old connection is closed first
+func (d *PCA9685Driver) Init() error {
+	if d.bus != nil {
+		d.bus.Close()
+		d.bus = nil
+		d.pca = nil
+	}
+
+	i2c, err := i2c.New(d.address, d.i2cDevice)
+	if err != nil {
+		return fmt.Errorf("error starting i2c with address - %w", err)
+	}
+
+	pca, err := pca9685.New(i2c, nil) //Also turns on register auto increment, SetPulses needs it
+	if err != nil {
+		i2c.Close()
+		return fmt.Errorf("error getting servo driver - %w", err)
+	}
+	if d.frequency > 0 {
+		err = pca.SetFreq(d.frequency)
+		if err != nil {
+			i2c.Close()
+			return fmt.Errorf("error setting pwm frequency %.0f - %w", d.frequency, err)
+		}
+	}
+	d.pca = pca
+	d.bus = i2c
+	return nil
+}
+
+// Converts the pulse width to a 12 bit duty cycle the same way pca9685.Servo.Fraction does
+func (d *PCA9685Driver) SetPulse(channel int, pulse float32) error {
+	if d.pca == nil {
+		return fmt.Errorf("pca9685 not initialized")
+	}
+	return d.pca.SetChannel(channel, 0, d.getDutyCycle(pulse))
+}
+
+// Writes a run of channels starting at startChannel with one auto increment write
+func (d *PCA9685Driver) SetPulses(startChannel int, pulses []float32) error {
+	if d.pca == nil {
+		return fmt.Errorf("pca9685 not initialized")
+	}
+	if startChannel < 0 || startChannel+len(pulses) > ChannelsPerBoard {
+		return fmt.Errorf("invalid channel range (%d-%d)", startChannel, startChannel+len(pulses)-1)
+	}
+
+	buf := make([]byte, 0, 1+4*len(pulses))
+	buf = append(buf, pca9685.Led0On+byte(4*startChannel))
+	for _, pulse := range pulses {
+		off := d.getDutyCycle(pulse)
+		buf = append(buf, 0, 0, byte(off)&0xFF, byte(off>>8)) //on is always 0
+	}
+	_, err := d.bus.WriteBytes(buf)
+	return err
+}
+
+func (d *PCA9685Driver) getDutyCycle(pulse float32) int {
+	dutyCycle := (int(pulse*d.pca.GetFreq()/1000000*0xFFFF) + 1) >> 4
+	if dutyCycle < 0 {
+		return 0
+	}
+	if dutyCycle > int(pca9685.StepCount) {
+		return int(pca9685.StepCount)
+	}
+	return dutyCycle
+}
@@ -0,0 +1,107 @@
+package carcommand
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SimDriver records every pulse written to it so the command path can run without an I2C bus
+type SimDriver struct {
+	lock    sync.RWMutex
+	pulses  map[int][]PulseRecord
+	writes  int
+	failing bool //Acts like the bus is disconnected
+}
+
+type PulseRecord struct {
+	Time  time.Time
+	Pulse float32
+}
+
+func NewSimDriver() *SimDriver {
+	return &SimDriver{
+		pulses: make(map[int][]PulseRecord, ChannelsPerBoard),
+	}
+}
+
+func (d *SimDriver) Init() error {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.failing {
+		return fmt.Errorf("sim bus disconnected")
+	}
+	return nil
+}
+
+// Makes every init and write fail until set back to false
+func (d *SimDriver) SetFailing(failing bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.failing = failing
+}
+
+func (d *SimDriver) SetPulse(channel int, pulse float32) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.failing {
+		return fmt.Errorf("sim bus disconnected")
+	}
+	d.writes++
+	d.record(channel, pulse)
+	return nil
+}
+
+func (d *SimDriver) SetPulses(startChannel int, pulses []float32) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.failing {
+		return fmt.Errorf("sim bus disconnected")
+	}
+	d.writes++
+	for i, pulse := range pulses {
+		d.record(startChannel+i, pulse)
+	}
+	return nil
+}
+
+func (d *SimDriver) record(channel int, pulse float32) {
+	d.pulses[channel] = append(d.pulses[channel], PulseRecord{
+		Time:  time.Now(),
+		Pulse: pulse,
+	})
+}
+
+// Number of bus writes, a batch of channels counts as one
+func (d *SimDriver) Writes() int {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.writes
+}
+
+// Returns a copy of every pulse written to the channel, oldest first
+func (d *SimDriver) Pulses(channel int) []PulseRecord {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	records := make([]PulseRecord, len(d.pulses[channel]))
+	copy(records, d.pulses[channel])
+	return records
+}
+
+// Returns the most recent pulse written to the channel
+func (d *SimDriver) LastPulse(channel int) (float32, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	records := d.pulses[channel]
+	if len(records) == 0 {
+		return 0, false
+	}
+	return records[len(records)-1].Pulse, true
+}
+
+func (d *SimDriver) Reset() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.pulses = make(map[int][]PulseRecord, ChannelsPerBoard)
+	d.writes = 0
+}
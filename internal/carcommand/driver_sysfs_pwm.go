@@ -0,0 +1,94 @@
+package carcommand
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const DefaultPWMChip = "/sys/class/pwm/pwmchip0"
+const DefaultPWMFrequency = 50 //Hz
+
+// SysfsPWMDriver drives the SoC's hardware PWM pins through /sys/class/pwm, channels are the chip's pwm numbers
+type SysfsPWMDriver struct {
+	chip     string //pwmchip directory, tests point this at a fake tree
+	period   time.Duration
+	exported map[int]bool
+}
+
+func NewSysfsPWMDriver(chip string, frequency float32) *SysfsPWMDriver {
+	if chip == "" {
+		chip = DefaultPWMChip
+	}
+	if frequency <= 0 {
+		frequency = DefaultPWMFrequency
+	}
+	return &SysfsPWMDriver{
+		chip:   chip,
+		period: time.Duration(float64(time.Second) / float64(frequency)),
+	}
+}
+
+// Channels get exported the first time they are written, so this only checks the chip is there
+func (d *SysfsPWMDriver) Init() error {
+	_, err := os.Stat(d.chip)
+	if err != nil {
+		return fmt.Errorf("pwm chip not found - %w", err)
+	}
+	d.exported = make(map[int]bool, ChannelsPerBoard)
+	return nil
+}
+
+func (d *SysfsPWMDriver) SetPulse(channel int, pulse float32) error {
+	if d.exported == nil {
+		return fmt.Errorf("sysfs pwm not initialized")
+	}
+	if !d.exported[channel] {
+		err := d.export(channel)
+		if err != nil {
+			return err
+		}
+		d.exported[channel] = true
+	}
+
+	dutyCycle := time.Duration(pulse * float32(time.Microsecond))
+	if dutyCycle > d.period {
+		dutyCycle = d.period
+	}
+	return d.write(channel, "duty_cycle", strconv.FormatInt(dutyCycle.Nanoseconds(), 10))
+}
+
+// Exports the channel if the kernel hasn't already, then sets the period and turns it on
+func (d *SysfsPWMDriver) export(channel int) error {
+	_, err := os.Stat(d.channelPath(channel))
+	if os.IsNotExist(err) {
+		err = os.WriteFile(filepath.Join(d.chip, "export"), []byte(strconv.Itoa(channel)), 0)
+		if err != nil {
+			return fmt.Errorf("failed exporting pwm%d - %w", channel, err)
+		}
+		_, err = os.Stat(d.channelPath(channel))
+	}
+	if err != nil {
+		return fmt.Errorf("pwm%d not available - %w", channel, err)
+	}
+
+	err = d.write(channel, "period", strconv.FormatInt(d.period.Nanoseconds(), 10))
+	if err != nil {
+		return err
+	}
+	return d.write(channel, "enable", "1")
+}
+
+func (d *SysfsPWMDriver) channelPath(channel int) string {
+	return filepath.Join(d.chip, fmt.Sprintf("pwm%d", channel))
+}
+
+func (d *SysfsPWMDriver) write(channel int, file string, value string) error {
+	err := os.WriteFile(filepath.Join(d.channelPath(channel), file), []byte(value), 0)
+	if err != nil {
+		return fmt.Errorf("failed writing pwm%d %s - %w", channel, file, err)
+	}
+	return nil
+}
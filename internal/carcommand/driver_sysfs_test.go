@@ -0,0 +1,146 @@
+package carcommand
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Makes a pwmchip directory with the given channels already exported
+func fakePWMChip(t *testing.T, exported ...string) string {
+	t.Helper()
+	chip := filepath.Join(t.TempDir(), "pwmchip0")
+	for _, channel := range exported {
+		err := os.MkdirAll(filepath.Join(chip, channel), 0755)
+		if err != nil {
+			t.Fatalf("failed making fake pwm tree: %s", err)
+		}
+	}
+	err := os.MkdirAll(chip, 0755)
+	if err != nil {
+		t.Fatalf("failed making fake pwm tree: %s", err)
+	}
+	return chip
+}
+
+func assertSysfsValue(t *testing.T, chip string, file string, expected string) {
+	t.Helper()
+	value, err := os.ReadFile(filepath.Join(chip, file))
+	if err != nil {
+		t.Errorf("failed reading %s: %s", file, err)
+		return
+	}
+	if strings.TrimSpace(string(value)) != expected {
+		t.Errorf("expected %s to be %s, got %s", file, expected, value)
+	}
+}
+
+func TestSysfsPWMDriver(t *testing.T) {
+	chip := fakePWMChip(t, "pwm1")
+	driver := NewSysfsPWMDriver(chip, 50)
+	err := driver.Init()
+	if err != nil {
+		t.Fatalf("failed init: %s", err)
+	}
+
+	err = driver.SetPulse(1, 1500)
+	if err != nil {
+		t.Fatalf("failed setting pulse: %s", err)
+	}
+	assertSysfsValue(t, chip, "pwm1/period", "20000000")
+	assertSysfsValue(t, chip, "pwm1/enable", "1")
+	assertSysfsValue(t, chip, "pwm1/duty_cycle", "1500000")
+
+	err = driver.SetPulse(1, 30000)
+	if err != nil {
+		t.Fatalf("failed setting pulse: %s", err)
+	}
+	assertSysfsValue(t, chip, "pwm1/duty_cycle", "20000000")
+
+	//pwm0 was never exported and the fake export file doesn't create it
+	err = driver.SetPulse(0, 1500)
+	if err == nil {
+		t.Errorf("expected error for a channel that can't be exported")
+	}
+	assertSysfsValue(t, chip, "export", "0")
+
+	if NewSysfsPWMDriver(filepath.Join(chip, "missing"), 50).Init() == nil {
+		t.Errorf("expected error for a missing pwm chip")
+	}
+}
+
+type fakeGPIOLines struct {
+	values [][]byte
+	closed bool
+}
+
+func (f *fakeGPIOLines) SetValues(values []byte) error {
+	f.values = append(f.values, append([]byte(nil), values...))
+	return nil
+}
+
+func (f *fakeGPIOLines) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestGPIODriver(t *testing.T) {
+	lines := &fakeGPIOLines{}
+	driver := NewGPIODriver("", []int{17, 27})
+	driver.open = func(device string, offsets []int) (gpioLines, error) {
+		if device != DefaultGPIOChip {
+			t.Errorf("expected default chip, got %s", device)
+		}
+		return lines, nil
+	}
+	err := driver.Init()
+	if err != nil {
+		t.Fatalf("failed init: %s", err)
+	}
+
+	tests := []struct {
+		channel  int
+		pulse    float32
+		expected []byte
+	}{
+		{channel: 1, pulse: 2000, expected: []byte{0, 1}},
+		{channel: 0, pulse: DefaultGPIOThreshold, expected: []byte{1, 1}},
+		{channel: 1, pulse: 1000, expected: []byte{1, 0}},
+	}
+	for _, tc := range tests {
+		err = driver.SetPulse(tc.channel, tc.pulse)
+		if err != nil {
+			t.Fatalf("failed setting pulse: %s", err)
+		}
+		last := lines.values[len(lines.values)-1]
+		if string(last) != string(tc.expected) {
+			t.Errorf("channel %d pulse %.0f: expected %v, got %v", tc.channel, tc.pulse, tc.expected, last)
+		}
+	}
+
+	if driver.SetPulse(2, 2000) == nil {
+		t.Errorf("expected error for a channel without a line")
+	}
+
+	err = driver.Init()
+	if err != nil {
+		t.Fatalf("failed reinit: %s", err)
+	}
+	if !lines.closed {
+		t.Errorf("expected old lines to be released on reinit")
+	}
+}
+
+func TestParseGPIOLines(t *testing.T) {
+	lines, err := ParseGPIOLines("17, 27,22")
+	if err != nil {
+		t.Fatalf("failed parsing lines: %s", err)
+	}
+	if len(lines) != 3 || lines[0] != 17 || lines[1] != 27 || lines[2] != 22 {
+		t.Errorf("unexpected lines %v", lines)
+	}
+	if _, err := ParseGPIOLines("17,x"); err == nil {
+		t.Errorf("expected error for invalid line")
+	}
+}
@@ -0,0 +1,188 @@
+package carcommand
+
+import (
+	"fmt"
+	"time"
+)
+
+type EscMode string
+
+const (
+	EscModeFR  EscMode = "fr"  //Forward/Reverse, reverse input drives backwards right away
+	EscModeFB  EscMode = "fb"  //Forward/Brake, reverse input only ever brakes
+	EscModeFBR EscMode = "fbr" //Forward/Brake/Reverse, first reverse input brakes and reverse needs a neutral in between
+)
+
+const DefaultEscMode = EscModeFR
+const DefaultBrakePulse = 100 * time.Millisecond
+const DefaultNeutralPulse = 100 * time.Millisecond
+const MaxBrakePercent = 100
+
+// What we think the ESC is doing, only used in fbr mode
+type escState int
+
+const (
+	escForward escState = iota //Driving forward or stopped, reverse input will brake
+	escBraking                 //ESC is treating reverse input as brake
+	escArming                  //Sending neutral so the ESC switches over to reverse
+	escReverse                 //ESC is in reverse, reverse input drives backwards
+)
+
+// Swapped out in tests
+var timeNow = time.Now
+
+type EscConfig struct {
+	Mode          EscMode
+	BrakePulse    time.Duration //How long to brake before the neutral pulse when reverse is asked for
+	NeutralPulse  time.Duration //How long the ESC needs to see neutral before it will reverse
+	DragBrake     int           //Percent of full brake held at neutral throttle in a forward gear, 0 coasts
+	HillHold      time.Duration //How long to brake harder after letting off the throttle, 0 turns it off
+	HillHoldBrake int           //Percent of full brake while hill hold is on
+}
+
+func ParseEscMode(value string) (EscMode, error) {
+	mode := EscMode(value)
+	switch mode {
+	case EscModeFR, EscModeFB, EscModeFBR:
+		return mode, nil
+	default:
+		return mode, fmt.Errorf("unsupported esc mode (%s)", value)
+	}
+}
+
+// An empty mode is allowed and uses the default
+func (c EscConfig) Validate() error {
+	if c.Mode != "" {
+		_, err := ParseEscMode(string(c.Mode))
+		if err != nil {
+			return err
+		}
+	}
+	if c.BrakePulse < 0 || c.NeutralPulse < 0 {
+		return fmt.Errorf("esc pulse durations can't be negative")
+	}
+	if c.DragBrake < 0 || c.DragBrake > MaxBrakePercent || c.HillHoldBrake < 0 || c.HillHoldBrake > MaxBrakePercent {
+		return fmt.Errorf("brake percents out of range (drag %d | hill hold %d)", c.DragBrake, c.HillHoldBrake)
+	}
+	if c.HillHold < 0 {
+		return fmt.Errorf("hill hold time can't be negative")
+	}
+	if c.HillHold > 0 && c.HillHoldBrake == 0 {
+		return fmt.Errorf("hill hold needs a brake percent")
+	}
+	mode := c.Mode
+	if mode == "" {
+		mode = DefaultEscMode
+	}
+	if (c.DragBrake > 0 || c.HillHold > 0) && mode == EscModeFR {
+		return fmt.Errorf("drag brake and hill hold need an esc mode that brakes (fb or fbr)")
+	}
+	return nil
+}
+
+// Takes the output from the gearing and changes it to match how the ESC model handles reverse
+func (s *Servo) getValueWithEscMode(value int) int {
+	if s.config.Esc.Mode != EscModeFBR {
+		return value //fb ESCs can only brake and fr ESCs reverse right away, so the gearing output is already right
+	}
+
+	mid := s.config.MidValue
+	forwardSide := value > mid
+	reverseSide := value < mid
+	if s.config.Inverted {
+		forwardSide, reverseSide = reverseSide, forwardSide
+	}
+
+	now := timeNow()
+	if forwardSide {
+		s.escState = escForward
+		return value
+	}
+
+	if !reverseSide { //Neutral
+		if s.escState == escBraking {
+			s.escState = escArming
+			s.escStateStart = now
+		}
+		return value
+	}
+
+	if s.transmission.gear != ReverseKey { //Driver wants to brake
+		if s.escState == escReverse {
+			return mid //ESC would drive backwards instead of braking
+		}
+		s.escState = escBraking
+		s.escStateStart = now
+		return value
+	}
+
+	//Driver wants reverse, walk the ESC through brake and neutral first
+	switch s.escState {
+	case escForward:
+		s.escState = escBraking
+		s.escStateStart = now
+		return value
+	case escBraking:
+		if now.Sub(s.escStateStart) < s.config.Esc.BrakePulse {
+			return value
+		}
+		s.escState = escArming
+		s.escStateStart = now
+		return mid
+	case escArming:
+		if now.Sub(s.escStateStart) < s.config.Esc.NeutralPulse {
+			return mid
+		}
+		s.escState = escReverse
+		return value
+	default:
+		return value
+	}
+}
+
+// Brakes a little at neutral throttle in a forward gear so the car doesn't coast or roll back.
+// Letting off the throttle starts hill hold, which brakes harder for a moment first.
+// input is the command before gearing, value is the geared output.
+func (s *Servo) getValueWithDragBrake(value int, input int) int {
+	if s.config.Esc.DragBrake == 0 && s.config.Esc.HillHold == 0 {
+		return value
+	}
+
+	mid := s.config.MidValue
+	input = getValueWithDeadZone(input, mid, s.config.DeadZone)
+	if input > mid {
+		s.escThrottled = true
+		return value
+	}
+	if input < mid { //Driver is braking or reversing on their own
+		s.escThrottled = false
+		s.hillHoldUntil = time.Time{}
+		return value
+	}
+
+	now := timeNow()
+	if s.escThrottled {
+		s.escThrottled = false
+		s.hillHoldUntil = now.Add(s.config.Esc.HillHold)
+	}
+
+	gear := s.transmission.gear
+	if gear == ReverseKey || gear == NeutralKey {
+		return value //Brake in reverse would drive forward, neutral is meant to roll
+	}
+	if now.Before(s.hillHoldUntil) {
+		return s.brakeValue(s.config.Esc.HillHoldBrake)
+	}
+	if s.config.Esc.DragBrake > 0 {
+		return s.brakeValue(s.config.Esc.DragBrake)
+	}
+	return value
+}
+
+// Output value for a percent of full brake, brake is the reverse side of mid
+func (s *Servo) brakeValue(percent int) int {
+	if s.config.Inverted {
+		return s.config.MidValue + (s.config.MaxValue-s.config.MidValue)*percent/MaxBrakePercent
+	}
+	return s.config.MidValue - (s.config.MidValue-s.config.MinValue)*percent/MaxBrakePercent
+}
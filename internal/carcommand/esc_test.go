@@ -0,0 +1,297 @@
+package carcommand
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEscBrakeReverse(t *testing.T) {
+	clock := time.Now()
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	type step struct {
+		gear    string
+		value   int
+		advance time.Duration
+		pulse   float32
+	}
+	tests := map[string]struct {
+		mode  EscMode
+		steps []step
+	}{
+		"fr_reverses_right_away": {
+			mode: EscModeFR,
+			steps: []step{
+				{gear: "R", value: 0, pulse: 1000},
+			},
+		},
+		"fbr_brakes_then_neutral_then_reverse": {
+			mode: EscModeFBR,
+			steps: []step{
+				{gear: "R", value: 0, pulse: 1000},                                   //ESC brakes
+				{gear: "R", value: 0, advance: 50 * time.Millisecond, pulse: 1000},   //still braking
+				{gear: "R", value: 0, advance: 50 * time.Millisecond, pulse: 1498},   //neutral pulse
+				{gear: "R", value: 0, advance: 50 * time.Millisecond, pulse: 1498},   //still neutral
+				{gear: "R", value: 0, advance: 50 * time.Millisecond, pulse: 1000},   //reversing
+				{gear: "R", value: 127, advance: 50 * time.Millisecond, pulse: 1498}, //stays in reverse through neutral
+				{gear: "R", value: 0, pulse: 1000},
+			},
+		},
+		"fbr_brake_then_release_arms_reverse": {
+			mode: EscModeFBR,
+			steps: []step{
+				{gear: "1", value: 0, pulse: 1000},                                  //brake in forward gear
+				{gear: "1", value: 127, pulse: 1498},                                //release, ESC sees neutral
+				{gear: "R", value: 0, advance: 100 * time.Millisecond, pulse: 1000}, //already armed so reverse right away
+			},
+		},
+		"fbr_no_brake_while_reversing_in_forward_gear": {
+			mode: EscModeFBR,
+			steps: []step{
+				{gear: "R", value: 0, pulse: 1000},
+				{gear: "R", value: 0, advance: 100 * time.Millisecond, pulse: 1498},
+				{gear: "R", value: 0, advance: 100 * time.Millisecond, pulse: 1000},
+				{gear: "1", value: 0, pulse: 1498}, //would reverse instead of brake
+				{gear: "1", value: 255, pulse: 2000},
+				{gear: "1", value: 0, pulse: 1000}, //forward disarmed reverse so this brakes
+			},
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			escCfg := testServoConfig("esc", TypeESC, 0)
+			escCfg.Esc = EscConfig{Mode: tc.mode, BrakePulse: 100 * time.Millisecond, NeutralPulse: 100 * time.Millisecond}
+			carCommand, driver := newSimCarCommand(t, escCfg)
+			for i, step := range tc.steps {
+				clock = clock.Add(step.advance)
+				err := carCommand.servoController.SetGear("esc", step.gear)
+				if err != nil {
+					t.Fatalf("failed setting gear: %s", err)
+				}
+				err = carCommand.servoController.SendCommand("esc", step.value)
+				if err != nil {
+					t.Fatalf("failed sending command: %s", err)
+				}
+				pulse, _ := driver.LastPulse(0)
+				if pulse < step.pulse-0.5 || pulse > step.pulse+0.5 {
+					t.Errorf("step %d: pulse %f, expected %f", i, pulse, step.pulse)
+				}
+			}
+		})
+	}
+}
+
+func TestGearRatios(t *testing.T) {
+	tests := map[string]struct {
+		numGears int
+		ratios   []int
+		inverted bool
+		gear     string
+		max      int
+		min      int
+	}{
+		"even_split": {
+			numGears: 6,
+			gear:     "5",
+			max:      232,
+			min:      22,
+		},
+		"even_split_top_gear": {
+			numGears: 6,
+			gear:     "6",
+			max:      255,
+			min:      0,
+		},
+		"custom_ratio": {
+			numGears: 3,
+			ratios:   []int{25, 50, 80},
+			gear:     "2",
+			max:      191,
+			min:      63,
+		},
+		"custom_top_gear_capped": {
+			numGears: 3,
+			ratios:   []int{25, 50, 80},
+			gear:     "3",
+			max:      229,
+			min:      25,
+		},
+		"custom_ratio_inverted": {
+			numGears: 3,
+			ratios:   []int{25, 50, 80},
+			inverted: true,
+			gear:     "2",
+			max:      190,
+			min:      64,
+		},
+		"many_gears": {
+			numGears: 10,
+			gear:     "10",
+			max:      255,
+			min:      0,
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			gears := makeGearRatios(tc.numGears, tc.ratios, 0, 255, tc.inverted)
+			gear, ok := gears[tc.gear]
+			if !ok {
+				t.Fatalf("gear %s not found", tc.gear)
+			}
+			if gear.max != tc.max || gear.min != tc.min {
+				t.Errorf("got max %d min %d, expected max %d min %d", gear.max, gear.min, tc.max, tc.min)
+			}
+		})
+	}
+}
+
+func TestShiftCut(t *testing.T) {
+	clock := time.Now()
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	escCfg := testServoConfig("esc", TypeESC, 0)
+	escCfg.NumGears = 2
+	escCfg.ShiftCut = 100 * time.Millisecond
+	carCommand, driver := newSimCarCommand(t, escCfg)
+
+	gear, err := carCommand.servoController.Shift("esc", true)
+	if err != nil || gear != "1" {
+		t.Fatalf("expected shift into 1st, got %s (%v)", gear, err)
+	}
+
+	err = carCommand.servoController.SendCommand("esc", 255)
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+	assertPulse(t, driver, 0, 1498) //throttle cut right after the shift
+
+	err = carCommand.servoController.SendCommand("esc", 0)
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+	assertPulse(t, driver, 0, 1247) //brake isn't cut
+
+	clock = clock.Add(100 * time.Millisecond)
+	err = carCommand.servoController.SendCommand("esc", 255)
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+	assertPulse(t, driver, 0, 1749)
+
+	gear, _ = carCommand.servoController.Shift("esc", true)
+	if gear != "2" {
+		t.Errorf("expected shift into 2nd, got %s", gear)
+	}
+	gear, _ = carCommand.servoController.Shift("esc", true)
+	if gear != "2" {
+		t.Errorf("expected to stay in top gear, got %s", gear)
+	}
+}
+
+func TestDragBrakeHillHold(t *testing.T) {
+	clock := time.Now()
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	type step struct {
+		gear    string
+		value   int
+		advance time.Duration
+		pulse   float32
+	}
+	tests := map[string]struct {
+		esc   EscConfig
+		steps []step
+	}{
+		"drag_brake_in_forward_gears": {
+			esc: EscConfig{Mode: EscModeFB, DragBrake: 20},
+			steps: []step{
+				{gear: "1", value: 127, pulse: 1400},
+				{gear: "1", value: 255, pulse: 2000},
+				{gear: "1", value: 127, pulse: 1400},
+				{gear: "1", value: 0, pulse: 1000}, //Driver brake goes through
+				{gear: "N", value: 127, pulse: 1498},
+				{gear: "R", value: 127, pulse: 1498},
+			},
+		},
+		"hill_hold_after_letting_off": {
+			esc: EscConfig{Mode: EscModeFB, HillHold: 300 * time.Millisecond, HillHoldBrake: 50},
+			steps: []step{
+				{gear: "1", value: 127, pulse: 1498}, //Never moved so nothing to hold
+				{gear: "1", value: 255, pulse: 2000},
+				{gear: "1", value: 127, pulse: 1251},
+				{gear: "1", value: 127, advance: 200 * time.Millisecond, pulse: 1251},
+				{gear: "1", value: 127, advance: 150 * time.Millisecond, pulse: 1498},
+			},
+		},
+		"hill_hold_then_drag_brake": {
+			esc: EscConfig{Mode: EscModeFBR, DragBrake: 20, HillHold: 300 * time.Millisecond, HillHoldBrake: 50},
+			steps: []step{
+				{gear: "1", value: 255, pulse: 2000},
+				{gear: "1", value: 127, pulse: 1251},
+				{gear: "1", value: 127, advance: 400 * time.Millisecond, pulse: 1400},
+			},
+		},
+		"hill_hold_respects_neutral_gear": {
+			esc: EscConfig{Mode: EscModeFB, HillHold: 300 * time.Millisecond, HillHoldBrake: 50},
+			steps: []step{
+				{gear: "1", value: 255, pulse: 2000},
+				{gear: "N", value: 127, pulse: 1498},
+			},
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			escCfg := testServoConfig("esc", TypeESC, 0)
+			escCfg.Esc = tc.esc
+			carCommand, driver := newSimCarCommand(t, escCfg)
+			for i, step := range tc.steps {
+				clock = clock.Add(step.advance)
+				err := carCommand.servoController.SetGear("esc", step.gear)
+				if err != nil {
+					t.Fatalf("failed setting gear: %s", err)
+				}
+				err = carCommand.servoController.SendCommand("esc", step.value)
+				if err != nil {
+					t.Fatalf("failed sending command: %s", err)
+				}
+				pulse, _ := driver.LastPulse(0)
+				if pulse < step.pulse-0.5 || pulse > step.pulse+0.5 {
+					t.Errorf("step %d: pulse %f, expected %f", i, pulse, step.pulse)
+				}
+			}
+		})
+	}
+}
+
+func TestDragBrakeValidate(t *testing.T) {
+	tests := map[string]struct {
+		esc   EscConfig
+		valid bool
+	}{
+		"drag_brake_fb":         {esc: EscConfig{Mode: EscModeFB, DragBrake: 20}, valid: true},
+		"hill_hold_fbr":         {esc: EscConfig{Mode: EscModeFBR, HillHold: time.Second, HillHoldBrake: 30}, valid: true},
+		"drag_brake_fr":         {esc: EscConfig{Mode: EscModeFR, DragBrake: 20}},
+		"drag_brake_default":    {esc: EscConfig{DragBrake: 20}},
+		"drag_brake_over_100":   {esc: EscConfig{Mode: EscModeFB, DragBrake: 120}},
+		"hill_hold_no_brake":    {esc: EscConfig{Mode: EscModeFB, HillHold: time.Second}},
+		"hill_hold_negative":    {esc: EscConfig{Mode: EscModeFB, HillHold: -time.Second, HillHoldBrake: 30}},
+		"hill_hold_brake_alone": {esc: EscConfig{Mode: EscModeFR, HillHoldBrake: 30}, valid: true},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := tc.esc.Validate()
+			if tc.valid && err != nil {
+				t.Errorf("expected valid config, got %s", err)
+			} else if !tc.valid && err == nil {
+				t.Errorf("expected invalid config")
+			}
+		})
+	}
+}
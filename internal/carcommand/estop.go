@@ -0,0 +1,62 @@
+package carcommand
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Estop holds every actuator in failsafe until it's cleared, it doesn't clear itself when commands come back
+type estop struct {
+	active bool
+	start  time.Time
+	reason string
+}
+
+func (c *CarCommand) latchEstop(reason string) error {
+	if c.estop.active {
+		return nil
+	}
+	c.estop = estop{
+		active: true,
+		start:  time.Now(),
+		reason: reason,
+	}
+	log.Printf("warning: estop latched - %s\n", reason)
+	c.stopReplay("interrupted by estop")
+	c.stopAllCruise("estop")
+	var err error
+	if c.servoController.calibration.Active { //Calibration writes pulses the failsafe would skip
+		name := c.servoController.calibration.Servo
+		err = c.servoController.StopCalibration()
+		c.sendEvent(EventCalibration, fmt.Sprintf("%s calibration stopped", name))
+	}
+	c.sendEvent(EventEstop, reason)
+	return err
+}
+
+func (c *CarCommand) clearEstop(reason string) {
+	if !c.estop.active {
+		return
+	}
+	log.Printf("estop cleared after %s - %s\n", time.Since(c.estop.start), reason)
+	c.estop = estop{}
+	c.sendEvent(EventEstopCleared, reason)
+}
+
+// Runs each tick while latched, the servos go through their failsafe stages from when it latched but escs never hold throttle
+func (c *CarCommand) holdEstop() error {
+	err := c.servoController.Estop(time.Since(c.estop.start))
+	c.reportOutputStats(c.servoController.OutputStats())
+	return err
+}
+
+// Controls that still work while the estop is latched
+func allowedInEstop(controlType string) bool {
+	switch controlType {
+	case ControlEstop, ControlEstopClear, ControlCruiseCancel:
+		return true
+	default:
+		return false
+	}
+}
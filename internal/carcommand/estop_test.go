@@ -0,0 +1,104 @@
+package carcommand
+
+import (
+	"testing"
+)
+
+func TestEstopLatches(t *testing.T) {
+	steerCfg := testServoConfig("steer", TypeServo, 0)
+	steerCfg.Failsafe = []FailsafeStage{{Action: FailsafePosition, Value: 200}}
+	carCommand, driver := newSimCarCommand(t, steerCfg, testServoConfig("pan", TypeServo, 1))
+
+	err := carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"steer": {Value: 0}}})
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+	assertPulse(t, driver, 0, 1000)
+	if result := carCommand.DoCalibration(CalibrationRequest{Action: CalibrateStart, Servo: "pan"}); result.Err != nil {
+		t.Fatalf("failed starting calibration: %s", result.Err)
+	}
+	assertEvent(t, carCommand, EventCalibration)
+
+	err = carCommand.DoControl(ControlCommand{Type: ControlEstop, Reason: "test"})
+	if err != nil {
+		t.Fatalf("failed latching estop: %s", err)
+	}
+	assertEvent(t, carCommand, EventCalibration) //Calibration handed the servo back
+	assertEvent(t, carCommand, EventEstop)
+	err = carCommand.holdEstop()
+	if err != nil {
+		t.Fatalf("failed holding estop: %s", err)
+	}
+	assertPulse(t, driver, 0, 1784)
+
+	rejected := map[string]func() error{
+		"shift": func() error {
+			return carCommand.DoControl(ControlCommand{Type: ControlUpShift, Servo: "steer"})
+		},
+		"calibration": func() error {
+			return carCommand.DoCalibration(CalibrationRequest{Action: CalibrateStart, Servo: "pan"}).Err
+		},
+		"replay": func() error {
+			return carCommand.DoRecording(RecordingRequest{Action: RecordPlay, Name: "slalom"}).Err
+		},
+	}
+	for name, try := range rejected {
+		if try() == nil {
+			t.Errorf("expected %s to be rejected while latched", name)
+		}
+	}
+	if err := carCommand.DoControl(ControlCommand{Type: ControlCruiseCancel}); err != nil {
+		t.Errorf("expected cruise cancel to still work while latched: %s", err)
+	}
+
+	err = carCommand.DoControl(ControlCommand{Type: ControlEstopClear, Reason: "test"})
+	if err != nil {
+		t.Fatalf("failed clearing estop: %s", err)
+	}
+	assertEvent(t, carCommand, EventEstopCleared)
+	if err := carCommand.DoControl(ControlCommand{Type: ControlUpShift, Servo: "steer"}); err != nil {
+		t.Errorf("expected controls to work once cleared: %s", err)
+	}
+}
+
+func TestEstopNeverHoldsThrottle(t *testing.T) {
+	tests := map[string]struct {
+		failsafe string
+		mode     EscMode
+		pulse    float32
+	}{
+		"hold":     {failsafe: "hold", pulse: 1498},
+		"position": {failsafe: "position:0:255", pulse: 1498},
+		"brake":    {failsafe: "brake:0:64", mode: EscModeFB, pulse: 1251},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			stages, err := ParseFailsafeStages(tc.failsafe)
+			if err != nil {
+				t.Fatalf("failed parsing stages: %s", err)
+			}
+			escCfg := testServoConfig("esc", TypeESC, 0)
+			escCfg.Esc.Mode = tc.mode
+			escCfg.Failsafe = stages
+			carCommand, driver := newSimCarCommand(t, escCfg)
+			for i := 0; i < 2; i++ { //gear is applied after the value
+				err = carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"esc": {Value: 255, Gear: "1"}}})
+				if err != nil {
+					t.Fatalf("failed sending command: %s", err)
+				}
+			}
+			assertPulse(t, driver, 0, 2000)
+
+			err = carCommand.DoControl(ControlCommand{Type: ControlEstop, Reason: "test"})
+			if err != nil {
+				t.Fatalf("failed latching estop: %s", err)
+			}
+			err = carCommand.holdEstop()
+			if err != nil {
+				t.Fatalf("failed holding estop: %s", err)
+			}
+			assertPulse(t, driver, 0, tc.pulse)
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package carcommand
+
+import (
+	"log"
+	"time"
+)
+
+const EventFailsafe = "failsafe"
+const EventFailsafeRecovered = "failsafe_recovered"
+const EventGear = "gear" //Message is the gear the car shifted into
+const EventActuatorsOffline = "actuators_offline"
+const EventActuatorsOnline = "actuators_online"
+const EventCalibration = "calibration" //Message says which servo started, stopped or saved a calibration
+const EventRecording = "recording"     //Message is the recording name and whether it started or was saved
+const EventReplay = "replay"           //Message is the recording name and why the replay started or stopped
+const EventCruise = "cruise"           //Message is the throttle name and either on with the latched value or off with why
+const EventEstop = "estop"             //Message is why the estop latched
+const EventEstopCleared = "estop_cleared"
+const EventOutputLimit = "output_limit" //Message is the source and the throttle it allows, or lifted
+const EventGyro = "gyro"                //Message is the servo and its gain, or off
+const EventCollision = "collision"      //Message is on or off, or the side and what collision braking did
+
+// Event is something the car did on its own that clients should know about
+type Event struct {
+	Type    string    `json:"type"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Never blocks the command loop, events are dropped if nobody is reading them
+func (c *CarCommand) sendEvent(eventType string, message string) {
+	event := Event{
+		Type:    eventType,
+		Message: message,
+		Time:    time.Now(),
+	}
+	select {
+	case c.EventChannel <- event:
+	default:
+		log.Printf("warning: event channel full, dropped %s event\n", eventType)
+	}
+}
@@ -0,0 +1,161 @@
+package carcommand
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type FailsafeAction string
+
+const (
+	FailsafeHold     FailsafeAction = "hold"     //Keep the last value sent
+	FailsafePosition FailsafeAction = "position" //Go to a set input value
+	FailsafeNeutral  FailsafeAction = "neutral"  //Go to mid, switch types turn off
+	FailsafeBrake    FailsafeAction = "brake"    //Send a value on the brake side of the range no matter the gear
+)
+
+const DefaultFailsafeTimeout = 333 * time.Millisecond //About 20 cycles at the default refresh rate
+
+// Used when a servo has no stages configured
+var defaultFailsafeStages = []FailsafeStage{{Action: FailsafeNeutral}}
+
+// FailsafeStage is one step of a servo's failsafe, stages run in order until commands come back
+type FailsafeStage struct {
+	Action   FailsafeAction
+	Duration time.Duration //How long to stay in this stage, ignored on the last stage
+	Value    int           //Input value used by position and brake
+}
+
+// Parses a comma separated list of action:durationMs:value stages (hold:200,brake:1000:64,neutral)
+func ParseFailsafeStages(value string) ([]FailsafeStage, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	stages := make([]FailsafeStage, 0, len(parts))
+	for _, part := range parts {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("invalid failsafe stage (%s)", part)
+		}
+
+		stage := FailsafeStage{
+			Action: FailsafeAction(fields[0]),
+		}
+		if len(fields) > 1 {
+			duration, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid failsafe stage duration (%s) - %w", part, err)
+			}
+			stage.Duration = time.Duration(duration) * time.Millisecond
+		}
+		if len(fields) > 2 {
+			stageValue, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid failsafe stage value (%s) - %w", part, err)
+			}
+			stage.Value = stageValue
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+func (c ServoConfig) validateFailsafe() error {
+	for i, stage := range c.Failsafe {
+		switch stage.Action {
+		case FailsafeHold, FailsafeNeutral:
+		case FailsafePosition:
+			if stage.Value < c.MinValue || stage.Value > c.MaxValue {
+				return fmt.Errorf("failsafe position out of range (%d)", stage.Value)
+			}
+		case FailsafeBrake:
+			if c.Type != TypeESC {
+				return fmt.Errorf("failsafe brake is only supported on esc servos")
+			}
+			mode := c.Esc.Mode
+			if mode == "" {
+				mode = DefaultEscMode
+			}
+			if mode == EscModeFR {
+				return fmt.Errorf("failsafe brake needs an esc mode that brakes (fb or fbr)") //fr escs would drive backwards
+			}
+			if stage.Value < c.MinValue || stage.Value >= c.MidValue {
+				return fmt.Errorf("failsafe brake value must be below mid (%d)", stage.Value)
+			}
+		default:
+			return fmt.Errorf("unsupported failsafe action (%s)", stage.Action)
+		}
+		if stage.Duration < 0 {
+			return fmt.Errorf("failsafe stage %d duration can't be negative", i)
+		}
+		if stage.Duration == 0 && i < len(c.Failsafe)-1 {
+			return fmt.Errorf("failsafe stage %d needs a duration since it isn't the last stage", i)
+		}
+	}
+	return nil
+}
+
+// Returns the stage that should be running once the failsafe has been active for elapsed
+func getFailsafeStage(stages []FailsafeStage, elapsed time.Duration) FailsafeStage {
+	for _, stage := range stages[:len(stages)-1] {
+		if elapsed < stage.Duration {
+			return stage
+		}
+		elapsed -= stage.Duration
+	}
+	return stages[len(stages)-1]
+}
+
+// Runs this servo's failsafe stage for how long the failsafe has been active
+func (s *Servo) ApplyFailsafe(elapsed time.Duration) error {
+	return s.applyFailsafeStage(getFailsafeStage(s.failsafeStages(), elapsed))
+}
+
+// Runs the failsafe stage like ApplyFailsafe, but nothing is left holding a value and escs only ever brake or go neutral
+func (s *Servo) ApplyEstop(elapsed time.Duration) error {
+	stage := getFailsafeStage(s.failsafeStages(), elapsed)
+	if stage.Action == FailsafeHold || (stage.Action == FailsafePosition && s.config.Type == TypeESC) {
+		stage = FailsafeStage{Action: FailsafeNeutral}
+	}
+	return s.applyFailsafeStage(stage)
+}
+
+func (s *Servo) failsafeStages() []FailsafeStage {
+	if len(s.config.Failsafe) == 0 {
+		return defaultFailsafeStages
+	}
+	return s.config.Failsafe
+}
+
+func (s *Servo) applyFailsafeStage(stage FailsafeStage) error {
+	switch stage.Action {
+	case FailsafeHold:
+		return nil
+	case FailsafePosition:
+		s.slew.reset(stage.Value)
+		return s.SetValue(stage.Value)
+	case FailsafeBrake:
+		s.slew.reset(s.config.MidValue)
+		return s.setBrake(stage.Value)
+	default:
+		return s.SetFailsafe()
+	}
+}
+
+// Writes a brake value straight to the output, skipping the gear so it still brakes in neutral
+func (s *Servo) setBrake(value int) error {
+	if s.config.Esc.Mode == EscModeFBR {
+		if s.escState == escReverse {
+			return s.writeValue(s.config.MidValue) //ESC would drive backwards instead of braking
+		}
+		s.escState = escBraking
+		s.escStateStart = timeNow()
+	}
+	if s.config.Inverted {
+		value = getInvertedValue(value, s.config.MidValue)
+	}
+	return s.writeValue(value)
+}
@@ -0,0 +1,117 @@
+package carcommand
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStagedFailsafe(t *testing.T) {
+	stages, err := ParseFailsafeStages("hold:200,brake:1000:64,neutral")
+	if err != nil {
+		t.Fatalf("failed parsing stages: %s", err)
+	}
+	escCfg := testServoConfig("esc", TypeESC, 0)
+	escCfg.Failsafe = stages
+	escCfg.Esc.Mode = EscModeFB
+	steerCfg := testServoConfig("steer", TypeServo, 1)
+	steerCfg.Failsafe = []FailsafeStage{{Action: FailsafeHold, Duration: 200 * time.Millisecond}, {Action: FailsafePosition, Value: 200}}
+	carCommand, driver := newSimCarCommand(t, escCfg, steerCfg)
+
+	err = carCommand.DoCommand(CommandGroup{Commands: map[string]Command{
+		"esc":   {Value: 255, Gear: "1"},
+		"steer": {Value: 0},
+	}})
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		esc     float32
+		steer   float32
+	}{
+		{elapsed: 0, esc: 1498, steer: 1000}, //gear is applied after the first value so esc is still in neutral
+		{elapsed: 100 * time.Millisecond, esc: 1498, steer: 1000},
+		{elapsed: 200 * time.Millisecond, esc: 1251, steer: 1784},
+		{elapsed: 1100 * time.Millisecond, esc: 1251, steer: 1784},
+		{elapsed: 1200 * time.Millisecond, esc: 1498, steer: 1784},
+		{elapsed: time.Hour, esc: 1498, steer: 1784},
+	}
+	for _, tc := range tests {
+		err := carCommand.servoController.Failsafe(tc.elapsed)
+		if err != nil {
+			t.Fatalf("failed running failsafe: %s", err)
+		}
+		assertPulse(t, driver, 0, tc.esc)
+		assertPulse(t, driver, 1, tc.steer)
+	}
+}
+
+func TestFailsafeValidate(t *testing.T) {
+	tests := map[string]struct {
+		servoType ServoType
+		escMode   EscMode
+		failsafe  string
+		valid     bool
+	}{
+		"esc_staged":          {servoType: TypeESC, escMode: EscModeFB, failsafe: "hold:200,brake:1000:64,neutral", valid: true},
+		"fbr_brake":           {servoType: TypeESC, escMode: EscModeFBR, failsafe: "brake:100:64", valid: true},
+		"fr_neutral":          {servoType: TypeESC, escMode: EscModeFR, failsafe: "hold:200,neutral", valid: true},
+		"servo_position":      {servoType: TypeServo, failsafe: "position:0:200", valid: true},
+		"brake_on_servo":      {servoType: TypeServo, failsafe: "brake:100:64"},
+		"brake_on_fr":         {servoType: TypeESC, escMode: EscModeFR, failsafe: "brake:100:64"},
+		"brake_default_mode":  {servoType: TypeESC, failsafe: "brake:100:64"},
+		"brake_above_mid":     {servoType: TypeESC, escMode: EscModeFB, failsafe: "brake:100:200"},
+		"missing_duration":    {servoType: TypeESC, failsafe: "hold,neutral"},
+		"unknown_action":      {servoType: TypeESC, failsafe: "explode"},
+		"position_over_range": {servoType: TypeServo, failsafe: "position:0:300"},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			servoCfg := testServoConfig("test", tc.servoType, 0)
+			servoCfg.Esc.Mode = tc.escMode
+			stages, err := ParseFailsafeStages(tc.failsafe)
+			if err != nil {
+				t.Fatalf("failed parsing stages: %s", err)
+			}
+			servoCfg.Failsafe = stages
+			err = servoCfg.Validate()
+			if tc.valid && err != nil {
+				t.Errorf("expected valid config, got %s", err)
+			} else if !tc.valid && err == nil {
+				t.Errorf("expected invalid config")
+			}
+		})
+	}
+}
+
+func TestFailsafeBrakeWhileReversing(t *testing.T) {
+	clock := time.Now()
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	escCfg := testServoConfig("esc", TypeESC, 0)
+	escCfg.Esc = EscConfig{Mode: EscModeFBR, BrakePulse: 100 * time.Millisecond, NeutralPulse: 100 * time.Millisecond}
+	escCfg.Failsafe = []FailsafeStage{{Action: FailsafeBrake, Value: 64}}
+	carCommand, driver := newSimCarCommand(t, escCfg)
+
+	err := carCommand.servoController.SetGear("esc", "R")
+	if err != nil {
+		t.Fatalf("failed setting gear: %s", err)
+	}
+	for i := 0; i < 3; i++ { //brake, neutral, then reverse
+		err = carCommand.servoController.SendCommand("esc", 0)
+		if err != nil {
+			t.Fatalf("failed sending command: %s", err)
+		}
+		clock = clock.Add(100 * time.Millisecond)
+	}
+	assertPulse(t, driver, 0, 1000)
+
+	err = carCommand.servoController.Failsafe(0)
+	if err != nil {
+		t.Fatalf("failed running failsafe: %s", err)
+	}
+	assertPulse(t, driver, 0, 1498) //a brake value would drive backwards
+}
@@ -0,0 +1,122 @@
+package carcommand
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+const MaxGyroGain = 100
+const DefaultGyroMaxRate = 360.0 //Degrees per second
+const DefaultGyroMaxCorrection = 50
+
+// YawRateSource is anything that can report how fast the car is rotating, ok is false when there's no reading
+type YawRateSource interface {
+	YawRate() (degreesPerSecond float64, ok bool)
+}
+
+// GyroConfig adds counter steer to a steering servo when the car yaws faster or slower than the driver asked for,
+// the same job a hobby gyro does between the receiver and the steering servo
+type GyroConfig struct {
+	Gain          int     //Percent, 0 turns the assist off for this servo
+	MaxRate       float64 //Yaw rate full steer asks for, in degrees per second
+	MaxCorrection int     //Percent of steering travel the assist can add on top of the driver
+	Reversed      bool    //Gyro reads positive turning the way low steer values point
+}
+
+// Live gyro settings, the client can change the gain and turn it on and off
+type gyroAssist struct {
+	source  YawRateSource
+	gain    int
+	enabled bool
+}
+
+func (c GyroConfig) Enabled() bool {
+	return c.Gain > 0
+}
+
+func (c GyroConfig) Validate() error {
+	if c.Gain < 0 || c.Gain > MaxGyroGain {
+		return fmt.Errorf("gyro gain out of range (%d)", c.Gain)
+	}
+	if !c.Enabled() {
+		return nil
+	}
+	if c.MaxRate <= 0 {
+		return fmt.Errorf("gyro max rate must be more than 0 (%.0f)", c.MaxRate)
+	}
+	if c.MaxCorrection < 1 || c.MaxCorrection > MaxCurvePercent {
+		return fmt.Errorf("gyro max correction out of range (%d)", c.MaxCorrection)
+	}
+	return nil
+}
+
+func newGyroAssist(cfg GyroConfig) gyroAssist {
+	return gyroAssist{
+		gain:    cfg.Gain,
+		enabled: cfg.Enabled(),
+	}
+}
+
+func (s *Servo) SetYawRateSource(source YawRateSource) {
+	if !s.config.Gyro.Enabled() {
+		return
+	}
+	s.gyro.source = source
+}
+
+func (s *Servo) SetGyroGain(gain int) error {
+	if !s.config.Gyro.Enabled() {
+		return fmt.Errorf("%s has no gyro assist", s.config.Name)
+	}
+	if gain < 0 || gain > MaxGyroGain {
+		return fmt.Errorf("gyro gain out of range (%d)", gain)
+	}
+	s.gyro.gain = gain
+	log.Printf("%s gyro gain set to %d%%\n", s.config.Name, gain)
+	return nil
+}
+
+func (s *Servo) SetGyroEnabled(enabled bool) error {
+	if !s.config.Gyro.Enabled() {
+		return fmt.Errorf("%s has no gyro assist", s.config.Name)
+	}
+	s.gyro.enabled = enabled
+	log.Printf("%s gyro enabled: %t\n", s.config.Name, enabled)
+	return nil
+}
+
+// Steers toward the yaw rate the driver's input asks for, value is in the servo's min to max range before inverting
+func (s *Servo) getValueWithGyro(value int) int {
+	if !s.gyro.enabled || s.gyro.gain == 0 || s.gyro.source == nil {
+		return value
+	}
+	yawRate, ok := s.gyro.source.YawRate()
+	if !ok {
+		return value //No reading, the driver steers on their own
+	}
+	if s.config.Gyro.Reversed {
+		yawRate = -yawRate
+	}
+
+	mid := s.config.MidValue
+	steer := 0.0
+	if value > mid {
+		steer = float64(value-mid) / float64(s.config.MaxValue-mid)
+	} else if value < mid {
+		steer = float64(value-mid) / float64(mid-s.config.MinValue)
+	}
+
+	cfg := s.config.Gyro
+	limit := float64(cfg.MaxCorrection) / MaxCurvePercent
+	correction := float64(s.gyro.gain) / MaxGyroGain * (steer*cfg.MaxRate - yawRate) / cfg.MaxRate
+	correction = math.Max(-limit, math.Min(limit, correction))
+	output := math.Max(-1, math.Min(1, steer+correction))
+
+	if output > 0 {
+		return mid + int(math.Round(output*float64(s.config.MaxValue-mid)))
+	} else if output < 0 {
+		return mid + int(math.Round(output*float64(mid-s.config.MinValue)))
+	}
+	return mid
+}
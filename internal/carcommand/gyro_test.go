@@ -0,0 +1,142 @@
+package carcommand
+
+import (
+	"testing"
+)
+
+type fakeYawRateSource struct {
+	yawRate float64
+	ok      bool
+}
+
+func (f *fakeYawRateSource) YawRate() (float64, bool) {
+	return f.yawRate, f.ok
+}
+
+func testGyroSteer(gyro GyroConfig) ServoConfig {
+	steer := testServoConfig("steer", TypeServo, 1)
+	steer.Gyro = gyro
+	return steer
+}
+
+func TestGyroAssist(t *testing.T) {
+	gyro := GyroConfig{Gain: 50, MaxRate: DefaultGyroMaxRate, MaxCorrection: DefaultGyroMaxCorrection}
+	reversed := gyro
+	reversed.Reversed = true
+
+	tests := map[string]struct {
+		gyro     GyroConfig
+		source   fakeYawRateSource
+		value    int
+		expected float32
+	}{
+		"no_reading": {
+			gyro:     gyro,
+			source:   fakeYawRateSource{yawRate: 90},
+			value:    127,
+			expected: 1498.04,
+		},
+		"counter_steers_unwanted_yaw": {
+			gyro:     gyro,
+			source:   fakeYawRateSource{yawRate: 90, ok: true},
+			value:    127,
+			expected: 1435.29, //Half gain of a quarter of max rate is 12.5% opposite lock
+		},
+		"yaw_matches_request": {
+			gyro:     gyro,
+			source:   fakeYawRateSource{yawRate: DefaultGyroMaxRate, ok: true},
+			value:    255,
+			expected: 2000,
+		},
+		"correction_limited": {
+			gyro:     gyro,
+			source:   fakeYawRateSource{yawRate: -2 * DefaultGyroMaxRate, ok: true},
+			value:    127,
+			expected: 1749.02,
+		},
+		"reversed_gyro": {
+			gyro:     reversed,
+			source:   fakeYawRateSource{yawRate: -90, ok: true},
+			value:    127,
+			expected: 1435.29,
+		},
+		"off_for_servo": {
+			source:   fakeYawRateSource{yawRate: 90, ok: true},
+			value:    127,
+			expected: 1498.04,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			carCommand, driver := newSimCarCommand(t, testGyroSteer(test.gyro))
+			source := test.source
+			carCommand.SetYawRateSource(&source)
+
+			err := carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"steer": {Value: test.value}}})
+			if err != nil {
+				t.Fatalf("failed sending command: %s", err)
+			}
+			assertPulse(t, driver, 1, test.expected)
+		})
+	}
+}
+
+func TestGyroControls(t *testing.T) {
+	carCommand, driver := newSimCarCommand(t, testGyroSteer(GyroConfig{Gain: 50, MaxRate: DefaultGyroMaxRate, MaxCorrection: DefaultGyroMaxCorrection}))
+	carCommand.SetYawRateSource(&fakeYawRateSource{yawRate: 90, ok: true})
+	straight := CommandGroup{Commands: map[string]Command{"steer": {Value: 127}}}
+
+	err := carCommand.DoControl(ControlCommand{Type: ControlGyroGain, Servo: "steer", Value: 100})
+	if err != nil {
+		t.Fatalf("failed setting gain: %s", err)
+	}
+	assertEvent(t, carCommand, EventGyro)
+	carCommand.DoCommand(straight)
+	assertPulse(t, driver, 1, 1372.55) //Full gain doubles the correction
+
+	err = carCommand.DoControl(ControlCommand{Type: ControlGyro, Servo: "steer", Value: 0})
+	if err != nil {
+		t.Fatalf("failed turning gyro off: %s", err)
+	}
+	assertEvent(t, carCommand, EventGyro)
+	carCommand.DoCommand(straight)
+	assertPulse(t, driver, 1, 1498.04)
+
+	err = carCommand.DoControl(ControlCommand{Type: ControlGyroGain, Servo: "steer", Value: MaxGyroGain + 1})
+	if err == nil {
+		t.Errorf("expected gain past the max to be rejected")
+	}
+
+	//Failsafe holds the steering where it is told to, the gyro doesn't fight it
+	carCommand.DoControl(ControlCommand{Type: ControlGyro, Servo: "steer", Value: 1})
+	err = carCommand.servoController.Failsafe(0)
+	if err != nil {
+		t.Fatalf("failed failsafe: %s", err)
+	}
+	assertPulse(t, driver, 1, 1498.04)
+}
+
+func TestGyroConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		servoType ServoType
+		gyro      GyroConfig
+		wantErr   bool
+	}{
+		"off":            {servoType: TypeServo},
+		"on":             {servoType: TypeServo, gyro: GyroConfig{Gain: 40, MaxRate: 300, MaxCorrection: 50}},
+		"gain_too_high":  {servoType: TypeServo, gyro: GyroConfig{Gain: 101, MaxRate: 300, MaxCorrection: 50}, wantErr: true},
+		"no_max_rate":    {servoType: TypeServo, gyro: GyroConfig{Gain: 40, MaxCorrection: 50}, wantErr: true},
+		"no_correction":  {servoType: TypeServo, gyro: GyroConfig{Gain: 40, MaxRate: 300}, wantErr: true},
+		"not_a_steering": {servoType: TypeESC, gyro: GyroConfig{Gain: 40, MaxRate: 300, MaxCorrection: 50}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := testServoConfig("steer", test.servoType, 1)
+			cfg.Gyro = test.gyro
+			err := cfg.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package carcommand
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Logical inputs the mixer builds outputs from
+const (
+	InputThrottle = "throttle"
+	InputSteer    = "steer"
+	InputPan      = "pan"
+	InputTilt     = "tilt"
+	InputAux      = "aux"
+)
+
+// Command names the client sends for each logical input
+var mixInputCommands = map[string]string{
+	"esc":   InputThrottle,
+	"steer": InputSteer,
+	"pan":   InputPan,
+	"tilt":  InputTilt,
+	"aux":   InputAux,
+}
+
+// Returns the logical input a command drives, empty if the command isn't one of them
+func CommandInput(name string) string {
+	return mixInputCommands[name]
+}
+
+// Logical input of a command, from the channel map when the server gave one
+func (c *CarCommand) commandInput(name string) string {
+	return mappedInput(c.config.Inputs, name)
+}
+
+func mappedInput(inputs map[string]string, name string) string {
+	if input, found := inputs[name]; found {
+		return input
+	}
+	return CommandInput(name)
+}
+
+// Range of the values the client sends, one byte per input
+const MixInputMin = 0
+const MixInputMid = 127
+const MixInputMax = 255
+
+const MaxMixWeight = 100
+
+const (
+	MixPresetNone       = ""
+	MixPresetTank       = "tank"        //left/right ESCs from throttle and steer
+	MixPreset4WSFront   = "4ws_front"   //front steer only, rear held straight
+	MixPreset4WSCrab    = "4ws_crab"    //rear steers the same way as the front
+	MixPreset4WSCounter = "4ws_counter" //rear steers opposite the front for a tighter turn
+)
+
+// Servo names the presets drive
+const (
+	MixServoLeft      = "left"
+	MixServoRight     = "right"
+	MixServoRearSteer = "rearsteer"
+)
+
+// MixerConfig turns the client's inputs into servo commands, leave it empty to send commands straight to servos by name
+type MixerConfig struct {
+	Preset         string
+	Outputs        []MixOutput //Added to the preset, replaces a preset output driving the same servo
+	SteerReduction int         //Percent steer is cut at full throttle
+}
+
+// MixOutput drives one servo from a weighted sum of the logical inputs
+type MixOutput struct {
+	Servo   string
+	Weights map[string]int //Percent of each input, negative reverses it
+}
+
+type Mixer struct {
+	config      MixerConfig
+	inputs      map[string]string //Logical input by command name, from the channel map
+	outputs     []MixOutput
+	ranges      map[string]mixRange
+	passThrough map[string]bool //Commands no output uses that still have a servo by the same name
+}
+
+type mixRange struct {
+	min int
+	mid int
+	max int
+}
+
+func NewMixer(cfg MixerConfig, inputs map[string]string) *Mixer {
+	return &Mixer{
+		config:      cfg,
+		inputs:      inputs,
+		outputs:     getMixOutputs(cfg),
+		ranges:      make(map[string]mixRange),
+		passThrough: make(map[string]bool),
+	}
+}
+
+// True if there is anything to mix, otherwise commands go straight to servos
+func (c MixerConfig) Enabled() bool {
+	return c.Preset != MixPresetNone || len(c.Outputs) > 0
+}
+
+func (c MixerConfig) Validate() error {
+	_, err := getMixPreset(c.Preset)
+	if err != nil {
+		return err
+	}
+	if c.SteerReduction < 0 || c.SteerReduction > MaxMixWeight {
+		return fmt.Errorf("steer reduction out of range (%d)", c.SteerReduction)
+	}
+	for _, output := range c.Outputs {
+		if output.Servo == "" {
+			return fmt.Errorf("mix output missing servo name")
+		}
+		for input, weight := range output.Weights {
+			if !isMixInput(input) {
+				return fmt.Errorf("%s mix has unsupported input (%s)", output.Servo, input)
+			}
+			if weight < -MaxMixWeight || weight > MaxMixWeight {
+				return fmt.Errorf("%s mix weight out of range (%s=%d)", output.Servo, input, weight)
+			}
+		}
+	}
+	return nil
+}
+
+func isMixInput(input string) bool {
+	for _, logical := range mixInputCommands {
+		if logical == input {
+			return true
+		}
+	}
+	return false
+}
+
+func getMixPreset(preset string) ([]MixOutput, error) {
+	esc := MixOutput{Servo: "esc", Weights: map[string]int{InputThrottle: 100}}
+	steer := MixOutput{Servo: "steer", Weights: map[string]int{InputSteer: 100}}
+
+	switch preset {
+	case MixPresetNone:
+		return nil, nil
+	case MixPresetTank:
+		return []MixOutput{
+			{Servo: MixServoLeft, Weights: map[string]int{InputThrottle: 100, InputSteer: 100}},
+			{Servo: MixServoRight, Weights: map[string]int{InputThrottle: 100, InputSteer: -100}},
+		}, nil
+	case MixPreset4WSFront:
+		return []MixOutput{esc, steer,
+			{Servo: MixServoRearSteer, Weights: map[string]int{}},
+		}, nil
+	case MixPreset4WSCrab:
+		return []MixOutput{esc, steer,
+			{Servo: MixServoRearSteer, Weights: map[string]int{InputSteer: 100}},
+		}, nil
+	case MixPreset4WSCounter:
+		return []MixOutput{esc, steer,
+			{Servo: MixServoRearSteer, Weights: map[string]int{InputSteer: -100}},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported mix preset (%s)", preset)
+	}
+}
+
+// Preset outputs with the configured outputs layered on top
+func getMixOutputs(cfg MixerConfig) []MixOutput {
+	outputs, _ := getMixPreset(cfg.Preset) //Checked in Validate
+	for _, custom := range cfg.Outputs {
+		replaced := false
+		for i := range outputs {
+			if outputs[i].Servo == custom.Servo {
+				outputs[i] = custom
+				replaced = true
+			}
+		}
+		if !replaced {
+			outputs = append(outputs, custom)
+		}
+	}
+	return outputs
+}
+
+// Parses a comma separated list of servo:input=weight;input=weight outputs (left:throttle=100;steer=100,right:throttle=100;steer=-100)
+func ParseMixOutputs(value string) ([]MixOutput, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	outputs := make([]MixOutput, 0, len(parts))
+	for _, part := range parts {
+		servo, weights, found := strings.Cut(strings.TrimSpace(part), ":")
+		if !found {
+			return nil, fmt.Errorf("invalid mix output (%s)", part)
+		}
+		output := MixOutput{
+			Servo:   servo,
+			Weights: make(map[string]int),
+		}
+		for _, weightPart := range strings.Split(weights, ";") {
+			input, weightString, found := strings.Cut(weightPart, "=")
+			if !found {
+				return nil, fmt.Errorf("invalid mix weight (%s)", weightPart)
+			}
+			weight, err := strconv.Atoi(weightString)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mix weight (%s) - %w", weightPart, err)
+			}
+			output.Weights[input] = weight
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// Looks up the value range of every output servo, needs to run after servos are added
+func (m *Mixer) Init(servoController *ServoController) error {
+	for _, output := range m.outputs {
+		servo, found := servoController.servos[output.Servo]
+		if !found {
+			return fmt.Errorf("mix output servo %s not found", output.Servo)
+		}
+		m.ranges[output.Servo] = mixRange{
+			min: servo.config.MinValue,
+			mid: servo.config.MidValue,
+			max: servo.config.MaxValue,
+		}
+	}
+
+	for name := range servoController.servos {
+		_, isOutput := m.ranges[name]
+		input := mappedInput(m.inputs, name)
+		if !isOutput && (input == "" || !m.usesInput(input)) {
+			m.passThrough[name] = true
+		}
+	}
+	return nil
+}
+
+func (m *Mixer) usesInput(input string) bool {
+	for _, output := range m.outputs {
+		if output.Weights[input] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds the servo commands from the client's commands
+func (m *Mixer) Mix(commands CommandGroup) CommandGroup {
+	inputs := make(map[string]float64, len(mixInputCommands))
+	gear := ""
+	for name, command := range commands.Commands {
+		input := mappedInput(m.inputs, name)
+		if input == "" {
+			continue
+		}
+		inputs[input] = getMixInput(command.Value)
+		if input == InputThrottle {
+			gear = command.Gear
+		}
+	}
+
+	if m.config.SteerReduction > 0 {
+		reduction := float64(m.config.SteerReduction) / MaxMixWeight * math.Abs(inputs[InputThrottle])
+		inputs[InputSteer] = inputs[InputSteer] * (1 - reduction)
+	}
+
+	mixed := CommandGroup{
+		Commands: make(map[string]Command, len(m.outputs)),
+	}
+	for _, output := range m.outputs {
+		sum := 0.0
+		for input, weight := range output.Weights {
+			sum += inputs[input] * float64(weight) / MaxMixWeight
+		}
+		sum = math.Max(-1, math.Min(1, sum))
+
+		command := Command{
+			Value: m.ranges[output.Servo].getValue(sum),
+		}
+		if output.Weights[InputThrottle] != 0 {
+			command.Gear = gear
+		}
+		mixed.Commands[output.Servo] = command
+	}
+
+	for name, command := range commands.Commands {
+		if m.passThrough[name] {
+			mixed.Commands[name] = command
+		}
+	}
+	return mixed
+}
+
+// Client value to -1 to 1
+func getMixInput(value int) float64 {
+	if value >= MixInputMid {
+		return float64(value-MixInputMid) / float64(MixInputMax-MixInputMid)
+	}
+	return float64(value-MixInputMid) / float64(MixInputMid-MixInputMin)
+}
+
+// -1 to 1 back to the output servo's value range
+func (r mixRange) getValue(mix float64) int {
+	if mix >= 0 {
+		return r.mid + int(math.Round(mix*float64(r.max-r.mid)))
+	}
+	return r.mid + int(math.Round(mix*float64(r.mid-r.min)))
+}
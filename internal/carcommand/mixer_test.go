@@ -0,0 +1,201 @@
+package carcommand
+
+import (
+	"testing"
+)
+
+func newMixedCarCommand(t *testing.T, mixer MixerConfig, servoCfgs ...ServoConfig) (*CarCommand, *SimDriver) {
+	t.Helper()
+	carCommand := NewCarCommand(CarCommandConfig{
+		RefreshRate: 60,
+		ServoControllerConfig: ServoControllerConfig{
+			Driver: DriverSim,
+		},
+		ServoConfigs: servoCfgs,
+		Mixer:        mixer,
+	})
+	err := carCommand.Init()
+	if err != nil {
+		t.Fatalf("failed init: %s", err)
+	}
+	return carCommand, carCommand.servoController.drivers[0].(*SimDriver)
+}
+
+func TestMixerPresets(t *testing.T) {
+	left := testServoConfig(MixServoLeft, TypeESC, 0)
+	right := testServoConfig(MixServoRight, TypeESC, 1)
+	esc := testServoConfig("esc", TypeESC, 0)
+	steer := testServoConfig("steer", TypeServo, 1)
+	rear := testServoConfig(MixServoRearSteer, TypeServo, 2)
+	pan := testServoConfig("pan", TypeServo, 3)
+
+	tests := map[string]struct {
+		mixer    MixerConfig
+		servos   []ServoConfig
+		commands map[string]Command
+		expected map[int]float32
+	}{
+		"tank_forward": {
+			mixer:    MixerConfig{Preset: MixPresetTank},
+			servos:   []ServoConfig{left, right},
+			commands: map[string]Command{"esc": {Value: 255, Gear: "1"}, "steer": {Value: 127}},
+			expected: map[int]float32{0: 1498, 1: 1498}, //gear is applied after the value
+		},
+		"tank_spin": {
+			mixer:    MixerConfig{Preset: MixPresetTank},
+			servos:   []ServoConfig{left, right},
+			commands: map[string]Command{"esc": {Value: 127}, "steer": {Value: 255}},
+			expected: map[int]float32{0: 1498, 1: 1498}, //still in neutral
+		},
+		"4ws_front": {
+			mixer:    MixerConfig{Preset: MixPreset4WSFront},
+			servos:   []ServoConfig{esc, steer, rear, pan},
+			commands: map[string]Command{"esc": {Value: 127}, "steer": {Value: 255}, "pan": {Value: 0}},
+			expected: map[int]float32{1: 2000, 2: 1498, 3: 1000},
+		},
+		"4ws_crab": {
+			mixer:    MixerConfig{Preset: MixPreset4WSCrab},
+			servos:   []ServoConfig{esc, steer, rear},
+			commands: map[string]Command{"esc": {Value: 127}, "steer": {Value: 255}},
+			expected: map[int]float32{1: 2000, 2: 2000},
+		},
+		"4ws_counter": {
+			mixer:    MixerConfig{Preset: MixPreset4WSCounter},
+			servos:   []ServoConfig{esc, steer, rear},
+			commands: map[string]Command{"esc": {Value: 127}, "steer": {Value: 255}},
+			expected: map[int]float32{1: 2000, 2: 1000},
+		},
+		"custom_clamped": {
+			mixer: MixerConfig{Outputs: []MixOutput{
+				{Servo: "steer", Weights: map[string]int{InputSteer: 100, InputPan: 100}},
+			}},
+			servos:   []ServoConfig{steer},
+			commands: map[string]Command{"steer": {Value: 255}, "pan": {Value: 255}},
+			expected: map[int]float32{1: 2000}, //clamps at full
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			carCommand, driver := newMixedCarCommand(t, tc.mixer, tc.servos...)
+			err := carCommand.DoCommand(CommandGroup{Commands: tc.commands})
+			if err != nil {
+				t.Fatalf("failed sending command: %s", err)
+			}
+			for channel, pulse := range tc.expected {
+				assertPulse(t, driver, channel, pulse)
+			}
+		})
+	}
+}
+
+func TestMixerTankTurn(t *testing.T) {
+	left := testServoConfig(MixServoLeft, TypeESC, 0)
+	right := testServoConfig(MixServoRight, TypeESC, 1)
+	carCommand, driver := newMixedCarCommand(t, MixerConfig{Preset: MixPresetTank}, left, right)
+
+	commands := CommandGroup{Commands: map[string]Command{"esc": {Value: 191, Gear: "1"}, "steer": {Value: 191}}}
+	for i := 0; i < 2; i++ { //second pass once both are in gear
+		err := carCommand.DoCommand(commands)
+		if err != nil {
+			t.Fatalf("failed sending command: %s", err)
+		}
+	}
+	assertPulse(t, driver, 0, 2000) //half throttle plus half steer
+	assertPulse(t, driver, 1, 1498) //half throttle minus half steer
+}
+
+func TestMixerSteerReduction(t *testing.T) {
+	steer := testServoConfig("steer", TypeServo, 1)
+	mixer := MixerConfig{
+		Outputs:        []MixOutput{{Servo: "steer", Weights: map[string]int{InputSteer: 100}}},
+		SteerReduction: 50,
+	}
+	carCommand, driver := newMixedCarCommand(t, mixer, steer)
+
+	err := carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"esc": {Value: 127}, "steer": {Value: 255}}})
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+	assertPulse(t, driver, 1, 2000) //full steer when stopped
+
+	err = carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"esc": {Value: 255}, "steer": {Value: 255}}})
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+	assertPulse(t, driver, 1, 1749) //half steer at full throttle
+}
+
+func TestMixerChannelMapInputs(t *testing.T) {
+	steer := testServoConfig("steer", TypeServo, 1)
+	tests := map[string]struct {
+		commands map[string]Command
+		expected float32
+	}{
+		"renamed_throttle": {
+			commands: map[string]Command{"drive": {Value: 0}},
+			expected: 1000,
+		},
+		"esc_not_throttle": {
+			commands: map[string]Command{"esc": {Value: 255}},
+			expected: 1498, //esc is mapped to aux, throttle stays centered
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			carCommand := NewCarCommand(CarCommandConfig{
+				RefreshRate: 60,
+				ServoControllerConfig: ServoControllerConfig{
+					Driver: DriverSim,
+				},
+				ServoConfigs: []ServoConfig{steer},
+				Mixer:        MixerConfig{Outputs: []MixOutput{{Servo: "steer", Weights: map[string]int{InputThrottle: 100}}}},
+				Inputs:       map[string]string{"drive": InputThrottle, "esc": InputAux},
+			})
+			err := carCommand.Init()
+			if err != nil {
+				t.Fatalf("failed init: %s", err)
+			}
+			err = carCommand.DoCommand(CommandGroup{Commands: tc.commands})
+			if err != nil {
+				t.Fatalf("failed sending command: %s", err)
+			}
+			assertPulse(t, carCommand.servoController.drivers[0].(*SimDriver), 1, tc.expected)
+		})
+	}
+}
+
+func TestMixerConfig(t *testing.T) {
+	outputs, err := ParseMixOutputs("left:throttle=100;steer=100,right:throttle=100;steer=-100")
+	if err != nil {
+		t.Fatalf("failed parsing outputs: %s", err)
+	}
+	if len(outputs) != 2 || outputs[1].Servo != MixServoRight || outputs[1].Weights[InputSteer] != -100 {
+		t.Errorf("unexpected outputs %+v", outputs)
+	}
+
+	invalid := map[string]MixerConfig{
+		"unknown_preset":  {Preset: "hovercraft"},
+		"unknown_input":   {Outputs: []MixOutput{{Servo: "esc", Weights: map[string]int{"boost": 100}}}},
+		"weight_range":    {Outputs: []MixOutput{{Servo: "esc", Weights: map[string]int{InputThrottle: 150}}}},
+		"reduction_range": {Preset: MixPresetTank, SteerReduction: 120},
+	}
+	for testName, cfg := range invalid {
+		t.Run(testName, func(t *testing.T) {
+			if cfg.Validate() == nil {
+				t.Errorf("expected invalid config")
+			}
+		})
+	}
+
+	carCommand := NewCarCommand(CarCommandConfig{
+		RefreshRate:           60,
+		ServoControllerConfig: ServoControllerConfig{Driver: DriverSim},
+		ServoConfigs:          []ServoConfig{testServoConfig("esc", TypeESC, 0)},
+		Mixer:                 MixerConfig{Preset: MixPresetTank},
+	})
+	if carCommand.Init() == nil {
+		t.Errorf("expected init to fail without left/right servos")
+	}
+}
@@ -0,0 +1,149 @@
+package carcommand
+
+import (
+	"fmt"
+	"time"
+)
+
+const OutputReportInterval = 10 * time.Second
+
+// BatchDriver can write a run of channels in one bus transaction
+type BatchDriver interface {
+	SetPulses(startChannel int, pulses []float32) error
+}
+
+// OutputStats describes one flush of the output stage
+type OutputStats struct {
+	Channels int           //Channels that changed
+	Writes   int           //Bus transactions used to write them
+	Errors   int           //Writes that failed
+	Latency  time.Duration //Time spent writing
+}
+
+// outputStage sits between the servos and the driver, servos write into it and the
+// command loop flushes it once per tick so only changed channels hit the bus
+type outputStage struct {
+	driver  OutputDriver
+	pending map[int]float32
+	written map[int]float32
+	stats   OutputStats
+}
+
+func newOutputStage(driver OutputDriver) *outputStage {
+	return &outputStage{
+		driver:  driver,
+		pending: make(map[int]float32, ChannelsPerBoard),
+		written: make(map[int]float32, ChannelsPerBoard),
+	}
+}
+
+func (o *outputStage) Init() error {
+	return o.driver.Init()
+}
+
+// Drops what we think the hardware has so the next flush rewrites every channel
+func (o *outputStage) forget() {
+	for channel, pulse := range o.written {
+		if _, pending := o.pending[channel]; !pending {
+			o.pending[channel] = pulse
+		}
+	}
+	o.written = make(map[int]float32, ChannelsPerBoard)
+}
+
+// Buffers the pulse until the next flush, the last pulse set on a channel wins
+func (o *outputStage) SetPulse(channel int, pulse float32) error {
+	if channel < 0 || channel >= ChannelsPerBoard {
+		return fmt.Errorf("channel out of range (%d)", channel)
+	}
+	o.pending[channel] = pulse
+	return nil
+}
+
+// Writes every channel that changed since the last flush
+func (o *outputStage) flush() (OutputStats, error) {
+	stats := OutputStats{}
+	first, last := -1, -1
+	for channel, pulse := range o.pending {
+		written, found := o.written[channel]
+		if found && written == pulse {
+			delete(o.pending, channel)
+			continue
+		}
+		stats.Channels++
+		if first == -1 || channel < first {
+			first = channel
+		}
+		if channel > last {
+			last = channel
+		}
+	}
+	if stats.Channels == 0 {
+		o.stats = stats
+		return stats, nil
+	}
+
+	start := time.Now()
+	var err error
+	if batchDriver, ok := o.driver.(BatchDriver); ok && o.canBatch(first, last) {
+		err = o.writeBatch(batchDriver, first, last, &stats)
+	} else {
+		err = o.writeEach(&stats)
+	}
+	stats.Latency = time.Since(start)
+	o.stats = stats
+	return stats, err
+}
+
+// Channels between the changed ones get rewritten with their last value, so they all need one
+func (o *outputStage) canBatch(first int, last int) bool {
+	for channel := first; channel <= last; channel++ {
+		_, pending := o.pending[channel]
+		_, written := o.written[channel]
+		if !pending && !written {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *outputStage) writeBatch(driver BatchDriver, first int, last int, stats *OutputStats) error {
+	pulses := make([]float32, 0, last-first+1)
+	for channel := first; channel <= last; channel++ {
+		pulse, found := o.pending[channel]
+		if !found {
+			pulse = o.written[channel]
+		}
+		pulses = append(pulses, pulse)
+	}
+
+	stats.Writes++
+	err := driver.SetPulses(first, pulses)
+	if err != nil {
+		stats.Errors++
+		return fmt.Errorf("%w: channels %d-%d - %s", ErrOutputWrite, first, last, err) //Left pending so the next flush retries
+	}
+	for i, pulse := range pulses {
+		o.written[first+i] = pulse
+		delete(o.pending, first+i)
+	}
+	return nil
+}
+
+func (o *outputStage) writeEach(stats *OutputStats) error {
+	var firstErr error
+	for channel, pulse := range o.pending {
+		stats.Writes++
+		err := o.driver.SetPulse(channel, pulse)
+		if err != nil {
+			stats.Errors++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%w: channel %d - %s", ErrOutputWrite, channel, err)
+			}
+			continue
+		}
+		o.written[channel] = pulse
+		delete(o.pending, channel)
+	}
+	return firstErr
+}
@@ -0,0 +1,90 @@
+package carcommand
+
+import (
+	"fmt"
+)
+
+const MaxThrottleLimit = 100 //Percent, lifts the limit
+
+// OutputLimit caps the throttle for a safety reason, sensors send these on LimitChannel.
+// Each source replaces its own last limit and the tightest of all the sources applies.
+type OutputLimit struct {
+	Source      string
+	MaxThrottle int  //Percent of full throttle, brakes are never limited
+	Neutral     bool //Throttle held at neutral
+	Reason      string
+}
+
+func (l OutputLimit) Validate() error {
+	if l.Source == "" {
+		return fmt.Errorf("output limit missing source")
+	}
+	if l.MaxThrottle < 0 || l.MaxThrottle > MaxThrottleLimit {
+		return fmt.Errorf("max throttle must be 0 to %d percent (%d)", MaxThrottleLimit, l.MaxThrottle)
+	}
+	return nil
+}
+
+func (l OutputLimit) lifted() bool {
+	return !l.Neutral && l.MaxThrottle >= MaxThrottleLimit
+}
+
+func (c *CarCommand) setOutputLimit(limit OutputLimit) error {
+	err := limit.Validate()
+	if err != nil {
+		return err
+	}
+	last, found := c.outputLimits[limit.Source]
+	if limit.lifted() {
+		if !found {
+			return nil
+		}
+		delete(c.outputLimits, limit.Source)
+		c.sendEvent(EventOutputLimit, fmt.Sprintf("%s lifted", limit.Source))
+		return nil
+	}
+	if found && last == limit {
+		return nil
+	}
+
+	c.outputLimits[limit.Source] = limit
+	if limit.Neutral {
+		c.stopAllCruise(limit.Source)
+		c.sendEvent(EventOutputLimit, fmt.Sprintf("%s neutral - %s", limit.Source, limit.Reason))
+		return nil
+	}
+	c.sendEvent(EventOutputLimit, fmt.Sprintf("%s throttle %d%% - %s", limit.Source, limit.MaxThrottle, limit.Reason))
+	return nil
+}
+
+// Tightest throttle percent of the driver and every source, 0 when any of them wants neutral
+func (c *CarCommand) maxThrottle() int {
+	maxThrottle := c.driverLimits.MaxThrottle
+	for _, limit := range c.outputLimits {
+		if limit.Neutral {
+			return 0
+		}
+		if limit.MaxThrottle < maxThrottle {
+			maxThrottle = limit.MaxThrottle
+		}
+	}
+	return maxThrottle
+}
+
+// Scales throttle commands that drive the car toward center, runs after cruise so a latched throttle is limited too.
+// Braking passes through untouched, even a neutral limit has to be able to stop the car.
+func (c *CarCommand) applyOutputLimits(commands CommandGroup) CommandGroup {
+	maxThrottle := c.maxThrottle()
+	if maxThrottle >= MaxThrottleLimit {
+		return commands
+	}
+
+	limited := make(map[string]Command, len(commands.Commands))
+	for name, command := range commands.Commands {
+		if c.commandInput(name) == InputThrottle && c.throttleSide(name, command) != "" {
+			command.Value = MixInputMid + (command.Value-MixInputMid)*maxThrottle/MaxThrottleLimit
+		}
+		limited[name] = command
+	}
+	return CommandGroup{Commands: limited}
+}
@@ -0,0 +1,88 @@
+package carcommand
+
+import (
+	"testing"
+)
+
+func TestOutputLimits(t *testing.T) {
+	escCfg := testServoConfig("esc", TypeESC, 0)
+	escCfg.Esc.Mode = EscModeFB
+	carCommand, _ := newSimCarCommand(t, escCfg)
+
+	steps := []struct {
+		name     string
+		limit    OutputLimit
+		event    bool
+		throttle int //Limited value of full throttle
+		reverse  int //Limited value of full reverse
+	}{
+		{name: "battery_low", limit: OutputLimit{Source: "battery", MaxThrottle: 50, Reason: "low"}, event: true, throttle: 127 + 64, reverse: 127 - 63},
+		{name: "same_again", limit: OutputLimit{Source: "battery", MaxThrottle: 50, Reason: "low"}, throttle: 127 + 64, reverse: 127 - 63},
+		{name: "tighter_source", limit: OutputLimit{Source: "imu", MaxThrottle: 20, Reason: "tilted"}, event: true, throttle: 127 + 25, reverse: 127 - 25},
+		{name: "looser_source_lifted", limit: OutputLimit{Source: "imu", MaxThrottle: MaxThrottleLimit}, event: true, throttle: 127 + 64, reverse: 127 - 63},
+		{name: "neutral", limit: OutputLimit{Source: "battery", Neutral: true, Reason: "cutoff"}, event: true, throttle: 127, reverse: 127},
+		{name: "lift_unknown", limit: OutputLimit{Source: "distance", MaxThrottle: MaxThrottleLimit}, throttle: 127, reverse: 127},
+	}
+
+	for _, step := range steps {
+		err := carCommand.setOutputLimit(step.limit)
+		if err != nil {
+			t.Fatalf("%s: failed setting limit: %s", step.name, err)
+		}
+		if step.event {
+			assertEvent(t, carCommand, EventOutputLimit)
+		} else if len(carCommand.EventChannel) != 0 {
+			t.Errorf("%s: expected no event, got %+v", step.name, <-carCommand.EventChannel)
+		}
+
+		commands := CommandGroup{Commands: map[string]Command{"esc": {Value: MixInputMax, Gear: "1"}, "steer": {Value: MixInputMax}}}
+		limited := carCommand.applyOutputLimits(commands)
+		if value := limited.Commands["esc"].Value; value != step.throttle {
+			t.Errorf("%s: expected throttle %d, got %d", step.name, step.throttle, value)
+		}
+		if limited.Commands["steer"].Value != MixInputMax {
+			t.Errorf("%s: expected steering to be left alone", step.name)
+		}
+		limited = carCommand.applyOutputLimits(CommandGroup{Commands: map[string]Command{"esc": {Value: MixInputMin, Gear: "R"}}})
+		if value := limited.Commands["esc"].Value; value != step.reverse {
+			t.Errorf("%s: expected reverse %d, got %d", step.name, step.reverse, value)
+		}
+		limited = carCommand.applyOutputLimits(CommandGroup{Commands: map[string]Command{"esc": {Value: MixInputMin, Gear: "1"}}})
+		if value := limited.Commands["esc"].Value; value != MixInputMin {
+			t.Errorf("%s: expected full brake, got %d", step.name, value)
+		}
+	}
+
+	if carCommand.setOutputLimit(OutputLimit{Source: "battery", MaxThrottle: 101}) == nil {
+		t.Errorf("expected a limit over 100%% to be rejected")
+	}
+}
+
+func TestDriverThrottleLimit(t *testing.T) {
+	escCfg := testServoConfig("drive", TypeESC, 0)
+	escCfg.Esc.Mode = EscModeFB
+	carCommand, _ := newSimCarCommand(t, escCfg)
+	carCommand.config.Inputs = map[string]string{"drive": InputThrottle} //From the channel map, the name alone isn't a throttle
+
+	err := carCommand.setDriverLimits(&DriverLimits{MaxThrottle: 40})
+	if err != nil {
+		t.Fatalf("failed setting driver limit: %s", err)
+	}
+
+	tests := map[string]struct {
+		command  Command
+		expected int
+	}{
+		"forward": {command: Command{Value: MixInputMax, Gear: "1"}, expected: 127 + 128*40/100},
+		"reverse": {command: Command{Value: MixInputMin, Gear: "R"}, expected: 127 - 127*40/100},
+		"brake":   {command: Command{Value: MixInputMin, Gear: "1"}, expected: MixInputMin},
+	}
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			limited := carCommand.applyOutputLimits(CommandGroup{Commands: map[string]Command{"drive": tc.command}})
+			if value := limited.Commands["drive"].Value; value != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, value)
+			}
+		})
+	}
+}
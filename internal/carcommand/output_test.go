@@ -0,0 +1,108 @@
+package carcommand
+
+import (
+	"fmt"
+	"testing"
+)
+
+type failingDriver struct {
+	*SimDriver
+	fail bool
+}
+
+func (d *failingDriver) SetPulse(channel int, pulse float32) error {
+	if d.fail {
+		return fmt.Errorf("bus error")
+	}
+	return d.SimDriver.SetPulse(channel, pulse)
+}
+
+func TestOutputStageBatching(t *testing.T) {
+	carCommand, driver := newSimCarCommand(t,
+		testServoConfig("esc", TypeESC, 0),
+		testServoConfig("steer", TypeServo, 1),
+		testServoConfig("pan", TypeServo, 3),
+	)
+
+	if driver.Writes() != 3 { //channels 0, 1 and 3 can't batch over unwritten channel 2
+		t.Errorf("expected 3 writes setting neutral on connect, got %d", driver.Writes())
+	}
+
+	tests := []struct {
+		commands map[string]Command
+		channels int
+		writes   int
+	}{
+		{ //already at neutral from connecting
+			commands: map[string]Command{"esc": {Value: 127}, "steer": {Value: 127}, "pan": {Value: 127}},
+			channels: 0,
+			writes:   0,
+		},
+		{ //only steer changed
+			commands: map[string]Command{"esc": {Value: 127}, "steer": {Value: 255}, "pan": {Value: 127}},
+			channels: 1,
+			writes:   1,
+		},
+		{ //adjacent channels go in one write
+			commands: map[string]Command{"esc": {Value: 127}, "steer": {Value: 0}, "pan": {Value: 127}},
+			channels: 1,
+			writes:   1,
+		},
+	}
+
+	for i, tc := range tests {
+		before := driver.Writes()
+		err := carCommand.DoCommand(CommandGroup{Commands: tc.commands})
+		if err != nil {
+			t.Fatalf("step %d failed sending command: %s", i, err)
+		}
+		stats := carCommand.servoController.OutputStats()
+		if stats.Channels != tc.channels || stats.Writes != tc.writes {
+			t.Errorf("step %d expected %d channels in %d writes, got %+v", i, tc.channels, tc.writes, stats)
+		}
+		if driver.Writes()-before != tc.writes {
+			t.Errorf("step %d expected %d driver writes, got %d", i, tc.writes, driver.Writes()-before)
+		}
+	}
+	assertPulse(t, driver, 1, 1000)
+}
+
+func TestOutputStageBatchesSpan(t *testing.T) {
+	driver := NewSimDriver()
+	output := newOutputStage(driver)
+	for channel := 0; channel < 4; channel++ {
+		output.SetPulse(channel, 1500)
+	}
+	stats, err := output.flush()
+	if err != nil || stats.Writes != 1 || stats.Channels != 4 {
+		t.Fatalf("expected 4 channels in 1 write, got %+v (%v)", stats, err)
+	}
+
+	output.SetPulse(0, 1000)
+	output.SetPulse(3, 2000)
+	stats, err = output.flush()
+	if err != nil || stats.Writes != 1 || stats.Channels != 2 {
+		t.Errorf("expected 2 channels in 1 write, got %+v (%v)", stats, err)
+	}
+	if pulses := driver.Pulses(1); len(pulses) != 2 || pulses[1].Pulse != 1500 {
+		t.Errorf("expected channel 1 rewritten with its last pulse, got %+v", pulses)
+	}
+}
+
+func TestOutputStageErrors(t *testing.T) {
+	driver := &failingDriver{SimDriver: NewSimDriver(), fail: true}
+	output := newOutputStage(driver)
+	output.SetPulse(0, 1500)
+	output.SetPulse(5, 1500)
+
+	stats, err := output.flush()
+	if err == nil || stats.Errors != 2 || stats.Writes != 2 {
+		t.Errorf("expected 2 failed writes, got %+v (%v)", stats, err)
+	}
+
+	driver.fail = false
+	stats, err = output.flush()
+	if err != nil || stats.Channels != 2 {
+		t.Errorf("expected failed channels to be retried, got %+v (%v)", stats, err)
+	}
+}
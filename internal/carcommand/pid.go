@@ -0,0 +1,79 @@
+package carcommand
+
+import (
+	"fmt"
+	"time"
+)
+
+// PIDConfig tunes a pid controller, gains are per unit of error
+type PIDConfig struct {
+	Kp float64
+	Ki float64
+	Kd float64
+}
+
+type pidController struct {
+	config       PIDConfig
+	min          float64
+	max          float64
+	integral     float64
+	lastMeasured float64
+	started      bool
+}
+
+func (c PIDConfig) Validate() error {
+	if c.Kp < 0 || c.Ki < 0 || c.Kd < 0 {
+		return fmt.Errorf("pid gains can't be negative (p %.3f | i %.3f | d %.3f)", c.Kp, c.Ki, c.Kd)
+	}
+	return nil
+}
+
+func newPIDController(cfg PIDConfig, min float64, max float64) pidController {
+	return pidController{
+		config: cfg,
+		min:    min,
+		max:    max,
+	}
+}
+
+// Starts the integral so the first output is close to start, taking over from the driver without a jump
+func (p *pidController) reset(start float64) {
+	p.integral = 0
+	if p.config.Ki > 0 {
+		p.integral = start / p.config.Ki
+	}
+	p.started = false
+}
+
+// Returns the output for how far measured is from target, dt is the time since the last update.
+// The derivative works on the measurement so changing the target doesn't kick the output,
+// and the integral stops growing while the output is pinned at a limit.
+func (p *pidController) update(target float64, measured float64, dt time.Duration) float64 {
+	seconds := dt.Seconds()
+	err := target - measured
+
+	derivative := 0.0
+	if p.started && seconds > 0 {
+		derivative = -(measured - p.lastMeasured) / seconds
+	}
+	p.lastMeasured = measured
+	p.started = true
+
+	integral := p.integral + err*seconds
+	output := p.config.Kp*err + p.config.Ki*integral + p.config.Kd*derivative
+	switch {
+	case output > p.max:
+		output = p.max
+		if err < 0 {
+			p.integral = integral
+		}
+	case output < p.min:
+		output = p.min
+		if err > 0 {
+			p.integral = integral
+		}
+	default:
+		p.integral = integral
+	}
+	return output
+}
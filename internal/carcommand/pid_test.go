@@ -0,0 +1,53 @@
+package carcommand
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPIDHoldsSpeedOnSlope(t *testing.T) {
+	tests := map[string]struct {
+		config PIDConfig
+		slope  float64 //Deceleration the hill adds, m/s/s
+		max    float64
+		speed  float64 //Expected speed at the end
+	}{
+		"flat":         {config: PIDConfig{Kp: 0.5, Ki: 0.5}, speed: 2, max: 1},
+		"climb":        {config: PIDConfig{Kp: 0.5, Ki: 0.5}, slope: 1, speed: 2, max: 1},
+		"descent":      {config: PIDConfig{Kp: 0.5, Ki: 0.5, Kd: 0.05}, slope: -1, speed: 2, max: 1},
+		"capped_climb": {config: PIDConfig{Kp: 0.5, Ki: 0.5}, slope: 1, speed: 1.5, max: 0.4}, //Throttle cap can't hold the target
+	}
+
+	dt := 20 * time.Millisecond
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			pid := newPIDController(test.config, 0, test.max)
+			pid.reset(0)
+			speed := 0.0
+			output := 0.0
+			for i := 0; i < 1500; i++ {
+				output = pid.update(2, speed, dt)
+				if output < 0 || output > test.max {
+					t.Fatalf("output %.3f outside 0 to %.2f", output, test.max)
+				}
+				speed += (10*output - 2*speed - test.slope) * dt.Seconds() //Throttle pushes, drag and the hill pull back
+				if speed < 0 {
+					speed = 0
+				}
+			}
+			if math.Abs(speed-test.speed) > 0.05 {
+				t.Errorf("expected to settle at %.2fm/s, got %.2fm/s (output %.3f)", test.speed, speed, output)
+			}
+		})
+	}
+}
+
+func TestPIDResetTakesOver(t *testing.T) {
+	pid := newPIDController(PIDConfig{Kp: 0.5, Ki: 0.5}, 0, 1)
+	pid.reset(0.6)
+	output := pid.update(2, 2, 20*time.Millisecond)
+	if math.Abs(output-0.6) > 0.001 {
+		t.Errorf("expected to start from the driver's throttle 0.6, got %.3f", output)
+	}
+}
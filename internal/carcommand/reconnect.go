@@ -0,0 +1,85 @@
+package carcommand
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const DefaultReconnectMin = 250 * time.Millisecond
+const DefaultReconnectMax = 5 * time.Second
+
+// Wrapped by errors that came from writing to the output driver, these take the actuators offline instead of stopping the car
+var ErrOutputWrite = errors.New("output write failed")
+
+// Wrapped by errors from a closed carcommand channel, the command loop can't restart without its input
+var ErrChannelClosed = errors.New("channel closed")
+
+// Takes the actuators offline for output write errors, anything else is returned
+func (c *CarCommand) checkOutputError(err error) error {
+	if err == nil || !errors.Is(err, ErrOutputWrite) {
+		return err
+	}
+	c.setOffline(err)
+	return nil
+}
+
+// Marks the actuators offline, the command loop stops writing and starts trying to reconnect
+func (c *CarCommand) setOffline(err error) {
+	if !c.actuatorsOnline {
+		return
+	}
+	c.actuatorsOnline = false
+	c.reconnectBackoff = c.config.ReconnectMin
+	c.nextReconnect = time.Now().Add(c.reconnectBackoff)
+	log.Printf("warning: actuators offline, reconnecting in %s - %s\n", c.reconnectBackoff, err.Error())
+	c.sendEvent(EventActuatorsOffline, err.Error())
+}
+
+// Tries to re-init the output driver once the backoff has passed, doubling the backoff each time it fails
+func (c *CarCommand) tryReconnect() {
+	if time.Now().Before(c.nextReconnect) {
+		return
+	}
+
+	err := c.servoController.Reconnect()
+	if err != nil {
+		c.reconnectBackoff *= 2
+		if c.reconnectBackoff > c.config.ReconnectMax {
+			c.reconnectBackoff = c.config.ReconnectMax
+		}
+		c.nextReconnect = time.Now().Add(c.reconnectBackoff)
+		log.Printf("warning: reconnecting actuators failed, trying again in %s - %s\n", c.reconnectBackoff, err.Error())
+		return
+	}
+
+	c.actuatorsOnline = true
+	log.Println("actuators back online")
+	c.sendEvent(EventActuatorsOnline, "actuators reconnected")
+}
+
+// Connects to every board, servos keep their settings and go to their failsafe state before every channel is rewritten
+func (s *ServoController) Reconnect() error {
+	for i, driver := range s.drivers {
+		err := driver.Init()
+		if err != nil {
+			return fmt.Errorf("error initializing output driver for board %d - %w", i, err)
+		}
+	}
+
+	for _, output := range s.outputs {
+		output.forget()
+	}
+	for name, servo := range s.servos {
+		if s.calibrating(name) {
+			continue //Forgotten calibration pulse gets rewritten
+		}
+		err := servo.SetFailsafe()
+		if err != nil {
+			return fmt.Errorf("error setting %s servo to failsafe: %w", servo.config.Name, err)
+		}
+	}
+	_, err := s.Flush()
+	return err
+}
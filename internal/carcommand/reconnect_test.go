@@ -0,0 +1,114 @@
+package carcommand
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestActuatorReconnect(t *testing.T) {
+	carCommand, driver := newSimCarCommand(t, testServoConfig("esc", TypeESC, 0), testServoConfig("steer", TypeServo, 1))
+
+	driver.SetFailing(true)
+	err := carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"steer": {Value: 255}}})
+	if !errors.Is(err, ErrOutputWrite) {
+		t.Fatalf("expected output write error, got %v", err)
+	}
+	if carCommand.checkOutputError(err) != nil || carCommand.actuatorsOnline {
+		t.Fatalf("expected write error to take actuators offline")
+	}
+	assertEvent(t, carCommand, EventActuatorsOffline)
+
+	carCommand.tryReconnect() //still inside the backoff
+	if carCommand.reconnectBackoff != DefaultReconnectMin {
+		t.Errorf("expected no reconnect attempt before the backoff, backoff is %s", carCommand.reconnectBackoff)
+	}
+
+	carCommand.nextReconnect = time.Now()
+	carCommand.tryReconnect() //bus still down
+	if carCommand.actuatorsOnline || carCommand.reconnectBackoff != 2*DefaultReconnectMin {
+		t.Errorf("expected failed reconnect to double the backoff, got %s", carCommand.reconnectBackoff)
+	}
+
+	driver.SetFailing(false)
+	driver.Reset()
+	carCommand.nextReconnect = time.Now()
+	carCommand.tryReconnect()
+	if !carCommand.actuatorsOnline {
+		t.Fatalf("expected actuators back online")
+	}
+	assertEvent(t, carCommand, EventActuatorsOnline)
+	assertPulse(t, driver, 0, 1498) //every channel rewritten in its failsafe state
+	assertPulse(t, driver, 1, 1498)
+
+	err = carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"steer": {Value: 255}}})
+	if err != nil {
+		t.Fatalf("failed sending command after reconnect: %s", err)
+	}
+	assertPulse(t, driver, 1, 2000)
+}
+
+func TestReconnectBackoffLimit(t *testing.T) {
+	carCommand, driver := newSimCarCommand(t, testServoConfig("steer", TypeServo, 1))
+	driver.SetFailing(true)
+	carCommand.setOffline(errors.New("bus error"))
+	for i := 0; i < 10; i++ {
+		carCommand.nextReconnect = time.Now()
+		carCommand.tryReconnect()
+	}
+	if carCommand.reconnectBackoff != DefaultReconnectMax {
+		t.Errorf("expected backoff to stop at %s, got %s", DefaultReconnectMax, carCommand.reconnectBackoff)
+	}
+}
+
+func assertEvent(t *testing.T, carCommand *CarCommand, eventType string) {
+	t.Helper()
+	select {
+	case event := <-carCommand.EventChannel:
+		if event.Type != eventType {
+			t.Errorf("expected %s event, got %+v", eventType, event)
+		}
+	default:
+		t.Errorf("expected %s event, got none", eventType)
+	}
+}
+
+func TestCommandLoopRestart(t *testing.T) {
+	carCommand := NewCarCommand(CarCommandConfig{
+		RefreshRate:  60,
+		ReconnectMin: 10 * time.Millisecond,
+		ServoControllerConfig: ServoControllerConfig{
+			Driver: DriverSim,
+		},
+		ServoConfigs: []ServoConfig{testServoConfig("steer", TypeServo, 1)},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- carCommand.Start(ctx)
+	}()
+
+	carCommand.CommandChannel <- CommandGroup{Commands: map[string]Command{"steer": {Value: 999}}} //out of bounds fails the loop
+	for _, eventType := range []string{EventActuatorsOffline, EventActuatorsOnline} {
+		select {
+		case event := <-carCommand.EventChannel:
+			if event.Type != eventType {
+				t.Fatalf("expected %s event, got %+v", eventType, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s event, got none", eventType)
+		}
+	}
+
+	close(carCommand.CommandChannel) //can't restart without commands
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, ErrChannelClosed) {
+			t.Errorf("expected closed channel error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the command loop to stop")
+	}
+}
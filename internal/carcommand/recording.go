@@ -0,0 +1,347 @@
+package carcommand
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Recordings are JSON lines files named <name>.jsonl in the recording dir.
+// The first line is a header, every line after it is one CommandGroup the car applied from the driver:
+//
+//	{"version":1,"name":"donuts","started":"2026-10-18T08:00:00Z"}
+//	{"t":0,"commands":{"esc":{"value":200,"gear":"1"},"steer":{"value":127}}}
+//	{"t":16,"commands":{"esc":{"value":210,"gear":"1"},"steer":{"value":40}}}
+//
+// t is milliseconds since the recording started. Commands are recorded before mixing so replays go
+// through the current mixer, slew limits and curves the same way live input does.
+const RecordingVersion = 1
+const RecordingExt = ".jsonl"
+const DefaultRecordingDir = "recordings"
+
+const ReplayInterruptDeadZone = 10 //Command values within this of center don't count as the driver taking over
+
+const RecordStart = "record"
+const RecordStop = "stop" //Stops recording and replaying
+const RecordPlay = "play"
+const RecordList = "list"
+
+var recordingNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// RecordingRequest comes from the server, the result is sent back on Reply
+type RecordingRequest struct {
+	Action string               `json:"action"`
+	Name   string               `json:"name"`
+	Reply  chan RecordingResult `json:"-"`
+}
+
+type RecordingResult struct {
+	Status     RecordingStatus
+	Recordings []RecordingInfo //Only filled in for list
+	Err        error
+}
+
+// RecordingStatus has the names of the recording being made and the one being replayed, empty when idle
+type RecordingStatus struct {
+	Recording string `json:"recording"`
+	Playing   string `json:"playing"`
+}
+
+type RecordingInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+type RecordingHeader struct {
+	Version int       `json:"version"`
+	Name    string    `json:"name"`
+	Started time.Time `json:"started"`
+}
+
+type RecordedFrame struct {
+	Offset   int64              `json:"t"` //Milliseconds since the recording started
+	Commands map[string]Command `json:"commands"`
+}
+
+type recorder struct {
+	name    string
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	start   time.Time
+}
+
+type player struct {
+	name   string
+	frames []RecordedFrame
+	start  time.Time
+	next   int
+}
+
+func (c *CarCommand) DoRecording(request RecordingRequest) RecordingResult {
+	result := RecordingResult{}
+	switch request.Action {
+	case RecordStart:
+		result.Err = c.startRecording(request.Name)
+	case RecordStop:
+		result.Err = c.stopRecording()
+		c.stopReplay("stopped")
+	case RecordPlay:
+		result.Err = c.startReplay(request.Name)
+	case RecordList:
+		result.Recordings, result.Err = ListRecordings(c.config.RecordingDir)
+	default:
+		result.Err = fmt.Errorf("unsupported recording action (%s)", request.Action)
+	}
+	result.Status = c.recordingStatus()
+	return result
+}
+
+func (c *CarCommand) recordingStatus() RecordingStatus {
+	status := RecordingStatus{}
+	if c.recorder != nil {
+		status.Recording = c.recorder.name
+	}
+	if c.player != nil {
+		status.Playing = c.player.name
+	}
+	return status
+}
+
+func (c *CarCommand) startRecording(name string) error {
+	if c.recorder != nil {
+		return fmt.Errorf("already recording %s", c.recorder.name)
+	}
+	if c.player != nil {
+		return fmt.Errorf("can't record while replaying %s", c.player.name)
+	}
+	recorder, err := newRecorder(c.config.RecordingDir, name)
+	if err != nil {
+		return err
+	}
+	c.recorder = recorder
+	c.sendEvent(EventRecording, fmt.Sprintf("%s started", name))
+	return nil
+}
+
+func (c *CarCommand) stopRecording() error {
+	if c.recorder == nil {
+		return nil
+	}
+	name := c.recorder.name
+	err := c.recorder.close()
+	c.recorder = nil
+	c.sendEvent(EventRecording, fmt.Sprintf("%s saved", name))
+	return err
+}
+
+// Recording errors stop the recording but never the car
+func (c *CarCommand) recordCommand(commands CommandGroup) {
+	if c.recorder == nil {
+		return
+	}
+	err := c.recorder.record(commands, time.Now())
+	if err != nil {
+		log.Printf("warning: recording %s stopped - %s\n", c.recorder.name, err.Error())
+		c.stopRecording()
+	}
+}
+
+func (c *CarCommand) startReplay(name string) error {
+	if c.recorder != nil {
+		return fmt.Errorf("can't replay while recording %s", c.recorder.name)
+	}
+	if c.estop.active {
+		return fmt.Errorf("can't replay while the estop is latched")
+	}
+	frames, err := LoadRecording(c.config.RecordingDir, name)
+	if err != nil {
+		return err
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("recording %s is empty", name)
+	}
+	c.player = &player{
+		name:   name,
+		frames: frames,
+		start:  time.Now(),
+	}
+	c.sendEvent(EventReplay, fmt.Sprintf("%s started", name))
+	return nil
+}
+
+func (c *CarCommand) stopReplay(reason string) {
+	if c.player == nil {
+		return
+	}
+	c.sendEvent(EventReplay, fmt.Sprintf("%s %s", c.player.name, reason))
+	c.player = nil
+}
+
+// The most recent frame that's due, frames that were due on earlier ticks are skipped like stale live commands
+func (c *CarCommand) nextReplayCommand(now time.Time) (CommandGroup, bool) {
+	if c.player == nil {
+		return CommandGroup{}, false
+	}
+	commands, ok := c.player.due(now)
+	if c.player.done() {
+		c.stopReplay("finished")
+	}
+	return commands, ok
+}
+
+// True if the driver moved a centering input away from center, resting input doesn't interrupt a replay.
+// Switches, sounds and cruise buttons rest at their ends so they're never checked.
+func (c *CarCommand) liveInput(commands CommandGroup) bool {
+	for name, command := range commands.Commands {
+		switch c.commandInput(name) {
+		case InputThrottle, InputSteer, InputPan, InputTilt:
+		default:
+			continue
+		}
+		if command.Value > MixInputMid+ReplayInterruptDeadZone || command.Value < MixInputMid-ReplayInterruptDeadZone {
+			return true
+		}
+	}
+	return false
+}
+
+func recordingPath(dir string, name string) (string, error) {
+	if !recordingNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid recording name (%s), use letters, numbers, - and _", name)
+	}
+	return filepath.Join(dir, name+RecordingExt), nil
+}
+
+func newRecorder(dir string, name string) (*recorder, error) {
+	path, err := recordingPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed making recording dir - %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating recording - %w", err)
+	}
+
+	r := &recorder{
+		name:   name,
+		file:   file,
+		writer: bufio.NewWriter(file),
+		start:  time.Now(),
+	}
+	r.encoder = json.NewEncoder(r.writer)
+	err = r.encoder.Encode(RecordingHeader{Version: RecordingVersion, Name: name, Started: r.start})
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed writing recording header - %w", err)
+	}
+	return r, nil
+}
+
+func (r *recorder) record(commands CommandGroup, now time.Time) error {
+	return r.encoder.Encode(RecordedFrame{
+		Offset:   now.Sub(r.start).Milliseconds(),
+		Commands: commands.Commands,
+	})
+}
+
+func (r *recorder) close() error {
+	err := r.writer.Flush()
+	if err != nil {
+		r.file.Close()
+		return fmt.Errorf("failed writing recording - %w", err)
+	}
+	return r.file.Close()
+}
+
+func (p *player) due(now time.Time) (CommandGroup, bool) {
+	elapsed := now.Sub(p.start).Milliseconds()
+	found := false
+	commands := CommandGroup{}
+	for p.next < len(p.frames) && p.frames[p.next].Offset <= elapsed {
+		commands.Commands = p.frames[p.next].Commands
+		found = true
+		p.next++
+	}
+	return commands, found
+}
+
+func (p *player) done() bool {
+	return p.next >= len(p.frames)
+}
+
+func LoadRecording(dir string, name string) ([]RecordedFrame, error) {
+	path, err := recordingPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening recording - %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	header := RecordingHeader{}
+	err = decoder.Decode(&header)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading recording header - %w", err)
+	}
+	if header.Version != RecordingVersion {
+		return nil, fmt.Errorf("unsupported recording version (%d)", header.Version)
+	}
+
+	frames := make([]RecordedFrame, 0)
+	for decoder.More() {
+		frame := RecordedFrame{}
+		err = decoder.Decode(&frame)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading frame %d - %w", len(frames), err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// Newest first, a missing dir just means nothing has been recorded
+func ListRecordings(dir string) ([]RecordingInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []RecordingInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading recording dir - %w", err)
+	}
+
+	recordings := make([]RecordingInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), RecordingExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		recordings = append(recordings, RecordingInfo{
+			Name:     strings.TrimSuffix(entry.Name(), RecordingExt),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].Modified.After(recordings[j].Modified)
+	})
+	return recordings, nil
+}
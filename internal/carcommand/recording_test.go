@@ -0,0 +1,150 @@
+package carcommand
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func record(t *testing.T, carCommand *CarCommand, request RecordingRequest) RecordingResult {
+	t.Helper()
+	result := carCommand.DoRecording(request)
+	if result.Err != nil {
+		t.Fatalf("failed recording %s: %s", request.Action, result.Err)
+	}
+	return result
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	carCommand, driver := newSimCarCommand(t, testServoConfig("steer", TypeServo, 1))
+	carCommand.config.RecordingDir = filepath.Join(t.TempDir(), "recordings")
+
+	result := record(t, carCommand, RecordingRequest{Action: RecordStart, Name: "slalom"})
+	if result.Status.Recording != "slalom" {
+		t.Fatalf("expected to be recording slalom, got %+v", result.Status)
+	}
+	assertEvent(t, carCommand, EventRecording)
+	if carCommand.DoRecording(RecordingRequest{Action: RecordPlay, Name: "slalom"}).Err == nil {
+		t.Errorf("expected replay to be rejected while recording")
+	}
+
+	start := carCommand.recorder.start
+	frames := []struct {
+		offset time.Duration
+		value  int
+	}{
+		{offset: 0, value: 255},
+		{offset: 100 * time.Millisecond, value: 0},
+		{offset: 200 * time.Millisecond, value: 127},
+	}
+	for _, frame := range frames {
+		err := carCommand.recorder.record(CommandGroup{Commands: map[string]Command{"steer": {Value: frame.value}}}, start.Add(frame.offset))
+		if err != nil {
+			t.Fatalf("failed recording frame: %s", err)
+		}
+	}
+	record(t, carCommand, RecordingRequest{Action: RecordStop})
+	assertEvent(t, carCommand, EventRecording)
+
+	result = record(t, carCommand, RecordingRequest{Action: RecordList})
+	if len(result.Recordings) != 1 || result.Recordings[0].Name != "slalom" {
+		t.Fatalf("expected slalom to be listed, got %+v", result.Recordings)
+	}
+
+	record(t, carCommand, RecordingRequest{Action: RecordPlay, Name: "slalom"})
+	assertEvent(t, carCommand, EventReplay)
+	start = carCommand.player.start
+
+	tests := []struct {
+		elapsed time.Duration
+		due     bool
+		pulse   float32
+	}{
+		{elapsed: 50 * time.Millisecond, due: true, pulse: 2000},
+		{elapsed: 60 * time.Millisecond, due: false},
+		{elapsed: 250 * time.Millisecond, due: true, pulse: 1498}, //Skips the frame at 100ms like a stale command
+	}
+	for _, tc := range tests {
+		commands, due := carCommand.nextReplayCommand(start.Add(tc.elapsed))
+		if due != tc.due {
+			t.Fatalf("at %s expected due %t, got %t", tc.elapsed, tc.due, due)
+		}
+		if !due {
+			continue
+		}
+		err := carCommand.DoCommand(commands)
+		if err != nil {
+			t.Fatalf("failed sending replayed command: %s", err)
+		}
+		assertPulse(t, driver, 1, tc.pulse)
+	}
+	if carCommand.player != nil {
+		t.Errorf("expected replay to finish after the last frame")
+	}
+	assertEvent(t, carCommand, EventReplay)
+}
+
+func TestLiveInput(t *testing.T) {
+	tests := map[string]struct {
+		commands map[string]Command
+		live     bool
+	}{
+		"resting": {
+			commands: map[string]Command{"esc": {Value: MixInputMid, Gear: "N"}, "steer": {Value: MixInputMid + ReplayInterruptDeadZone}},
+		},
+		"steering": {
+			commands: map[string]Command{"esc": {Value: MixInputMid}, "steer": {Value: MixInputMid - ReplayInterruptDeadZone - 1}},
+			live:     true,
+		},
+		"throttle": {
+			commands: map[string]Command{"esc": {Value: MixInputMax}},
+			live:     true,
+		},
+		"renamed_throttle": {
+			commands: map[string]Command{"drive": {Value: MixInputMin}},
+			live:     true,
+		},
+		"switches_at_rest": {
+			commands: map[string]Command{"esc": {Value: MixInputMid}, "lights": {Value: MixInputMax}, "horn": {Value: MixInputMin}, "aux": {Value: MixInputMax}},
+		},
+	}
+
+	carCommand := NewCarCommand(CarCommandConfig{
+		RefreshRate: 60,
+		Inputs:      map[string]string{"drive": InputThrottle, "esc": InputThrottle, "steer": InputSteer, "aux": InputAux},
+	})
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			if live := carCommand.liveInput(CommandGroup{Commands: tc.commands}); live != tc.live {
+				t.Errorf("expected live %t, got %t", tc.live, live)
+			}
+		})
+	}
+}
+
+func TestRecordingErrors(t *testing.T) {
+	dir := t.TempDir()
+	carCommand, _ := newSimCarCommand(t, testServoConfig("steer", TypeServo, 1))
+	carCommand.config.RecordingDir = dir
+
+	err := os.WriteFile(filepath.Join(dir, "future"+RecordingExt), []byte(`{"version":99,"name":"future"}`+"\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed writing recording: %s", err)
+	}
+
+	tests := map[string]RecordingRequest{
+		"bad_name":        {Action: RecordStart, Name: "../escape"},
+		"missing":         {Action: RecordPlay, Name: "missing"},
+		"newer_version":   {Action: RecordPlay, Name: "future"},
+		"unknown_action":  {Action: "rewind"},
+		"empty_play_name": {Action: RecordPlay},
+	}
+	for testName, request := range tests {
+		t.Run(testName, func(t *testing.T) {
+			if carCommand.DoRecording(request).Err == nil {
+				t.Errorf("expected %s to fail", request.Action)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"time"
 
 	"github.com/googolgl/go-pca9685"
 )
@@ -16,16 +17,34 @@ const ReverseKey = "R"
 const NeutralKey = "N"
 
 type Servo struct {
-	config       ServoConfig
-	servo        *pca9685.Servo
-	transmission Transmission
+	config        ServoConfig
+	driver        OutputDriver
+	transmission  Transmission
+	switchState   int //Latched state of switch types
+	switchInput   int //Last switch input, used to find the edge of a press
+	rateIndex     int
+	curveEnabled  bool
+	slew          slewLimiter
+	escState      escState
+	escStateStart time.Time
+	escThrottled  bool //Last input was forward throttle, letting off starts hill hold
+	hillHoldUntil time.Time
+	gyro          gyroAssist
 	//Limit uint32
 }
 
 type Transmission struct {
-	numGears   int
-	gear       string
-	gearRatios map[string]GearRatio
+	numGears      int
+	gear          string
+	gearRatios    map[string]GearRatio
+	shiftCut      time.Duration
+	shiftCutUntil time.Time
+	mode          TransmissionMode
+	drive         bool //In D with an automatic transmission picking the forward gear
+	gearStart     time.Time
+	speedSource   SpeedSource
+	maxGear       int  //Highest forward gear the driver can pick, 0 allows all
+	noReverse     bool //Reverse is locked out, shifting into it stays in neutral
 }
 
 type GearRatio struct {
@@ -34,42 +53,58 @@ type GearRatio struct {
 }
 
 type ServoConfig struct {
-	Name      string
-	Channel   int
-	MaxPulse  float32
-	MinPulse  float32
-	MaxValue  int
-	MidValue  int
-	MinValue  int
-	Inverted  bool
-	MidOffset int
-	DeadZone  int
-	NumGears  int
-
-	Type string //TODO make this Alex's goenum.  ESC, Servo, Toggle On/Off, Toggle FWD/Off/REV
-	//RangeMapper func()
+	Name         string
+	Board        int //Index of the board in ServoControllerConfig.Boards
+	Channel      int
+	MaxPulse     float32
+	MinPulse     float32
+	MaxValue     int
+	MidValue     int
+	MinValue     int
+	Inverted     bool
+	MidOffset    int
+	DeadZone     int
+	NumGears     int
+	GearRatios   []int         //Percent of full throttle for each gear, optional
+	ShiftCut     time.Duration //Throttle drops to neutral this long after each gear change
+	Transmission TransmissionMode
+	Auto         AutoConfig
+	Threshold    int //Distance from mid that counts as a press for switch types
+	Curve        ResponseCurve
+	Slew         SlewConfig
+	Failsafe     []FailsafeStage
+	Esc          EscConfig
+	Gyro         GyroConfig
+
+	Type ServoType
 }
 
-func NewServo(cfg ServoConfig, servoController *pca9685.PCA9685) *Servo {
+func NewServo(cfg ServoConfig, driver OutputDriver) *Servo {
 	if cfg.NumGears < 1 {
 		cfg.NumGears = 1
 	}
+	if cfg.Esc.Mode == "" {
+		cfg.Esc.Mode = DefaultEscMode
+	}
+	if cfg.Transmission == "" {
+		cfg.Transmission = DefaultTransmissionMode
+	}
 
 	servo := Servo{
-		config: cfg,
+		config:       cfg,
+		driver:       driver,
+		curveEnabled: true,
+		slew:         newSlewLimiter(cfg.Slew, cfg.MidValue),
+		gyro:         newGyroAssist(cfg.Gyro),
 		transmission: Transmission{
 			numGears:   cfg.NumGears, //Not counting Reverse and Neutral
 			gear:       "N",
-			gearRatios: makeGearRatios(cfg.NumGears, cfg.MinValue, cfg.MaxValue, cfg.Inverted),
+			gearRatios: makeGearRatios(cfg.NumGears, cfg.GearRatios, cfg.MinValue, cfg.MaxValue, cfg.Inverted),
+			shiftCut:   cfg.ShiftCut,
+			mode:       cfg.Transmission,
 		},
 	}
 
-	servo.servo = servoController.ServoNew(cfg.Channel, &pca9685.ServOptions{
-		AcRange:  AcRange,
-		MinPulse: cfg.MinPulse,
-		MaxPulse: cfg.MaxPulse,
-	})
-
 	log.Printf("New Servo (%s): %+v\n\n", servo.config.Name, servo)
 	return &servo
 }
@@ -78,10 +113,12 @@ func mapToRange(value, min, max, minReturn, maxReturn int) int {
 	return (maxReturn-minReturn)*(value-min)/(max-min) + minReturn
 }
 
-// Returns a set of gear ratios (max servo value) equal to provided number of gears + a reverse gear in position 0 that contains the bottom half of the values
-func makeGearRatios(numGears, minValue, maxValue int, inverted bool) map[string]GearRatio {
+// Returns a set of gear ratios (max servo value) for each forward gear, plus reverse and neutral.
+// Gears use their ratio as a percent of full throttle, gears without a ratio split the top half of the range evenly
+func makeGearRatios(numGears int, ratios []int, minValue, maxValue int, inverted bool) map[string]GearRatio {
 	gears := make(map[string]GearRatio, numGears+2)
 	midValue := (maxValue - minValue) / 2
+	spread := (maxValue - midValue) / numGears
 
 	if inverted {
 		//Reverse gear is bottom half of servo range
@@ -96,24 +133,12 @@ func makeGearRatios(numGears, minValue, maxValue int, inverted bool) map[string]
 			min: midValue,
 		}
 
-		//Split top half of servo range between the numGears
-
-		spread := (maxValue - midValue) / numGears
-		currentMax := midValue
-		currentMin := currentMax - spread
-
 		for i := 1; i <= numGears; i++ {
-			gearRatio := GearRatio{
+			currentMin := midValue - getGearSpread(i, numGears, ratios, spread, midValue-minValue)
+			gears[strconv.Itoa(i)] = GearRatio{
 				max: getInvertedValue(currentMin, midValue), //Use midvalue here so that gear ratio only has upper limit but not lower limit
 				min: currentMin,
 			}
-			if i == numGears {
-				gearRatio.min = minValue
-			} else {
-				currentMax = currentMin
-				currentMin -= spread
-			}
-			gears[strconv.Itoa(i)] = gearRatio
 		}
 	} else {
 		//Reverse gear is bottom half of servo range
@@ -128,66 +153,112 @@ func makeGearRatios(numGears, minValue, maxValue int, inverted bool) map[string]
 			min: midValue,
 		}
 
-		//Split top half of servo range between the numGears
-
-		spread := (maxValue - midValue) / numGears
-		currentMin := midValue
-		currentMax := currentMin + spread
-
 		for i := 1; i <= numGears; i++ {
-			gearRatio := GearRatio{
+			currentMax := midValue + getGearSpread(i, numGears, ratios, spread, maxValue-midValue)
+			gears[strconv.Itoa(i)] = GearRatio{
 				max: currentMax,
 				min: getInvertedValue(currentMax, midValue), //Use midvalue here so that gear ratio only has upper limit but not lower limit
 			}
-			if i == numGears {
-				gearRatio.max = maxValue
-			} else {
-				currentMin = currentMax
-				currentMax += spread
-			}
-			gears[strconv.Itoa(i)] = gearRatio
 		}
 	}
 	return gears
 }
 
+// Returns how far past mid the gear can go
+func getGearSpread(gear, numGears int, ratios []int, spread, fullSpread int) int {
+	if gear <= len(ratios) {
+		return fullSpread * ratios[gear-1] / 100
+	}
+	if gear == numGears {
+		return fullSpread //Top gear always gets the full range
+	}
+	return gear * spread
+}
+
 func (s *Servo) SetNeutral() error {
 	return s.SetValue(s.config.MidValue)
 }
 
+// Same as neutral except switch types also turn off, skips the slew limiter so a stop is never delayed
+func (s *Servo) SetFailsafe() error {
+	s.switchState = switchOff
+	s.switchInput = switchOff
+	s.slew.reset(s.config.MidValue)
+	return s.SetNeutral()
+}
+
+// Runs the input through the slew limiter before setting it, tick is the time since the last update.
+// Only this path gets gyro assist, failsafe and calibration values go out as they are
+func (s *Servo) SetLimitedValue(value int, tick time.Duration) error {
+	forwardGear := s.config.Type == TypeESC && s.transmission.gear != ReverseKey
+	return s.setValue(s.slew.limit(value, s.config.MidValue, s.config.DeadZone, forwardGear, tick), true)
+}
+
+func (s *Servo) Ramping() bool {
+	return s.slew.ramping()
+}
+
 func (s *Servo) UpShift() {
+	if s.config.Type != TypeESC {
+		return
+	}
+	if s.transmission.mode == TransmissionAuto {
+		switch {
+		case s.transmission.drive: //Car picks the forward gear
+		case s.transmission.gear == ReverseKey:
+			s.changeGear(NeutralKey)
+		case s.transmission.gear == NeutralKey:
+			s.startDrive()
+		}
+		return
+	}
+
 	switch s.transmission.gear {
 	case ReverseKey:
-		s.transmission.gear = NeutralKey
+		s.changeGear(NeutralKey)
 	case NeutralKey:
-		s.transmission.gear = "1"
+		s.changeGear("1")
 	default:
 		gearInt, err := strconv.Atoi(s.transmission.gear) //Should never error because we control this internally
 		if err != nil {
 			log.Println("error up")
 		} else {
 			if gearInt > 0 && gearInt < s.transmission.numGears { //Do nothing if already in top gear
-				s.transmission.gear = strconv.Itoa(gearInt + 1)
+				s.changeGear(strconv.Itoa(gearInt + 1))
 			}
 		}
 	}
 }
 
 func (s *Servo) DownShift() {
+	if s.config.Type != TypeESC {
+		return
+	}
+	if s.transmission.mode == TransmissionAuto {
+		switch {
+		case s.transmission.drive:
+			s.transmission.drive = false
+			s.changeGear(NeutralKey)
+		case s.transmission.gear == NeutralKey:
+			s.changeGear(ReverseKey)
+		}
+		return
+	}
+
 	switch s.transmission.gear {
 	case ReverseKey: //Already in reverse so do nothing
 		//s.transmission.gear = NeutralKey
 	case NeutralKey:
-		s.transmission.gear = ReverseKey
+		s.changeGear(ReverseKey)
 	case "1":
-		s.transmission.gear = NeutralKey
+		s.changeGear(NeutralKey)
 	default:
 		gearInt, err := strconv.Atoi(s.transmission.gear) //Should never error because we control this internally
 		if err != nil {
 			log.Println("error up")
 		} else {
 			if gearInt > 1 && gearInt <= s.transmission.numGears { //Don't include first gear here
-				s.transmission.gear = strconv.Itoa(gearInt - 1)
+				s.changeGear(strconv.Itoa(gearInt - 1))
 			}
 		}
 
@@ -195,11 +266,22 @@ func (s *Servo) DownShift() {
 }
 
 func (s *Servo) SetGear(gear string) error {
-	if gear == "" || s.config.Type != "esc" {
+	if gear == "" || s.config.Type != TypeESC {
 		return nil
 	}
 	if gear == ReverseKey || gear == NeutralKey {
-		s.transmission.gear = gear
+		s.transmission.drive = false
+		s.changeGear(gear)
+		return nil
+	}
+
+	if s.transmission.mode == TransmissionAuto {
+		if gear != DriveKey {
+			return fmt.Errorf("automatic transmission only takes D, R or N (%s)", gear)
+		}
+		if !s.transmission.drive {
+			s.startDrive()
+		}
 		return nil
 	}
 
@@ -209,20 +291,80 @@ func (s *Servo) SetGear(gear string) error {
 	}
 
 	if gearInt > 0 && gearInt <= s.transmission.numGears {
-		s.transmission.gear = gear
+		s.changeGear(gear)
 		return nil
 	}
 
 	return fmt.Errorf("gear value out of range (%s)", gear)
 }
 
+// Returns the current gear, in drive this is D followed by the gear the automatic picked
+func (s *Servo) Gear() string {
+	if s.transmission.drive {
+		return DriveKey + s.transmission.gear
+	}
+	return s.transmission.gear
+}
+
+func (s *Servo) startDrive() {
+	s.transmission.drive = true
+	s.changeGear("1")
+}
+
+// Starts the shift cut if the gear actually changed, gears past the limit are held at it
+func (s *Servo) changeGear(gear string) {
+	gear = s.limitGear(gear)
+	if gear == s.transmission.gear {
+		return
+	}
+	s.transmission.gear = gear
+	s.transmission.gearStart = timeNow()
+	if s.transmission.shiftCut > 0 {
+		s.transmission.shiftCutUntil = timeNow().Add(s.transmission.shiftCut)
+	}
+}
+
+// Limits which gears can be picked, shifts down right away if the current gear is past the new limit
+func (s *Servo) SetGearLimit(maxGear int, noReverse bool) {
+	if s.config.Type != TypeESC {
+		return
+	}
+	s.transmission.maxGear = maxGear
+	s.transmission.noReverse = noReverse
+	s.changeGear(s.transmission.gear)
+}
+
+func (s *Servo) limitGear(gear string) string {
+	if gear == ReverseKey && s.transmission.noReverse {
+		return NeutralKey
+	}
+	gearInt, err := strconv.Atoi(gear)
+	if err == nil && s.transmission.maxGear > 0 && gearInt > s.transmission.maxGear {
+		return strconv.Itoa(s.transmission.maxGear)
+	}
+	return gear
+}
+
+// Drops forward throttle to mid while the shift cut is running, brake and reverse still go through
+func (s *Servo) getValueWithShiftCut(value int) int {
+	if !timeNow().Before(s.transmission.shiftCutUntil) {
+		return value
+	}
+	if (!s.config.Inverted && value > s.config.MidValue) || (s.config.Inverted && value < s.config.MidValue) {
+		return s.config.MidValue
+	}
+	return value
+}
+
 func (s *Servo) getValueWithGear(value int) (int, error) {
 	//Still make sure our value is within the overall min and max before scaling it to our gear ratio
 	if value > s.config.MaxValue || value < s.config.MinValue {
-		return value, fmt.Errorf("%s value out of bounds - (value %d)", value, s.config.Name)
+		return value, fmt.Errorf("%s value out of bounds - (value %d)", s.config.Name, value)
 	}
 	valueRatio := 0
 
+	value = s.getValueWithCurve(value) //Dead zone is for positional servos, escs keep their full throttle response
+
 	if s.config.Inverted {
 		value = getInvertedValue(value, s.config.MidValue)
 	}
@@ -242,13 +384,17 @@ func (s *Servo) getValueWithGear(value int) (int, error) {
 	return valueRatio, nil
 }
 
-func (s *Servo) getValueWithOffset(value int) (int, error) {
+func (s *Servo) getValueWithOffset(value int, assist bool) (int, error) {
 
 	if value > s.config.MaxValue || value < s.config.MinValue {
-		return value, fmt.Errorf("%s value out of bounds - (value %d)", value, s.config.Name)
+		return value, fmt.Errorf("%s value out of bounds - (value %d)", s.config.Name, value)
 	}
 
 	value = getValueWithDeadZone(value, s.config.MidValue, s.config.DeadZone)
+	value = s.getValueWithCurve(value)
+	if assist {
+		value = s.getValueWithGyro(value)
+	}
 
 	if s.config.Inverted {
 		value = getInvertedValue(value, s.config.MidValue)
@@ -266,32 +412,51 @@ func (s *Servo) getValueWithOffset(value int) (int, error) {
 }
 
 func (s *Servo) SetValue(value int) error {
+	return s.setValue(value, false)
+}
+
+func (s *Servo) setValue(value int, assist bool) error {
 	var (
 		err error
 	)
 
 	switch s.config.Type {
-	case "esc":
+	case TypeESC:
+		s.autoShift(value)
+		input := value
 		value, err = s.getValueWithGear(value)
 		if err != nil {
 			return fmt.Errorf("error setting value with gear - %w", err)
 		}
-	case "servo":
+		value = s.getValueWithDragBrake(value, input) //Before the shift cut so shifting never brakes
+		value = s.getValueWithShiftCut(value)
+		value = s.getValueWithEscMode(value)
+	case TypeToggle, TypeMomentary, TypeTriState:
+		value, err = s.getSwitchValue(value)
+		if err != nil {
+			return fmt.Errorf("error getting switch value - %w", err)
+		}
+	case TypeServo:
 		fallthrough
 	default:
-		value, err = s.getValueWithOffset(value)
+		value, err = s.getValueWithOffset(value, assist)
 		if err != nil {
 			return fmt.Errorf("error getting value with offset - %w", err)
 		}
 	}
 
+	return s.writeValue(value)
+}
+
+// Writes a value that has already been through the servo's pipeline
+func (s *Servo) writeValue(value int) error {
 	finalValue := float32(value) / float32(s.config.MaxValue)
 
 	if s.transmission.gear == "R" {
 		log.Printf("FinalValue: %f\n", finalValue)
 	}
 
-	err = s.servo.Fraction(finalValue)
+	err := s.writeFraction(finalValue)
 	if err != nil {
 		return fmt.Errorf("failed sending command: (value %d | final - %f) - error:  %w\n", value, finalValue, err)
 	}
@@ -299,6 +464,15 @@ func (s *Servo) SetValue(value int) error {
 	return nil
 }
 
+// Fraction as pulse width expressed between 0.0 MinPulse and 1.0 MaxPulse
+func (s *Servo) writeFraction(fraction float32) error {
+	if fraction < 0.0 || fraction > 1.0 {
+		return fmt.Errorf("must be 0.0 to 1.0")
+	}
+	pulse := s.config.MinPulse + fraction*(s.config.MaxPulse-s.config.MinPulse)
+	return s.driver.SetPulse(s.config.Channel, pulse)
+}
+
 func getInvertedValue(value, mid int) int {
 	var invertedDistance int
 	if value > mid {
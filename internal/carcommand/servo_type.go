@@ -0,0 +1,188 @@
+package carcommand
+
+import (
+	"fmt"
+)
+
+type ServoType string
+
+const (
+	TypeESC       ServoType = "esc"       //Throttle with a virtual transmission
+	TypeServo     ServoType = "servo"     //Positional servo (steering, pan, tilt)
+	TypeToggle    ServoType = "toggle"    //Latched on/off, each press flips the state (lights)
+	TypeMomentary ServoType = "momentary" //On only while pressed (winch, horn)
+	TypeTriState  ServoType = "tristate"  //Latched FWD/OFF/REV, pressing up or down steps one state (motorized accessory)
+)
+
+const DefaultSwitchThreshold = 64
+
+// Switch states shared by the toggle, momentary and tristate types
+const (
+	switchRev = -1
+	switchOff = 0
+	switchFwd = 1
+)
+
+func ParseServoType(value string) (ServoType, error) {
+	servoType := ServoType(value)
+	switch servoType {
+	case TypeESC, TypeServo, TypeToggle, TypeMomentary, TypeTriState:
+		return servoType, nil
+	default:
+		return servoType, fmt.Errorf("unsupported servo type (%s)", value)
+	}
+}
+
+// Validate checks the settings shared by all servos and then the ones specific to its type
+func (c ServoConfig) Validate() error {
+	_, err := ParseServoType(string(c.Type))
+	if err != nil {
+		return err
+	}
+	if c.Name == "" {
+		return fmt.Errorf("servo name is required")
+	}
+	if c.Board < 0 || c.Board >= MaxBoards {
+		return fmt.Errorf("%s board out of range (%d)", c.Name, c.Board)
+	}
+	if c.Channel < 0 || c.Channel >= ChannelsPerBoard {
+		return fmt.Errorf("%s channel out of range (%d)", c.Name, c.Channel)
+	}
+	if c.MinPulse <= 0 || c.MinPulse >= c.MaxPulse {
+		return fmt.Errorf("%s pulse range invalid (min %.0f | max %.0f)", c.Name, c.MinPulse, c.MaxPulse)
+	}
+	if c.MinValue >= c.MaxValue {
+		return fmt.Errorf("%s value range invalid (min %d | max %d)", c.Name, c.MinValue, c.MaxValue)
+	}
+	if c.MidValue <= c.MinValue || c.MidValue >= c.MaxValue {
+		return fmt.Errorf("%s mid value out of range (%d)", c.Name, c.MidValue)
+	}
+	if c.DeadZone < 0 {
+		return fmt.Errorf("%s dead zone can't be negative (%d)", c.Name, c.DeadZone)
+	}
+	if c.Slew.Accel < 0 || c.Slew.Decel < 0 || c.Slew.Brake < 0 || c.Slew.Reverse < 0 {
+		return fmt.Errorf("%s slew rates can't be negative", c.Name)
+	}
+	err = c.Curve.Validate()
+	if err != nil {
+		return fmt.Errorf("%s response curve invalid - %w", c.Name, err)
+	}
+	err = c.validateFailsafe()
+	if err != nil {
+		return fmt.Errorf("%s failsafe invalid - %w", c.Name, err)
+	}
+	err = c.Gyro.Validate()
+	if err != nil {
+		return fmt.Errorf("%s gyro invalid - %w", c.Name, err)
+	}
+	if c.Gyro.Enabled() && c.Type != TypeServo {
+		return fmt.Errorf("%s gyro assist needs a servo type (%s)", c.Name, c.Type)
+	}
+
+	switch c.Type {
+	case TypeESC:
+		if c.NumGears < 1 {
+			return fmt.Errorf("%s needs at least 1 gear (%d)", c.Name, c.NumGears)
+		}
+		if len(c.GearRatios) > 0 && len(c.GearRatios) != c.NumGears {
+			return fmt.Errorf("%s has %d gear ratios for %d gears", c.Name, len(c.GearRatios), c.NumGears)
+		}
+		for _, ratio := range c.GearRatios {
+			if ratio < 1 || ratio > 100 {
+				return fmt.Errorf("%s gear ratio out of range (%d)", c.Name, ratio)
+			}
+		}
+		if c.ShiftCut < 0 {
+			return fmt.Errorf("%s shift cut can't be negative", c.Name)
+		}
+		if c.Transmission != "" {
+			_, err = ParseTransmissionMode(string(c.Transmission))
+			if err != nil {
+				return fmt.Errorf("%s transmission invalid - %w", c.Name, err)
+			}
+		}
+		if c.Transmission == TransmissionAuto {
+			err = c.Auto.Validate(c.NumGears)
+			if err != nil {
+				return fmt.Errorf("%s automatic transmission invalid - %w", c.Name, err)
+			}
+		}
+		err = c.Esc.Validate()
+		if err != nil {
+			return fmt.Errorf("%s esc config invalid - %w", c.Name, err)
+		}
+	case TypeServo:
+		if c.MidOffset <= c.MinValue-c.MidValue || c.MidOffset >= c.MaxValue-c.MidValue {
+			return fmt.Errorf("%s mid offset out of range (%d)", c.Name, c.MidOffset)
+		}
+	case TypeToggle, TypeMomentary, TypeTriState:
+		if c.Threshold <= 0 || c.Threshold > c.MaxValue-c.MidValue || c.Threshold > c.MidValue-c.MinValue {
+			return fmt.Errorf("%s switch threshold out of range (%d)", c.Name, c.Threshold)
+		}
+	}
+	return nil
+}
+
+// Returns which way the switch input is being pushed, if at all
+func (s *Servo) getSwitchInput(value int) int {
+	if value >= s.config.MidValue+s.config.Threshold {
+		return switchFwd
+	} else if value <= s.config.MidValue-s.config.Threshold {
+		return switchRev
+	}
+	return switchOff
+}
+
+// Switch types only act on the edge of a press so holding the input doesn't keep flipping the state
+func (s *Servo) getSwitchValue(value int) (int, error) {
+	if value > s.config.MaxValue || value < s.config.MinValue {
+		return value, fmt.Errorf("%s value out of bounds - (value %d)", s.config.Name, value)
+	}
+
+	input := s.getSwitchInput(value)
+	pressed := input != switchOff && input != s.switchInput
+	s.switchInput = input
+
+	switch s.config.Type {
+	case TypeToggle:
+		if pressed && input == switchFwd {
+			if s.switchState == switchOff {
+				s.switchState = switchFwd
+			} else {
+				s.switchState = switchOff
+			}
+		}
+	case TypeMomentary:
+		if input == switchFwd {
+			s.switchState = switchFwd
+		} else {
+			s.switchState = switchOff
+		}
+	case TypeTriState:
+		if pressed {
+			s.switchState += input
+			if s.switchState > switchFwd {
+				s.switchState = switchFwd
+			} else if s.switchState < switchRev {
+				s.switchState = switchRev
+			}
+		}
+	}
+
+	switchValue := s.config.MidValue
+	switch s.switchState {
+	case switchFwd:
+		switchValue = s.config.MaxValue
+	case switchRev:
+		switchValue = s.config.MinValue
+	default:
+		if s.config.Type != TypeTriState {
+			switchValue = s.config.MinValue //Two state switches are off at the bottom of the range
+		}
+	}
+
+	if s.config.Inverted && switchValue != s.config.MidValue { //Tristate off stays centered
+		switchValue = s.config.MaxValue - (switchValue - s.config.MinValue) //Flips end to end so both ends land on the limits
+	}
+	return switchValue, nil
+}
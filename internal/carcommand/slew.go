@@ -0,0 +1,100 @@
+package carcommand
+
+import (
+	"time"
+)
+
+const DefaultNeutralBypass = true
+
+// SlewConfig limits how fast a servo's input can change, rates are value units per second and 0 means unlimited.
+// Servos without a transmission use Accel for the upper half of the range and Reverse for the lower half.
+type SlewConfig struct {
+	Accel         int  //Moving away from mid on the forward side
+	Decel         int  //Moving back toward mid from either side
+	Brake         int  //Moving away from mid on the reverse side while in a forward gear
+	Reverse       int  //Moving away from mid on the reverse side while in reverse
+	NeutralBypass bool //Jump straight to neutral instead of ramping, failsafe always jumps
+}
+
+type slewLimiter struct {
+	config  SlewConfig
+	current float64
+	target  int
+}
+
+func newSlewLimiter(cfg SlewConfig, mid int) slewLimiter {
+	return slewLimiter{
+		config:  cfg,
+		current: float64(mid),
+		target:  mid,
+	}
+}
+
+func (c SlewConfig) enabled() bool {
+	return c.Accel > 0 || c.Decel > 0 || c.Brake > 0 || c.Reverse > 0
+}
+
+// Returns the rate that applies when moving from the current value to the target
+func (l *slewLimiter) getRate(mid int, forwardGear bool) int {
+	current := int(l.current)
+	switch {
+	case l.target > mid && l.target > current && current >= mid:
+		return l.config.Accel
+	case l.target < mid && l.target < current && current <= mid:
+		if forwardGear {
+			return l.config.Brake
+		}
+		return l.config.Reverse
+	default:
+		return l.config.Decel //Heading back toward mid, crossing over mid ramps to mid first
+	}
+}
+
+// Steps the current value toward the target and returns the value to send this tick
+func (l *slewLimiter) limit(target, mid, deadZone int, forwardGear bool, tick time.Duration) int {
+	l.target = target
+	if !l.config.enabled() {
+		l.current = float64(target)
+		return target
+	}
+
+	if l.config.NeutralBypass && target <= mid+deadZone && target >= mid-deadZone {
+		l.current = float64(target)
+		return target
+	}
+
+	rate := l.getRate(mid, forwardGear)
+	if rate <= 0 {
+		l.current = float64(target)
+		return target
+	}
+
+	//Don't cross over mid in one step, so decel and accel rates are applied on their own side
+	stepTarget := float64(target)
+	if (l.current > float64(mid) && target < mid) || (l.current < float64(mid) && target > mid) {
+		stepTarget = float64(mid)
+	}
+
+	step := float64(rate) * tick.Seconds()
+	if l.current < stepTarget {
+		l.current += step
+		if l.current > stepTarget {
+			l.current = stepTarget
+		}
+	} else if l.current > stepTarget {
+		l.current -= step
+		if l.current < stepTarget {
+			l.current = stepTarget
+		}
+	}
+	return int(l.current)
+}
+
+func (l *slewLimiter) ramping() bool {
+	return int(l.current) != l.target
+}
+
+func (l *slewLimiter) reset(value int) {
+	l.current = float64(value)
+	l.target = value
+}
@@ -0,0 +1,121 @@
+package carcommand
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlewLimiter(t *testing.T) {
+	tick := 100 * time.Millisecond
+	slewCfg := SlewConfig{
+		Accel:         200, //20 per tick
+		Decel:         500, //50 per tick
+		Brake:         1000,
+		Reverse:       100,
+		NeutralBypass: true,
+	}
+
+	tests := map[string]struct {
+		config      SlewConfig
+		forwardGear bool
+		start       int
+		targets     []int
+		expected    []int
+	}{
+		"unlimited": {
+			config:      SlewConfig{},
+			forwardGear: true,
+			start:       127,
+			targets:     []int{255, 0},
+			expected:    []int{255, 0},
+		},
+		"accel_ramps_up": {
+			config:      slewCfg,
+			forwardGear: true,
+			start:       127,
+			targets:     []int{255, 255, 255, 255},
+			expected:    []int{147, 167, 187, 207},
+		},
+		"decel_ramps_to_target": {
+			config:      slewCfg,
+			forwardGear: true,
+			start:       255,
+			targets:     []int{180, 180},
+			expected:    []int{205, 180},
+		},
+		"brake_in_forward_gear": {
+			config:      slewCfg,
+			forwardGear: true,
+			start:       127,
+			targets:     []int{0},
+			expected:    []int{27},
+		},
+		"reverse_in_reverse_gear": {
+			config:      slewCfg,
+			forwardGear: false,
+			start:       127,
+			targets:     []int{0, 0},
+			expected:    []int{117, 107},
+		},
+		"crossing_mid_stops_at_mid": {
+			config:      slewCfg,
+			forwardGear: true,
+			start:       160,
+			targets:     []int{0, 0},
+			expected:    []int{127, 27},
+		},
+		"neutral_bypass": {
+			config:      slewCfg,
+			forwardGear: true,
+			start:       255,
+			targets:     []int{127},
+			expected:    []int{127},
+		},
+		"neutral_without_bypass": {
+			config:      SlewConfig{Accel: 200, Decel: 500},
+			forwardGear: true,
+			start:       255,
+			targets:     []int{127},
+			expected:    []int{205},
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			limiter := newSlewLimiter(tc.config, 127)
+			limiter.reset(tc.start)
+			for i, target := range tc.targets {
+				value := limiter.limit(target, 127, 1, tc.forwardGear, tick)
+				if value != tc.expected[i] {
+					t.Errorf("step %d: got %d, expected %d", i, value, tc.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFailsafeSkipsSlewLimiter(t *testing.T) {
+	escCfg := testServoConfig("esc", TypeESC, 0)
+	escCfg.Slew = SlewConfig{Accel: 60, Decel: 60}
+	carCommand, driver := newSimCarCommand(t, escCfg)
+	carCommand.servoController.SetGear("esc", "1")
+
+	for i := 0; i < 10; i++ {
+		err := carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"esc": {Value: 255, Gear: "1"}}})
+		if err != nil {
+			t.Fatalf("failed sending command: %s", err)
+		}
+	}
+	if !carCommand.servoController.Ramping() {
+		t.Errorf("expected esc to still be ramping")
+	}
+
+	err := carCommand.servoController.Failsafe(0)
+	if err != nil {
+		t.Fatalf("failed setting failsafe: %s", err)
+	}
+	assertPulse(t, driver, 0, 1498)
+	if carCommand.servoController.Ramping() {
+		t.Errorf("expected failsafe to stop ramping")
+	}
+}
@@ -0,0 +1,129 @@
+package carcommand
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const DefaultSpeedHoldStep = 0.25 //Meters per second
+const DefaultSpeedHoldMaxThrottle = 100
+
+// SpeedHoldConfig makes cruise hold a speed instead of a throttle when the throttle has a speed source,
+// so the car keeps its pace up and down hills
+type SpeedHoldConfig struct {
+	PID         PIDConfig //Output is a fraction of full forward throttle, error is in meters per second
+	Step        float64   //How far each cruise nudge moves the target speed
+	MaxThrottle int       //Percent, the most speed hold gives on a climb
+}
+
+func (c SpeedHoldConfig) Enabled() bool {
+	return c.PID.Kp > 0 || c.PID.Ki > 0
+}
+
+func (c SpeedHoldConfig) Validate() error {
+	err := c.PID.Validate()
+	if err != nil {
+		return err
+	}
+	if c.Step <= 0 {
+		return fmt.Errorf("speed hold step must be more than 0 (%.2f)", c.Step)
+	}
+	if c.MaxThrottle < 1 || c.MaxThrottle > MaxThrottleLimit {
+		return fmt.Errorf("speed hold max throttle must be 1 to %d percent (%d)", MaxThrottleLimit, c.MaxThrottle)
+	}
+	return nil
+}
+
+// Switches a cruise over to holding target, starting from the throttle it already had
+func (c *CarCommand) startSpeedHold(cruise *cruiseControl, target float64) {
+	cruise.targetSpeed = target
+	cruise.pid = newPIDController(c.config.SpeedHold.PID, 0, c.speedHoldMax())
+	cruise.pid.reset(throttleFraction(cruise.value))
+	cruise.lastHold = time.Time{}
+}
+
+// Holds the speed the car is going when cruise latches, false if there's no speed to hold
+func (c *CarCommand) latchSpeedHold(name string, cruise *cruiseControl) bool {
+	source := c.speedSources[name]
+	if !c.config.SpeedHold.Enabled() || source == nil {
+		return false
+	}
+	speed, ok := source.Speed()
+	if !ok || speed <= 0 {
+		return false
+	}
+	c.startSpeedHold(cruise, speed)
+	return true
+}
+
+// Sets the speed to hold from a control, starts cruise if it wasn't on
+func (c *CarCommand) setCruiseSpeed(name string, speed float64) error {
+	if speed <= 0 {
+		c.stopCruise(name, "cancelled")
+		return nil
+	}
+	if !c.config.SpeedHold.Enabled() {
+		return fmt.Errorf("speed hold not configured")
+	}
+	if c.speedSources[name] == nil {
+		return fmt.Errorf("%s has no speed source", name)
+	}
+
+	cruise, found := c.cruise[name]
+	if !found {
+		cruise = &cruiseControl{}
+		c.cruise[name] = cruise
+	}
+	if !cruise.active {
+		cruise.active = true
+		cruise.value = MixInputMid
+		cruise.gear = "" //Holds in whatever gear the next command has
+	}
+	if cruise.targetSpeed > 0 {
+		cruise.targetSpeed = speed
+	} else {
+		c.startSpeedHold(cruise, speed)
+	}
+	c.sendEvent(EventCruise, cruise.message(name))
+	return nil
+}
+
+// Returns the throttle value that holds the target speed, false when the speed reading is gone
+func (c *CarCommand) holdSpeed(name string, cruise *cruiseControl) (int, bool) {
+	source := c.speedSources[name]
+	if source == nil {
+		return 0, false
+	}
+	speed, ok := source.Speed()
+	if !ok {
+		return 0, false
+	}
+
+	now := timeNow()
+	dt := c.tickDuration
+	if !cruise.lastHold.IsZero() {
+		dt = now.Sub(cruise.lastHold)
+	}
+	cruise.lastHold = now
+	cruise.pid.max = c.speedHoldMax() //The driver can change while cruising
+	output := cruise.pid.update(cruise.targetSpeed, speed, dt)
+	return MixInputMid + int(math.Round(output*float64(MixInputMax-MixInputMid))), true
+}
+
+// Fraction of full throttle speed hold can use, the tighter of its config and the driver's profile
+func (c *CarCommand) speedHoldMax() float64 {
+	maxThrottle := c.config.SpeedHold.MaxThrottle
+	if c.driverLimits.MaxThrottle < maxThrottle {
+		maxThrottle = c.driverLimits.MaxThrottle
+	}
+	return float64(maxThrottle) / MaxThrottleLimit
+}
+
+// Forward throttle of a command value from 0 to 1
+func throttleFraction(value int) float64 {
+	if value <= MixInputMid {
+		return 0
+	}
+	return float64(value-MixInputMid) / float64(MixInputMax-MixInputMid)
+}
@@ -0,0 +1,132 @@
+package carcommand
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type TransmissionMode string
+
+const (
+	TransmissionManual TransmissionMode = "manual" //Driver picks the gear
+	TransmissionAuto   TransmissionMode = "auto"   //Driver picks D/R/N and the car picks the forward gear
+)
+
+const DriveKey = "D"
+
+const DefaultTransmissionMode = TransmissionManual
+const DefaultUpShiftThrottle = 80 //percent
+const DefaultDownShiftThrottle = 30
+const DefaultUpShiftTime = 1000 * time.Millisecond
+const DefaultDownShiftTime = 500 * time.Millisecond
+
+// AutoConfig decides when an automatic transmission shifts. Shifts need the throttle past a threshold
+// for at least the set time in the current gear, and if a speed source is available, the shift speed too.
+type AutoConfig struct {
+	UpShiftThrottle   int           //Percent of forward throttle to shift up
+	DownShiftThrottle int           //Percent of forward throttle to shift down
+	UpShiftTime       time.Duration //Time in a gear before it can shift up
+	DownShiftTime     time.Duration //Time in a gear before it can shift down
+	ShiftSpeeds       []float64     //Optional speed to shift up out of each gear, downshifts happen below the lower gear's speed
+}
+
+// SpeedSource is anything that can report how fast the car is going, ok is false when there's no reading
+type SpeedSource interface {
+	Speed() (speed float64, ok bool)
+}
+
+func ParseTransmissionMode(value string) (TransmissionMode, error) {
+	mode := TransmissionMode(value)
+	switch mode {
+	case TransmissionManual, TransmissionAuto:
+		return mode, nil
+	default:
+		return mode, fmt.Errorf("unsupported transmission mode (%s)", value)
+	}
+}
+
+// Parses a comma separated list of shift speeds (0.5,1.2,2)
+func ParseShiftSpeeds(value string) ([]float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	speeds := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		speed, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shift speed (%s) - %w", part, err)
+		}
+		speeds = append(speeds, speed)
+	}
+	return speeds, nil
+}
+
+func (c AutoConfig) Validate(numGears int) error {
+	if c.UpShiftThrottle < 1 || c.UpShiftThrottle > 100 || c.DownShiftThrottle < 0 || c.DownShiftThrottle >= c.UpShiftThrottle {
+		return fmt.Errorf("shift throttles out of range (up %d | down %d)", c.UpShiftThrottle, c.DownShiftThrottle)
+	}
+	if c.UpShiftTime < 0 || c.DownShiftTime < 0 {
+		return fmt.Errorf("shift times can't be negative")
+	}
+	if len(c.ShiftSpeeds) > 0 && len(c.ShiftSpeeds) != numGears-1 {
+		return fmt.Errorf("needs %d shift speeds for %d gears, got %d", numGears-1, numGears, len(c.ShiftSpeeds))
+	}
+	for i := 1; i < len(c.ShiftSpeeds); i++ {
+		if c.ShiftSpeeds[i] <= c.ShiftSpeeds[i-1] {
+			return fmt.Errorf("shift speeds must increase (%f)", c.ShiftSpeeds[i])
+		}
+	}
+	return nil
+}
+
+func (s *Servo) SetSpeedSource(source SpeedSource) {
+	s.transmission.speedSource = source
+}
+
+// Picks the forward gear while in drive, value is the driver's throttle input
+func (s *Servo) autoShift(value int) {
+	if !s.transmission.drive {
+		return
+	}
+
+	if s.config.Inverted {
+		value = getInvertedValue(value, s.config.MidValue)
+	}
+	throttle := 0
+	if value > s.config.MidValue {
+		throttle = (value - s.config.MidValue) * 100 / (s.config.MaxValue - s.config.MidValue)
+	}
+
+	gear, err := strconv.Atoi(s.transmission.gear)
+	if err != nil {
+		return
+	}
+
+	cfg := s.config.Auto
+	timeInGear := timeNow().Sub(s.transmission.gearStart)
+
+	speed, haveSpeed := 0.0, false
+	if s.transmission.speedSource != nil && len(cfg.ShiftSpeeds) > 0 {
+		speed, haveSpeed = s.transmission.speedSource.Speed()
+	}
+
+	if gear < s.transmission.numGears && throttle >= cfg.UpShiftThrottle && timeInGear >= cfg.UpShiftTime {
+		if !haveSpeed || speed >= cfg.ShiftSpeeds[gear-1] {
+			s.changeGear(strconv.Itoa(gear + 1))
+		}
+		return
+	}
+
+	if gear > 1 && timeInGear >= cfg.DownShiftTime {
+		if haveSpeed {
+			if speed < cfg.ShiftSpeeds[gear-2] {
+				s.changeGear(strconv.Itoa(gear - 1))
+			}
+		} else if throttle <= cfg.DownShiftThrottle {
+			s.changeGear(strconv.Itoa(gear - 1))
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package carcommand
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeSpeedSource struct {
+	speed float64
+	lost  bool
+}
+
+func (f *fakeSpeedSource) Speed() (float64, bool) {
+	return f.speed, !f.lost
+}
+
+func newAutoEsc(t *testing.T) (*CarCommand, *Servo) {
+	escCfg := testServoConfig("esc", TypeESC, 0)
+	escCfg.NumGears = 3
+	escCfg.Transmission = TransmissionAuto
+	escCfg.Auto = AutoConfig{
+		UpShiftThrottle:   80,
+		DownShiftThrottle: 30,
+		UpShiftTime:       time.Second,
+		DownShiftTime:     500 * time.Millisecond,
+	}
+	carCommand, _ := newSimCarCommand(t, escCfg)
+	return carCommand, carCommand.servoController.servos["esc"]
+}
+
+func TestAutoTransmission(t *testing.T) {
+	clock := time.Now()
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	_, esc := newAutoEsc(t)
+	if err := esc.SetGear("2"); err == nil {
+		t.Errorf("expected numeric gear to be rejected in auto mode")
+	}
+
+	esc.UpShift()
+	if esc.Gear() != DriveKey+"1" {
+		t.Fatalf("expected D1 after upshift from N, got %s", esc.Gear())
+	}
+
+	tests := []struct {
+		advance time.Duration
+		value   int
+		gear    string
+	}{
+		{advance: 0, value: 255, gear: "D1"},                      //not in gear long enough
+		{advance: time.Second, value: 255, gear: "D2"},            //full throttle long enough
+		{advance: 200 * time.Millisecond, value: 127, gear: "D2"}, //gear is still within the down shift time
+		{advance: 300 * time.Millisecond, value: 180, gear: "D2"}, //part throttle holds the gear
+		{advance: 0, value: 127, gear: "D1"},                      //off throttle shifts down
+		{advance: time.Hour, value: 127, gear: "D1"},              //never below first
+	}
+	for i, tc := range tests {
+		clock = clock.Add(tc.advance)
+		err := esc.SetValue(tc.value)
+		if err != nil {
+			t.Fatalf("step %d failed setting value: %s", i, err)
+		}
+		if esc.Gear() != tc.gear {
+			t.Errorf("step %d expected gear %s, got %s", i, tc.gear, esc.Gear())
+		}
+	}
+
+	esc.DownShift()
+	esc.DownShift()
+	if esc.Gear() != ReverseKey {
+		t.Errorf("expected R after two downshifts from D, got %s", esc.Gear())
+	}
+	if err := esc.SetGear(DriveKey); err != nil || esc.Gear() != "D1" {
+		t.Errorf("expected D1 after selecting D, got %s (%v)", esc.Gear(), err)
+	}
+}
+
+func TestAutoTransmissionSpeed(t *testing.T) {
+	clock := time.Now()
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	carCommand, esc := newAutoEsc(t)
+	esc.config.Auto.ShiftSpeeds = []float64{1, 2}
+	speed := &fakeSpeedSource{}
+	err := carCommand.SetSpeedSource("esc", speed)
+	if err != nil {
+		t.Fatalf("failed setting speed source: %s", err)
+	}
+
+	err = carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"esc": {Value: 127, Gear: DriveKey}}})
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+	assertGearEvent(t, carCommand, "D1")
+
+	clock = clock.Add(time.Second)
+	esc.SetValue(255)
+	if esc.Gear() != "D1" {
+		t.Errorf("expected to hold D1 below shift speed, got %s", esc.Gear())
+	}
+
+	speed.speed = 1.5
+	err = carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"esc": {Value: 255}}})
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+	if esc.Gear() != "D2" {
+		t.Errorf("expected D2 above shift speed, got %s", esc.Gear())
+	}
+	assertGearEvent(t, carCommand, "D2")
+
+	clock = clock.Add(time.Second)
+	esc.SetValue(127) //speed still above 1st gear's shift speed so no downshift
+	if esc.Gear() != "D2" {
+		t.Errorf("expected to hold D2 while still moving, got %s", esc.Gear())
+	}
+
+	speed.speed = 0.5
+	err = carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"esc": {Value: 127}}})
+	if err != nil {
+		t.Fatalf("failed sending command: %s", err)
+	}
+	if esc.Gear() != "D1" {
+		t.Errorf("expected D1 once slowed down, got %s", esc.Gear())
+	}
+	assertGearEvent(t, carCommand, "D1")
+}
+
+func assertGearEvent(t *testing.T, carCommand *CarCommand, gear string) {
+	t.Helper()
+	select {
+	case event := <-carCommand.EventChannel:
+		if event.Type != EventGear || event.Message != gear {
+			t.Errorf("expected %s gear event, got %+v", gear, event)
+		}
+	default:
+		t.Errorf("expected %s gear event, got none", gear)
+	}
+}
+
+func TestGearLimit(t *testing.T) {
+	escCfg := testServoConfig("esc", TypeESC, 0)
+	escCfg.NumGears = 6
+	carCommand, _ := newSimCarCommand(t, escCfg)
+	esc := carCommand.servoController.servos["esc"]
+	esc.SetGear("6")
+
+	limit := func(limits *DriverLimits) {
+		t.Helper()
+		err := carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"esc": {Value: MixInputMid}}, Limits: limits})
+		if err != nil {
+			t.Fatalf("failed sending limited command: %s", err)
+		}
+	}
+
+	limit(&DriverLimits{MaxThrottle: 100, MaxGear: 3})
+	if esc.Gear() != "3" {
+		t.Fatalf("expected a new limit to shift down to 3, got %s", esc.Gear())
+	}
+	assertGearEvent(t, carCommand, "3")
+
+	esc.UpShift()
+	esc.SetGear("5")
+	if esc.Gear() != "3" {
+		t.Errorf("expected to hold 3 at the limit, got %s", esc.Gear())
+	}
+
+	limit(&DriverLimits{MaxThrottle: 100, MaxGear: 3, NoReverse: true})
+	esc.SetGear("1")
+	esc.DownShift()
+	esc.DownShift()
+	esc.SetGear(ReverseKey)
+	if esc.Gear() != NeutralKey {
+		t.Errorf("expected reverse lock to stop in N, got %s", esc.Gear())
+	}
+
+	limit(nil) //Unlimited driver
+	esc.SetGear("6")
+	if esc.Gear() != "6" {
+		t.Errorf("expected every gear once the limit is lifted, got %s", esc.Gear())
+	}
+	if carCommand.DoCommand(CommandGroup{Commands: map[string]Command{}, Limits: &DriverLimits{MaxGear: -1}}) == nil {
+		t.Errorf("expected a negative max gear to be rejected")
+	}
+}
+
+func TestBadGearTracked(t *testing.T) {
+	carCommand, esc := newAutoEsc(t)
+
+	tests := []struct {
+		gear    string
+		badGear string
+	}{
+		{gear: "2", badGear: "2"}, //numeric gears are rejected in auto mode
+		{gear: "2", badGear: "2"}, //repeated bad gear is only logged once
+		{gear: "3", badGear: "3"},
+		{gear: DriveKey, badGear: ""},
+	}
+	for i, tc := range tests {
+		err := carCommand.DoCommand(CommandGroup{Commands: map[string]Command{"esc": {Value: 127, Gear: tc.gear}}})
+		if err != nil {
+			t.Fatalf("step %d failed: %s", i, err)
+		}
+		if carCommand.badGears["esc"] != tc.badGear {
+			t.Errorf("step %d expected bad gear %q, got %q", i, tc.badGear, carCommand.badGears["esc"])
+		}
+	}
+	if esc.Gear() != "D1" {
+		t.Errorf("expected D1 after selecting D, got %s", esc.Gear())
+	}
+}
@@ -6,11 +6,14 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Speshl/goremotecontrol_web/internal/carcam"
 	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
 	"github.com/Speshl/goremotecontrol_web/internal/carmic"
 	"github.com/Speshl/goremotecontrol_web/internal/carspeaker"
+	"github.com/Speshl/goremotecontrol_web/internal/sensors"
 	"github.com/Speshl/goremotecontrol_web/internal/server"
 	"github.com/googolgl/go-pca9685"
 )
@@ -27,6 +30,16 @@ const DefaultForceLocal = false
 
 // Default Socket Server Config
 const DefaultSilentConnections = false
+const DefaultChannelMap = server.DefaultChannelMap
+const DefaultRole = "guest" //Has no profile so it can't drive, admin has to be given out with ROLES
+const DefaultUserRoles = ""
+const DefaultUsers = "username:password" //The original login, set USERS on any car others can reach
+const DefaultProfileThrottle = 100
+const DefaultProfileSteer = 100
+const DefaultProfileGear = 0 //All gears
+const DefaultProfileReverse = true
+const DefaultProfilePanTilt = true
+const DefaultEstopSound = "" //No alert
 
 // Default Mic Config
 const DefaultMicDevice = "0"
@@ -47,10 +60,20 @@ const DefaultMode = ""
 
 // Default Command Options
 const DefaultRefreshRate = 60 //command refresh rate
+const DefaultDriver = carcommand.DriverPCA9685
+const DefaultFailsafeTimeout = int(carcommand.DefaultFailsafeTimeout / time.Millisecond)
+const DefaultReconnectMin = int(carcommand.DefaultReconnectMin / time.Millisecond)
+const DefaultReconnectMax = int(carcommand.DefaultReconnectMax / time.Millisecond)
 const DefaultAddress = pca9685.Address
 const DefaultI2CDevice = "/dev/i2c-1"
+const DefaultPWMFrequency = 0                 //0 keeps the driver default
+const DefaultMixer = carcommand.MixPresetNone //No mixing, commands go to servos by name
+const DefaultMix = ""
+const DefaultCalibrationFile = carcommand.DefaultCalibrationFile
+const DefaultRecordingDir = carcommand.DefaultRecordingDir
+const DefaultMixSteerReduction = 0
 
-const DefaultType = "servo"
+const DefaultType = string(carcommand.TypeServo)
 const DefaultInverted = false
 const DefaultMidOffset = 0
 const DefaultDeadZone = 1
@@ -59,6 +82,86 @@ const DefaultMinPulse = pca9685.ServoMinPulseDef
 const DefaultMaxValue = 255
 const DefaultMinValue = 0
 const DefaultNumGears = 1
+const DefaultGearRatios = "" //Empty splits the range evenly between gears
+const DefaultShiftCut = 0
+const DefaultTransmission = string(carcommand.DefaultTransmissionMode)
+const DefaultAutoUpThrottle = carcommand.DefaultUpShiftThrottle
+const DefaultAutoDownThrottle = carcommand.DefaultDownShiftThrottle
+const DefaultAutoUpTime = 1000 //ms
+const DefaultAutoDownTime = 500
+const DefaultShiftSpeeds = ""
+const DefaultThreshold = carcommand.DefaultSwitchThreshold
+const DefaultExpo = 0
+const DefaultRates = ""
+const DefaultCurve = ""
+const DefaultAccel = 0 //0 disables slew limiting
+const DefaultDecel = 0
+const DefaultBrake = 0
+const DefaultReverse = 0
+const DefaultNeutralBypass = carcommand.DefaultNeutralBypass
+const DefaultFailsafe = "" //Empty uses neutral
+const DefaultEscMode = string(carcommand.DefaultEscMode)
+const DefaultBrakePulse = int(carcommand.DefaultBrakePulse / time.Millisecond)
+const DefaultNeutralPulse = int(carcommand.DefaultNeutralPulse / time.Millisecond)
+const DefaultDragBrake = 0 //Percent, 0 lets the car coast
+const DefaultHillHold = 0  //Milliseconds, 0 turns hill hold off
+const DefaultHillHoldBrake = 30
+const DefaultGyroGain = 0 //Percent, 0 turns gyro assist off
+const DefaultGyroMaxRate = carcommand.DefaultGyroMaxRate
+const DefaultGyroMaxCorrection = carcommand.DefaultGyroMaxCorrection
+const DefaultGyroReversed = false
+const DefaultSpeedHoldKp = 0.0 //0 for both Kp and Ki turns speed hold off, cruise holds the throttle
+const DefaultSpeedHoldKi = 0.0
+const DefaultSpeedHoldKd = 0.0
+const DefaultSpeedHoldStep = carcommand.DefaultSpeedHoldStep
+const DefaultSpeedHoldMaxThrottle = carcommand.DefaultSpeedHoldMaxThrottle
+const DefaultCollisionMinDistance = carcommand.DefaultCollisionMinDistance
+const DefaultCollisionReaction = int(carcommand.DefaultCollisionReaction / time.Millisecond)
+const DefaultCollisionDecel = carcommand.DefaultCollisionDecel
+const DefaultCollisionTopSpeed = carcommand.DefaultCollisionTopSpeed
+
+// Default Battery Options
+const DefaultBatterySensor = "" //No battery monitoring
+const DefaultBatteryCells = 0   //Counted from the first reading
+const DefaultBatteryCapacity = 0
+const DefaultBatteryShunt = sensors.DefaultShuntOhms
+const DefaultBatteryDivider = 1.0
+const DefaultBatteryLowCell = sensors.DefaultLowCellVolts
+const DefaultBatteryCutoffCell = sensors.DefaultCutoffCellVolts
+const DefaultBatteryLowThrottle = sensors.DefaultLowThrottle
+const DefaultBatteryHold = int(sensors.DefaultBatteryHold / time.Millisecond)
+const DefaultBatteryPoll = int(sensors.DefaultBatteryPoll / time.Millisecond)
+
+// Default IMU Options
+const DefaultIMUSensor = "" //No imu
+const DefaultIMUPoll = int(sensors.DefaultIMUPoll / time.Millisecond)
+const DefaultRolloverAngle = sensors.DefaultRolloverAngle
+const DefaultRolloverHold = int(sensors.DefaultRolloverHold / time.Millisecond)
+const DefaultCrashG = sensors.DefaultCrashG
+const DefaultCrashCut = int(sensors.DefaultCrashCut / time.Millisecond)
+const DefaultCrashSound = "" //No sound
+const DefaultRolloverSound = ""
+const DefaultIMUTelemetry = int(sensors.DefaultIMUTelemetry / time.Millisecond) //0 sends no raw data
+
+// Default Wheel Speed Options
+const DefaultWheelSpeedLine = -1 //No speed sensor
+const DefaultWheelSpeedDevice = sensors.DefaultWheelSpeedDevice
+const DefaultPulsesPerRev = sensors.DefaultPulsesPerRev
+const DefaultWheelDiameter = sensors.DefaultWheelDiameter
+const DefaultSensorRatio = sensors.DefaultSensorRatio
+const DefaultSpeedWindow = sensors.DefaultSpeedWindow
+const DefaultSpeedTimeout = int(sensors.DefaultSpeedTimeout / time.Millisecond)
+const DefaultSpeedDebounce = int(sensors.DefaultSpeedDebounce / time.Microsecond)
+const DefaultSpeedTelemetry = int(sensors.DefaultSpeedTelemetry / time.Millisecond)
+const DefaultWheelSpeedServo = "esc"
+
+// Default Distance Options
+const DefaultDistanceSensor = "" //No sensor on that side
+const DefaultDistanceDevice = sensors.DefaultDistanceDevice
+const DefaultDistanceLine = -1
+const DefaultDistanceMaxRange = sensors.DefaultDistanceMaxRange
+const DefaultDistancePoll = int(sensors.DefaultDistancePoll / time.Millisecond)
+const DefaultDistanceTelemetry = int(sensors.DefaultDistanceTelemetry / time.Millisecond)
 
 type ServerConfig struct {
 	Name        string
@@ -73,17 +176,31 @@ type CarConfig struct {
 	CommandConfig      carcommand.CarCommandConfig
 	SpeakerConfig      carspeaker.SpeakerConfig
 	MicConfig          carmic.MicConfig
+	BatteryConfig      sensors.BatteryConfig
+	IMUConfig          sensors.IMUConfig
+	WheelSpeedConfig   sensors.WheelSpeedConfig
+	DistanceConfigs    []sensors.DistanceConfig
 }
 
-func GetConfig(ctx context.Context) CarConfig {
+func GetConfig(ctx context.Context) (CarConfig, error) {
+	commandConfig, err := GetCommandConfig(ctx)
+	if err != nil {
+		return CarConfig{}, fmt.Errorf("invalid command config - %w", err)
+	}
 	carConfig := CarConfig{
 		ServerConfig:       GetServerConfig(ctx),
 		SocketServerConfig: GetSocketServerConfig(ctx),
 		CamConfig:          GetCamConfig(ctx),
-		CommandConfig:      GetCommandConfig(ctx),
+		CommandConfig:      commandConfig,
 		MicConfig:          GetMicConfig(ctx),
 		SpeakerConfig:      GetSpeakerConfig(ctx),
+		BatteryConfig:      GetBatteryConfig(ctx),
+		IMUConfig:          GetIMUConfig(ctx),
+		WheelSpeedConfig:   GetWheelSpeedConfig(ctx),
+		DistanceConfigs:    GetDistanceConfigs(ctx),
 	}
+	carConfig.CommandConfig.Inputs = server.ChannelInputs(carConfig.SocketServerConfig.ChannelMap) //Car finds its throttles the same way the server does
+	checkChannelMap(carConfig.SocketServerConfig.ChannelMap, carConfig.CommandConfig)
 
 	log.Printf("Server Config: \n%+v\n", carConfig.ServerConfig)
 	log.Printf("Socket Config: \n%+v\n", carConfig.SocketServerConfig)
@@ -91,7 +208,11 @@ func GetConfig(ctx context.Context) CarConfig {
 	log.Printf("Mic Config: \n%+v\n", carConfig.MicConfig)
 	log.Printf("Speaker Config: \n%+v\n", carConfig.SpeakerConfig)
 	log.Printf("Command Config: \n%+v\n", carConfig.CommandConfig)
-	return carConfig
+	log.Printf("Battery Config: \n%+v\n", carConfig.BatteryConfig)
+	log.Printf("IMU Config: \n%+v\n", carConfig.IMUConfig)
+	log.Printf("Wheel Speed Config: \n%+v\n", carConfig.WheelSpeedConfig)
+	log.Printf("Distance Configs: \n%+v\n", carConfig.DistanceConfigs)
+	return carConfig, nil
 }
 
 func GetServerConfig(ctx context.Context) ServerConfig {
@@ -103,10 +224,94 @@ func GetServerConfig(ctx context.Context) ServerConfig {
 }
 
 func GetSocketServerConfig(ctx context.Context) server.SocketServerConfig {
-	return server.SocketServerConfig{
+	cfg := server.SocketServerConfig{
 		SilentConnects: GetBoolEnv("SILENTCONNECTIONS", DefaultSilentConnections),
 		ForceLocal:     GetBoolEnv("FORCELOCAL", DefaultForceLocal),
 	}
+
+	channelMap, err := server.ParseChannelMap(GetStringEnv("CHANNELMAP", DefaultChannelMap))
+	if err != nil {
+		log.Printf("warning:CHANNELMAP not parsed, using default - error: %s\n", err)
+		channelMap, _ = server.ParseChannelMap(DefaultChannelMap)
+	}
+	cfg.ChannelMap = channelMap
+
+	users, err := server.ParseUsers(GetStringEnv("USERS", DefaultUsers))
+	if err != nil {
+		log.Printf("warning:USERS not parsed, nobody can log in - error: %s\n", err)
+	}
+	cfg.Users = users
+
+	userRoles, err := server.ParseUserRoles(GetStringEnv("ROLES", DefaultUserRoles))
+	if err != nil {
+		log.Printf("warning:ROLES not parsed - error: %s\n", err)
+	}
+	cfg.UserRoles = userRoles
+	cfg.DefaultRole = GetStringEnv("DEFAULTROLE", DefaultRole)
+	cfg.Profiles = GetLimitProfiles()
+	cfg.EstopSound = GetStringEnv("ESTOPSOUND", DefaultEstopSound)
+	checkRoles(cfg)
+	return cfg
+}
+
+func GetLimitProfiles() []server.LimitProfile {
+	profiles := make([]server.LimitProfile, 0)
+	for i := 0; i < server.MaxLimitProfiles; i++ {
+		envPrefix := fmt.Sprintf("PROFILE%d_", i)
+		profile := server.LimitProfile{
+			Name:        GetStringEnv(envPrefix+"NAME", ""),
+			MaxThrottle: GetIntEnv(envPrefix+"MAXTHROTTLE", DefaultProfileThrottle),
+			MaxSteer:    GetIntEnv(envPrefix+"MAXSTEER", DefaultProfileSteer),
+			MaxGear:     GetIntEnv(envPrefix+"MAXGEAR", DefaultProfileGear),
+			Reverse:     GetBoolEnv(envPrefix+"REVERSE", DefaultProfileReverse),
+			PanTilt:     GetBoolEnv(envPrefix+"PANTILT", DefaultProfilePanTilt),
+		}
+		if profile.Name == "" {
+			continue
+		}
+		err := profile.Validate()
+		if err != nil {
+			log.Printf("warning:%s skipped - error: %s\n", envPrefix, err)
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles
+}
+
+// Warns about roles without a profile and users without a password, these can't drive or can't log in
+func checkRoles(cfg server.SocketServerConfig) {
+	profiles := map[string]bool{server.RoleAdmin: true}
+	for _, profile := range cfg.Profiles {
+		profiles[profile.Name] = true
+	}
+	if !profiles[cfg.DefaultRole] {
+		log.Printf("warning:DEFAULTROLE %s has no profile\n", cfg.DefaultRole)
+	}
+	for username, role := range cfg.UserRoles {
+		if !profiles[role] {
+			log.Printf("warning:ROLES %s has role %s with no profile\n", username, role)
+		}
+		if _, found := cfg.Users[username]; !found {
+			log.Printf("warning:ROLES %s has no password in USERS so can't log in\n", username)
+		}
+	}
+}
+
+// Warns about channels that won't reach a servo, these would stop the command loop
+func checkChannelMap(channelMap []server.ChannelSlot, commandCfg carcommand.CarCommandConfig) {
+	if commandCfg.Mixer.Enabled() {
+		return //Mixer decides which servos the channels reach
+	}
+	servos := make(map[string]bool, len(commandCfg.ServoConfigs))
+	for _, servoCfg := range commandCfg.ServoConfigs {
+		servos[servoCfg.Name] = true
+	}
+	for _, slot := range channelMap {
+		if slot.Type != server.ChannelSound && !servos[slot.Name] {
+			log.Printf("warning:CHANNELMAP channel %s:%s has no servo with that name\n", slot.Name, slot.Type)
+		}
+	}
 }
 
 func GetMicConfig(ctx context.Context) carmic.MicConfig {
@@ -135,21 +340,75 @@ func GetCamConfig(ctx context.Context) carcam.CamConfig {
 	}
 }
 
-func GetCommandConfig(ctx context.Context) carcommand.CarCommandConfig {
+// Servos sharing a board channel would fight over the output, so that stops startup instead of being skipped
+func GetCommandConfig(ctx context.Context) (carcommand.CarCommandConfig, error) {
 	cfg := carcommand.CarCommandConfig{
-		RefreshRate: GetIntEnv("REFRESH", DefaultRefreshRate),
+		RefreshRate:     GetIntEnv("REFRESH", DefaultRefreshRate),
+		FailsafeTimeout: time.Duration(GetIntEnv("FAILSAFETIMEOUT", DefaultFailsafeTimeout)) * time.Millisecond,
+		ReconnectMin:    time.Duration(GetIntEnv("RECONNECTMIN", DefaultReconnectMin)) * time.Millisecond,
+		ReconnectMax:    time.Duration(GetIntEnv("RECONNECTMAX", DefaultReconnectMax)) * time.Millisecond,
 		ServoControllerConfig: carcommand.ServoControllerConfig{
-			Address:   DefaultAddress, //GetStringEnv("ADDRESS", DefaultAddress),
-			I2CDevice: GetStringEnv("I2CDEVICE", DefaultI2CDevice),
+			Boards: GetBoardConfigs(),
 		},
+		Mixer: carcommand.MixerConfig{
+			Preset:         GetStringEnv("MIXER", DefaultMixer),
+			SteerReduction: GetIntEnv("MIXSTEERREDUCTION", DefaultMixSteerReduction),
+		},
+		SpeedHold: carcommand.SpeedHoldConfig{
+			PID: carcommand.PIDConfig{
+				Kp: GetFloatEnv("SPEEDHOLD_KP", DefaultSpeedHoldKp),
+				Ki: GetFloatEnv("SPEEDHOLD_KI", DefaultSpeedHoldKi),
+				Kd: GetFloatEnv("SPEEDHOLD_KD", DefaultSpeedHoldKd),
+			},
+			Step:        GetFloatEnv("SPEEDHOLD_STEP", DefaultSpeedHoldStep),
+			MaxThrottle: GetIntEnv("SPEEDHOLD_MAXTHROTTLE", DefaultSpeedHoldMaxThrottle),
+		},
+		Collision: carcommand.CollisionConfig{
+			MinDistance:  GetFloatEnv("COLLISION_MINDISTANCE", DefaultCollisionMinDistance),
+			ReactionTime: time.Duration(GetIntEnv("COLLISION_REACTION", DefaultCollisionReaction)) * time.Millisecond,
+			Deceleration: GetFloatEnv("COLLISION_DECEL", DefaultCollisionDecel),
+			TopSpeed:     GetFloatEnv("COLLISION_TOPSPEED", DefaultCollisionTopSpeed),
+		},
+	}
+	err := cfg.Collision.Validate()
+	if err != nil {
+		log.Printf("warning:COLLISION_ using defaults - error: %s\n", err)
+		cfg.Collision = carcommand.CollisionConfig{
+			MinDistance:  DefaultCollisionMinDistance,
+			ReactionTime: carcommand.DefaultCollisionReaction,
+			Deceleration: DefaultCollisionDecel,
+			TopSpeed:     DefaultCollisionTopSpeed,
+		}
+	}
+	if cfg.SpeedHold.Enabled() {
+		err := cfg.SpeedHold.Validate()
+		if err != nil {
+			log.Printf("warning:SPEEDHOLD_ off - error: %s\n", err)
+			cfg.SpeedHold.PID = carcommand.PIDConfig{}
+		}
+	}
+	cfg.RecordingDir = GetStringEnv("RECORDINGDIR", DefaultRecordingDir)
+	cfg.CalibrationFile = GetStringEnv("CALIBRATIONFILE", DefaultCalibrationFile)
+	calibrations, err := carcommand.LoadCalibrations(cfg.CalibrationFile)
+	if err != nil {
+		log.Printf("warning:CALIBRATIONFILE not loaded, using env pulses - error: %s\n", err)
+	}
+
+	mixOutputs, err := carcommand.ParseMixOutputs(GetStringEnv("MIX", DefaultMix))
+	if err != nil {
+		log.Printf("warning:MIX not parsed - error: %s\n", err)
+	} else {
+		cfg.Mixer.Outputs = mixOutputs
 	}
 
+	usedChannels := make(map[[2]int]string, carcommand.MaxSupportedServos)
 	for i := 0; i < carcommand.MaxSupportedServos; i++ {
 		envPrefix := fmt.Sprintf("SERVO%d_", i)
 		servoCfg := carcommand.ServoConfig{
 			Name:      GetStringEnv(envPrefix+"NAME", ""),
-			Type:      GetStringEnv(envPrefix+"TYPE", DefaultType),
-			Channel:   GetIntEnv(envPrefix+"CHANNEL", i),
+			Board:     GetIntEnv(envPrefix+"BOARD", i/carcommand.ChannelsPerBoard),
+			Type:      carcommand.ServoType(GetStringEnv(envPrefix+"TYPE", DefaultType)),
+			Channel:   GetIntEnv(envPrefix+"CHANNEL", i%carcommand.ChannelsPerBoard),
 			MaxPulse:  float32(GetIntEnv(envPrefix+"MAXPULSE", int(DefaultMaxPulse))),
 			MinPulse:  float32(GetIntEnv(envPrefix+"MINPULSE", int(DefaultMinPulse))),
 			MaxValue:  GetIntEnv(envPrefix+"MAXVALUE", DefaultMaxValue),
@@ -158,16 +417,267 @@ func GetCommandConfig(ctx context.Context) carcommand.CarCommandConfig {
 			MidOffset: GetIntEnv(envPrefix+"MIDOFFSET", DefaultMidOffset),
 			DeadZone:  GetIntEnv(envPrefix+"DEADZONE", DefaultDeadZone),
 			NumGears:  GetIntEnv(envPrefix+"NUMGEARS", DefaultNumGears),
+			Threshold: GetIntEnv(envPrefix+"THRESHOLD", DefaultThreshold),
 		}
 		servoCfg.MidValue = (servoCfg.MaxValue - servoCfg.MinValue) / 2
 
-		if servoCfg.Name != "" {
-			cfg.ServoConfigs = append(cfg.ServoConfigs, servoCfg)
+		if servoCfg.Name == "" {
+			continue
+		}
+
+		servoCfg.Curve = GetCurveConfig(envPrefix)
+		servoCfg.Slew = carcommand.SlewConfig{
+			Accel:         GetIntEnv(envPrefix+"ACCEL", DefaultAccel),
+			Decel:         GetIntEnv(envPrefix+"DECEL", DefaultDecel),
+			Brake:         GetIntEnv(envPrefix+"BRAKE", DefaultBrake),
+			Reverse:       GetIntEnv(envPrefix+"REVERSE", DefaultReverse),
+			NeutralBypass: GetBoolEnv(envPrefix+"NEUTRALBYPASS", DefaultNeutralBypass),
+		}
+
+		gearRatios, err := carcommand.ParsePercents(GetStringEnv(envPrefix+"GEARRATIOS", DefaultGearRatios))
+		if err != nil {
+			log.Printf("warning:%sGEARRATIOS not parsed - error: %s\n", envPrefix, err)
+		} else if len(gearRatios) > 0 {
+			servoCfg.GearRatios = gearRatios
+			servoCfg.NumGears = len(gearRatios)
+		}
+		servoCfg.ShiftCut = time.Duration(GetIntEnv(envPrefix+"SHIFTCUT", DefaultShiftCut)) * time.Millisecond
+
+		servoCfg.Transmission = carcommand.TransmissionMode(GetStringEnv(envPrefix+"TRANSMISSION", DefaultTransmission))
+		servoCfg.Auto = carcommand.AutoConfig{
+			UpShiftThrottle:   GetIntEnv(envPrefix+"AUTOUPTHROTTLE", DefaultAutoUpThrottle),
+			DownShiftThrottle: GetIntEnv(envPrefix+"AUTODOWNTHROTTLE", DefaultAutoDownThrottle),
+			UpShiftTime:       time.Duration(GetIntEnv(envPrefix+"AUTOUPTIME", DefaultAutoUpTime)) * time.Millisecond,
+			DownShiftTime:     time.Duration(GetIntEnv(envPrefix+"AUTODOWNTIME", DefaultAutoDownTime)) * time.Millisecond,
+		}
+		shiftSpeeds, err := carcommand.ParseShiftSpeeds(GetStringEnv(envPrefix+"SHIFTSPEEDS", DefaultShiftSpeeds))
+		if err != nil {
+			log.Printf("warning:%sSHIFTSPEEDS not parsed - error: %s\n", envPrefix, err)
+		} else {
+			servoCfg.Auto.ShiftSpeeds = shiftSpeeds
+		}
+
+		servoCfg.Esc = carcommand.EscConfig{
+			Mode:          carcommand.EscMode(GetStringEnv(envPrefix+"ESCMODE", DefaultEscMode)),
+			BrakePulse:    time.Duration(GetIntEnv(envPrefix+"BRAKEPULSE", DefaultBrakePulse)) * time.Millisecond,
+			NeutralPulse:  time.Duration(GetIntEnv(envPrefix+"NEUTRALPULSE", DefaultNeutralPulse)) * time.Millisecond,
+			DragBrake:     GetIntEnv(envPrefix+"DRAGBRAKE", DefaultDragBrake),
+			HillHold:      time.Duration(GetIntEnv(envPrefix+"HILLHOLD", DefaultHillHold)) * time.Millisecond,
+			HillHoldBrake: GetIntEnv(envPrefix+"HILLHOLDBRAKE", DefaultHillHoldBrake),
+		}
+
+		servoCfg.Gyro = carcommand.GyroConfig{
+			Gain:          GetIntEnv(envPrefix+"GYROGAIN", DefaultGyroGain),
+			MaxRate:       GetFloatEnv(envPrefix+"GYROMAXRATE", DefaultGyroMaxRate),
+			MaxCorrection: GetIntEnv(envPrefix+"GYROMAXCORRECTION", DefaultGyroMaxCorrection),
+			Reversed:      GetBoolEnv(envPrefix+"GYROREVERSED", DefaultGyroReversed),
+		}
+
+		failsafe, err := carcommand.ParseFailsafeStages(GetStringEnv(envPrefix+"FAILSAFE", DefaultFailsafe))
+		if err != nil {
+			log.Printf("warning:%sFAILSAFE not parsed - error: %s\n", envPrefix, err)
+		} else {
+			servoCfg.Failsafe = failsafe
+		}
+
+		if calibration, found := calibrations[servoCfg.Name]; found {
+			calibrated := calibration.Apply(servoCfg)
+			err = calibrated.Validate()
+			if err != nil {
+				log.Printf("warning:%s calibration not used - error: %s\n", servoCfg.Name, err)
+			} else {
+				servoCfg = calibrated
+			}
 		}
+
+		err = servoCfg.Validate()
+		if err != nil {
+			log.Printf("warning:SERVO%d skipped - error: %s\n", i, err)
+			continue
+		}
+		if servoCfg.Board >= len(cfg.ServoControllerConfig.Boards) {
+			log.Printf("warning:SERVO%d skipped - error: board %d not configured\n", i, servoCfg.Board)
+			continue
+		}
+		boardChannel := [2]int{servoCfg.Board, servoCfg.Channel}
+		if other, found := usedChannels[boardChannel]; found {
+			return cfg, fmt.Errorf("SERVO%d %s uses board %d channel %d already used by %s", i, servoCfg.Name, servoCfg.Board, servoCfg.Channel, other)
+		}
+		usedChannels[boardChannel] = servoCfg.Name
+		cfg.ServoConfigs = append(cfg.ServoConfigs, servoCfg)
+	}
+	return cfg, nil
+}
+
+func GetBatteryConfig(ctx context.Context) sensors.BatteryConfig {
+	cfg := sensors.BatteryConfig{
+		Sensor:          GetStringEnv("BATTERY_SENSOR", DefaultBatterySensor),
+		Address:         GetAddressEnv("BATTERY_ADDRESS", 0),
+		I2CDevice:       GetStringEnv("BATTERY_I2CDEVICE", GetStringEnv("I2CDEVICE", DefaultI2CDevice)),
+		Cells:           GetIntEnv("BATTERY_CELLS", DefaultBatteryCells),
+		CapacityMah:     GetIntEnv("BATTERY_CAPACITY", DefaultBatteryCapacity),
+		ShuntOhms:       GetFloatEnv("BATTERY_SHUNT", DefaultBatteryShunt),
+		Divider:         GetFloatEnv("BATTERY_DIVIDER", DefaultBatteryDivider),
+		LowCellVolts:    GetFloatEnv("BATTERY_LOWCELL", DefaultBatteryLowCell),
+		CutoffCellVolts: GetFloatEnv("BATTERY_CUTOFFCELL", DefaultBatteryCutoffCell),
+		LowThrottle:     GetIntEnv("BATTERY_LOWTHROTTLE", DefaultBatteryLowThrottle),
+		Hold:            time.Duration(GetIntEnv("BATTERY_HOLD", DefaultBatteryHold)) * time.Millisecond,
+		PollInterval:    time.Duration(GetIntEnv("BATTERY_POLL", DefaultBatteryPoll)) * time.Millisecond,
+	}
+	if !cfg.Enabled() {
+		return cfg
+	}
+	err := cfg.Validate()
+	if err != nil {
+		log.Printf("warning:BATTERY_ monitoring off - error: %s\n", err)
+		cfg.Sensor = ""
+	}
+	return cfg
+}
+
+func GetIMUConfig(ctx context.Context) sensors.IMUConfig {
+	cfg := sensors.IMUConfig{
+		Sensor:            GetStringEnv("IMU_SENSOR", DefaultIMUSensor),
+		Address:           GetAddressEnv("IMU_ADDRESS", 0),
+		I2CDevice:         GetStringEnv("IMU_I2CDEVICE", GetStringEnv("I2CDEVICE", DefaultI2CDevice)),
+		PollInterval:      time.Duration(GetIntEnv("IMU_POLL", DefaultIMUPoll)) * time.Millisecond,
+		RolloverAngle:     GetFloatEnv("IMU_ROLLOVERANGLE", DefaultRolloverAngle),
+		RolloverHold:      time.Duration(GetIntEnv("IMU_ROLLOVERHOLD", DefaultRolloverHold)) * time.Millisecond,
+		CrashG:            GetFloatEnv("IMU_CRASHG", DefaultCrashG),
+		CrashCut:          time.Duration(GetIntEnv("IMU_CRASHCUT", DefaultCrashCut)) * time.Millisecond,
+		CrashSound:        GetStringEnv("IMU_CRASHSOUND", DefaultCrashSound),
+		RolloverSound:     GetStringEnv("IMU_ROLLOVERSOUND", DefaultRolloverSound),
+		TelemetryInterval: time.Duration(GetIntEnv("IMU_TELEMETRY", DefaultIMUTelemetry)) * time.Millisecond,
+	}
+	if !cfg.Enabled() {
+		return cfg
+	}
+	err := cfg.Validate()
+	if err != nil {
+		log.Printf("warning:IMU_ monitoring off - error: %s\n", err)
+		cfg.Sensor = ""
 	}
 	return cfg
 }
 
+func GetWheelSpeedConfig(ctx context.Context) sensors.WheelSpeedConfig {
+	cfg := sensors.WheelSpeedConfig{
+		Device:            GetStringEnv("WHEELSPEED_DEVICE", DefaultWheelSpeedDevice),
+		Line:              GetIntEnv("WHEELSPEED_LINE", DefaultWheelSpeedLine),
+		PulsesPerRev:      GetIntEnv("WHEELSPEED_PULSESPERREV", DefaultPulsesPerRev),
+		WheelDiameter:     GetFloatEnv("WHEELSPEED_DIAMETER", DefaultWheelDiameter),
+		SensorRatio:       GetFloatEnv("WHEELSPEED_RATIO", DefaultSensorRatio),
+		Window:            GetIntEnv("WHEELSPEED_WINDOW", DefaultSpeedWindow),
+		Timeout:           time.Duration(GetIntEnv("WHEELSPEED_TIMEOUT", DefaultSpeedTimeout)) * time.Millisecond,
+		Debounce:          time.Duration(GetIntEnv("WHEELSPEED_DEBOUNCE", DefaultSpeedDebounce)) * time.Microsecond,
+		TelemetryInterval: time.Duration(GetIntEnv("WHEELSPEED_TELEMETRY", DefaultSpeedTelemetry)) * time.Millisecond,
+		Servo:             GetStringEnv("WHEELSPEED_SERVO", DefaultWheelSpeedServo),
+	}
+	if !cfg.Enabled() {
+		return cfg
+	}
+	err := cfg.Validate()
+	if err != nil {
+		log.Printf("warning:WHEELSPEED_ sensing off - error: %s\n", err)
+		cfg.Line = DefaultWheelSpeedLine
+	}
+	return cfg
+}
+
+// One sensor for each side, set with DISTANCE_FRONT_SENSOR and DISTANCE_REAR_SENSOR
+func GetDistanceConfigs(ctx context.Context) []sensors.DistanceConfig {
+	configs := make([]sensors.DistanceConfig, 0, 2)
+	for _, side := range []string{carcommand.DistanceFront, carcommand.DistanceRear} {
+		envPrefix := fmt.Sprintf("DISTANCE_%s_", strings.ToUpper(side))
+		cfg := sensors.DistanceConfig{
+			Side:              side,
+			Sensor:            GetStringEnv(envPrefix+"SENSOR", DefaultDistanceSensor),
+			Address:           GetAddressEnv(envPrefix+"ADDRESS", 0),
+			I2CDevice:         GetStringEnv(envPrefix+"I2CDEVICE", GetStringEnv("I2CDEVICE", DefaultI2CDevice)),
+			GPIODevice:        GetStringEnv(envPrefix+"DEVICE", DefaultDistanceDevice),
+			TriggerLine:       GetIntEnv(envPrefix+"TRIGGER", DefaultDistanceLine),
+			EchoLine:          GetIntEnv(envPrefix+"ECHO", DefaultDistanceLine),
+			MaxRange:          GetFloatEnv(envPrefix+"MAXRANGE", DefaultDistanceMaxRange),
+			PollInterval:      time.Duration(GetIntEnv(envPrefix+"POLL", DefaultDistancePoll)) * time.Millisecond,
+			TelemetryInterval: time.Duration(GetIntEnv(envPrefix+"TELEMETRY", DefaultDistanceTelemetry)) * time.Millisecond,
+		}
+		if !cfg.Enabled() {
+			continue
+		}
+		err := cfg.Validate()
+		if err != nil {
+			log.Printf("warning:%s sensing off - error: %s\n", envPrefix, err)
+			continue
+		}
+		configs = append(configs, cfg)
+	}
+	return configs
+}
+
+// Board 0 uses the top level driver settings, more boards are added by setting BOARDn_ADDRESS or BOARDn_DRIVER
+func GetBoardConfigs() []carcommand.BoardConfig {
+	boards := []carcommand.BoardConfig{{
+		Driver:    GetStringEnv("DRIVER", DefaultDriver),
+		Address:   GetAddressEnv("ADDRESS", DefaultAddress),
+		I2CDevice: GetStringEnv("I2CDEVICE", DefaultI2CDevice),
+		Frequency: float32(GetIntEnv("PWMFREQ", DefaultPWMFrequency)),
+		Device:    GetStringEnv("DEVICE", ""),
+		Lines:     GetLinesEnv("LINES"),
+	}}
+
+	for i := 1; i < carcommand.MaxBoards; i++ {
+		envPrefix := fmt.Sprintf("BOARD%d_", i)
+		_, hasAddress := os.LookupEnv(AppEnvBase + envPrefix + "ADDRESS")
+		_, hasDriver := os.LookupEnv(AppEnvBase + envPrefix + "DRIVER")
+		if !hasAddress && !hasDriver {
+			continue
+		}
+		if len(boards) != i {
+			log.Printf("warning:%s skipped - error: boards must be numbered in order\n", envPrefix)
+			continue
+		}
+		boards = append(boards, carcommand.BoardConfig{
+			Driver:    GetStringEnv(envPrefix+"DRIVER", boards[0].Driver),
+			Address:   GetAddressEnv(envPrefix+"ADDRESS", DefaultAddress),
+			I2CDevice: GetStringEnv(envPrefix+"I2CDEVICE", boards[0].I2CDevice),
+			Frequency: float32(GetIntEnv(envPrefix+"PWMFREQ", int(boards[0].Frequency))),
+			Device:    GetStringEnv(envPrefix+"DEVICE", ""),
+			Lines:     GetLinesEnv(envPrefix + "LINES"),
+		})
+	}
+	return boards
+}
+
+func GetLinesEnv(env string) []int {
+	lines, err := carcommand.ParseGPIOLines(GetStringEnv(env, ""))
+	if err != nil {
+		log.Printf("warning:%s not parsed - error: %s\n", env, err)
+		return nil
+	}
+	return lines
+}
+
+func GetCurveConfig(envPrefix string) carcommand.ResponseCurve {
+	curve := carcommand.ResponseCurve{
+		Expo: GetIntEnv(envPrefix+"EXPO", DefaultExpo),
+	}
+
+	rates, err := carcommand.ParsePercents(GetStringEnv(envPrefix+"RATES", DefaultRates))
+	if err != nil {
+		log.Printf("warning:%sRATES not parsed - error: %s\n", envPrefix, err)
+	} else {
+		curve.Rates = rates
+	}
+
+	points, err := carcommand.ParseCurvePoints(GetStringEnv(envPrefix+"CURVE", DefaultCurve))
+	if err != nil {
+		log.Printf("warning:%sCURVE not parsed - error: %s\n", envPrefix, err)
+	} else {
+		curve.Points = points
+	}
+	return curve
+}
+
 func GetIntEnv(env string, defaultValue int) int {
 	envValue, found := os.LookupEnv(AppEnvBase + env)
 	if !found {
@@ -183,6 +693,33 @@ func GetIntEnv(env string, defaultValue int) int {
 	}
 }
 
+// Takes decimal or 0x prefixed hex (0x41)
+func GetAddressEnv(env string, defaultValue byte) byte {
+	envValue, found := os.LookupEnv(AppEnvBase + env)
+	if !found {
+		return defaultValue
+	}
+	value, err := strconv.ParseUint(envValue, 0, 8)
+	if err != nil {
+		log.Printf("warning:%s not parsed - error: %s\n", env, err)
+		return defaultValue
+	}
+	return byte(value)
+}
+
+func GetFloatEnv(env string, defaultValue float64) float64 {
+	envValue, found := os.LookupEnv(AppEnvBase + env)
+	if !found {
+		return defaultValue
+	}
+	value, err := strconv.ParseFloat(envValue, 64)
+	if err != nil {
+		log.Printf("warning:%s not parsed - error: %s\n", env, err)
+		return defaultValue
+	}
+	return value
+}
+
 func GetBoolEnv(env string, defaultValue bool) bool {
 	envValue, found := os.LookupEnv(AppEnvBase + env)
 	if !found {
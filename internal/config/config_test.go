@@ -0,0 +1,280 @@
+package config
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+	"github.com/Speshl/goremotecontrol_web/internal/server"
+)
+
+func setEnvs(t *testing.T, envs map[string]string) {
+	t.Helper()
+	for env, value := range envs {
+		t.Setenv(AppEnvBase+env, value)
+	}
+}
+
+func TestGetBoardConfigs(t *testing.T) {
+	tests := map[string]struct {
+		envs     map[string]string
+		expected []carcommand.BoardConfig
+	}{
+		"default": {
+			expected: []carcommand.BoardConfig{{Driver: DefaultDriver, Address: DefaultAddress, I2CDevice: DefaultI2CDevice, Frequency: DefaultPWMFrequency}},
+		},
+		"second_board": {
+			envs: map[string]string{"BOARD1_ADDRESS": "0x41"},
+			expected: []carcommand.BoardConfig{
+				{Driver: DefaultDriver, Address: DefaultAddress, I2CDevice: DefaultI2CDevice, Frequency: DefaultPWMFrequency},
+				{Driver: DefaultDriver, Address: 0x41, I2CDevice: DefaultI2CDevice, Frequency: DefaultPWMFrequency},
+			},
+		},
+		"out_of_order": {
+			envs:     map[string]string{"BOARD2_ADDRESS": "0x42"},
+			expected: []carcommand.BoardConfig{{Driver: DefaultDriver, Address: DefaultAddress, I2CDevice: DefaultI2CDevice, Frequency: DefaultPWMFrequency}},
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			setEnvs(t, tc.envs)
+			boards := GetBoardConfigs()
+			if len(boards) != len(tc.expected) {
+				t.Fatalf("expected %d boards, got %+v", len(tc.expected), boards)
+			}
+			for i, board := range boards {
+				expected := tc.expected[i]
+				if board.Driver != expected.Driver || board.Address != expected.Address || board.I2CDevice != expected.I2CDevice || board.Frequency != expected.Frequency {
+					t.Errorf("board %d expected %+v, got %+v", i, expected, board)
+				}
+			}
+		})
+	}
+}
+
+func TestGetCommandConfig(t *testing.T) {
+	tests := map[string]struct {
+		envs     map[string]string
+		servos   []string
+		channels [][2]int
+		err      bool
+	}{
+		"servos": {
+			envs: map[string]string{
+				"SERVO0_NAME": "esc", "SERVO0_TYPE": "esc",
+				"SERVO1_NAME": "steer",
+			},
+			servos:   []string{"esc", "steer"},
+			channels: [][2]int{{0, 0}, {0, 1}},
+		},
+		"moved_channel": {
+			envs: map[string]string{
+				"SERVO0_NAME": "esc", "SERVO0_CHANNEL": "5",
+				"SERVO1_NAME": "steer",
+			},
+			servos:   []string{"esc", "steer"},
+			channels: [][2]int{{0, 5}, {0, 1}},
+		},
+		"second_board": {
+			envs: map[string]string{
+				"BOARD1_ADDRESS": "0x41",
+				"SERVO0_NAME":    "esc",
+				"SERVO1_NAME":    "steer", "SERVO1_BOARD": "1", "SERVO1_CHANNEL": "0",
+			},
+			servos:   []string{"esc", "steer"},
+			channels: [][2]int{{0, 0}, {1, 0}},
+		},
+		"missing_board_skipped": {
+			envs: map[string]string{
+				"SERVO0_NAME": "esc",
+				"SERVO1_NAME": "steer", "SERVO1_BOARD": "1",
+			},
+			servos:   []string{"esc"},
+			channels: [][2]int{{0, 0}},
+		},
+		"invalid_servo_skipped": {
+			envs: map[string]string{
+				"SERVO0_NAME": "esc", "SERVO0_TYPE": "rocket",
+				"SERVO1_NAME": "steer",
+			},
+			servos:   []string{"steer"},
+			channels: [][2]int{{0, 1}},
+		},
+		"duplicate_channel": {
+			envs: map[string]string{
+				"SERVO0_NAME": "esc",
+				"SERVO1_NAME": "steer", "SERVO1_CHANNEL": "0",
+			},
+			err: true,
+		},
+		"duplicate_on_second_board": {
+			envs: map[string]string{
+				"BOARD1_ADDRESS": "0x41",
+				"SERVO0_NAME":    "esc", "SERVO0_BOARD": "1", "SERVO0_CHANNEL": "3",
+				"SERVO1_NAME": "steer", "SERVO1_BOARD": "1", "SERVO1_CHANNEL": "3",
+			},
+			err: true,
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			setEnvs(t, tc.envs)
+			cfg, err := GetCommandConfig(context.Background())
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error, got servos %+v", cfg.ServoConfigs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(cfg.ServoConfigs) != len(tc.servos) {
+				t.Fatalf("expected servos %v, got %+v", tc.servos, cfg.ServoConfigs)
+			}
+			for i, servoCfg := range cfg.ServoConfigs {
+				if servoCfg.Name != tc.servos[i] || servoCfg.Board != tc.channels[i][0] || servoCfg.Channel != tc.channels[i][1] {
+					t.Errorf("servo %d expected %s on board %d channel %d, got %s on board %d channel %d",
+						i, tc.servos[i], tc.channels[i][0], tc.channels[i][1], servoCfg.Name, servoCfg.Board, servoCfg.Channel)
+				}
+			}
+		})
+	}
+}
+
+func TestGetConfigChannelMap(t *testing.T) {
+	tests := map[string]struct {
+		envs      map[string]string
+		slots     int
+		inputs    map[string]string
+		duplicate bool
+	}{
+		"default": {
+			envs:   map[string]string{},
+			inputs: map[string]string{"esc": carcommand.InputThrottle, "steer": carcommand.InputSteer},
+		},
+		"renamed_throttle": {
+			envs: map[string]string{
+				"CHANNELMAP":  "drive:value:throttle,drive:gear,esc:value:aux",
+				"SERVO0_NAME": "drive", "SERVO0_TYPE": "esc",
+				"SERVO1_NAME": "esc",
+			},
+			slots:  3,
+			inputs: map[string]string{"drive": carcommand.InputThrottle, "esc": carcommand.InputAux},
+		},
+		"invalid_uses_default": {
+			envs:   map[string]string{"CHANNELMAP": "esc:rocket"},
+			inputs: map[string]string{"esc": carcommand.InputThrottle},
+		},
+		"duplicate_servo_channel": {
+			envs: map[string]string{
+				"SERVO0_NAME": "esc",
+				"SERVO1_NAME": "steer", "SERVO1_CHANNEL": "0",
+			},
+			duplicate: true,
+		},
+	}
+
+	defaultMap, err := server.ParseChannelMap(DefaultChannelMap)
+	if err != nil {
+		t.Fatalf("failed parsing default channel map: %s", err)
+	}
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			setEnvs(t, tc.envs)
+			cfg, err := GetConfig(context.Background())
+			if tc.duplicate {
+				if err == nil {
+					t.Fatalf("expected duplicate channel to stop startup")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			slots := tc.slots
+			if slots == 0 {
+				slots = len(defaultMap)
+			}
+			if len(cfg.SocketServerConfig.ChannelMap) != slots {
+				t.Errorf("expected %d slots, got %+v", slots, cfg.SocketServerConfig.ChannelMap)
+			}
+			for name, input := range tc.inputs {
+				if cfg.CommandConfig.Inputs[name] != input {
+					t.Errorf("expected %s to be %s, got %q", name, input, cfg.CommandConfig.Inputs[name])
+				}
+			}
+		})
+	}
+}
+
+func TestGetSocketServerConfigRoles(t *testing.T) {
+	tests := map[string]struct {
+		envs        map[string]string
+		users       map[string]string
+		roles       map[string]string
+		defaultRole string
+		profiles    []string
+	}{
+		"default": {
+			users:       map[string]string{"username": "password"},
+			roles:       map[string]string{},
+			defaultRole: DefaultRole,
+		},
+		"roles_and_profiles": {
+			envs: map[string]string{
+				"USERS":                "alice:secret,bob:letmein",
+				"ROLES":                "alice:admin,bob:kid",
+				"DEFAULTROLE":          "kid",
+				"PROFILE0_NAME":        "kid",
+				"PROFILE0_MAXGEAR":     "2",
+				"PROFILE1_NAME":        "fast",
+				"PROFILE1_MAXTHROTTLE": "150", //out of range, skipped
+			},
+			users:       map[string]string{"alice": "secret", "bob": "letmein"},
+			roles:       map[string]string{"alice": server.RoleAdmin, "bob": "kid"},
+			defaultRole: "kid",
+			profiles:    []string{"kid"},
+		},
+		"invalid_roles": {
+			envs:        map[string]string{"ROLES": "alice"},
+			defaultRole: DefaultRole,
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			setEnvs(t, tc.envs)
+			cfg := GetSocketServerConfig(context.Background())
+			if tc.users != nil && len(cfg.Users) != len(tc.users) {
+				t.Errorf("expected users %v, got %v", tc.users, cfg.Users)
+			}
+			for username, password := range tc.users {
+				if cfg.Users[username] != password {
+					t.Errorf("expected %s to have password %s, got %q", username, password, cfg.Users[username])
+				}
+			}
+			if len(cfg.UserRoles) != len(tc.roles) {
+				t.Errorf("expected roles %v, got %v", tc.roles, cfg.UserRoles)
+			}
+			for username, role := range tc.roles {
+				if cfg.UserRoles[username] != role {
+					t.Errorf("expected %s to be %s, got %q", username, role, cfg.UserRoles[username])
+				}
+			}
+			if cfg.DefaultRole != tc.defaultRole {
+				t.Errorf("expected default role %s, got %s", tc.defaultRole, cfg.DefaultRole)
+			}
+			if len(cfg.Profiles) != len(tc.profiles) {
+				t.Fatalf("expected profiles %v, got %+v", tc.profiles, cfg.Profiles)
+			}
+			for i, profile := range cfg.Profiles {
+				if profile.Name != tc.profiles[i] {
+					t.Errorf("profile %d expected %s, got %s", i, tc.profiles[i], profile.Name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package sensors
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+const BatterySource = "battery" //Sensor name on readings and source of its output limits
+
+const DefaultBatteryPoll = 500 * time.Millisecond
+const DefaultShuntOhms = 0.1
+const DefaultLowCellVolts = 3.5
+const DefaultCutoffCellVolts = 3.3
+const DefaultLowThrottle = 50              //Percent of full throttle once the pack is low
+const DefaultBatteryHold = 2 * time.Second //Sag under hard throttle recovers quicker than this so it doesn't trip the limits
+
+const MaxCellVolts = 4.25 //Fully charged lipo with some slack, used to count cells
+
+type BatteryState string
+
+// States only step down, a pack that was low stays low until the car restarts on a fresh one
+const (
+	BatteryOK     BatteryState = "ok"
+	BatteryLow    BatteryState = "low"    //Throttle limited
+	BatteryCutoff BatteryState = "cutoff" //Throttle held at neutral
+	BatteryError  BatteryState = "error"  //Sensor not answering, limits stay where they were
+)
+
+// Resting lipo cell voltage to percent remaining, in order of voltage
+var lipoCurve = []struct {
+	volts   float64
+	percent float64
+}{
+	{volts: 3.27, percent: 0},
+	{volts: 3.61, percent: 5},
+	{volts: 3.69, percent: 10},
+	{volts: 3.73, percent: 20},
+	{volts: 3.77, percent: 30},
+	{volts: 3.80, percent: 40},
+	{volts: 3.84, percent: 50},
+	{volts: 3.87, percent: 60},
+	{volts: 3.95, percent: 70},
+	{volts: 4.02, percent: 80},
+	{volts: 4.11, percent: 90},
+	{volts: 4.20, percent: 100},
+}
+
+type BatteryConfig struct {
+	Sensor          string //Empty turns battery monitoring off
+	Address         byte   //0 uses the sensor's default address
+	I2CDevice       string
+	Cells           int //0 counts them from the first reading
+	CapacityMah     int //0 estimates what's left from voltage alone
+	ShuntOhms       float64
+	Divider         float64 //Pack voltage over the voltage at the ads1115 pin
+	LowCellVolts    float64
+	CutoffCellVolts float64
+	LowThrottle     int
+	Hold            time.Duration
+	PollInterval    time.Duration
+}
+
+type BatteryMonitor struct {
+	config   BatteryConfig
+	open     I2COpener
+	bus      I2CBus
+	sensor   batterySensor
+	limits   chan<- carcommand.OutputLimit
+	readings chan<- Reading
+
+	cells        int
+	state        BatteryState
+	belowSince   time.Time //When the cells first dropped under the next threshold, zero if they aren't
+	startPercent float64   //Estimated from the first reading, coulomb counting goes from here
+	usedMah      float64
+	lastRead     time.Time
+}
+
+func (c BatteryConfig) Enabled() bool {
+	return c.Sensor != ""
+}
+
+func (c BatteryConfig) Validate() error {
+	switch c.Sensor {
+	case BatteryINA219, BatteryINA226:
+		if c.ShuntOhms <= 0 {
+			return fmt.Errorf("%s needs the shunt resistance", c.Sensor)
+		}
+	case BatteryADS1115:
+		if c.Divider < 1 {
+			return fmt.Errorf("ads1115 divider must be at least 1 (%.2f)", c.Divider)
+		}
+	default:
+		return fmt.Errorf("unsupported battery sensor (%s)", c.Sensor)
+	}
+	if c.Cells < 0 {
+		return fmt.Errorf("cells can't be negative (%d)", c.Cells)
+	}
+	if c.CapacityMah < 0 {
+		return fmt.Errorf("capacity can't be negative (%d)", c.CapacityMah)
+	}
+	if c.CutoffCellVolts <= 0 || c.LowCellVolts <= c.CutoffCellVolts || c.LowCellVolts >= MaxCellVolts {
+		return fmt.Errorf("cell thresholds must be cutoff < low < %.2fV (cutoff %.2fV low %.2fV)", MaxCellVolts, c.CutoffCellVolts, c.LowCellVolts)
+	}
+	if c.LowThrottle < 0 || c.LowThrottle > carcommand.MaxThrottleLimit {
+		return fmt.Errorf("low throttle must be 0 to %d percent (%d)", carcommand.MaxThrottleLimit, c.LowThrottle)
+	}
+	if c.Hold < 0 {
+		return fmt.Errorf("hold can't be negative")
+	}
+	return nil
+}
+
+// Limits go to the car's LimitChannel, readings to the clients
+func NewBatteryMonitor(cfg BatteryConfig, limits chan<- carcommand.OutputLimit, readings chan<- Reading) *BatteryMonitor {
+	if cfg.Address == 0 {
+		cfg.Address = DefaultINAAddress
+		if cfg.Sensor == BatteryADS1115 {
+			cfg.Address = DefaultADS1115Address
+		}
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultBatteryPoll
+	}
+	return &BatteryMonitor{
+		config:       cfg,
+		open:         openI2C,
+		limits:       limits,
+		readings:     readings,
+		cells:        cfg.Cells,
+		state:        BatteryOK,
+		startPercent: -1,
+	}
+}
+
+// Polls until the context is done, sensor errors are retried on the next poll and never stop the car
+func (b *BatteryMonitor) Start(ctx context.Context) error {
+	err := b.config.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid battery config - %w", err)
+	}
+	defer b.disconnect()
+
+	ticker := time.NewTicker(b.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			reading, limit, err := b.update(time.Now())
+			if err != nil {
+				log.Printf("warning: battery not read - %s\n", err.Error())
+				b.disconnect()
+			}
+			publish(b.readings, reading)
+			if limit == nil {
+				continue
+			}
+			select {
+			case b.limits <- *limit:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+func (b *BatteryMonitor) connect() error {
+	bus, err := b.open(b.config.Address, b.config.I2CDevice)
+	if err != nil {
+		return fmt.Errorf("failed opening %s at 0x%02x - %w", b.config.Sensor, b.config.Address, err)
+	}
+	sensor, err := newBatterySensor(b.config, bus)
+	if err == nil {
+		err = sensor.init()
+	}
+	if err != nil {
+		bus.Close()
+		return err
+	}
+	b.bus = bus
+	b.sensor = sensor
+	return nil
+}
+
+func (b *BatteryMonitor) disconnect() {
+	if b.bus != nil {
+		b.bus.Close()
+	}
+	b.bus = nil
+	b.sensor = nil
+}
+
+// Reads the pack and returns the limit to send when the state steps down
+func (b *BatteryMonitor) update(now time.Time) (Reading, *carcommand.OutputLimit, error) {
+	reading := Reading{
+		Sensor: BatterySource,
+		State:  string(BatteryError),
+		Values: make(map[string]float64),
+		Time:   now,
+	}
+	if b.sensor == nil {
+		err := b.connect()
+		if err != nil {
+			return reading, nil, err
+		}
+	}
+	volts, amps, err := b.sensor.read()
+	if err != nil {
+		return reading, nil, err
+	}
+	if b.cells == 0 {
+		if volts < 1 {
+			return reading, nil, fmt.Errorf("no pack voltage to count cells from (%.2fV)", volts)
+		}
+		b.cells = int(math.Ceil(volts / MaxCellVolts))
+		log.Printf("battery looks like %dS at %.2fV\n", b.cells, volts)
+	}
+	cellVolts := volts / float64(b.cells)
+
+	if !b.lastRead.IsZero() {
+		b.usedMah += amps * 1000 * now.Sub(b.lastRead).Hours()
+	}
+	b.lastRead = now
+	percent := lipoPercent(cellVolts)
+	if b.startPercent < 0 {
+		b.startPercent = percent
+	}
+
+	reading.Values["voltage"] = volts
+	reading.Values["current"] = amps
+	reading.Values["cellVoltage"] = cellVolts
+	reading.Values["cells"] = float64(b.cells)
+	reading.Values["usedMah"] = b.usedMah
+	if b.config.CapacityMah > 0 {
+		capacity := float64(b.config.CapacityMah)
+		remaining := math.Max(capacity*b.startPercent/100-b.usedMah, 0)
+		reading.Values["remainingMah"] = remaining
+		percent = remaining / capacity * 100
+	}
+	reading.Values["percent"] = percent
+
+	limit := b.stepState(cellVolts, now)
+	reading.State = string(b.state)
+	return reading, limit, nil
+}
+
+func (b *BatteryMonitor) stepState(cellVolts float64, now time.Time) *carcommand.OutputLimit {
+	next := b.state
+	switch {
+	case cellVolts < b.config.CutoffCellVolts:
+		next = BatteryCutoff
+	case cellVolts < b.config.LowCellVolts && b.state == BatteryOK:
+		next = BatteryLow
+	}
+	if next == b.state {
+		b.belowSince = time.Time{}
+		return nil
+	}
+	if b.belowSince.IsZero() {
+		b.belowSince = now
+	}
+	if now.Sub(b.belowSince) < b.config.Hold {
+		return nil
+	}
+
+	b.state = next
+	b.belowSince = time.Time{}
+	reason := fmt.Sprintf("cells at %.2fV", cellVolts)
+	log.Printf("warning: battery %s - %s\n", b.state, reason)
+	if b.state == BatteryCutoff {
+		return &carcommand.OutputLimit{Source: BatterySource, Neutral: true, Reason: reason}
+	}
+	return &carcommand.OutputLimit{Source: BatterySource, MaxThrottle: b.config.LowThrottle, Reason: reason}
+}
+
+// Linear between the points of the lipo curve
+func lipoPercent(cellVolts float64) float64 {
+	if cellVolts <= lipoCurve[0].volts {
+		return 0
+	}
+	for i := 1; i < len(lipoCurve); i++ {
+		high := lipoCurve[i]
+		if cellVolts <= high.volts {
+			low := lipoCurve[i-1]
+			return low.percent + (cellVolts-low.volts)/(high.volts-low.volts)*(high.percent-low.percent)
+		}
+	}
+	return 100
+}
@@ -0,0 +1,110 @@
+package sensors
+
+import (
+	"fmt"
+)
+
+const BatteryINA219 = "ina219"
+const BatteryINA226 = "ina226"
+const BatteryADS1115 = "ads1115" //Voltage only, read through a divider on AIN0
+
+// Default addresses with the address pins tied to ground
+const DefaultINAAddress = 0x40
+const DefaultADS1115Address = 0x48
+
+// Shunt and bus voltage registers are the same on both INAs, only the LSBs differ
+const inaShuntRegister = 0x01
+const inaBusRegister = 0x02
+
+const ads1115ConversionRegister = 0x00
+const ads1115ConfigRegister = 0x01
+
+// AIN0 against ground, +/-4.096V, continuous conversion at 128 samples per second, comparator off
+const ads1115Config = 0x4283
+const ads1115VoltsPerBit = 4.096 / 32768
+
+type batterySensor interface {
+	init() error
+	read() (volts float64, amps float64, err error)
+}
+
+type ina219 struct {
+	bus       I2CBus
+	shuntOhms float64
+}
+
+type ina226 struct {
+	bus       I2CBus
+	shuntOhms float64
+}
+
+type ads1115 struct {
+	bus     I2CBus
+	divider float64
+}
+
+func newBatterySensor(cfg BatteryConfig, bus I2CBus) (batterySensor, error) {
+	switch cfg.Sensor {
+	case BatteryINA219:
+		return &ina219{bus: bus, shuntOhms: cfg.ShuntOhms}, nil
+	case BatteryINA226:
+		return &ina226{bus: bus, shuntOhms: cfg.ShuntOhms}, nil
+	case BatteryADS1115:
+		return &ads1115{bus: bus, divider: cfg.Divider}, nil
+	default:
+		return nil, fmt.Errorf("unsupported battery sensor (%s)", cfg.Sensor)
+	}
+}
+
+// Power on defaults measure up to 32V and 320mV across the shunt, current is worked out here instead of with the calibration register
+func (s *ina219) init() error {
+	return nil
+}
+
+func (s *ina219) read() (float64, float64, error) {
+	bus, err := s.bus.ReadRegU16BE(inaBusRegister)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed reading ina219 bus voltage - %w", err)
+	}
+	shunt, err := s.bus.ReadRegU16BE(inaShuntRegister)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed reading ina219 shunt voltage - %w", err)
+	}
+	volts := float64(bus>>3) * 0.004 //Low 3 bits are status flags
+	shuntVolts := float64(int16(shunt)) * 0.00001
+	return volts, shuntVolts / s.shuntOhms, nil
+}
+
+func (s *ina226) init() error {
+	return nil
+}
+
+func (s *ina226) read() (float64, float64, error) {
+	bus, err := s.bus.ReadRegU16BE(inaBusRegister)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed reading ina226 bus voltage - %w", err)
+	}
+	shunt, err := s.bus.ReadRegU16BE(inaShuntRegister)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed reading ina226 shunt voltage - %w", err)
+	}
+	volts := float64(bus) * 0.00125
+	shuntVolts := float64(int16(shunt)) * 0.0000025
+	return volts, shuntVolts / s.shuntOhms, nil
+}
+
+func (s *ads1115) init() error {
+	err := s.bus.WriteRegU16BE(ads1115ConfigRegister, ads1115Config)
+	if err != nil {
+		return fmt.Errorf("failed configuring ads1115 - %w", err)
+	}
+	return nil
+}
+
+func (s *ads1115) read() (float64, float64, error) {
+	raw, err := s.bus.ReadRegU16BE(ads1115ConversionRegister)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed reading ads1115 - %w", err)
+	}
+	return float64(int16(raw)) * ads1115VoltsPerBit * s.divider, 0, nil
+}
@@ -0,0 +1,222 @@
+package sensors
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+type fakeI2C struct {
+	registers map[byte]uint16
+	writes    map[byte]uint16
+	bytes     map[byte]byte //8 bit registers
+	err       error
+	closed    bool
+}
+
+func newFakeI2C(registers map[byte]uint16) *fakeI2C {
+	return &fakeI2C{registers: registers, writes: make(map[byte]uint16), bytes: make(map[byte]byte)}
+}
+
+func (f *fakeI2C) ReadRegU8(reg byte) (byte, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	return f.bytes[reg], nil
+}
+
+func (f *fakeI2C) WriteRegU8(reg byte, value byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.bytes[reg] = value
+	return nil
+}
+
+func (f *fakeI2C) ReadRegU16BE(reg byte) (uint16, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	return f.registers[reg], nil
+}
+
+func (f *fakeI2C) WriteRegU16BE(reg byte, value uint16) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.writes[reg] = value
+	return nil
+}
+
+func (f *fakeI2C) Close() error {
+	f.closed = true
+	return nil
+}
+
+func testBatteryConfig(sensor string) BatteryConfig {
+	return BatteryConfig{
+		Sensor:          sensor,
+		ShuntOhms:       DefaultShuntOhms,
+		Divider:         4,
+		LowCellVolts:    DefaultLowCellVolts,
+		CutoffCellVolts: DefaultCutoffCellVolts,
+		LowThrottle:     DefaultLowThrottle,
+		Hold:            DefaultBatteryHold,
+	}
+}
+
+func newFakeBatteryMonitor(cfg BatteryConfig, bus *fakeI2C) *BatteryMonitor {
+	monitor := NewBatteryMonitor(cfg, nil, nil)
+	monitor.open = func(address byte, device string) (I2CBus, error) {
+		if bus == nil {
+			return nil, fmt.Errorf("no device at 0x%02x", address)
+		}
+		return bus, nil
+	}
+	return monitor
+}
+
+func TestBatterySensors(t *testing.T) {
+	tests := map[string]struct {
+		registers map[byte]uint16
+		volts     float64
+		amps      float64
+		config    uint16 //Expected config write, 0 for none
+	}{
+		BatteryINA219: {
+			registers: map[byte]uint16{inaBusRegister: 2000 << 3, inaShuntRegister: 10000},
+			volts:     8,
+			amps:      1,
+		},
+		BatteryINA226: {
+			registers: map[byte]uint16{inaBusRegister: 6400, inaShuntRegister: 20000},
+			volts:     8,
+			amps:      0.5,
+		},
+		BatteryADS1115: {
+			registers: map[byte]uint16{ads1115ConversionRegister: 16000},
+			volts:     8,
+			config:    ads1115Config,
+		},
+	}
+
+	for sensor, tc := range tests {
+		t.Run(sensor, func(t *testing.T) {
+			bus := newFakeI2C(tc.registers)
+			monitor := newFakeBatteryMonitor(testBatteryConfig(sensor), bus)
+			reading, _, err := monitor.update(time.Now())
+			if err != nil {
+				t.Fatalf("failed reading: %s", err)
+			}
+			if math.Abs(reading.Values["voltage"]-tc.volts) > 0.001 || math.Abs(reading.Values["current"]-tc.amps) > 0.001 {
+				t.Errorf("expected %.2fV %.2fA, got %+v", tc.volts, tc.amps, reading.Values)
+			}
+			if reading.Values["cells"] != 2 {
+				t.Errorf("expected 2 cells counted, got %.0f", reading.Values["cells"])
+			}
+			if bus.writes[ads1115ConfigRegister] != tc.config {
+				t.Errorf("expected config %04x, got %04x", tc.config, bus.writes[ads1115ConfigRegister])
+			}
+		})
+	}
+}
+
+func TestBatteryCutoff(t *testing.T) {
+	bus := newFakeI2C(map[byte]uint16{})
+	cfg := testBatteryConfig(BatteryINA226)
+	cfg.CapacityMah = 2000
+	monitor := newFakeBatteryMonitor(cfg, bus)
+	clock := time.Now()
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		volts   float64
+		state   BatteryState
+		limit   *carcommand.OutputLimit
+	}{
+		{name: "full", volts: 8.4, state: BatteryOK},
+		{name: "sag", advance: time.Second, volts: 6.9, state: BatteryOK},
+		{name: "sag_recovered", advance: time.Second, volts: 7.6, state: BatteryOK},
+		{name: "low", advance: time.Second, volts: 6.9, state: BatteryOK},
+		{name: "low_held", advance: DefaultBatteryHold, volts: 6.9, state: BatteryLow, limit: &carcommand.OutputLimit{Source: BatterySource, MaxThrottle: DefaultLowThrottle, Reason: "cells at 3.45V"}},
+		{name: "low_again", advance: time.Second, volts: 6.9, state: BatteryLow},
+		{name: "cutoff", advance: time.Second, volts: 6.4, state: BatteryLow},
+		{name: "cutoff_held", advance: DefaultBatteryHold, volts: 6.4, state: BatteryCutoff, limit: &carcommand.OutputLimit{Source: BatterySource, Neutral: true, Reason: "cells at 3.20V"}},
+		{name: "resting_voltage_stays_cut", advance: DefaultBatteryHold, volts: 7.4, state: BatteryCutoff},
+	}
+
+	for _, step := range steps {
+		clock = clock.Add(step.advance)
+		bus.registers[inaBusRegister] = uint16(step.volts / 0.00125)
+		bus.registers[inaShuntRegister] = 8000 //200mA through the default shunt
+		reading, limit, err := monitor.update(clock)
+		if err != nil {
+			t.Fatalf("%s: failed reading: %s", step.name, err)
+		}
+		if reading.State != string(step.state) {
+			t.Errorf("%s: expected state %s, got %s", step.name, step.state, reading.State)
+		}
+		if (limit == nil) != (step.limit == nil) || (limit != nil && *limit != *step.limit) {
+			t.Errorf("%s: expected limit %+v, got %+v", step.name, step.limit, limit)
+		}
+	}
+
+	//200mA for the 11 seconds after the first reading
+	if used := monitor.usedMah; math.Abs(used-200*11/3600.0) > 0.001 {
+		t.Errorf("expected 0.61mAh used, got %.2f", used)
+	}
+	if monitor.startPercent != 100 {
+		t.Errorf("expected a full pack to start at 100%%, got %.1f", monitor.startPercent)
+	}
+}
+
+func TestBatterySensorErrors(t *testing.T) {
+	monitor := newFakeBatteryMonitor(testBatteryConfig(BatteryINA219), nil)
+	reading, limit, err := monitor.update(time.Now())
+	if err == nil || reading.State != string(BatteryError) || limit != nil {
+		t.Fatalf("expected a missing sensor to error without a limit, got %+v %+v %v", reading, limit, err)
+	}
+
+	bus := newFakeI2C(map[byte]uint16{inaBusRegister: 2000 << 3})
+	monitor = newFakeBatteryMonitor(testBatteryConfig(BatteryINA219), bus)
+	bus.err = fmt.Errorf("bus error")
+	_, _, err = monitor.update(time.Now())
+	if err == nil {
+		t.Fatalf("expected the bus error")
+	}
+	bus.err = nil
+	reading, _, err = monitor.update(time.Now())
+	if err != nil || reading.State != string(BatteryOK) {
+		t.Errorf("expected the sensor to come back, got %+v %v", reading, err)
+	}
+}
+
+func TestBatteryConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		change func(cfg *BatteryConfig)
+		valid  bool
+	}{
+		"default":         {change: func(cfg *BatteryConfig) {}, valid: true},
+		"unknown_sensor":  {change: func(cfg *BatteryConfig) { cfg.Sensor = "ina999" }},
+		"no_shunt":        {change: func(cfg *BatteryConfig) { cfg.ShuntOhms = 0 }},
+		"cutoff_over_low": {change: func(cfg *BatteryConfig) { cfg.CutoffCellVolts = 3.6 }},
+		"throttle_range":  {change: func(cfg *BatteryConfig) { cfg.LowThrottle = 120 }},
+		"ads_divider": {change: func(cfg *BatteryConfig) {
+			cfg.Sensor = BatteryADS1115
+			cfg.Divider = 0
+		}},
+	}
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			cfg := testBatteryConfig(BatteryINA219)
+			tc.change(&cfg)
+			if err := cfg.Validate(); (err == nil) != tc.valid {
+				t.Errorf("expected valid %t, got %v", tc.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package sensors
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+const DistanceReading = "distance" //Sensor name on readings, values are keyed by side
+
+const DefaultDistancePoll = 60 * time.Millisecond //Ultrasonic echoes need this long to die out
+const DefaultDistanceMaxRange = 2.0               //Meters
+const DefaultDistanceTelemetry = 200 * time.Millisecond
+const DefaultDistanceDevice = "/dev/gpiochip0"
+
+// Distance older than this many polls is stale and collision braking lets go
+const distanceStalePolls = 3
+
+const (
+	DistanceOK    = "ok"
+	DistanceError = "error"
+)
+
+type DistanceConfig struct {
+	Side              string //carcommand.DistanceFront or carcommand.DistanceRear
+	Sensor            string //Empty turns this side off
+	Address           byte   //0 uses the sensor's default address
+	I2CDevice         string
+	GPIODevice        string
+	TriggerLine       int
+	EchoLine          int
+	MaxRange          float64 //Anything further reads as this, past it the sensors don't see reliably
+	PollInterval      time.Duration
+	TelemetryInterval time.Duration //How often the distance goes to clients, 0 sends none
+}
+
+// DistanceMonitor reads one side's distance sensor, it is a carcommand.DistanceSource
+type DistanceMonitor struct {
+	config   DistanceConfig
+	openI2C  I2COpener
+	openEcho EchoOpener
+	readings chan<- Reading
+
+	sensor        distanceSensor
+	lastTelemetry time.Time
+
+	lock         sync.RWMutex //Distance is read by carcommand's loop
+	distance     float64
+	distanceTime time.Time
+}
+
+func (c DistanceConfig) Enabled() bool {
+	return c.Sensor != ""
+}
+
+func (c DistanceConfig) Validate() error {
+	if c.Side != carcommand.DistanceFront && c.Side != carcommand.DistanceRear {
+		return fmt.Errorf("unsupported distance side (%s)", c.Side)
+	}
+	switch c.Sensor {
+	case DistanceVL53L0X:
+	case DistanceHCSR04:
+		if c.TriggerLine < 0 || c.EchoLine < 0 || c.TriggerLine == c.EchoLine {
+			return fmt.Errorf("%s needs separate trigger and echo lines (%d | %d)", c.Sensor, c.TriggerLine, c.EchoLine)
+		}
+	default:
+		return fmt.Errorf("unsupported distance sensor (%s)", c.Sensor)
+	}
+	if c.MaxRange <= 0 {
+		return fmt.Errorf("max range must be more than 0 (%.2f)", c.MaxRange)
+	}
+	if c.PollInterval < 0 || c.TelemetryInterval < 0 {
+		return fmt.Errorf("distance durations can't be negative")
+	}
+	return nil
+}
+
+func NewDistanceMonitor(cfg DistanceConfig, readings chan<- Reading) *DistanceMonitor {
+	if cfg.Address == 0 {
+		cfg.Address = DefaultVL53L0XAddress
+	}
+	if cfg.GPIODevice == "" {
+		cfg.GPIODevice = DefaultDistanceDevice
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultDistancePoll
+	}
+	return &DistanceMonitor{
+		config:   cfg,
+		openI2C:  openI2C,
+		openEcho: openGPIOEcho,
+		readings: readings,
+	}
+}
+
+// Polls until the context is done, sensor errors are retried on the next poll and never stop the car
+func (m *DistanceMonitor) Start(ctx context.Context) error {
+	err := m.config.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid %s distance config - %w", m.config.Side, err)
+	}
+	defer m.disconnect()
+
+	ticker := time.NewTicker(m.config.PollInterval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := m.poll(time.Now())
+			if err != nil {
+				if !failing { //Polled too often to log every failure
+					log.Printf("warning: %s distance not read - %s\n", m.config.Side, err.Error())
+					publish(m.readings, Reading{Sensor: DistanceReading, State: DistanceError, Time: time.Now()})
+				}
+				failing = true
+				m.disconnect()
+				continue
+			}
+			failing = false
+		}
+	}
+}
+
+func (m *DistanceMonitor) connect() error {
+	switch m.config.Sensor {
+	case DistanceVL53L0X:
+		bus, err := m.openI2C(m.config.Address, m.config.I2CDevice)
+		if err != nil {
+			return fmt.Errorf("failed opening %s at 0x%02x - %w", m.config.Sensor, m.config.Address, err)
+		}
+		m.sensor = &vl53l0x{bus: bus}
+	case DistanceHCSR04:
+		lines, err := m.openEcho(m.config.GPIODevice, m.config.TriggerLine, m.config.EchoLine)
+		if err != nil {
+			return fmt.Errorf("failed opening %s on %s - %w", m.config.Sensor, m.config.GPIODevice, err)
+		}
+		m.sensor = &hcsr04{lines: lines, timeout: m.config.PollInterval}
+	}
+	err := m.sensor.init()
+	if err != nil {
+		m.disconnect()
+		return err
+	}
+	return nil
+}
+
+func (m *DistanceMonitor) disconnect() {
+	if m.sensor != nil {
+		m.sensor.Close()
+	}
+	m.sensor = nil
+}
+
+func (m *DistanceMonitor) poll(now time.Time) error {
+	if m.sensor == nil {
+		err := m.connect()
+		if err != nil {
+			return err
+		}
+	}
+	distance, err := m.sensor.read()
+	if err != nil {
+		return err
+	}
+	distance = math.Min(distance, m.config.MaxRange)
+
+	m.lock.Lock()
+	m.distance = distance
+	m.distanceTime = now
+	m.lock.Unlock()
+
+	if m.config.TelemetryInterval > 0 && now.Sub(m.lastTelemetry) >= m.config.TelemetryInterval {
+		m.lastTelemetry = now
+		publish(m.readings, Reading{
+			Sensor: DistanceReading,
+			State:  DistanceOK,
+			Values: map[string]float64{m.config.Side: distance},
+			Time:   now,
+		})
+	}
+	return nil
+}
+
+// Distance to the nearest obstacle in meters, ok is false once readings stop coming
+func (m *DistanceMonitor) Distance() (float64, bool) {
+	return m.distanceAt(time.Now())
+}
+
+func (m *DistanceMonitor) distanceAt(now time.Time) (float64, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.distanceTime.IsZero() || now.Sub(m.distanceTime) > distanceStalePolls*m.config.PollInterval {
+		return 0, false
+	}
+	return m.distance, true
+}
@@ -0,0 +1,120 @@
+package sensors
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const DistanceHCSR04 = "hcsr04"   //Ultrasonic, trigger and echo on gpio lines
+const DistanceVL53L0X = "vl53l0x" //Time of flight over i2c
+
+const DefaultVL53L0XAddress = 0x29
+
+const speedOfSound = 343.0 //Meters per second
+
+const vl53l0xIdentityRegister = 0xC0
+const vl53l0xIdentity = 0xEE
+const vl53l0xI2CModeRegister = 0x88
+const vl53l0xRangeStartRegister = 0x00
+const vl53l0xInterruptStatusRegister = 0x13
+const vl53l0xInterruptClearRegister = 0x0B
+const vl53l0xRangeRegister = 0x1E //Millimeters
+const vl53l0xOutOfRange = 8190
+const vl53l0xRangeTimeout = 50 * time.Millisecond
+
+type distanceSensor interface {
+	init() error
+	read() (float64, error) //Meters
+	Close() error
+}
+
+// echoLines triggers an ultrasonic sensor and times its echo, tests swap in a fake
+type echoLines interface {
+	sendTrigger() error
+	readEcho(timeout time.Duration) (time.Duration, error)
+	Close() error
+}
+
+type EchoOpener func(device string, triggerLine int, echoLine int) (echoLines, error)
+
+type hcsr04 struct {
+	lines   echoLines
+	timeout time.Duration
+}
+
+type vl53l0x struct {
+	bus I2CBus
+}
+
+func (s *hcsr04) init() error {
+	return nil
+}
+
+// Sound goes out and back, so the distance is half of what it covered
+func (s *hcsr04) read() (float64, error) {
+	err := s.lines.sendTrigger()
+	if err != nil {
+		return 0, fmt.Errorf("failed triggering hcsr04 - %w", err)
+	}
+	echo, err := s.lines.readEcho(s.timeout)
+	if err != nil {
+		return 0, fmt.Errorf("failed reading hcsr04 echo - %w", err)
+	}
+	return echo.Seconds() * speedOfSound / 2, nil
+}
+
+func (s *hcsr04) Close() error {
+	return s.lines.Close()
+}
+
+func (s *vl53l0x) init() error {
+	identity, err := s.bus.ReadRegU8(vl53l0xIdentityRegister)
+	if err != nil {
+		return fmt.Errorf("failed reading vl53l0x identity - %w", err)
+	}
+	if identity != vl53l0xIdentity {
+		return fmt.Errorf("unexpected vl53l0x identity 0x%02x", identity)
+	}
+	return s.bus.WriteRegU8(vl53l0xI2CModeRegister, 0x00) //Standard i2c mode
+}
+
+// Takes a single measurement, past its range the sensor reads as clear
+func (s *vl53l0x) read() (float64, error) {
+	err := s.bus.WriteRegU8(vl53l0xRangeStartRegister, 0x01)
+	if err != nil {
+		return 0, fmt.Errorf("failed starting vl53l0x range - %w", err)
+	}
+
+	deadline := time.Now().Add(vl53l0xRangeTimeout)
+	for {
+		status, err := s.bus.ReadRegU8(vl53l0xInterruptStatusRegister)
+		if err != nil {
+			return 0, fmt.Errorf("failed reading vl53l0x status - %w", err)
+		}
+		if status&0x07 != 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("vl53l0x range timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	millimeters, err := s.bus.ReadRegU16BE(vl53l0xRangeRegister)
+	if err != nil {
+		return 0, fmt.Errorf("failed reading vl53l0x range - %w", err)
+	}
+	err = s.bus.WriteRegU8(vl53l0xInterruptClearRegister, 0x01)
+	if err != nil {
+		return 0, fmt.Errorf("failed clearing vl53l0x interrupt - %w", err)
+	}
+	if millimeters >= vl53l0xOutOfRange {
+		return math.Inf(1), nil
+	}
+	return float64(millimeters) / 1000, nil
+}
+
+func (s *vl53l0x) Close() error {
+	return s.bus.Close()
+}
@@ -0,0 +1,125 @@
+package sensors
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+type fakeEcho struct {
+	echo     time.Duration
+	err      error
+	triggers int
+}
+
+func (f *fakeEcho) sendTrigger() error {
+	f.triggers++
+	return nil
+}
+
+func (f *fakeEcho) readEcho(timeout time.Duration) (time.Duration, error) {
+	return f.echo, f.err
+}
+
+func (f *fakeEcho) Close() error {
+	return nil
+}
+
+func TestDistanceMonitor(t *testing.T) {
+	tests := map[string]struct {
+		sensor      string
+		millimeters uint16 //vl53l0x millimeters
+		identity    byte
+		echo        time.Duration
+		echoErr     error
+		distance    float64
+		err         bool
+	}{
+		"vl53l0x":            {sensor: DistanceVL53L0X, identity: vl53l0xIdentity, millimeters: 420, distance: 0.42},
+		"vl53l0x_clear":      {sensor: DistanceVL53L0X, identity: vl53l0xIdentity, millimeters: vl53l0xOutOfRange, distance: 1.5},
+		"vl53l0x_wrong_chip": {sensor: DistanceVL53L0X, identity: 0x12, err: true},
+		"hcsr04":             {sensor: DistanceHCSR04, echo: 2915452 * time.Nanosecond, distance: 0.5},
+		"hcsr04_far":         {sensor: DistanceHCSR04, echo: 38 * time.Millisecond, distance: 1.5}, //No echo came back
+		"hcsr04_timeout":     {sensor: DistanceHCSR04, echoErr: fmt.Errorf("i/o timeout"), err: true},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			bus := newFakeI2C(map[byte]uint16{vl53l0xRangeRegister: test.millimeters})
+			bus.bytes[vl53l0xIdentityRegister] = test.identity
+			bus.bytes[vl53l0xInterruptStatusRegister] = 0x04
+			echo := &fakeEcho{echo: test.echo, err: test.echoErr}
+
+			readings := make(chan Reading, 10)
+			monitor := NewDistanceMonitor(DistanceConfig{
+				Side:              carcommand.DistanceFront,
+				Sensor:            test.sensor,
+				TriggerLine:       5,
+				EchoLine:          6,
+				MaxRange:          1.5,
+				TelemetryInterval: DefaultDistanceTelemetry,
+			}, readings)
+			monitor.openI2C = func(address byte, device string) (I2CBus, error) {
+				return bus, nil
+			}
+			monitor.openEcho = func(device string, triggerLine int, echoLine int) (echoLines, error) {
+				return echo, nil
+			}
+
+			now := time.Now()
+			err := monitor.poll(now)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				if _, ok := monitor.distanceAt(now); ok {
+					t.Errorf("expected no distance after an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed polling: %s", err)
+			}
+
+			distance, ok := monitor.distanceAt(now)
+			if !ok || math.Abs(distance-test.distance) > 0.001 {
+				t.Errorf("expected %.3fm, got %.3fm (ok %t)", test.distance, distance, ok)
+			}
+			reading := <-readings
+			if reading.State != DistanceOK || math.Abs(reading.Values[carcommand.DistanceFront]-test.distance) > 0.001 {
+				t.Errorf("expected an ok front reading of %.3fm, got %+v", test.distance, reading)
+			}
+			if _, ok := monitor.distanceAt(now.Add(distanceStalePolls*DefaultDistancePoll + time.Millisecond)); ok {
+				t.Errorf("expected a stale distance to read as none")
+			}
+		})
+	}
+}
+
+func TestDistanceConfigValidate(t *testing.T) {
+	valid := DistanceConfig{Side: carcommand.DistanceRear, Sensor: DistanceHCSR04, TriggerLine: 5, EchoLine: 6, MaxRange: 2}
+	tests := map[string]struct {
+		change func(cfg *DistanceConfig)
+		err    bool
+	}{
+		"valid":        {change: func(cfg *DistanceConfig) {}},
+		"bad_side":     {change: func(cfg *DistanceConfig) { cfg.Side = "left" }, err: true},
+		"bad_sensor":   {change: func(cfg *DistanceConfig) { cfg.Sensor = "lidar" }, err: true},
+		"shared_lines": {change: func(cfg *DistanceConfig) { cfg.EchoLine = 5 }, err: true},
+		"no_range":     {change: func(cfg *DistanceConfig) { cfg.MaxRange = 0 }, err: true},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			cfg := valid
+			test.change(&cfg)
+			err := cfg.Validate()
+			if (err != nil) != test.err {
+				t.Errorf("expected error %t, got %v", test.err, err)
+			}
+		})
+	}
+}
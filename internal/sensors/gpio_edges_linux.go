@@ -0,0 +1,197 @@
+//go:build linux
+
+package sensors
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Line event and handle ioctls from linux/gpio.h (v1 ABI)
+const gpioGetLineEventIoctl = 0xc030b404
+const gpioGetLineHandleIoctl = 0xc16cb403
+const gpioHandleSetLineValuesIoctl = 0xc040b409
+const gpioHandlesMax = 64
+const gpioHandleRequestInput = 1 << 0
+const gpioHandleRequestOutput = 1 << 1
+const gpioEventRequestRisingEdge = 1 << 0
+const gpioEventRequestBothEdges = 3
+const gpioEventRisingEdge = 1 //Event id, falling edges are 2
+const gpioEventDataSize = 16  //u64 timestamp, u32 id and padding
+
+const triggerPulse = 10 * time.Microsecond
+
+type gpioEventRequest struct {
+	lineOffset    uint32
+	handleFlags   uint32
+	eventFlags    uint32
+	consumerLabel [32]byte
+	fd            int32
+}
+
+type gpioHandleRequest struct {
+	lineOffsets   [gpioHandlesMax]uint32
+	flags         uint32
+	defaultValues [gpioHandlesMax]uint8
+	consumerLabel [32]byte
+	lines         uint32
+	fd            int32
+}
+
+type gpioHandleData struct {
+	values [gpioHandlesMax]uint8
+}
+
+type gpioLineEvents struct {
+	file *os.File
+}
+
+// Trigger output and echo input of an ultrasonic sensor
+type gpioEcho struct {
+	trigger *os.File
+	echo    *os.File
+}
+
+// Requests rising edge events on the line, each read blocks until the next edge
+func openGPIOEdges(device string, line int) (edgeReader, error) {
+	file, err := requestGPIOEvents(device, line, gpioEventRequestRisingEdge)
+	if err != nil {
+		return nil, err
+	}
+	return &gpioLineEvents{file: file}, nil
+}
+
+// Requests the trigger line as an output and both edges of the echo line
+func openGPIOEcho(device string, triggerLine int, echoLine int) (echoLines, error) {
+	trigger, err := requestGPIOOutput(device, triggerLine)
+	if err != nil {
+		return nil, err
+	}
+	echo, err := requestGPIOEvents(device, echoLine, gpioEventRequestBothEdges)
+	if err != nil {
+		trigger.Close()
+		return nil, err
+	}
+	return &gpioEcho{trigger: trigger, echo: echo}, nil
+}
+
+func requestGPIOEvents(device string, line int, eventFlags uint32) (*os.File, error) {
+	chip, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer chip.Close()
+
+	request := gpioEventRequest{
+		lineOffset:  uint32(line),
+		handleFlags: gpioHandleRequestInput,
+		eventFlags:  eventFlags,
+	}
+	copy(request.consumerLabel[:], "goremotecontrol")
+
+	err = gpioIoctl(chip.Fd(), gpioGetLineEventIoctl, unsafe.Pointer(&request))
+	if err != nil {
+		return nil, fmt.Errorf("failed requesting line %d events - %w", line, err)
+	}
+	err = syscall.SetNonblock(int(request.fd), true) //Lets reads time out with a deadline
+	if err != nil {
+		syscall.Close(int(request.fd))
+		return nil, err
+	}
+	return os.NewFile(uintptr(request.fd), device), nil
+}
+
+func requestGPIOOutput(device string, line int) (*os.File, error) {
+	chip, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer chip.Close()
+
+	request := gpioHandleRequest{
+		flags: gpioHandleRequestOutput,
+		lines: 1,
+	}
+	request.lineOffsets[0] = uint32(line)
+	copy(request.consumerLabel[:], "goremotecontrol")
+
+	err = gpioIoctl(chip.Fd(), gpioGetLineHandleIoctl, unsafe.Pointer(&request))
+	if err != nil {
+		return nil, fmt.Errorf("failed requesting line %d - %w", line, err)
+	}
+	return os.NewFile(uintptr(request.fd), device), nil
+}
+
+// Returns the kernel's timestamp of the edge, only the time between edges is used so the clock doesn't matter
+func (e *gpioLineEvents) readEdge() (time.Duration, error) {
+	timestamp, _, err := readGPIOEvent(e.file)
+	return timestamp, err
+}
+
+func (e *gpioLineEvents) Close() error {
+	return e.file.Close()
+}
+
+// Sends the pulse that starts a measurement
+func (e *gpioEcho) sendTrigger() error {
+	data := gpioHandleData{}
+	data.values[0] = 1
+	err := gpioIoctl(e.trigger.Fd(), gpioHandleSetLineValuesIoctl, unsafe.Pointer(&data))
+	if err != nil {
+		return err
+	}
+	time.Sleep(triggerPulse)
+	data.values[0] = 0
+	return gpioIoctl(e.trigger.Fd(), gpioHandleSetLineValuesIoctl, unsafe.Pointer(&data))
+}
+
+// Returns how long the echo line was high, edges before the rising one are left over from an earlier measurement
+func (e *gpioEcho) readEcho(timeout time.Duration) (time.Duration, error) {
+	err := e.echo.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return 0, err
+	}
+	var rose time.Duration
+	haveRise := false
+	for {
+		timestamp, rising, err := readGPIOEvent(e.echo)
+		if err != nil {
+			return 0, err
+		}
+		if rising {
+			rose = timestamp
+			haveRise = true
+			continue
+		}
+		if haveRise {
+			return timestamp - rose, nil
+		}
+	}
+}
+
+func (e *gpioEcho) Close() error {
+	e.trigger.Close()
+	return e.echo.Close()
+}
+
+func readGPIOEvent(file *os.File) (time.Duration, bool, error) {
+	data := make([]byte, gpioEventDataSize)
+	_, err := io.ReadFull(file, data)
+	if err != nil {
+		return 0, false, err
+	}
+	return time.Duration(binary.LittleEndian.Uint64(data[:8])), binary.LittleEndian.Uint32(data[8:12]) == gpioEventRisingEdge, nil
+}
+
+func gpioIoctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package sensors
+
+import (
+	"fmt"
+)
+
+func openGPIOEdges(device string, line int) (edgeReader, error) {
+	return nil, fmt.Errorf("gpio character devices are only supported on linux")
+}
+
+func openGPIOEcho(device string, triggerLine int, echoLine int) (echoLines, error) {
+	return nil, fmt.Errorf("gpio character devices are only supported on linux")
+}
@@ -0,0 +1,363 @@
+package sensors
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+const IMUSource = "imu" //Sensor name on readings and source of its output limits
+
+// Sent on the car's event channel so they reach the driver with the car's own events
+const EventCrash = "crash"       //Message is how hard the impact was
+const EventRollover = "rollover" //Message is how far over the car is
+const EventRolloverRecovered = "rollover_recovered"
+
+const DefaultIMUPoll = 10 * time.Millisecond
+const DefaultRolloverAngle = 60.0
+const DefaultRolloverHold = 500 * time.Millisecond //Jumps and ramps tilt the car for less than this
+const DefaultCrashG = 4.0
+const DefaultCrashCut = time.Second
+const DefaultIMUTelemetry = 100 * time.Millisecond
+
+// How much of each accelerometer sample goes into the gravity estimate, bumps and the car's own acceleration average out
+const gravitySmoothing = 0.05
+
+// Samples averaged for the gyro's zero point at startup, the car needs to sit still for them
+const gyroBiasSamples = 100
+
+// Yaw rate older than this many polls is stale and the steering gyro lets go
+const yawRateStalePolls = 5
+
+const (
+	IMUOK       = "ok"
+	IMURollover = "rollover"
+	IMUCrash    = "crash" //Throttle still cut after an impact
+	IMUError    = "error"
+)
+
+type IMUConfig struct {
+	Sensor            string //Empty turns the imu off
+	Address           byte   //0 uses the sensor's default address
+	I2CDevice         string
+	PollInterval      time.Duration
+	RolloverAngle     float64 //Degrees from level that counts as rolled over
+	RolloverHold      time.Duration
+	CrashG            float64       //Acceleration that counts as an impact
+	CrashCut          time.Duration //Throttle held at neutral after an impact
+	CrashSound        string        //Sound groups to play, empty plays nothing
+	RolloverSound     string
+	TelemetryInterval time.Duration //How often raw data goes to clients, 0 sends none
+}
+
+type IMUMonitor struct {
+	config   IMUConfig
+	open     I2COpener
+	bus      I2CBus
+	sensor   imuSensor
+	limits   chan<- carcommand.OutputLimit
+	events   chan<- carcommand.Event
+	sounds   chan<- string
+	readings chan<- Reading
+
+	gravity       [3]float64 //Smoothed accelerometer, which way is down
+	haveGravity   bool
+	tiltedSince   time.Time //Zero while the car is upright
+	rolledOver    bool
+	crashUntil    time.Time
+	throttleCut   bool //Last limit sent held neutral
+	lastTelemetry time.Time
+	gyroBias      float64
+	biasSamples   int
+
+	lock        sync.RWMutex //Yaw rate is read by carcommand's loop
+	yawRate     float64
+	yawRateTime time.Time
+}
+
+func (c IMUConfig) Enabled() bool {
+	return c.Sensor != ""
+}
+
+func (c IMUConfig) Validate() error {
+	switch c.Sensor {
+	case IMUMPU6050, IMUICM20948:
+	default:
+		return fmt.Errorf("unsupported imu (%s)", c.Sensor)
+	}
+	if c.RolloverAngle <= 0 || c.RolloverAngle >= 180 {
+		return fmt.Errorf("rollover angle must be between 0 and 180 degrees (%.0f)", c.RolloverAngle)
+	}
+	if c.CrashG <= 1 {
+		return fmt.Errorf("crash threshold must be over 1g or gravity alone trips it (%.1f)", c.CrashG)
+	}
+	if c.RolloverHold < 0 || c.CrashCut < 0 || c.TelemetryInterval < 0 {
+		return fmt.Errorf("imu durations can't be negative")
+	}
+	return nil
+}
+
+// Limits go to the car's LimitChannel, events to its EventChannel and sounds to the speaker
+func NewIMUMonitor(cfg IMUConfig, limits chan<- carcommand.OutputLimit, events chan<- carcommand.Event, sounds chan<- string, readings chan<- Reading) *IMUMonitor {
+	if cfg.Address == 0 {
+		cfg.Address = DefaultMPU6050Address
+		if cfg.Sensor == IMUICM20948 {
+			cfg.Address = DefaultICM20948Address
+		}
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultIMUPoll
+	}
+	return &IMUMonitor{
+		config:   cfg,
+		open:     openI2C,
+		limits:   limits,
+		events:   events,
+		sounds:   sounds,
+		readings: readings,
+	}
+}
+
+// Polls until the context is done, sensor errors are retried on the next poll and never stop the car
+func (m *IMUMonitor) Start(ctx context.Context) error {
+	err := m.config.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid imu config - %w", err)
+	}
+	defer m.disconnect()
+
+	ticker := time.NewTicker(m.config.PollInterval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := m.poll(ctx, time.Now())
+			if err != nil {
+				if !failing { //Polled too often to log every failure
+					log.Printf("warning: imu not read - %s\n", err.Error())
+					publish(m.readings, Reading{Sensor: IMUSource, State: IMUError, Time: time.Now()})
+				}
+				failing = true
+				m.disconnect()
+				continue
+			}
+			failing = false
+		}
+	}
+}
+
+func (m *IMUMonitor) connect() error {
+	bus, err := m.open(m.config.Address, m.config.I2CDevice)
+	if err != nil {
+		return fmt.Errorf("failed opening %s at 0x%02x - %w", m.config.Sensor, m.config.Address, err)
+	}
+	sensor, err := newIMUSensor(m.config, bus)
+	if err == nil {
+		err = sensor.init()
+	}
+	if err != nil {
+		bus.Close()
+		return err
+	}
+	m.bus = bus
+	m.sensor = sensor
+	return nil
+}
+
+func (m *IMUMonitor) disconnect() {
+	if m.bus != nil {
+		m.bus.Close()
+	}
+	m.bus = nil
+	m.sensor = nil
+}
+
+func (m *IMUMonitor) poll(ctx context.Context, now time.Time) error {
+	if m.sensor == nil {
+		err := m.connect()
+		if err != nil {
+			return err
+		}
+	}
+	sample, err := m.sensor.read()
+	if err != nil {
+		return err
+	}
+
+	m.updateYawRate(sample.Gyro[2], now)
+	m.updateGravity(sample.Accel)
+	m.detectRollover(now)
+	m.detectCrash(sample, now)
+	err = m.updateLimit(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	if m.config.TelemetryInterval > 0 && now.Sub(m.lastTelemetry) >= m.config.TelemetryInterval {
+		m.lastTelemetry = now
+		publish(m.readings, m.telemetry(sample, now))
+	}
+	return nil
+}
+
+// Learns the gyro's zero point first, there is no yaw rate until it has
+func (m *IMUMonitor) updateYawRate(gyroZ float64, now time.Time) {
+	if m.biasSamples < gyroBiasSamples {
+		m.biasSamples++
+		m.gyroBias += (gyroZ - m.gyroBias) / float64(m.biasSamples)
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.yawRate = gyroZ - m.gyroBias
+	m.yawRateTime = now
+}
+
+// YawRate is the latest rotation around the z axis in degrees per second, positive turning left
+func (m *IMUMonitor) YawRate() (float64, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.yawRateTime.IsZero() || time.Since(m.yawRateTime) > yawRateStalePolls*m.config.PollInterval {
+		return 0, false
+	}
+	return m.yawRate, true
+}
+
+func (m *IMUMonitor) updateGravity(accel [3]float64) {
+	if !m.haveGravity {
+		m.gravity = accel
+		m.haveGravity = true
+		return
+	}
+	for i := range m.gravity {
+		m.gravity[i] += (accel[i] - m.gravity[i]) * gravitySmoothing
+	}
+}
+
+func (m *IMUMonitor) detectRollover(now time.Time) {
+	tilt := tiltAngle(m.gravity)
+	if tilt < m.config.RolloverAngle {
+		m.tiltedSince = time.Time{}
+		if m.rolledOver {
+			m.rolledOver = false
+			m.sendEvent(EventRolloverRecovered, fmt.Sprintf("back upright at %.0f degrees", tilt))
+		}
+		return
+	}
+	if m.tiltedSince.IsZero() {
+		m.tiltedSince = now
+	}
+	if m.rolledOver || now.Sub(m.tiltedSince) < m.config.RolloverHold {
+		return
+	}
+	m.rolledOver = true
+	m.sendEvent(EventRollover, fmt.Sprintf("tilted %.0f degrees", tilt))
+	m.playSound(m.config.RolloverSound)
+}
+
+// Every impact holds the throttle cut a little longer, only the first of a run is reported
+func (m *IMUMonitor) detectCrash(sample IMUSample, now time.Time) {
+	g := magnitude(sample.Accel)
+	if g < m.config.CrashG {
+		return
+	}
+	if !now.Before(m.crashUntil) {
+		m.sendEvent(EventCrash, fmt.Sprintf("%.1fg impact", g))
+		m.playSound(m.config.CrashSound)
+	}
+	m.crashUntil = now.Add(m.config.CrashCut)
+}
+
+func (m *IMUMonitor) updateLimit(ctx context.Context, now time.Time) error {
+	cut := m.rolledOver || now.Before(m.crashUntil)
+	if cut == m.throttleCut {
+		return nil
+	}
+	limit := carcommand.OutputLimit{Source: IMUSource, MaxThrottle: carcommand.MaxThrottleLimit}
+	if cut {
+		limit.Neutral = true
+		limit.Reason = IMUCrash
+		if m.rolledOver {
+			limit.Reason = IMURollover
+		}
+	}
+	select {
+	case m.limits <- limit:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	m.throttleCut = cut
+	return nil
+}
+
+func (m *IMUMonitor) state(now time.Time) string {
+	switch {
+	case m.rolledOver:
+		return IMURollover
+	case now.Before(m.crashUntil):
+		return IMUCrash
+	default:
+		return IMUOK
+	}
+}
+
+func (m *IMUMonitor) telemetry(sample IMUSample, now time.Time) Reading {
+	return Reading{
+		Sensor: IMUSource,
+		State:  m.state(now),
+		Time:   now,
+		Values: map[string]float64{
+			"accelX": sample.Accel[0],
+			"accelY": sample.Accel[1],
+			"accelZ": sample.Accel[2],
+			"gyroX":  sample.Gyro[0],
+			"gyroY":  sample.Gyro[1],
+			"gyroZ":  sample.Gyro[2],
+			"roll":   math.Atan2(m.gravity[1], m.gravity[2]) * 180 / math.Pi,
+			"pitch":  math.Atan2(-m.gravity[0], math.Hypot(m.gravity[1], m.gravity[2])) * 180 / math.Pi,
+			"tilt":   tiltAngle(m.gravity),
+		},
+	}
+}
+
+// Never blocks the sensor loop, same as the car's own events
+func (m *IMUMonitor) sendEvent(eventType string, message string) {
+	log.Printf("imu %s: %s\n", eventType, message)
+	select {
+	case m.events <- carcommand.Event{Type: eventType, Message: message, Time: time.Now()}:
+	default:
+		log.Printf("warning: event channel full, dropped %s event\n", eventType)
+	}
+}
+
+func (m *IMUMonitor) playSound(sound string) {
+	if sound == "" {
+		return
+	}
+	select {
+	case m.sounds <- sound:
+	default:
+		log.Printf("warning: sound channel full, %s not played\n", sound)
+	}
+}
+
+// Degrees between down and the car's z axis, 0 sitting level and 180 on its roof
+func tiltAngle(gravity [3]float64) float64 {
+	g := magnitude(gravity)
+	if g == 0 {
+		return 0
+	}
+	return math.Acos(math.Max(-1, math.Min(1, gravity[2]/g))) * 180 / math.Pi
+}
+
+func magnitude(vector [3]float64) float64 {
+	return math.Sqrt(vector[0]*vector[0] + vector[1]*vector[1] + vector[2]*vector[2])
+}
@@ -0,0 +1,153 @@
+package sensors
+
+import (
+	"fmt"
+	"log"
+)
+
+const IMUMPU6050 = "mpu6050"
+const IMUICM20948 = "icm20948"
+
+// Default addresses, most icm20948 breakouts pull AD0 high
+const DefaultMPU6050Address = 0x68
+const DefaultICM20948Address = 0x69
+
+// Both chips are set to +/-8g and +/-500 degrees per second
+const imuGPerBit = 1.0 / 4096
+const imuDPSPerBit = 1.0 / 65.5
+
+const mpu6050PowerRegister = 0x6B
+const mpu6050GyroConfigRegister = 0x1B
+const mpu6050AccelConfigRegister = 0x1C
+const mpu6050AccelRegister = 0x3B
+const mpu6050GyroRegister = 0x43
+const mpu6050WhoAmIRegister = 0x75
+const mpu6050WhoAmI = 0x68
+
+const icm20948BankRegister = 0x7F //Bank number goes in bits 4-5
+const icm20948WhoAmIRegister = 0x00
+const icm20948PowerRegister = 0x06
+const icm20948AccelRegister = 0x2D
+const icm20948GyroRegister = 0x33
+const icm20948GyroConfigRegister = 0x01 //Bank 2
+const icm20948AccelConfigRegister = 0x14
+const icm20948WhoAmI = 0xEA
+
+// IMUSample is one reading of every axis, x points forward and z up when the car sits level
+type IMUSample struct {
+	Accel [3]float64 //g
+	Gyro  [3]float64 //Degrees per second
+}
+
+type imuSensor interface {
+	init() error
+	read() (IMUSample, error)
+}
+
+type mpu6050 struct {
+	bus I2CBus
+}
+
+type icm20948 struct {
+	bus I2CBus
+}
+
+func newIMUSensor(cfg IMUConfig, bus I2CBus) (imuSensor, error) {
+	switch cfg.Sensor {
+	case IMUMPU6050:
+		return &mpu6050{bus: bus}, nil
+	case IMUICM20948:
+		return &icm20948{bus: bus}, nil
+	default:
+		return nil, fmt.Errorf("unsupported imu (%s)", cfg.Sensor)
+	}
+}
+
+// Reads three big endian axes starting at reg
+func readAxes(bus I2CBus, reg byte, scale float64) ([3]float64, error) {
+	axes := [3]float64{}
+	for i := range axes {
+		raw, err := bus.ReadRegU16BE(reg + byte(2*i))
+		if err != nil {
+			return axes, err
+		}
+		axes[i] = float64(int16(raw)) * scale
+	}
+	return axes, nil
+}
+
+func readIMU(bus I2CBus, accelReg byte, gyroReg byte) (IMUSample, error) {
+	accel, err := readAxes(bus, accelReg, imuGPerBit)
+	if err != nil {
+		return IMUSample{}, fmt.Errorf("failed reading accelerometer - %w", err)
+	}
+	gyro, err := readAxes(bus, gyroReg, imuDPSPerBit)
+	if err != nil {
+		return IMUSample{}, fmt.Errorf("failed reading gyro - %w", err)
+	}
+	return IMUSample{Accel: accel, Gyro: gyro}, nil
+}
+
+func (s *mpu6050) init() error {
+	whoAmI, err := s.bus.ReadRegU8(mpu6050WhoAmIRegister)
+	if err != nil {
+		return fmt.Errorf("failed reading mpu6050 id - %w", err)
+	}
+	if whoAmI != mpu6050WhoAmI {
+		log.Printf("warning: mpu6050 id is 0x%02x not 0x%02x, may be a clone\n", whoAmI, mpu6050WhoAmI)
+	}
+	writes := []struct {
+		reg   byte
+		value byte
+	}{
+		{reg: mpu6050PowerRegister, value: 0x00}, //Wake up
+		{reg: mpu6050GyroConfigRegister, value: 0x08},
+		{reg: mpu6050AccelConfigRegister, value: 0x10},
+	}
+	for _, write := range writes {
+		err = s.bus.WriteRegU8(write.reg, write.value)
+		if err != nil {
+			return fmt.Errorf("failed configuring mpu6050 - %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *mpu6050) read() (IMUSample, error) {
+	return readIMU(s.bus, mpu6050AccelRegister, mpu6050GyroRegister)
+}
+
+func (s *icm20948) init() error {
+	writes := []struct {
+		reg   byte
+		value byte
+	}{
+		{reg: icm20948BankRegister, value: 0x00},
+		{reg: icm20948PowerRegister, value: 0x01}, //Wake up on the best clock
+		{reg: icm20948BankRegister, value: 0x20},
+		{reg: icm20948GyroConfigRegister, value: 0x02},
+		{reg: icm20948AccelConfigRegister, value: 0x04},
+		{reg: icm20948BankRegister, value: 0x00}, //Data registers are in bank 0
+	}
+	for i, write := range writes {
+		err := s.bus.WriteRegU8(write.reg, write.value)
+		if err != nil {
+			return fmt.Errorf("failed configuring icm20948 - %w", err)
+		}
+		if i != 0 {
+			continue
+		}
+		whoAmI, err := s.bus.ReadRegU8(icm20948WhoAmIRegister)
+		if err != nil {
+			return fmt.Errorf("failed reading icm20948 id - %w", err)
+		}
+		if whoAmI != icm20948WhoAmI {
+			return fmt.Errorf("icm20948 id is 0x%02x not 0x%02x", whoAmI, icm20948WhoAmI)
+		}
+	}
+	return nil
+}
+
+func (s *icm20948) read() (IMUSample, error) {
+	return readIMU(s.bus, icm20948AccelRegister, icm20948GyroRegister)
+}
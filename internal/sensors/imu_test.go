@@ -0,0 +1,232 @@
+package sensors
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+func testIMUConfig(sensor string) IMUConfig {
+	return IMUConfig{
+		Sensor:            sensor,
+		RolloverAngle:     DefaultRolloverAngle,
+		RolloverHold:      DefaultRolloverHold,
+		CrashG:            DefaultCrashG,
+		CrashCut:          DefaultCrashCut,
+		CrashSound:        "crash",
+		RolloverSound:     "rollover",
+		TelemetryInterval: DefaultIMUTelemetry,
+	}
+}
+
+type imuHarness struct {
+	monitor  *IMUMonitor
+	bus      *fakeI2C
+	limits   chan carcommand.OutputLimit
+	events   chan carcommand.Event
+	sounds   chan string
+	readings chan Reading
+}
+
+func newIMUHarness(cfg IMUConfig) *imuHarness {
+	h := &imuHarness{
+		bus:      newFakeI2C(make(map[byte]uint16)),
+		limits:   make(chan carcommand.OutputLimit, 10),
+		events:   make(chan carcommand.Event, 10),
+		sounds:   make(chan string, 10),
+		readings: make(chan Reading, 100),
+	}
+	h.bus.bytes[mpu6050WhoAmIRegister] = mpu6050WhoAmI
+	h.bus.bytes[icm20948WhoAmIRegister] = icm20948WhoAmI
+	h.monitor = NewIMUMonitor(cfg, h.limits, h.events, h.sounds, h.readings)
+	h.monitor.open = func(address byte, device string) (I2CBus, error) {
+		return h.bus, nil
+	}
+	return h
+}
+
+// Sets the accelerometer registers for the given g on each axis
+func (h *imuHarness) setAccel(reg byte, accel [3]float64) {
+	for i, g := range accel {
+		h.bus.registers[reg+byte(2*i)] = uint16(int16(math.Round(g / imuGPerBit)))
+	}
+}
+
+func (h *imuHarness) poll(t *testing.T, now time.Time) {
+	t.Helper()
+	err := h.monitor.poll(context.Background(), now)
+	if err != nil {
+		t.Fatalf("unexpected poll error: %s", err.Error())
+	}
+}
+
+func (h *imuHarness) drainEvents() []string {
+	events := []string{}
+	for {
+		select {
+		case event := <-h.events:
+			events = append(events, event.Type)
+		default:
+			return events
+		}
+	}
+}
+
+func TestIMUSensors(t *testing.T) {
+	tests := map[string]struct {
+		sensor   string
+		accelReg byte
+		gyroReg  byte
+		address  byte
+	}{
+		"mpu6050":  {sensor: IMUMPU6050, accelReg: mpu6050AccelRegister, gyroReg: mpu6050GyroRegister, address: DefaultMPU6050Address},
+		"icm20948": {sensor: IMUICM20948, accelReg: icm20948AccelRegister, gyroReg: icm20948GyroRegister, address: DefaultICM20948Address},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newIMUHarness(testIMUConfig(test.sensor))
+			if h.monitor.config.Address != test.address {
+				t.Errorf("expected default address 0x%02x, got 0x%02x", test.address, h.monitor.config.Address)
+			}
+			h.setAccel(test.accelReg, [3]float64{0.5, -0.25, 1})
+			h.bus.registers[test.gyroReg+4] = uint16(int16(131)) //2 degrees per second of yaw
+			h.poll(t, time.Now())
+
+			reading := <-h.readings
+			if reading.State != IMUOK {
+				t.Errorf("expected state %s, got %s", IMUOK, reading.State)
+			}
+			expected := map[string]float64{"accelX": 0.5, "accelY": -0.25, "accelZ": 1, "gyroZ": 2}
+			for key, value := range expected {
+				if math.Abs(reading.Values[key]-value) > 0.01 {
+					t.Errorf("expected %s %.2f, got %.2f", key, value, reading.Values[key])
+				}
+			}
+		})
+	}
+}
+
+func TestIMURollover(t *testing.T) {
+	h := newIMUHarness(testIMUConfig(IMUMPU6050))
+	start := time.Now()
+	h.setAccel(mpu6050AccelRegister, [3]float64{0, 0, 1})
+	h.poll(t, start)
+
+	//Upside down, the smoothed gravity needs a few polls to swing past the rollover angle
+	h.setAccel(mpu6050AccelRegister, [3]float64{0, 0, -1})
+	now := start
+	for i := 0; i < 50; i++ {
+		now = now.Add(DefaultIMUPoll)
+		h.poll(t, now)
+	}
+	if h.monitor.rolledOver {
+		t.Fatalf("rolled over before the hold")
+	}
+	now = now.Add(DefaultRolloverHold)
+	h.poll(t, now)
+	if events := h.drainEvents(); len(events) != 1 || events[0] != EventRollover {
+		t.Fatalf("expected a rollover event, got %v", events)
+	}
+	if sound := <-h.sounds; sound != "rollover" {
+		t.Errorf("expected rollover sound, got %s", sound)
+	}
+	limit := <-h.limits
+	if !limit.Neutral || limit.Source != IMUSource || limit.Reason != IMURollover {
+		t.Errorf("expected neutral rollover limit, got %+v", limit)
+	}
+
+	h.setAccel(mpu6050AccelRegister, [3]float64{0, 0, 1})
+	for i := 0; i < 100; i++ {
+		now = now.Add(DefaultIMUPoll)
+		h.poll(t, now)
+	}
+	if events := h.drainEvents(); len(events) != 1 || events[0] != EventRolloverRecovered {
+		t.Fatalf("expected a recovered event, got %v", events)
+	}
+	limit = <-h.limits
+	if limit.Neutral || limit.MaxThrottle != carcommand.MaxThrottleLimit {
+		t.Errorf("expected the limit lifted, got %+v", limit)
+	}
+}
+
+func TestIMUCrash(t *testing.T) {
+	h := newIMUHarness(testIMUConfig(IMUMPU6050))
+	start := time.Now()
+	h.setAccel(mpu6050AccelRegister, [3]float64{0, 0, 1})
+	h.poll(t, start)
+
+	h.setAccel(mpu6050AccelRegister, [3]float64{-5, 0, 1})
+	h.poll(t, start.Add(DefaultIMUPoll))
+	h.poll(t, start.Add(2*DefaultIMUPoll)) //Same impact, reported once
+	if events := h.drainEvents(); len(events) != 1 || events[0] != EventCrash {
+		t.Fatalf("expected one crash event, got %v", events)
+	}
+	if sound := <-h.sounds; sound != "crash" {
+		t.Errorf("expected crash sound, got %s", sound)
+	}
+	limit := <-h.limits
+	if !limit.Neutral || limit.Reason != IMUCrash {
+		t.Errorf("expected neutral crash limit, got %+v", limit)
+	}
+
+	h.setAccel(mpu6050AccelRegister, [3]float64{0, 0, 1})
+	h.poll(t, start.Add(DefaultCrashCut))
+	if len(h.limits) != 0 {
+		t.Errorf("limit lifted before the cut ended")
+	}
+	h.poll(t, start.Add(2*DefaultIMUPoll+DefaultCrashCut))
+	limit = <-h.limits
+	if limit.Neutral {
+		t.Errorf("expected the limit lifted, got %+v", limit)
+	}
+}
+
+func TestIMUConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		change  func(*IMUConfig)
+		wantErr bool
+	}{
+		"defaults":         {change: func(c *IMUConfig) {}},
+		"unknown sensor":   {change: func(c *IMUConfig) { c.Sensor = "bno055" }, wantErr: true},
+		"flat rollover":    {change: func(c *IMUConfig) { c.RolloverAngle = 0 }, wantErr: true},
+		"crash at gravity": {change: func(c *IMUConfig) { c.CrashG = 1 }, wantErr: true},
+		"negative hold":    {change: func(c *IMUConfig) { c.RolloverHold = -time.Second }, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := testIMUConfig(IMUICM20948)
+			test.change(&cfg)
+			err := cfg.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("expected error %t, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestIMUYawRate(t *testing.T) {
+	h := newIMUHarness(testIMUConfig(IMUMPU6050))
+	h.setAccel(mpu6050AccelRegister, [3]float64{0, 0, 1})
+	h.bus.registers[mpu6050GyroRegister+4] = uint16(int16(131)) //2 degrees per second of drift sitting still
+	for i := 0; i < gyroBiasSamples; i++ {
+		h.poll(t, time.Now())
+		if _, ok := h.monitor.YawRate(); ok {
+			t.Fatalf("yaw rate reported before the gyro zero was learned")
+		}
+	}
+
+	h.bus.registers[mpu6050GyroRegister+4] = uint16(int16(786)) //12 degrees per second
+	h.poll(t, time.Now())
+	yawRate, ok := h.monitor.YawRate()
+	if !ok || math.Abs(yawRate-10) > 0.1 {
+		t.Errorf("expected 10 degrees per second without the drift, got %.2f (ok %t)", yawRate, ok)
+	}
+
+	h.monitor.yawRateTime = time.Now().Add(-yawRateStalePolls * 2 * DefaultIMUPoll)
+	if _, ok := h.monitor.YawRate(); ok {
+		t.Errorf("expected a stale yaw rate to be dropped")
+	}
+}
@@ -0,0 +1,44 @@
+package sensors
+
+import (
+	"log"
+	"time"
+
+	"github.com/googolgl/go-i2c"
+)
+
+// Reading is what a sensor publishes for clients, values are keyed by what they measure
+type Reading struct {
+	Sensor string             `json:"sensor"`
+	State  string             `json:"state"`
+	Values map[string]float64 `json:"values"`
+	Time   time.Time          `json:"time"`
+}
+
+// I2CBus is the part of an i2c device the sensors use, tests swap in a fake
+type I2CBus interface {
+	ReadRegU8(reg byte) (byte, error)
+	ReadRegU16BE(reg byte) (uint16, error)
+	WriteRegU8(reg byte, value byte) error
+	WriteRegU16BE(reg byte, value uint16) error
+	Close() error
+}
+
+// Opens the sensor's address on the same bus device the servo boards use, each address gets its own handle
+type I2COpener func(address byte, device string) (I2CBus, error)
+
+func openI2C(address byte, device string) (I2CBus, error) {
+	return i2c.New(address, device)
+}
+
+// Never blocks the sensor loop, readings are dropped if nobody is reading them
+func publish(readings chan<- Reading, reading Reading) {
+	if readings == nil {
+		return
+	}
+	select {
+	case readings <- reading:
+	default:
+		log.Printf("warning: sensor reading channel full, dropped %s reading\n", reading.Sensor)
+	}
+}
@@ -0,0 +1,279 @@
+package sensors
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+const WheelSpeedSource = "wheelspeed" //Sensor name on readings
+
+const DefaultWheelSpeedDevice = "/dev/gpiochip0"
+const DefaultPulsesPerRev = 1
+const DefaultWheelDiameter = 0.1 //Meters
+const DefaultSensorRatio = 1.0   //Sensor turns per wheel turn, more than 1 for a sensor on the motor
+const DefaultSpeedWindow = 4     //Pulse intervals averaged
+const DefaultSpeedTimeout = 500 * time.Millisecond
+const DefaultSpeedDebounce = 200 * time.Microsecond
+const DefaultSpeedTelemetry = 200 * time.Millisecond
+const DefaultWheelSpeedRetry = time.Second
+
+const (
+	WheelSpeedOK    = "ok"
+	WheelSpeedError = "error"
+)
+
+type WheelSpeedConfig struct {
+	Device            string //gpiochip the hall sensor or encoder is on
+	Line              int    //Less than 0 turns speed sensing off
+	PulsesPerRev      int    //Pulses for each turn of whatever the sensor watches
+	WheelDiameter     float64
+	SensorRatio       float64
+	Window            int
+	Timeout           time.Duration //No pulses for this long reads as stopped
+	Debounce          time.Duration //Edges closer together than this are noise
+	TelemetryInterval time.Duration //How often speed goes to clients, 0 sends none
+	Servo             string        //Esc that shifts and holds cruise speed from this reading
+}
+
+// edgeReader blocks until the next rising edge and returns when it happened
+type edgeReader interface {
+	readEdge() (time.Duration, error)
+	Close() error
+}
+
+type EdgeOpener func(device string, line int) (edgeReader, error)
+
+// WheelSpeedMonitor turns sensor pulses into speed and distance, it is a carcommand.SpeedSource
+type WheelSpeedMonitor struct {
+	config   WheelSpeedConfig
+	open     EdgeOpener
+	readings chan<- Reading
+
+	lock        sync.RWMutex //Speed is read by carcommand's loop
+	estimator   speedEstimator
+	connected   bool
+	lastArrival time.Time //Wall clock of the last edge, edge timestamps come from the kernel's clock
+}
+
+// speedEstimator works only from pulse timestamps so it can be fed synthetic pulse streams
+type speedEstimator struct {
+	metersPerPulse float64
+	debounce       time.Duration
+	timeout        time.Duration
+	intervals      []time.Duration //Most recent last
+	window         int
+	last           time.Duration
+	pulses         int64
+}
+
+func (c WheelSpeedConfig) Enabled() bool {
+	return c.Line >= 0
+}
+
+func (c WheelSpeedConfig) Validate() error {
+	if c.PulsesPerRev < 1 {
+		return fmt.Errorf("pulses per rev must be at least 1 (%d)", c.PulsesPerRev)
+	}
+	if c.WheelDiameter <= 0 || c.SensorRatio <= 0 {
+		return fmt.Errorf("wheel diameter and sensor ratio must be more than 0 (%.3f | %.2f)", c.WheelDiameter, c.SensorRatio)
+	}
+	if c.Window < 1 {
+		return fmt.Errorf("speed window must be at least 1 (%d)", c.Window)
+	}
+	if c.Timeout <= 0 || c.Debounce < 0 || c.TelemetryInterval < 0 {
+		return fmt.Errorf("speed timeout must be more than 0 and durations can't be negative")
+	}
+	return nil
+}
+
+// Distance covered by each pulse
+func (c WheelSpeedConfig) MetersPerPulse() float64 {
+	return math.Pi * c.WheelDiameter / (float64(c.PulsesPerRev) * c.SensorRatio)
+}
+
+func NewWheelSpeedMonitor(cfg WheelSpeedConfig, readings chan<- Reading) *WheelSpeedMonitor {
+	if cfg.Device == "" {
+		cfg.Device = DefaultWheelSpeedDevice
+	}
+	return &WheelSpeedMonitor{
+		config:    cfg,
+		open:      openGPIOEdges,
+		readings:  readings,
+		estimator: newSpeedEstimator(cfg.MetersPerPulse(), cfg.Window, cfg.Debounce, cfg.Timeout),
+	}
+}
+
+func newSpeedEstimator(metersPerPulse float64, window int, debounce time.Duration, timeout time.Duration) speedEstimator {
+	return speedEstimator{
+		metersPerPulse: metersPerPulse,
+		debounce:       debounce,
+		timeout:        timeout,
+		window:         window,
+		intervals:      make([]time.Duration, 0, window),
+	}
+}
+
+// Reads edges until the context is done, a sensor that stops answering reads as no speed and is retried
+func (m *WheelSpeedMonitor) Start(ctx context.Context) error {
+	err := m.config.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid wheel speed config - %w", err)
+	}
+
+	if m.config.TelemetryInterval > 0 {
+		go m.publishTelemetry(ctx)
+	}
+	for {
+		err := m.readEdges(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Printf("warning: wheel speed not read - %s\n", err.Error())
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(DefaultWheelSpeedRetry):
+		}
+	}
+}
+
+func (m *WheelSpeedMonitor) readEdges(ctx context.Context) error {
+	reader, err := m.open(m.config.Device, m.config.Line)
+	if err != nil {
+		return fmt.Errorf("failed opening line %d on %s - %w", m.config.Line, m.config.Device, err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			reader.Close() //Unblocks the read
+		case <-stop:
+			reader.Close()
+		}
+	}()
+
+	m.setConnected(true)
+	defer m.setConnected(false)
+	for {
+		timestamp, err := reader.readEdge()
+		if err != nil {
+			return err
+		}
+		m.addPulse(timestamp, time.Now())
+	}
+}
+
+func (m *WheelSpeedMonitor) setConnected(connected bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.connected = connected
+}
+
+func (m *WheelSpeedMonitor) addPulse(timestamp time.Duration, arrival time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.estimator.addPulse(timestamp) {
+		m.lastArrival = arrival
+	}
+}
+
+// Speed in meters per second, ok is false while the sensor isn't connected
+func (m *WheelSpeedMonitor) Speed() (float64, bool) {
+	return m.speedAt(time.Now())
+}
+
+func (m *WheelSpeedMonitor) speedAt(now time.Time) (float64, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if !m.connected {
+		return 0, false
+	}
+	if m.lastArrival.IsZero() {
+		return 0, true //Connected and no pulses yet, sitting still
+	}
+	return m.estimator.speed(now.Sub(m.lastArrival)), true
+}
+
+// Meters covered since the car started
+func (m *WheelSpeedMonitor) Distance() float64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.estimator.distance()
+}
+
+func (m *WheelSpeedMonitor) publishTelemetry(ctx context.Context) {
+	ticker := time.NewTicker(m.config.TelemetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			publish(m.readings, m.reading(now))
+		}
+	}
+}
+
+func (m *WheelSpeedMonitor) reading(now time.Time) Reading {
+	reading := Reading{Sensor: WheelSpeedSource, State: WheelSpeedError, Time: now}
+	speed, ok := m.speedAt(now)
+	if !ok {
+		return reading
+	}
+	reading.State = WheelSpeedOK
+	reading.Values = map[string]float64{
+		"speed":    speed,
+		"distance": m.Distance(),
+		"wheelRpm": speed / (math.Pi * m.config.WheelDiameter) * 60,
+	}
+	return reading
+}
+
+// Returns false for edges inside the debounce time, they don't count as pulses.
+// The first pulse after a stop starts the average over instead of dragging it down.
+func (e *speedEstimator) addPulse(timestamp time.Duration) bool {
+	if e.pulses > 0 {
+		interval := timestamp - e.last
+		if interval < e.debounce {
+			return false
+		}
+		if interval >= e.timeout {
+			e.intervals = e.intervals[:0] //Was stopped
+		} else {
+			if len(e.intervals) == e.window {
+				copy(e.intervals, e.intervals[1:])
+				e.intervals = e.intervals[:e.window-1]
+			}
+			e.intervals = append(e.intervals, interval)
+		}
+	}
+	e.last = timestamp
+	e.pulses++
+	return true
+}
+
+// Averages the recent intervals, sinceLast is how long it has been since the last pulse.
+// Going longer than the average without a pulse means the car is slowing, so that caps the speed.
+func (e *speedEstimator) speed(sinceLast time.Duration) float64 {
+	if len(e.intervals) == 0 || sinceLast >= e.timeout {
+		return 0
+	}
+	total := time.Duration(0)
+	for _, interval := range e.intervals {
+		total += interval
+	}
+	average := total / time.Duration(len(e.intervals))
+	if sinceLast > average {
+		average = sinceLast
+	}
+	return e.metersPerPulse / average.Seconds()
+}
+
+func (e *speedEstimator) distance() float64 {
+	return float64(e.pulses) * e.metersPerPulse
+}
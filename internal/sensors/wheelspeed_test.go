@@ -0,0 +1,129 @@
+package sensors
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpeedEstimator(t *testing.T) {
+	ms := time.Millisecond
+	tests := map[string]struct {
+		pulses    []time.Duration
+		sinceLast time.Duration
+		speed     float64
+		distance  float64
+	}{
+		"steady":       {pulses: []time.Duration{0, 100 * ms, 200 * ms, 300 * ms, 400 * ms}, sinceLast: 10 * ms, speed: 1, distance: 0.5},
+		"window":       {pulses: []time.Duration{0, 400 * ms, 450 * ms, 500 * ms, 550 * ms, 600 * ms}, speed: 2, distance: 0.6},
+		"one_pulse":    {pulses: []time.Duration{0}, speed: 0, distance: 0.1},
+		"debounce":     {pulses: []time.Duration{0, 100 * ms, 100*ms + 50*time.Microsecond, 200 * ms}, speed: 1, distance: 0.3},
+		"stopped":      {pulses: []time.Duration{0, 100 * ms, 200 * ms}, sinceLast: 500 * ms, speed: 0, distance: 0.3},
+		"slowing":      {pulses: []time.Duration{0, 100 * ms, 200 * ms}, sinceLast: 200 * ms, speed: 0.5, distance: 0.3},
+		"after_a_stop": {pulses: []time.Duration{0, 100 * ms, 2000 * ms, 2050 * ms}, speed: 2, distance: 0.4}, //The long gap doesn't drag the average
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			estimator := newSpeedEstimator(0.1, DefaultSpeedWindow, DefaultSpeedDebounce, DefaultSpeedTimeout)
+			for _, pulse := range test.pulses {
+				estimator.addPulse(pulse)
+			}
+			if speed := estimator.speed(test.sinceLast); math.Abs(speed-test.speed) > 0.001 {
+				t.Errorf("expected %.3fm/s, got %.3fm/s", test.speed, speed)
+			}
+			if distance := estimator.distance(); math.Abs(distance-test.distance) > 0.001 {
+				t.Errorf("expected %.3fm, got %.3fm", test.distance, distance)
+			}
+		})
+	}
+}
+
+func TestMetersPerPulse(t *testing.T) {
+	cfg := WheelSpeedConfig{PulsesPerRev: 2, WheelDiameter: 0.1, SensorRatio: 4} //Motor side sensor, 4 motor turns a wheel turn
+	if perPulse := cfg.MetersPerPulse(); math.Abs(perPulse-math.Pi*0.1/8) > 0.000001 {
+		t.Errorf("expected %.5fm per pulse, got %.5fm", math.Pi*0.1/8, perPulse)
+	}
+}
+
+type fakeEdges struct {
+	edges     chan time.Duration
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (f *fakeEdges) readEdge() (time.Duration, error) {
+	select {
+	case edge := <-f.edges:
+		return edge, nil
+	case <-f.closed:
+		return 0, fmt.Errorf("closed")
+	}
+}
+
+func (f *fakeEdges) Close() error {
+	f.closeOnce.Do(func() { close(f.closed) })
+	return nil
+}
+
+func TestWheelSpeedMonitor(t *testing.T) {
+	edges := &fakeEdges{edges: make(chan time.Duration), closed: make(chan struct{})}
+	monitor := NewWheelSpeedMonitor(WheelSpeedConfig{
+		Line:          4,
+		PulsesPerRev:  1,
+		WheelDiameter: 0.1 / math.Pi, //0.1m a pulse
+		SensorRatio:   1,
+		Window:        DefaultSpeedWindow,
+		Timeout:       time.Hour, //Wall clock between test pulses doesn't matter
+		Debounce:      DefaultSpeedDebounce,
+	}, nil)
+	monitor.open = func(device string, line int) (edgeReader, error) {
+		if device != DefaultWheelSpeedDevice || line != 4 {
+			t.Errorf("expected line 4 on %s, got %d on %s", DefaultWheelSpeedDevice, line, device)
+		}
+		return edges, nil
+	}
+
+	if _, ok := monitor.Speed(); ok {
+		t.Errorf("expected no speed before the sensor is read")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- monitor.Start(ctx)
+	}()
+
+	for i := 0; i < 6; i++ {
+		edges.edges <- time.Duration(i) * 50 * time.Millisecond
+	}
+	deadline := time.Now().Add(time.Second)
+	for monitor.Distance() < 0.55 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond) //Last pulse is counted after the read returns
+	}
+
+	speed, ok := monitor.Speed()
+	if !ok || math.Abs(speed-2) > 0.01 {
+		t.Errorf("expected 2m/s, got %.3fm/s (ok %t)", speed, ok)
+	}
+	reading := monitor.reading(time.Now())
+	if reading.State != WheelSpeedOK || math.Abs(reading.Values["distance"]-0.6) > 0.01 {
+		t.Errorf("expected an ok reading 0.6m along, got %+v", reading)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected a clean stop, got %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("monitor didn't stop")
+	}
+	if _, ok := monitor.Speed(); ok {
+		t.Errorf("expected no speed once the sensor is closed")
+	}
+}
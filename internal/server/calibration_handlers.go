@@ -0,0 +1,86 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+const calibrationTimeout = 2 * time.Second //carcommand answers within a tick unless it isn't running
+
+// Handles /calibration/{start,nudge,mark,save,stop,status}, the body is the rest of a carcommand.CalibrationRequest
+//
+//	POST /calibration/start {"servo":"steer"}
+//	POST /calibration/nudge {"value":-10}
+//	POST /calibration/mark  {"point":"min"}
+//
+// Every response is the calibration status after the step, admins only since it moves servos past their limits
+func (s *Server) calibrationHandler(w http.ResponseWriter, req *http.Request) {
+	claims, err := requestClaims(req)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if claims.Role != RoleAdmin {
+		log.Printf("%s (%s) tried calibrating\n", claims.Username, claims.Role)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	request := carcommand.CalibrationRequest{}
+	action := strings.TrimPrefix(req.URL.Path, "/calibration/")
+	if action != carcommand.CalibrateStatus {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		err = json.NewDecoder(req.Body).Decode(&request)
+		if err != nil && !errors.Is(err, io.EOF) { //Empty body is fine for stop and save
+			log.Printf("error decoding calibration body: %s", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	request.Action = action
+
+	result, err := s.calibrate(request)
+	if err != nil {
+		log.Printf("calibration %s failed: %s", action, err.Error())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{"error": result.Err.Error(), "status": result.Status})
+		return
+	}
+	json.NewEncoder(w).Encode(result.Status)
+}
+
+// Sends the request to the command loop and waits for its answer
+func (s *Server) calibrate(request carcommand.CalibrationRequest) (carcommand.CalibrationResult, error) {
+	request.Reply = make(chan carcommand.CalibrationResult, 1)
+	timeout := time.NewTimer(calibrationTimeout)
+	defer timeout.Stop()
+
+	select {
+	case s.calibrationChannel <- request:
+	case <-timeout.C:
+		return carcommand.CalibrationResult{}, fmt.Errorf("carcommand not accepting calibration requests")
+	}
+	select {
+	case result := <-request.Reply:
+		return result, nil
+	case <-timeout.C:
+		return carcommand.CalibrationResult{}, fmt.Errorf("no answer from carcommand")
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+func TestCalibrationHandler(t *testing.T) {
+	s := &Server{
+		calibrationChannel: make(chan carcommand.CalibrationRequest, 1),
+		config: SocketServerConfig{
+			UserRoles:   map[string]string{"username": RoleAdmin, "visitor": "kid"},
+			DefaultRole: "kid",
+		},
+	}
+	tokens := make(map[string]string)
+	for _, username := range []string{"username", "visitor"} {
+		token, err := s.generateJWT(username)
+		if err != nil {
+			t.Fatalf("failed generating token: %s", err)
+		}
+		tokens[username] = token
+	}
+	go func() {
+		for request := range s.calibrationChannel {
+			result := carcommand.CalibrationResult{Status: carcommand.CalibrationStatus{Active: true, Servo: request.Servo, Pulse: 1500 + request.Value}}
+			if request.Action == carcommand.CalibrateMark {
+				result.Err = fmt.Errorf("bad point %s", request.Point)
+			}
+			request.Reply <- result
+		}
+	}()
+	defer close(s.calibrationChannel)
+
+	tests := map[string]struct {
+		user   string
+		method string
+		path   string
+		body   string
+		code   int
+		pulse  float32
+	}{
+		"no_login": {
+			method: http.MethodPost,
+			path:   "/calibration/start",
+			body:   `{"servo":"steer"}`,
+			code:   http.StatusUnauthorized,
+		},
+		"not_admin": {
+			user:   "visitor",
+			method: http.MethodPost,
+			path:   "/calibration/start",
+			body:   `{"servo":"steer"}`,
+			code:   http.StatusForbidden,
+		},
+		"start": {
+			user:   "username",
+			method: http.MethodPost,
+			path:   "/calibration/start",
+			body:   `{"servo":"steer","value":10}`,
+			code:   http.StatusOK,
+			pulse:  1510,
+		},
+		"status_get": {
+			user:   "username",
+			method: http.MethodGet,
+			path:   "/calibration/status",
+			code:   http.StatusOK,
+			pulse:  1500,
+		},
+		"nudge_get": {
+			user:   "username",
+			method: http.MethodGet,
+			path:   "/calibration/nudge",
+			code:   http.StatusMethodNotAllowed,
+		},
+		"bad_body": {
+			user:   "username",
+			method: http.MethodPost,
+			path:   "/calibration/nudge",
+			body:   `{"value":`,
+			code:   http.StatusBadRequest,
+		},
+		"car_error": {
+			user:   "username",
+			method: http.MethodPost,
+			path:   "/calibration/mark",
+			body:   `{"point":"middle"}`,
+			code:   http.StatusBadRequest,
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.user != "" {
+				req.AddCookie(&http.Cookie{Name: "token", Value: tokens[tc.user]})
+			}
+			recorder := httptest.NewRecorder()
+			s.calibrationHandler(recorder, req)
+			if recorder.Code != tc.code {
+				t.Fatalf("expected code %d, got %d", tc.code, recorder.Code)
+			}
+			if tc.code != http.StatusOK {
+				return
+			}
+			status := carcommand.CalibrationStatus{}
+			err := json.NewDecoder(recorder.Body).Decode(&status)
+			if err != nil {
+				t.Fatalf("failed decoding status: %s", err)
+			}
+			if status.Pulse != tc.pulse {
+				t.Errorf("expected pulse %.0f, got %.0f", tc.pulse, status.Pulse)
+			}
+		})
+	}
+}
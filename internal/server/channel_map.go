@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+type ChannelType string
+
+const (
+	ChannelValue  ChannelType = "value"  //Byte is the command value for the named servo
+	ChannelGear   ChannelType = "gear"   //Byte is the gear for the named servo
+	ChannelSound  ChannelType = "sound"  //Byte picks a meme sound group, name is ignored
+	ChannelCruise ChannelType = "cruise" //Byte is the cruise control button for the named throttle (carcommand.CruiseToggle...)
+)
+
+// Matches the layout clients sent before the channel map existed
+const DefaultChannelMap = "esc:value,esc:gear,steer:value,pan:value,tilt:value,sound:sound"
+
+// Values of the sound byte, 0 plays nothing
+var soundGroups = []string{"", "affirmative", "negative", "aggressive", "sorry"}
+
+// ChannelSlot binds one byte of the command message to a servo, the client builds its command from these in order
+type ChannelSlot struct {
+	Name  string      `json:"name"`
+	Type  ChannelType `json:"type"`
+	Input string      `json:"input,omitempty"` //Logical input of a value slot (carcommand.InputThrottle...), limits and safety features find servos by it
+}
+
+// Parses a comma separated list of name:type:input slots (drive:value:throttle,drive:gear,steer:value)
+func ParseChannelMap(value string) ([]ChannelSlot, error) {
+	if value == "" {
+		return nil, fmt.Errorf("channel map is empty")
+	}
+	parts := strings.Split(value, ",")
+	channelMap := make([]ChannelSlot, 0, len(parts))
+	for _, part := range parts {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("channel not in name:type:input form (%s)", part)
+		}
+		slot := ChannelSlot{
+			Name: fields[0],
+			Type: ChannelValue,
+		}
+		if len(fields) > 1 {
+			slot.Type = ChannelType(fields[1])
+		}
+		if len(fields) > 2 {
+			slot.Input = fields[2]
+		}
+		channelMap = append(channelMap, slot)
+	}
+	return channelMap, ValidateChannelMap(channelMap)
+}
+
+// Logical input of every servo with a value slot. Without an input a slot uses the one its name has always meant (esc is the throttle),
+// then servos that take a gear or cruise are throttles.
+func ChannelInputs(channelMap []ChannelSlot) map[string]string {
+	inputs := make(map[string]string, len(channelMap))
+	for _, slot := range channelMap {
+		if slot.Type != ChannelValue {
+			continue
+		}
+		input := slot.Input
+		if input == "" {
+			input = carcommand.CommandInput(slot.Name)
+		}
+		if input != "" {
+			inputs[slot.Name] = input
+		}
+	}
+	for _, slot := range channelMap {
+		if slot.Type != ChannelGear && slot.Type != ChannelCruise {
+			continue
+		}
+		if _, found := inputs[slot.Name]; !found {
+			inputs[slot.Name] = carcommand.InputThrottle
+		}
+	}
+	return inputs
+}
+
+func ValidateChannelMap(channelMap []ChannelSlot) error {
+	if len(channelMap) == 0 {
+		return fmt.Errorf("channel map is empty")
+	}
+	seen := make(map[string]bool, len(channelMap))
+	for i, slot := range channelMap {
+		if slot.Name == "" {
+			return fmt.Errorf("channel %d missing name", i)
+		}
+		switch slot.Type {
+		case ChannelValue, ChannelGear, ChannelSound, ChannelCruise:
+		default:
+			return fmt.Errorf("channel %d has unsupported type (%s)", i, slot.Type)
+		}
+		if slot.Input != "" && slot.Type != ChannelValue {
+			return fmt.Errorf("channel %d takes no input, only value channels do (%s:%s)", i, slot.Name, slot.Type)
+		}
+		switch slot.Input {
+		case "", carcommand.InputThrottle, carcommand.InputSteer, carcommand.InputPan, carcommand.InputTilt, carcommand.InputAux:
+		default:
+			return fmt.Errorf("channel %d has unsupported input (%s)", i, slot.Input)
+		}
+		key := slot.Name + ":" + string(slot.Type)
+		if seen[key] {
+			return fmt.Errorf("channel %d is a duplicate (%s)", i, key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+func TestParseChannelMap(t *testing.T) {
+	tests := map[string]struct {
+		value string
+		slots int
+		valid bool
+	}{
+		"default":        {value: DefaultChannelMap, slots: 6, valid: true},
+		"extra_channel":  {value: "throttle:value,throttle:gear,steer,winch:value,lights", slots: 5, valid: true},
+		"empty":          {value: ""},
+		"unknown_type":   {value: "esc:value,steer:analog"},
+		"duplicate_slot": {value: "esc:value,esc:value"},
+		"missing_name":   {value: "esc:value,:gear"},
+		"inputs":         {value: "drive:value:throttle,drive:gear,wheel:value:steer", slots: 3, valid: true},
+		"unknown_input":  {value: "drive:value:boost"},
+		"input_on_gear":  {value: "drive:value,drive:gear:throttle"},
+		"extra_field":    {value: "drive:value:throttle:fast"},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			channelMap, err := ParseChannelMap(tc.value)
+			if !tc.valid {
+				if err == nil {
+					t.Errorf("expected invalid channel map")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed parsing channel map: %s", err)
+			}
+			if len(channelMap) != tc.slots {
+				t.Errorf("expected %d slots, got %d", tc.slots, len(channelMap))
+			}
+		})
+	}
+}
+
+func TestChannelInputs(t *testing.T) {
+	tests := map[string]struct {
+		value    string
+		expected map[string]string
+	}{
+		"default": {
+			value:    DefaultChannelMap,
+			expected: map[string]string{"esc": carcommand.InputThrottle, "steer": carcommand.InputSteer, "pan": carcommand.InputPan, "tilt": carcommand.InputTilt},
+		},
+		"named_inputs": {
+			value:    "drive:value:throttle,wheel:value:steer,esc:value:aux",
+			expected: map[string]string{"drive": carcommand.InputThrottle, "wheel": carcommand.InputSteer, "esc": carcommand.InputAux},
+		},
+		"gear_makes_a_throttle": {
+			value:    "throttle:value,throttle:gear,winch:value",
+			expected: map[string]string{"throttle": carcommand.InputThrottle},
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			channelMap, err := ParseChannelMap(tc.value)
+			if err != nil {
+				t.Fatalf("failed parsing channel map: %s", err)
+			}
+			inputs := ChannelInputs(channelMap)
+			if len(inputs) != len(tc.expected) {
+				t.Errorf("expected %d inputs, got %+v", len(tc.expected), inputs)
+			}
+			for name, input := range tc.expected {
+				if inputs[name] != input {
+					t.Errorf("expected %s to be %s, got %s", name, input, inputs[name])
+				}
+			}
+		})
+	}
+}
+
+func TestCommandParser(t *testing.T) {
+	channelMap, err := ParseChannelMap("throttle:value,throttle:gear,steer:value,winch:value,sound:sound,throttle:cruise")
+	if err != nil {
+		t.Fatalf("failed parsing channel map: %s", err)
+	}
+	s := &Server{
+		commandChannel:   make(chan carcommand.CommandGroup, 1),
+		controlChannel:   make(chan carcommand.ControlCommand, 3),
+		memeSoundChannel: make(chan string, 1),
+		config:           SocketServerConfig{ChannelMap: channelMap},
+	}
+
+	s.commandParser([]byte{255, gearByteReverse, 0, 200, 2, carcommand.CruiseUp}, UnlimitedProfile(RoleAdmin))
+	commands := (<-s.commandChannel).Commands
+	expected := map[string]carcommand.Command{
+		"throttle": {Value: 255, Gear: "R", Cruise: carcommand.CruiseUp},
+		"steer":    {Value: 0},
+		"winch":    {Value: 200},
+	}
+	if len(commands) != len(expected) {
+		t.Errorf("expected %d commands, got %+v", len(expected), commands)
+	}
+	for name, command := range expected {
+		if commands[name] != command {
+			t.Errorf("expected %s command %+v, got %+v", name, command, commands[name])
+		}
+	}
+	if sound := <-s.memeSoundChannel; sound != "negative" {
+		t.Errorf("expected negative sound, got %s", sound)
+	}
+
+	s.commandParser([]byte{127, 0, 127}, UnlimitedProfile(RoleAdmin)) //wrong length is dropped
+	if len(s.commandChannel) != 0 {
+		t.Errorf("expected short command to be dropped")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 
 	socketio "github.com/googollee/go-socket.io"
 	"github.com/pion/webrtc/v3"
@@ -16,6 +17,10 @@ type Connection struct {
 	Cancel         context.CancelFunc
 	CTX            context.Context
 	AudioPlayer    ClientAudioTrackPlayer
+	Username       string //Empty when the client didn't log in
+
+	limits     LimitProfile
+	limitsLock sync.RWMutex
 }
 
 func NewConnection(socketConn socketio.Conn, audioPlayer ClientAudioTrackPlayer, forceLocal bool) (*Connection, error) {
@@ -47,6 +52,19 @@ func NewConnection(socketConn socketio.Conn, audioPlayer ClientAudioTrackPlayer,
 	return conn, nil
 }
 
+func (c *Connection) Limits() LimitProfile {
+	c.limitsLock.RLock()
+	defer c.limitsLock.RUnlock()
+	return c.limits
+}
+
+// Takes effect on the next command, the client doesn't have to reconnect
+func (c *Connection) SetLimits(profile LimitProfile) {
+	c.limitsLock.Lock()
+	c.limits = profile
+	c.limitsLock.Unlock()
+}
+
 func (c *Connection) Disconnect() {
 	c.Cancel()
 	c.PeerConnection.Close()
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+type estopState struct {
+	Estopped bool      `json:"estopped"`
+	Reason   string    `json:"reason,omitempty"`
+	Since    time.Time `json:"since"`
+}
+
+type estopRequest struct {
+	Reason string `json:"reason"`
+}
+
+const estopRetryInterval = 500 * time.Millisecond
+
+// Latches the car's estop, anything that can reach the server can do this
+// The latch always goes to the car, even if the server thinks it's latched, and is retried until the car takes it
+func (s *Server) Estop(reason string) error {
+	s.estopSendLock.Lock() //Keeps latches and clears reaching the car in the order they were asked for
+	defer s.estopSendLock.Unlock()
+	err := s.sendControl(carcommand.ControlCommand{Type: carcommand.ControlEstop, Reason: reason})
+	if err != nil {
+		s.retryEstop(reason)
+		return fmt.Errorf("estop not taken by the car, retrying - %w", err)
+	}
+	s.setEstopped(reason, time.Now())
+	return nil
+}
+
+// Keeps sending a dropped estop until the car takes it or an admin clears it, only one retry runs at a time
+func (s *Server) retryEstop(reason string) {
+	s.estopLock.Lock()
+	defer s.estopLock.Unlock()
+	if s.estopRetrying {
+		return
+	}
+	s.estopRetrying = true
+	go func() {
+		for {
+			time.Sleep(estopRetryInterval)
+			s.estopSendLock.Lock()
+			s.estopLock.Lock()
+			retrying := s.estopRetrying
+			s.estopLock.Unlock()
+			if !retrying { //Cleared while waiting
+				s.estopSendLock.Unlock()
+				return
+			}
+			err := s.sendControl(carcommand.ControlCommand{Type: carcommand.ControlEstop, Reason: reason})
+			if err == nil {
+				s.estopLock.Lock()
+				s.estopRetrying = false
+				s.estopLock.Unlock()
+				s.setEstopped(reason, time.Now())
+				s.estopSendLock.Unlock()
+				log.Println("estop reached the car after retrying")
+				return
+			}
+			s.estopSendLock.Unlock()
+		}
+	}()
+}
+
+// Records the car latching, keeps the first reason if it already was and plays the alert when it wasn't
+func (s *Server) setEstopped(reason string, since time.Time) {
+	s.estopLock.Lock()
+	defer s.estopLock.Unlock()
+	if s.estop.Estopped {
+		return
+	}
+	s.estop = estopState{
+		Estopped: true,
+		Reason:   reason,
+		Since:    since,
+	}
+
+	if s.config.EstopSound == "" {
+		return
+	}
+	select {
+	case s.memeSoundChannel <- s.config.EstopSound:
+	default:
+		log.Println("warning: sound channel full, estop alert not played")
+	}
+}
+
+// Clears the car's estop and stops any estop retry, the server stays latched if the car doesn't take it
+func (s *Server) ClearEstop(reason string) error {
+	s.estopSendLock.Lock()
+	defer s.estopSendLock.Unlock()
+	s.estopLock.Lock()
+	s.estopRetrying = false
+	s.estopLock.Unlock()
+
+	err := s.sendControl(carcommand.ControlCommand{Type: carcommand.ControlEstopClear, Reason: reason})
+	if err != nil {
+		return fmt.Errorf("estop clear not taken by the car - %w", err)
+	}
+	s.estopLock.Lock()
+	s.estop = estopState{}
+	s.estopLock.Unlock()
+	return nil
+}
+
+// Keeps the server's estop state in line with the car, which can latch or clear it on its own
+func (s *Server) trackEstop(event carcommand.Event) {
+	switch event.Type {
+	case carcommand.EventEstop:
+		s.setEstopped(event.Message, event.Time)
+	case carcommand.EventEstopCleared:
+		s.estopLock.Lock()
+		s.estop = estopState{}
+		s.estopLock.Unlock()
+	}
+}
+
+func (s *Server) estopStatus() estopState {
+	s.estopLock.Lock()
+	defer s.estopLock.Unlock()
+	return s.estop
+}
+
+// Tells a client that just connected about a latched estop, later changes come with the car's events
+func (s *Server) emitEstop(socketConn socketio.Conn) {
+	state := s.estopStatus()
+	if !state.Estopped {
+		return
+	}
+	encodedEvent, err := encode(carcommand.Event{Type: carcommand.EventEstop, Message: state.Reason, Time: state.Since})
+	if err != nil {
+		log.Printf("error encoding estop event: %s\n", err.Error())
+		return
+	}
+	socketConn.Emit("event", encodedEvent)
+}
+
+func (s *Server) onEstop(socketConn socketio.Conn, msg string) {
+	driver := socketConn.ID()
+	s.connectionsLock.RLock()
+	if connection, ok := s.connections[socketConn.ID()]; ok && connection.Username != "" {
+		driver = connection.Username
+	}
+	s.connectionsLock.RUnlock()
+	if msg == "" {
+		msg = "socket event"
+	}
+	err := s.Estop(fmt.Sprintf("%s from %s", msg, driver))
+	if err != nil {
+		log.Printf("warning: %s\n", err.Error())
+	}
+}
+
+// Latches and clears the estop
+//
+//	GET  /estop        returns the estop state
+//	POST /estop        {"reason":"ran into the fence"} latches it, the body is optional
+//	POST /estop/clear  clears it, admins only
+func (s *Server) estopHandler(w http.ResponseWriter, req *http.Request) {
+	status := http.StatusOK //Unavailable when the car didn't take the change, the body still has the server's state
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == "/estop":
+	case req.Method == http.MethodPost && req.URL.Path == "/estop":
+		request := estopRequest{}
+		err := json.NewDecoder(req.Body).Decode(&request)
+		if err != nil && !errors.Is(err, io.EOF) {
+			log.Printf("error decoding estop body: %s", err.Error())
+		}
+		if request.Reason == "" {
+			request.Reason = "http request"
+		}
+		err = s.Estop(request.Reason)
+		if err != nil {
+			log.Printf("warning: %s\n", err.Error())
+			status = http.StatusServiceUnavailable
+		}
+	case req.Method == http.MethodPost && req.URL.Path == "/estop/clear":
+		claims, err := requestClaims(req)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if claims.Role != RoleAdmin {
+			log.Printf("%s (%s) tried clearing the estop\n", claims.Username, claims.Role)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		err = s.ClearEstop("cleared by " + claims.Username)
+		if err != nil {
+			log.Printf("warning: %s\n", err.Error())
+			status = http.StatusServiceUnavailable
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(s.estopStatus())
+}
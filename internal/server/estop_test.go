@@ -0,0 +1,241 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+func TestEstopHandler(t *testing.T) {
+	s := newLimitsServer()
+	s.config.EstopSound = "negative"
+
+	tokens := make(map[string]string)
+	for _, username := range []string{"username", "visitor"} {
+		token, err := s.generateJWT(username)
+		if err != nil {
+			t.Fatalf("failed generating token: %s", err)
+		}
+		tokens[username] = token
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		method   string
+		path     string
+		body     string
+		code     int
+		estopped bool
+		control  string //Control sent to the car, empty for none
+	}{
+		{name: "status", method: http.MethodGet, path: "/estop", code: http.StatusOK},
+		{name: "latch", method: http.MethodPost, path: "/estop", body: `{"reason":"fence"}`, code: http.StatusOK, estopped: true, control: carcommand.ControlEstop},
+		{name: "latch_again", method: http.MethodPost, path: "/estop", code: http.StatusOK, estopped: true, control: carcommand.ControlEstop},
+		{name: "clear_no_login", method: http.MethodPost, path: "/estop/clear", code: http.StatusUnauthorized, estopped: true},
+		{name: "clear_not_admin", user: "visitor", method: http.MethodPost, path: "/estop/clear", code: http.StatusForbidden, estopped: true},
+		{name: "clear_get", user: "username", method: http.MethodGet, path: "/estop/clear", code: http.StatusMethodNotAllowed, estopped: true},
+		{name: "clear", user: "username", method: http.MethodPost, path: "/estop/clear", code: http.StatusOK, control: carcommand.ControlEstopClear},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.user != "" {
+			req.AddCookie(&http.Cookie{Name: "token", Value: tokens[tc.user]})
+		}
+		w := httptest.NewRecorder()
+		s.estopHandler(w, req)
+		if w.Code != tc.code {
+			t.Errorf("%s: expected code %d, got %d", tc.name, tc.code, w.Code)
+		}
+		if s.estopStatus().Estopped != tc.estopped {
+			t.Errorf("%s: expected estopped %t", tc.name, tc.estopped)
+		}
+		if tc.code == http.StatusOK {
+			state := estopState{}
+			err := json.NewDecoder(w.Body).Decode(&state)
+			if err != nil || state.Estopped != tc.estopped {
+				t.Errorf("%s: expected estopped %t in response, got %+v (%v)", tc.name, tc.estopped, state, err)
+			}
+		}
+
+		if tc.control == "" {
+			if len(s.controlChannel) != 0 {
+				t.Errorf("%s: expected no control, got %+v", tc.name, <-s.controlChannel)
+			}
+			continue
+		}
+		if len(s.controlChannel) == 0 {
+			t.Fatalf("%s: expected %s control, got none", tc.name, tc.control)
+		}
+		if control := <-s.controlChannel; control.Type != tc.control {
+			t.Errorf("%s: expected %s control, got %+v", tc.name, tc.control, control)
+		}
+	}
+
+	if sound := <-s.memeSoundChannel; sound != "negative" {
+		t.Errorf("expected the estop alert to play, got %s", sound)
+	}
+	if len(s.memeSoundChannel) != 0 {
+		t.Errorf("expected the alert to play once")
+	}
+}
+
+func TestEstopStatusWhileSending(t *testing.T) {
+	s := newLimitsServer()
+	s.controlChannel = make(chan carcommand.ControlCommand) //carcommand busy, the send waits
+
+	done := make(chan error)
+	go func() {
+		done <- s.Estop("test")
+	}()
+	time.Sleep(controlTimeout / 4)
+	if s.estopStatus().Estopped { //Has to answer while the estop is still being sent, and not latch before the car has it
+		t.Errorf("estop latched before the car took it")
+	}
+	if control := <-s.controlChannel; control.Type != carcommand.ControlEstop {
+		t.Errorf("expected the estop to reach the car, got %+v", control)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected estop error: %s", err)
+	}
+	if !s.estopStatus().Estopped {
+		t.Errorf("expected estop to latch once the car took it")
+	}
+}
+
+func TestEstopNotTaken(t *testing.T) {
+	s := newLimitsServer()
+	s.controlChannel = make(chan carcommand.ControlCommand) //Nothing reads these yet
+
+	if err := s.Estop("test"); err == nil {
+		t.Fatal("expected an error when the car doesn't take the estop")
+	}
+	if s.estopStatus().Estopped {
+		t.Errorf("estop latched on the server but not the car")
+	}
+	if err := s.Estop("again"); err == nil {
+		t.Fatal("expected an error when the car doesn't take the estop")
+	}
+
+	select { //The retry keeps going until the car reads it
+	case control := <-s.controlChannel:
+		if control.Type != carcommand.ControlEstop || control.Reason != "test" {
+			t.Errorf("expected the dropped estop to be resent, got %+v", control)
+		}
+	case <-time.After(3 * estopRetryInterval):
+		t.Fatal("dropped estop never resent")
+	}
+	deadline := time.Now().Add(controlTimeout)
+	for !s.estopStatus().Estopped {
+		if time.Now().After(deadline) {
+			t.Fatal("estop not latched after the retry reached the car")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select { //Only one retry was running
+	case control := <-s.controlChannel:
+		t.Errorf("expected a single retry, got %+v", control)
+	case <-time.After(2 * estopRetryInterval):
+	}
+}
+
+func TestEstopHandlerNotTaken(t *testing.T) {
+	s := newLimitsServer()
+	s.controlChannel = make(chan carcommand.ControlCommand)
+	defer s.ClearEstop("test") //Stops the retry
+
+	w := httptest.NewRecorder()
+	s.estopHandler(w, httptest.NewRequest(http.MethodPost, "/estop", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected code %d when the car doesn't take the estop, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	state := estopState{}
+	err := json.NewDecoder(w.Body).Decode(&state)
+	if err != nil || state.Estopped {
+		t.Errorf("expected not estopped in response, got %+v (%v)", state, err)
+	}
+}
+
+func TestClearEstopNotTaken(t *testing.T) {
+	s := newLimitsServer()
+	if err := s.Estop("test"); err != nil {
+		t.Fatalf("unexpected estop error: %s", err)
+	}
+	<-s.controlChannel
+	s.controlChannel = make(chan carcommand.ControlCommand)
+
+	if err := s.ClearEstop("test"); err == nil {
+		t.Fatal("expected an error when the car doesn't take the clear")
+	}
+	if !s.estopStatus().Estopped {
+		t.Errorf("expected estop to stay latched when the car didn't take the clear")
+	}
+
+	go func() {
+		<-s.controlChannel
+	}()
+	if err := s.ClearEstop("test"); err != nil {
+		t.Fatalf("expected the clear to be retried, got %s", err)
+	}
+	if s.estopStatus().Estopped {
+		t.Errorf("expected estop to clear once the car took it")
+	}
+}
+
+func TestClearEstopStopsRetry(t *testing.T) {
+	s := newLimitsServer()
+	s.controlChannel = make(chan carcommand.ControlCommand)
+	if err := s.Estop("test"); err == nil {
+		t.Fatal("expected an error when the car doesn't take the estop")
+	}
+
+	received := make(chan carcommand.ControlCommand, 2)
+	go func() {
+		for control := range s.controlChannel {
+			received <- control
+		}
+	}()
+	if err := s.ClearEstop("test"); err != nil {
+		t.Fatalf("unexpected clear error: %s", err)
+	}
+	if control := <-received; control.Type != carcommand.ControlEstopClear {
+		t.Errorf("expected the clear, got %+v", control)
+	}
+	select {
+	case control := <-received:
+		t.Errorf("expected the retry to stop after the clear, got %+v", control)
+	case <-time.After(2 * estopRetryInterval):
+	}
+}
+
+func TestTrackEstop(t *testing.T) {
+	s := newLimitsServer()
+	since := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		event    carcommand.Event
+		estopped bool
+		reason   string
+	}{
+		{event: carcommand.Event{Type: carcommand.EventEstop, Message: "tipped over", Time: since}, estopped: true, reason: "tipped over"},
+		{event: carcommand.Event{Type: carcommand.EventEstop, Message: "again", Time: time.Now()}, estopped: true, reason: "tipped over"},
+		{event: carcommand.Event{Type: carcommand.EventGear, Message: "N"}, estopped: true, reason: "tipped over"},
+		{event: carcommand.Event{Type: carcommand.EventEstopCleared}, estopped: false},
+	}
+	for i, tc := range tests {
+		s.trackEstop(tc.event)
+		state := s.estopStatus()
+		if state.Estopped != tc.estopped || state.Reason != tc.reason {
+			t.Errorf("step %d expected estopped %t (%s), got %+v", i, tc.estopped, tc.reason, state)
+		}
+	}
+	if len(s.controlChannel) != 0 {
+		t.Errorf("expected car events not to send controls")
+	}
+}
@@ -1,19 +1,19 @@
 package server
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 var tempSecretKey = []byte("TempSecretKey") //TODO: Load from env variable
-var tempUser = "username"
-var tempPass = "password"
 
 type Credentials struct {
 	Password string `json:"password"`
@@ -22,12 +22,18 @@ type Credentials struct {
 
 type Claims struct {
 	Username string `json:"username"`
+	Role     string `json:"role"`
 	jwt.RegisteredClaims
 }
 
 func (s *Server) RegisterHTTPHandlers() {
 	http.HandleFunc("/index", s.indexHandler)
 	http.HandleFunc("/login", s.loginHandler)
+	http.HandleFunc("/calibration/", s.calibrationHandler)
+	http.HandleFunc("/recordings/", s.recordingHandler)
+	http.HandleFunc("/limits", s.limitsHandler)
+	http.HandleFunc("/estop", s.estopHandler)
+	http.HandleFunc("/estop/", s.estopHandler)
 
 	//auth testing
 	http.HandleFunc("/authed", s.authedHandler)
@@ -65,7 +71,7 @@ func (s *Server) loginHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	tokenString, err := s.generateJWT()
+	tokenString, err := s.generateJWT(creds.Username)
 	if err != nil {
 		log.Printf("Error generating JWT: %s", err.Error())
 		return
@@ -109,7 +115,7 @@ func (s *Server) signinHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	tokenString, err := s.generateJWT()
+	tokenString, err := s.generateJWT(creds.Username)
 	if err != nil {
 		log.Printf("Error generating JWT: %s", err.Error())
 		return
@@ -158,19 +164,38 @@ func (s *Server) authedHandler(w http.ResponseWriter, req *http.Request) {
 	template.Execute(w, nil) //Can pass map[string]any here and use go templates to dynamically build the html page
 }
 
+// Every configured user can log in with their own password, roles come from the username
 func (s *Server) validateCredentials(creds Credentials) error {
-	if creds.Username != tempUser && creds.Password != tempPass {
+	password, found := s.config.Users[creds.Username]
+	if !found || subtle.ConstantTimeCompare([]byte(creds.Password), []byte(password)) != 1 {
 		return fmt.Errorf("invalid username and password")
 	}
 	return nil
 }
 
+// Parses a comma separated list of username:password pairs (username:password,visitor:letmein), passwords can't have commas
+func ParseUsers(value string) (map[string]string, error) {
+	users := make(map[string]string)
+	if value == "" {
+		return users, nil
+	}
+	for _, part := range strings.Split(value, ",") {
+		username, password, found := strings.Cut(strings.TrimSpace(part), ":")
+		if !found || username == "" || password == "" {
+			return nil, fmt.Errorf("user not in username:password form (%s)", username)
+		}
+		users[username] = password
+	}
+	return users, nil
+}
+
 /*********************************JWT******************************/
 
-func (s *Server) generateJWT() (string, error) {
+func (s *Server) generateJWT(username string) (string, error) {
 	expirationTime := time.Now().Add(5 * time.Minute)
 	claims := &Claims{
-		Username: tempUser,
+		Username: username,
+		Role:     s.userRole(username),
 		RegisteredClaims: jwt.RegisteredClaims{
 			// In JWT, the expiry time is expressed as unix milliseconds
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
@@ -184,3 +209,23 @@ func (s *Server) generateJWT() (string, error) {
 	}
 	return tokenString, nil
 }
+
+// Claims from the request's token cookie
+func requestClaims(req *http.Request) (*Claims, error) {
+	cookie, err := req.Cookie("token")
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
+		return tempSecretKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing token: %w", err)
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("token not valid")
+	}
+	return claims, nil
+}
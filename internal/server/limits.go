@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+const RoleAdmin = "admin" //Never limited and the only role that can change another connection's profile
+const MaxLimitProfiles = 8
+
+// LimitProfile caps what a driver can do, every role drives with the profile of the same name.
+// Roles without a profile get the zero profile which has no throttle or steering.
+type LimitProfile struct {
+	Name        string `json:"name"`
+	MaxThrottle int    `json:"maxThrottle"` //Percent of full driving throttle, 0 can't drive. Braking is never limited.
+	MaxSteer    int    `json:"maxSteer"`    //Percent of full steering throw
+	MaxGear     int    `json:"maxGear"`     //Highest forward gear, 0 allows all
+	Reverse     bool   `json:"reverse"`
+	PanTilt     bool   `json:"panTilt"`
+}
+
+func UnlimitedProfile(name string) LimitProfile {
+	return LimitProfile{
+		Name:        name,
+		MaxThrottle: 100,
+		MaxSteer:    100,
+		Reverse:     true,
+		PanTilt:     true,
+	}
+}
+
+func (p LimitProfile) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("profile missing name")
+	}
+	if p.Name == RoleAdmin {
+		return fmt.Errorf("%s can't be limited", RoleAdmin)
+	}
+	if p.MaxThrottle < 0 || p.MaxThrottle > 100 {
+		return fmt.Errorf("max throttle must be 0 to 100 percent (%d)", p.MaxThrottle)
+	}
+	if p.MaxSteer < 0 || p.MaxSteer > 100 {
+		return fmt.Errorf("max steer must be 0 to 100 percent (%d)", p.MaxSteer)
+	}
+	if p.MaxGear < 0 {
+		return fmt.Errorf("max gear can't be negative (%d)", p.MaxGear)
+	}
+	return nil
+}
+
+// Parses a comma separated list of username:role pairs (username:admin,visitor:guest)
+func ParseUserRoles(value string) (map[string]string, error) {
+	roles := make(map[string]string)
+	if value == "" {
+		return roles, nil
+	}
+	for _, part := range strings.Split(value, ",") {
+		username, role, found := strings.Cut(strings.TrimSpace(part), ":")
+		if !found || username == "" || role == "" {
+			return nil, fmt.Errorf("user role not in username:role form (%s)", part)
+		}
+		roles[username] = role
+	}
+	return roles, nil
+}
+
+// Scales steering toward center, holds pan/tilt centered and keeps gears in the profile, servos are found by their input in the channel map.
+// The commands carry the profile's limits too, throttle is scaled in the car since only it knows when a throttle brakes
+// and it holds the gear limit for shift controls and automatics. Drivers without any throttle are held at neutral.
+func (p LimitProfile) apply(commands carcommand.CommandGroup, inputs map[string]string) carcommand.CommandGroup {
+	for name, command := range commands.Commands {
+		switch inputs[name] {
+		case carcommand.InputThrottle:
+			if p.MaxThrottle == 0 {
+				command.Value = carcommand.MixInputMid
+			}
+			command.Gear = p.limitGear(command.Gear)
+		case carcommand.InputSteer:
+			command.Value = limitThrow(command.Value, p.MaxSteer)
+		case carcommand.InputPan, carcommand.InputTilt:
+			if !p.PanTilt {
+				command.Value = carcommand.MixInputMid
+			}
+		}
+		commands.Commands[name] = command
+	}
+	commands.Limits = &carcommand.DriverLimits{
+		MaxThrottle: p.MaxThrottle,
+		MaxGear:     p.MaxGear,
+		NoReverse:   !p.Reverse,
+	}
+	return commands
+}
+
+// Forward gears past the profile's top gear are held at it and reverse drops to neutral without reverse
+func (p LimitProfile) limitGear(gear string) string {
+	if gear == carcommand.ReverseKey && !p.Reverse {
+		return carcommand.NeutralKey
+	}
+	gearInt, err := strconv.Atoi(gear)
+	if err == nil && p.MaxGear > 0 && gearInt > p.MaxGear {
+		return strconv.Itoa(p.MaxGear)
+	}
+	return gear
+}
+
+func limitThrow(value int, percent int) int {
+	return carcommand.MixInputMid + (value-carcommand.MixInputMid)*percent/100
+}
+
+// True when the connection logged in as an admin, a profile lifted to admin limits doesn't count
+func loggedInAdmin(header http.Header) bool {
+	claims, err := requestClaims(&http.Request{Header: header})
+	return err == nil && claims.Role == RoleAdmin
+}
+
+// Profile of the role, admin is never limited
+func (s *Server) profileFor(role string) (LimitProfile, bool) {
+	if role == RoleAdmin {
+		return UnlimitedProfile(RoleAdmin), true
+	}
+	for _, profile := range s.config.Profiles {
+		if profile.Name == role {
+			return profile, true
+		}
+	}
+	return LimitProfile{Name: role}, false
+}
+
+// Role given to a user when they log in, users without one get the default role
+func (s *Server) userRole(username string) string {
+	role, found := s.config.UserRoles[username]
+	if !found {
+		return s.config.DefaultRole
+	}
+	return role
+}
+
+// Connections without a valid login token drive with the default role
+func (s *Server) connectionProfile(header http.Header) (string, LimitProfile) {
+	username := ""
+	role := s.config.DefaultRole
+	claims, err := requestClaims(&http.Request{Header: header})
+	if err == nil {
+		username = claims.Username
+		role = claims.Role
+	}
+	profile, found := s.profileFor(role)
+	if !found {
+		log.Printf("warning: role %s has no limit profile, %s can't drive\n", role, username)
+	}
+	return username, profile
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+)
+
+type limitsRequest struct {
+	Connection string `json:"connection"`
+	Profile    string `json:"profile"`
+}
+
+type connectionLimits struct {
+	ID       string       `json:"id"`
+	Username string       `json:"username"`
+	Limits   LimitProfile `json:"limits"`
+}
+
+type limitsResponse struct {
+	Connections []connectionLimits `json:"connections"`
+	Profiles    []LimitProfile     `json:"profiles"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// Lets an admin see and change the limits of connected drivers, changes apply on the next command
+//
+//	GET  /limits  lists connections and profiles
+//	POST /limits  {"connection":"<id>","profile":"kid"}
+func (s *Server) limitsHandler(w http.ResponseWriter, req *http.Request) {
+	claims, err := requestClaims(req)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if claims.Role != RoleAdmin {
+		log.Printf("%s (%s) tried changing limits\n", claims.Username, claims.Role)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	response := limitsResponse{}
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		request := limitsRequest{}
+		err := json.NewDecoder(req.Body).Decode(&request)
+		if err != nil {
+			log.Printf("error decoding limits body: %s", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = s.setConnectionProfile(request.Connection, request.Profile)
+		if err != nil {
+			response.Error = err.Error()
+		} else {
+			log.Printf("%s set %s to %s limits\n", claims.Username, request.Connection, request.Profile)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	response.Connections = s.listConnectionLimits()
+	response.Profiles = append([]LimitProfile{UnlimitedProfile(RoleAdmin)}, s.config.Profiles...)
+	w.Header().Set("Content-Type", "application/json")
+	if response.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) setConnectionProfile(id string, name string) error {
+	profile, found := s.profileFor(name)
+	if !found {
+		return fmt.Errorf("profile %s not found", name)
+	}
+	s.connectionsLock.RLock()
+	connection, ok := s.connections[id]
+	s.connectionsLock.RUnlock()
+	if !ok {
+		return fmt.Errorf("connection %s not found", id)
+	}
+	connection.SetLimits(profile)
+	return nil
+}
+
+func (s *Server) listConnectionLimits() []connectionLimits {
+	s.connectionsLock.RLock()
+	defer s.connectionsLock.RUnlock()
+	connections := make([]connectionLimits, 0, len(s.connections))
+	for id, connection := range s.connections {
+		connections = append(connections, connectionLimits{
+			ID:       id,
+			Username: connection.Username,
+			Limits:   connection.Limits(),
+		})
+	}
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].ID < connections[j].ID
+	})
+	return connections
+}
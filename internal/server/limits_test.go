@@ -0,0 +1,277 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+var kidProfile = LimitProfile{Name: "kid", MaxThrottle: 40, MaxSteer: 50, MaxGear: 2}
+
+func newLimitsServer() *Server {
+	return &Server{
+		commandChannel:   make(chan carcommand.CommandGroup, 1),
+		controlChannel:   make(chan carcommand.ControlCommand, 9),
+		memeSoundChannel: make(chan string, 1),
+		connections:      make(map[string]*Connection),
+		config: SocketServerConfig{
+			ChannelMap:  []ChannelSlot{{Name: "esc", Type: ChannelValue}, {Name: "esc", Type: ChannelGear}, {Name: "steer", Type: ChannelValue}, {Name: "pan", Type: ChannelValue}},
+			Profiles:    []LimitProfile{kidProfile},
+			Users:       map[string]string{"username": "password", "visitor": "letmein"},
+			UserRoles:   map[string]string{"username": RoleAdmin, "visitor": "kid"},
+			DefaultRole: "kid",
+		},
+	}
+}
+
+func TestLimitProfileApply(t *testing.T) {
+	renamed, err := ParseChannelMap("drive:value:throttle,drive:gear,wheel:value:steer,cam:value:pan")
+	if err != nil {
+		t.Fatalf("failed parsing channel map: %s", err)
+	}
+
+	tests := map[string]struct {
+		profile    LimitProfile
+		channelMap []ChannelSlot
+		msg        []byte
+		expected   map[string]carcommand.Command
+		limits     carcommand.DriverLimits
+	}{
+		"admin": {
+			profile: UnlimitedProfile(RoleAdmin),
+			msg:     []byte{255, 6, 0, 200},
+			expected: map[string]carcommand.Command{
+				"esc":   {Value: 255, Gear: "6"},
+				"steer": {Value: 0},
+				"pan":   {Value: 200},
+			},
+			limits: carcommand.DriverLimits{MaxThrottle: 100},
+		},
+		"kid": {
+			profile: kidProfile,
+			msg:     []byte{255, 6, 0, 200},
+			expected: map[string]carcommand.Command{
+				"esc":   {Value: 255, Gear: "2"}, //Throttle is scaled by the car with the command's limits
+				"steer": {Value: 127 - 127*50/100},
+				"pan":   {Value: carcommand.MixInputMid},
+			},
+			limits: carcommand.DriverLimits{MaxThrottle: 40, MaxGear: 2, NoReverse: true},
+		},
+		"kid_reverse": {
+			profile: kidProfile,
+			msg:     []byte{0, gearByteReverse, 127, 127},
+			expected: map[string]carcommand.Command{
+				"esc": {Value: 0, Gear: carcommand.NeutralKey},
+			},
+			limits: carcommand.DriverLimits{MaxThrottle: 40, MaxGear: 2, NoReverse: true},
+		},
+		"kid_braking": {
+			profile: kidProfile,
+			msg:     []byte{0, gearByteHold, 127, 127},
+			expected: map[string]carcommand.Command{
+				"esc":   {Value: 0},
+				"steer": {Value: 127},
+				"pan":   {Value: 127},
+			},
+			limits: carcommand.DriverLimits{MaxThrottle: 40, MaxGear: 2, NoReverse: true},
+		},
+		"no_throttle": {
+			profile: LimitProfile{Name: "guest"},
+			msg:     []byte{0, gearByteHold, 255, 127},
+			expected: map[string]carcommand.Command{
+				"esc":   {Value: carcommand.MixInputMid},
+				"steer": {Value: carcommand.MixInputMid},
+			},
+			limits: carcommand.DriverLimits{NoReverse: true},
+		},
+		"renamed_servos": {
+			profile:    kidProfile,
+			channelMap: renamed,
+			msg:        []byte{255, 6, 0, 200},
+			expected: map[string]carcommand.Command{
+				"drive": {Value: 255, Gear: "2"},
+				"wheel": {Value: 127 - 127*50/100},
+				"cam":   {Value: carcommand.MixInputMid},
+			},
+			limits: carcommand.DriverLimits{MaxThrottle: 40, MaxGear: 2, NoReverse: true},
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			s := newLimitsServer()
+			if tc.channelMap != nil {
+				s.config.ChannelMap = tc.channelMap
+			}
+			s.commandParser(tc.msg, tc.profile)
+			commandGroup := <-s.commandChannel
+			for name, command := range tc.expected {
+				if commandGroup.Commands[name] != command {
+					t.Errorf("expected %s command %+v, got %+v", name, command, commandGroup.Commands[name])
+				}
+			}
+			if commandGroup.Limits == nil || *commandGroup.Limits != tc.limits {
+				t.Errorf("expected limits %+v, got %+v", tc.limits, commandGroup.Limits)
+			}
+			if len(s.controlChannel) != 0 {
+				t.Errorf("expected limits to go with the commands, got %d controls", len(s.controlChannel))
+			}
+		})
+	}
+}
+
+func TestStoppedCarCommandDoesntBlock(t *testing.T) {
+	s := newLimitsServer()
+	s.commandChannel = make(chan carcommand.CommandGroup) //Nothing reads these, like a carcommand that stopped
+	s.controlChannel = make(chan carcommand.ControlCommand)
+
+	done := make(chan struct{})
+	go func() {
+		s.commandParser([]byte{255, 6, 0, 200}, kidProfile)
+		s.Estop("test") //Dropped, retried in the background
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handlers blocked on carcommand")
+	}
+	if s.estopStatus().Estopped {
+		t.Errorf("estop shouldn't latch on the server until the car takes it")
+	}
+	s.estopLock.Lock()
+	retrying := s.estopRetrying
+	s.estopLock.Unlock()
+	if !retrying {
+		t.Errorf("expected the dropped estop to be retried")
+	}
+}
+
+func TestLimitsHandler(t *testing.T) {
+	s := newLimitsServer()
+	s.connections["visitor"] = &Connection{ID: "visitor", limits: kidProfile}
+
+	tokens := make(map[string]string)
+	for _, username := range []string{"username", "visitor"} {
+		token, err := s.generateJWT(username)
+		if err != nil {
+			t.Fatalf("failed generating token: %s", err)
+		}
+		tokens[username] = token
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		method  string
+		body    string
+		code    int
+		profile string
+	}{
+		{name: "no_login", method: http.MethodGet, code: http.StatusUnauthorized, profile: "kid"},
+		{name: "not_admin", user: "visitor", method: http.MethodPost, body: `{"connection":"visitor","profile":"admin"}`, code: http.StatusForbidden, profile: "kid"},
+		{name: "list", user: "username", method: http.MethodGet, code: http.StatusOK, profile: "kid"},
+		{name: "unknown_profile", user: "username", method: http.MethodPost, body: `{"connection":"visitor","profile":"racer"}`, code: http.StatusBadRequest, profile: "kid"},
+		{name: "unknown_connection", user: "username", method: http.MethodPost, body: `{"connection":"gone","profile":"admin"}`, code: http.StatusBadRequest, profile: "kid"},
+		{name: "lift", user: "username", method: http.MethodPost, body: `{"connection":"visitor","profile":"admin"}`, code: http.StatusOK, profile: RoleAdmin},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, "/limits", strings.NewReader(tc.body))
+		if tc.user != "" {
+			req.AddCookie(&http.Cookie{Name: "token", Value: tokens[tc.user]})
+		}
+		w := httptest.NewRecorder()
+		s.limitsHandler(w, req)
+		if w.Code != tc.code {
+			t.Errorf("%s: expected code %d, got %d", tc.name, tc.code, w.Code)
+		}
+		if name := s.connections["visitor"].Limits().Name; name != tc.profile {
+			t.Errorf("%s: expected %s limits, got %s", tc.name, tc.profile, name)
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		response := limitsResponse{}
+		err := json.NewDecoder(w.Body).Decode(&response)
+		if err != nil {
+			t.Fatalf("%s: failed decoding response: %s", tc.name, err)
+		}
+		if len(response.Connections) != 1 || len(response.Profiles) != 2 {
+			t.Errorf("%s: expected 1 connection and 2 profiles, got %+v", tc.name, response)
+		}
+	}
+}
+
+func TestConnectionProfile(t *testing.T) {
+	s := newLimitsServer()
+	adminToken, err := s.generateJWT("username")
+	if err != nil {
+		t.Fatalf("failed generating token: %s", err)
+	}
+	visitorToken, err := s.generateJWT("visitor")
+	if err != nil {
+		t.Fatalf("failed generating token: %s", err)
+	}
+
+	tests := map[string]struct {
+		cookie  string
+		profile LimitProfile
+		admin   bool
+	}{
+		"no_login":  {profile: kidProfile},
+		"bad_token": {cookie: "token=nonsense", profile: kidProfile},
+		"visitor":   {cookie: "token=" + visitorToken, profile: kidProfile},
+		"admin":     {cookie: "token=" + adminToken, profile: UnlimitedProfile(RoleAdmin), admin: true},
+	}
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			header := http.Header{}
+			if tc.cookie != "" {
+				header.Set("Cookie", tc.cookie)
+			}
+			_, profile := s.connectionProfile(header)
+			if profile != tc.profile {
+				t.Errorf("expected %+v, got %+v", tc.profile, profile)
+			}
+			if loggedInAdmin(header) != tc.admin {
+				t.Errorf("expected logged in admin %t", tc.admin)
+			}
+		})
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	s := newLimitsServer()
+	tests := map[string]struct {
+		creds Credentials
+		valid bool
+	}{
+		"admin":          {creds: Credentials{Username: "username", Password: "password"}, valid: true},
+		"visitor":        {creds: Credentials{Username: "visitor", Password: "letmein"}, valid: true},
+		"wrong_password": {creds: Credentials{Username: "visitor", Password: "password"}},
+		"unknown_user":   {creds: Credentials{Username: "stranger", Password: "password"}},
+		"no_password":    {creds: Credentials{Username: "visitor"}},
+	}
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := s.validateCredentials(tc.creds)
+			if (err == nil) != tc.valid {
+				t.Errorf("expected valid %t, got %v", tc.valid, err)
+			}
+		})
+	}
+
+	users, err := ParseUsers("username:password, visitor:pass:word")
+	if err != nil || users["username"] != "password" || users["visitor"] != "pass:word" {
+		t.Errorf("unexpected users %v (%v)", users, err)
+	}
+	if _, err := ParseUsers("visitor"); err == nil {
+		t.Errorf("expected a user without a password to be rejected")
+	}
+}
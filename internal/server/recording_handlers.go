@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+const recordingTimeout = 2 * time.Second
+
+type recordingResponse struct {
+	Status     carcommand.RecordingStatus `json:"status"`
+	Recordings []carcommand.RecordingInfo `json:"recordings,omitempty"`
+	Error      string                     `json:"error,omitempty"`
+}
+
+// Handles the drive recordings
+//
+//	GET  /recordings/        lists the saved recordings
+//	POST /recordings/record  {"name":"slalom"}
+//	POST /recordings/play    {"name":"slalom"}
+//	POST /recordings/stop    stops recording and replaying
+//
+// Every response has the recording status after the request.
+// Admins only, replays drive the car without a driver's limits.
+func (s *Server) recordingHandler(w http.ResponseWriter, req *http.Request) {
+	claims, err := requestClaims(req)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if claims.Role != RoleAdmin {
+		log.Printf("%s (%s) tried using recordings\n", claims.Username, claims.Role)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	request := carcommand.RecordingRequest{}
+	action := strings.TrimPrefix(req.URL.Path, "/recordings/")
+	if action == "" || action == carcommand.RecordList {
+		action = carcommand.RecordList
+	} else {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		err = json.NewDecoder(req.Body).Decode(&request)
+		if err != nil && !errors.Is(err, io.EOF) { //Empty body is fine for stop
+			log.Printf("error decoding recording body: %s", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	request.Action = action
+
+	result, err := s.sendRecordingRequest(request)
+	if err != nil {
+		log.Printf("recording %s failed: %s", action, err.Error())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	response := recordingResponse{
+		Status:     result.Status,
+		Recordings: result.Recordings,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if result.Err != nil {
+		response.Error = result.Err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// Sends the request to the command loop and waits for its answer
+func (s *Server) sendRecordingRequest(request carcommand.RecordingRequest) (carcommand.RecordingResult, error) {
+	request.Reply = make(chan carcommand.RecordingResult, 1)
+	timeout := time.NewTimer(recordingTimeout)
+	defer timeout.Stop()
+
+	select {
+	case s.recordingChannel <- request:
+	case <-timeout.C:
+		return carcommand.RecordingResult{}, fmt.Errorf("carcommand not accepting recording requests")
+	}
+	select {
+	case result := <-request.Reply:
+		return result, nil
+	case <-timeout.C:
+		return carcommand.RecordingResult{}, fmt.Errorf("no answer from carcommand")
+	}
+}
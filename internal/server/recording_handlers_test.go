@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+)
+
+func TestRecordingHandler(t *testing.T) {
+	s := &Server{
+		recordingChannel: make(chan carcommand.RecordingRequest, 1),
+		config: SocketServerConfig{
+			UserRoles:   map[string]string{"username": RoleAdmin, "visitor": "kid"},
+			DefaultRole: "kid",
+		},
+	}
+	tokens := make(map[string]string)
+	for _, username := range []string{"username", "visitor"} {
+		token, err := s.generateJWT(username)
+		if err != nil {
+			t.Fatalf("failed generating token: %s", err)
+		}
+		tokens[username] = token
+	}
+	go func() {
+		for request := range s.recordingChannel {
+			result := carcommand.RecordingResult{}
+			switch request.Action {
+			case carcommand.RecordList:
+				result.Recordings = []carcommand.RecordingInfo{{Name: "slalom"}}
+			case carcommand.RecordPlay:
+				result.Status.Playing = request.Name
+			default:
+				result.Err = fmt.Errorf("unsupported recording action (%s)", request.Action)
+			}
+			request.Reply <- result
+		}
+	}()
+	defer close(s.recordingChannel)
+
+	tests := map[string]struct {
+		user    string
+		method  string
+		path    string
+		body    string
+		code    int
+		playing string
+		listed  int
+	}{
+		"no_login": {
+			method: http.MethodPost,
+			path:   "/recordings/play",
+			body:   `{"name":"slalom"}`,
+			code:   http.StatusUnauthorized,
+		},
+		"not_admin": {
+			user:   "visitor",
+			method: http.MethodPost,
+			path:   "/recordings/play",
+			body:   `{"name":"slalom"}`,
+			code:   http.StatusForbidden,
+		},
+		"list": {
+			user:   "username",
+			method: http.MethodGet,
+			path:   "/recordings/",
+			code:   http.StatusOK,
+			listed: 1,
+		},
+		"play": {
+			user:    "username",
+			method:  http.MethodPost,
+			path:    "/recordings/play",
+			body:    `{"name":"slalom"}`,
+			code:    http.StatusOK,
+			playing: "slalom",
+		},
+		"play_get": {
+			user:   "username",
+			method: http.MethodGet,
+			path:   "/recordings/play",
+			code:   http.StatusMethodNotAllowed,
+		},
+		"car_error": {
+			user:   "username",
+			method: http.MethodPost,
+			path:   "/recordings/rewind",
+			code:   http.StatusBadRequest,
+		},
+	}
+
+	for testName, tc := range tests {
+		t.Run(testName, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.user != "" {
+				req.AddCookie(&http.Cookie{Name: "token", Value: tokens[tc.user]})
+			}
+			recorder := httptest.NewRecorder()
+			s.recordingHandler(recorder, req)
+			if recorder.Code != tc.code {
+				t.Fatalf("expected code %d, got %d", tc.code, recorder.Code)
+			}
+			if tc.code != http.StatusOK && tc.code != http.StatusBadRequest {
+				return
+			}
+			response := recordingResponse{}
+			err := json.NewDecoder(recorder.Body).Decode(&response)
+			if err != nil {
+				t.Fatalf("failed decoding response: %s", err)
+			}
+			if response.Status.Playing != tc.playing || len(response.Recordings) != tc.listed {
+				t.Errorf("unexpected response %+v", response)
+			}
+		})
+	}
+}
@@ -1,11 +1,16 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
+	"github.com/Speshl/goremotecontrol_web/internal/sensors"
 	socketio "github.com/googollee/go-socket.io"
 	"github.com/googollee/go-socket.io/engineio"
 	"github.com/googollee/go-socket.io/engineio/transport"
@@ -14,13 +19,19 @@ import (
 	"github.com/pion/webrtc/v3"
 )
 
+const controlTimeout = 200 * time.Millisecond //carcommand reads controls every tick unless it isn't running
+
 type ClientAudioTrackPlayer func(*webrtc.TrackRemote, *webrtc.RTPReceiver)
 
 type Server struct {
-	carAudioTrack    *webrtc.TrackLocalStaticSample
-	carVideoTrack    *webrtc.TrackLocalStaticSample
-	commandChannel   chan carcommand.CommandGroup
-	memeSoundChannel chan string
+	carAudioTrack      *webrtc.TrackLocalStaticSample
+	carVideoTrack      *webrtc.TrackLocalStaticSample
+	commandChannel     chan carcommand.CommandGroup
+	controlChannel     chan carcommand.ControlCommand
+	calibrationChannel chan carcommand.CalibrationRequest
+	recordingChannel   chan carcommand.RecordingRequest
+	eventChannel       chan carcommand.Event
+	memeSoundChannel   chan string
 
 	clientAudioTrackPlayer ClientAudioTrackPlayer
 
@@ -28,19 +39,35 @@ type Server struct {
 	connections     map[string]*Connection
 	connectionsLock sync.RWMutex
 
+	estop         estopState
+	estopLock     sync.Mutex
+	estopSendLock sync.Mutex //Held while an estop change goes to carcommand, estopLock isn't
+	estopRetrying bool       //A dropped estop is being resent, guarded by estopLock
+
+	commandsDropped atomic.Bool //Logged once when carcommand stops taking commands
+
+	actuatorsOffline     *carcommand.Event //Last offline event until the car says it's back, sent to new clients
+	actuatorsOfflineLock sync.Mutex
+
 	config SocketServerConfig
 }
 
 type SocketServerConfig struct {
 	SilentConnects bool
 	ForceLocal     bool
+	ChannelMap     []ChannelSlot
+	Profiles       []LimitProfile
+	Users          map[string]string //Password of each user that can log in
+	UserRoles      map[string]string //Role of each user that can log in
+	DefaultRole    string            //Role of connections that didn't log in and users without a role
+	EstopSound     string            //Sound group played when the estop latches, empty plays nothing
 }
 
 var allowOriginFunc = func(r *http.Request) bool {
 	return true
 }
 
-func NewSocketServer(cfg SocketServerConfig, audioTrack *webrtc.TrackLocalStaticSample, videoTrack *webrtc.TrackLocalStaticSample, commandChannel chan carcommand.CommandGroup, memeSoundChannel chan string, audioPlayer ClientAudioTrackPlayer) *Server {
+func NewSocketServer(cfg SocketServerConfig, audioTrack *webrtc.TrackLocalStaticSample, videoTrack *webrtc.TrackLocalStaticSample, commandChannel chan carcommand.CommandGroup, controlChannel chan carcommand.ControlCommand, calibrationChannel chan carcommand.CalibrationRequest, recordingChannel chan carcommand.RecordingRequest, eventChannel chan carcommand.Event, memeSoundChannel chan string, audioPlayer ClientAudioTrackPlayer) *Server {
 	socketioServer := socketio.NewServer(&engineio.Options{
 		Transports: []transport.Transport{
 			&polling.Transport{
@@ -58,6 +85,10 @@ func NewSocketServer(cfg SocketServerConfig, audioTrack *webrtc.TrackLocalStatic
 
 		memeSoundChannel:       memeSoundChannel,
 		commandChannel:         commandChannel,
+		controlChannel:         controlChannel,
+		calibrationChannel:     calibrationChannel,
+		recordingChannel:       recordingChannel,
+		eventChannel:           eventChannel,
 		carAudioTrack:          audioTrack,
 		carVideoTrack:          videoTrack,
 		clientAudioTrackPlayer: audioPlayer,
@@ -73,6 +104,102 @@ func (s *Server) Serve() error {
 	return s.socketio.Serve()
 }
 
+// Sends every car event to all connected clients until the context is done
+func (s *Server) ForwardEvents(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-s.eventChannel:
+			if !ok {
+				return
+			}
+			log.Printf("car event (%s): %s\n", event.Type, event.Message)
+			s.trackActuators(event)
+			s.trackEstop(event)
+			encodedEvent, err := encode(event)
+			if err != nil {
+				log.Printf("error encoding event: %s\n", err.Error())
+				continue
+			}
+			s.socketio.BroadcastToNamespace("/", "event", encodedEvent)
+		}
+	}
+}
+
+// Remembers whether the car can be driven so clients that connect later are told
+func (s *Server) trackActuators(event carcommand.Event) {
+	s.actuatorsOfflineLock.Lock()
+	defer s.actuatorsOfflineLock.Unlock()
+	switch event.Type {
+	case carcommand.EventActuatorsOffline:
+		s.actuatorsOffline = &event
+	case carcommand.EventActuatorsOnline:
+		s.actuatorsOffline = nil
+	}
+}
+
+func (s *Server) emitActuators(socketConn socketio.Conn) {
+	s.actuatorsOfflineLock.Lock()
+	event := s.actuatorsOffline
+	s.actuatorsOfflineLock.Unlock()
+	if event == nil {
+		return
+	}
+	encodedEvent, err := encode(*event)
+	if err != nil {
+		log.Printf("error encoding actuators event: %s\n", err.Error())
+		return
+	}
+	socketConn.Emit("event", encodedEvent)
+}
+
+// Sends every sensor reading to all connected clients until the context is done
+func (s *Server) ForwardSensorReadings(ctx context.Context, readings <-chan sensors.Reading) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case reading, ok := <-readings:
+			if !ok {
+				return
+			}
+			encodedReading, err := encode(reading)
+			if err != nil {
+				log.Printf("error encoding %s reading: %s\n", reading.Sensor, err.Error())
+				continue
+			}
+			s.socketio.BroadcastToNamespace("/", "sensor", encodedReading)
+		}
+	}
+}
+
+// Waits a moment for carcommand to take the control, handlers never block on a carcommand that stopped
+func (s *Server) sendControl(control carcommand.ControlCommand) error {
+	timeout := time.NewTimer(controlTimeout)
+	defer timeout.Stop()
+	select {
+	case s.controlChannel <- control:
+		return nil
+	case <-timeout.C:
+		return fmt.Errorf("carcommand not accepting controls, %s dropped", control.Type)
+	}
+}
+
+// Commands come many times a second, so one carcommand can't take right away is dropped for the next
+func (s *Server) sendCommand(commandGroup carcommand.CommandGroup) {
+	select {
+	case s.commandChannel <- commandGroup:
+		if s.commandsDropped.Swap(false) {
+			log.Println("carcommand taking commands again")
+		}
+	default:
+		if !s.commandsDropped.Swap(true) {
+			log.Println("warning: carcommand not taking commands, dropping them until it does")
+		}
+	}
+}
+
 func (s *Server) GetHandler() *socketio.Server {
 	return s.socketio
 }
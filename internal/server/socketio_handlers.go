@@ -1,6 +1,7 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"strconv"
@@ -10,6 +11,12 @@ import (
 	"github.com/pion/webrtc/v3"
 )
 
+// Values of the gear byte in a command that aren't gear numbers
+const gearByteNeutral = 0
+const gearByteDrive = 253 //Automatic transmission picks the forward gear
+const gearByteHold = 254
+const gearByteReverse = 255
+
 func (s *Server) RegisterSocketIOHandlers() {
 	s.socketio.OnConnect("/", s.onConnect)
 
@@ -19,6 +26,10 @@ func (s *Server) RegisterSocketIOHandlers() {
 
 	s.socketio.OnEvent("/", "command", s.onCommand)
 
+	s.socketio.OnEvent("/", "control", s.onControl)
+
+	s.socketio.OnEvent("/", "estop", s.onEstop)
+
 	s.socketio.OnDisconnect("/", s.OnDisconnect)
 
 	s.socketio.OnError("/", s.onError)
@@ -32,10 +43,22 @@ func (s *Server) onConnect(socketConn socketio.Conn) error {
 	if err != nil {
 		return fmt.Errorf("failed creating new client: %w", err)
 	}
+	username, profile := s.connectionProfile(socketConn.RemoteHeader())
+	conn.Username = username
+	conn.SetLimits(profile)
+	log.Printf("client %s driving as %s with %s limits\n", id, username, profile.Name)
 
 	s.connectionsLock.Lock()
 	s.connections[id] = conn
 	s.connectionsLock.Unlock()
+
+	encodedChannelMap, err := encode(s.config.ChannelMap)
+	if err != nil {
+		return fmt.Errorf("failed encoding channel map: %w", err)
+	}
+	socketConn.Emit("channelmap", encodedChannelMap) //Client builds its commands and UI from this
+	s.emitEstop(socketConn)
+	s.emitActuators(socketConn)
 	return nil
 }
 
@@ -64,68 +87,122 @@ func (s *Server) onICECandidate(socketConn socketio.Conn, msg []byte) {
 
 func (s *Server) onCommand(socketConn socketio.Conn, msg []byte) {
 	//log.Printf("candidate recieved from client: %s", socketConn.ID())
-	s.commandParser(msg)
+	limits, ok := s.connectionLimits(socketConn.ID())
+	if !ok {
+		log.Printf("command from unknown client: %s", socketConn.ID())
+		return
+	}
+	s.commandParser(msg, limits)
+}
+
+func (s *Server) connectionLimits(id string) (LimitProfile, bool) {
+	s.connectionsLock.RLock()
+	connection, ok := s.connections[id]
+	s.connectionsLock.RUnlock()
+	if !ok {
+		return LimitProfile{}, false
+	}
+	return connection.Limits(), true
+}
+
+func (s *Server) onControl(socketConn socketio.Conn, msg string) {
+	control := carcommand.ControlCommand{}
+	err := json.Unmarshal([]byte(msg), &control)
+	if err != nil {
+		log.Printf("control from %s failed unmarshaling: %s\n", socketConn.ID(), msg)
+		return
+	}
+	switch control.Type {
+	case carcommand.ControlEstop, carcommand.ControlEstopClear:
+		log.Printf("control from %s tried the estop, it has its own event\n", socketConn.ID())
+		return
+	}
+	_, ok := s.connectionLimits(socketConn.ID())
+	if !ok {
+		log.Printf("control from unknown client: %s", socketConn.ID())
+		return
+	}
+	if control.Type == carcommand.ControlCollision && !loggedInAdmin(socketConn.RemoteHeader()) {
+		log.Printf("control from %s tried switching collision braking\n", socketConn.ID())
+		return
+	}
+	err = s.sendControl(control)
+	if err != nil {
+		log.Printf("warning: control from %s not sent - %s\n", socketConn.ID(), err.Error())
+	}
 }
 
 func (s *Server) OnDisconnect(socketConn socketio.Conn, reason string) {
 	log.Printf("socketio connection disconnected (%s): %s\n", reason, socketConn.ID())
 	s.RemoveClient(socketConn.ID())
+
+	select {
+	case s.controlChannel <- carcommand.ControlCommand{Type: carcommand.ControlCruiseCancel}: //Nobody left holding the throttle
+	default:
+		log.Println("warning: control channel full, cruise not cancelled on disconnect")
+	}
 }
 
 func (s *Server) onError(socketConn socketio.Conn, err error) {
 	log.Printf("socketio connection %s error: %s\n", socketConn.ID(), err.Error())
 }
 
-func (s *Server) commandParser(msg []byte) {
-	if len(msg) != 6 {
-		log.Println("error: command is incorrect length")
+// Commands are limited by the sending driver's profile before they reach the car
+func (s *Server) commandParser(msg []byte, limits LimitProfile) {
+	if len(msg) != len(s.config.ChannelMap) {
+		log.Printf("error: command is incorrect length (expected %d got %d)\n", len(s.config.ChannelMap), len(msg))
 		return
 	}
 
 	commandGroup := carcommand.CommandGroup{
-		Commands: make(map[string]carcommand.Command, 4),
+		Commands: make(map[string]carcommand.Command, len(msg)),
 	}
 
-	gear := "N"
-	if msg[1] == 255 {
-		gear = "R"
-	} else if msg[1] == 0 {
-		gear = "N"
-	} else if msg[1] > 0 && msg[1] < 7 {
-		gear = strconv.Itoa(int(msg[1]))
-	}
-	commandGroup.Commands["esc"] = carcommand.Command{
-		Value: int(msg[0]),
-		Gear:  gear,
+	sound := byte(0)
+	for i, slot := range s.config.ChannelMap {
+		command := commandGroup.Commands[slot.Name]
+		switch slot.Type {
+		case ChannelValue:
+			command.Value = int(msg[i])
+		case ChannelGear:
+			command.Gear = getGear(msg[i])
+		case ChannelCruise:
+			if msg[i] > carcommand.CruiseCancel {
+				log.Printf("error: invalid cruise command (%d)\n", msg[i])
+				break
+			}
+			command.Cruise = int(msg[i])
+		case ChannelSound:
+			sound = msg[i]
+			continue
+		}
+		commandGroup.Commands[slot.Name] = command
 	}
 
-	commandGroup.Commands["steer"] = carcommand.Command{
-		Value: int(msg[2]),
-	}
+	commandGroup = limits.apply(commandGroup, ChannelInputs(s.config.ChannelMap))
+	s.sendCommand(commandGroup) //servo slots go to carCommand with the driver's limits
 
-	commandGroup.Commands["pan"] = carcommand.Command{
-		Value: int(msg[3]),
+	if sound == 0 {
+		return
 	}
-
-	commandGroup.Commands["tilt"] = carcommand.Command{
-		Value: int(msg[4]),
+	if int(sound) >= len(soundGroups) {
+		log.Println("error: invalid sound command")
+		return
 	}
+	s.memeSoundChannel <- soundGroups[sound]
+}
 
-	s.commandChannel <- commandGroup //first 4 bytes go to carCommand
-
-	//5th byte is a sound signal
-	switch msg[5] {
-	case 0:
-		break
-	case 1:
-		s.memeSoundChannel <- "affirmative"
-	case 2:
-		s.memeSoundChannel <- "negative"
-	case 3:
-		s.memeSoundChannel <- "aggressive"
-	case 4:
-		s.memeSoundChannel <- "sorry"
+func getGear(gearByte byte) string {
+	switch gearByte {
+	case gearByteReverse:
+		return "R"
+	case gearByteDrive:
+		return "D"
+	case gearByteHold:
+		return "" //Car keeps the gear it was shifted into with upshift/downshift controls
+	case gearByteNeutral:
+		return "N"
 	default:
-		log.Println("error: invalid sound command")
+		return strconv.Itoa(int(gearByte))
 	}
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/Speshl/goremotecontrol_web/internal/carmic"
 	"github.com/Speshl/goremotecontrol_web/internal/carspeaker"
 	"github.com/Speshl/goremotecontrol_web/internal/config"
+	"github.com/Speshl/goremotecontrol_web/internal/sensors"
 	"github.com/Speshl/goremotecontrol_web/internal/server"
 )
 
@@ -27,11 +28,17 @@ type App struct {
 	cam          *carcam.CarCam
 	command      *carcommand.CarCommand
 	socketServer *server.Server
+
+	sensorReadings chan sensors.Reading
+	imu            *sensors.IMUMonitor        //nil without an imu
+	wheelSpeed     *sensors.WheelSpeedMonitor //nil without a speed sensor
+	distances      []*sensors.DistanceMonitor
 }
 
 func main() {
 	app := App{
-		done: make(chan os.Signal, 1),
+		done:           make(chan os.Signal, 1),
+		sensorReadings: make(chan sensors.Reading, 20),
 	}
 
 	log.Println("starting server...")
@@ -45,7 +52,13 @@ func main() {
 	}()
 
 	app.ctx, app.cancel = context.WithCancel(context.Background())
-	app.config = config.GetConfig(app.ctx)
+	carConfig, err := config.GetConfig(app.ctx)
+	if err != nil {
+		app.cancel()
+		app.done <- os.Kill
+		log.Fatalf("failed loading config - %s", err)
+	}
+	app.config = carConfig
 
 	//Start audio recieve pipeline listener
 	app.StartGStreamerPipelines()
@@ -54,7 +67,7 @@ func main() {
 	if err != nil {
 		app.cancel()
 		app.done <- os.Kill
-		log.Fatalf("failed starting speaker - %w", err)
+		log.Fatalf("failed starting speaker - %s", err)
 	}
 	app.speaker = carspeaker
 
@@ -71,7 +84,7 @@ func main() {
 	if err != nil {
 		app.cancel()
 		app.done <- os.Kill
-		log.Fatalf("failed starting mic - %w", err)
+		log.Fatalf("failed starting mic - %s", err)
 	}
 	app.mic = carmic
 
@@ -79,7 +92,7 @@ func main() {
 	if err != nil {
 		app.cancel()
 		app.done <- os.Kill
-		log.Fatalf("failed starting mic - %w", err)
+		log.Fatalf("failed starting cam - %s", err)
 	}
 	app.cam = carCam
 
@@ -87,9 +100,11 @@ func main() {
 	time.Sleep(2 * time.Second)
 
 	app.command = app.StartCommand()
+	app.StartSensors()
 
 	app.socketServer = app.StartSocketServer()
 	defer app.socketServer.Close()
+	app.StartEstopSignal()
 
 	app.StartHTTPServer()
 
@@ -15,8 +15,140 @@ setTimeout(() => {
 }, 1000);
 
 const keyPressTracker = new KeyPressTracker();
+
+//Events the car sends on its own (failsafe, recovery, gear changes)
+camPlayer.getSocket().on('event', (encodedEvent) => {
+    let event = JSON.parse(atob(encodedEvent));
+    console.log("Car event: ", event);
+    if(event.type == 'gear'){
+        keyPressTracker.setGear(event.message);
+        gamePadTracker.setGear(event.message);
+        return;
+    }
+    if(event.type == 'cruise'){
+        document.getElementById('cruiseState').innerHTML = event.message;
+        return;
+    }
+    if(event.type == 'gyro'){
+        document.getElementById('gyroState').innerHTML = event.message;
+        return;
+    }
+    if(event.type == 'collision'){
+        document.getElementById('collisionState').innerHTML = event.message;
+        return;
+    }
+    if(event.type == 'estop'){
+        document.getElementById('estopState').innerHTML = 'LATCHED: ' + event.message;
+    }
+    if(event.type == 'estop_cleared'){
+        document.getElementById('estopState').innerHTML = 'off';
+    }
+    document.getElementById('carEvent').innerHTML = event.type + ': ' + event.message;
+});
+
+//Readings the car's sensors publish while it runs
+const proximity = {}; //Latest distance for each side, sides report separately
+camPlayer.getSocket().on('sensor', (encodedReading) => {
+    let reading = JSON.parse(atob(encodedReading));
+    if(reading.sensor == 'battery'){
+        let values = reading.values;
+        if(reading.state == 'error'){
+            document.getElementById('batteryState').innerHTML = 'sensor error';
+            return;
+        }
+        document.getElementById('batteryState').innerHTML = values.voltage.toFixed(2) + 'V (' + values.cellVoltage.toFixed(2) + 'V/cell) '
+            + values.current.toFixed(1) + 'A ' + values.percent.toFixed(0) + '% ' + reading.state;
+    }
+    if(reading.sensor == 'imu'){
+        if(reading.state == 'error'){
+            document.getElementById('imuState').innerHTML = 'sensor error';
+            return;
+        }
+        document.getElementById('imuState').innerHTML = 'roll ' + reading.values.roll.toFixed(0) + ' pitch '
+            + reading.values.pitch.toFixed(0) + ' ' + reading.state;
+    }
+    if(reading.sensor == 'distance'){
+        if(reading.state == 'error'){
+            document.getElementById('distanceState').innerHTML = 'sensor error';
+            return;
+        }
+        for(const side in reading.values){
+            proximity[side] = reading.values[side];
+        }
+        document.getElementById('distanceState').innerHTML = Object.keys(proximity).map((side) => side + ' ' + proximity[side].toFixed(2) + 'm').join(' ');
+    }
+    if(reading.sensor == 'wheelspeed'){
+        if(reading.state == 'error'){
+            document.getElementById('speedState').innerHTML = 'sensor error';
+            return;
+        }
+        document.getElementById('speedState').innerHTML = reading.values.speed.toFixed(2) + 'm/s '
+            + reading.values.distance.toFixed(0) + 'm';
+    }
+});
 const gamePadTracker = new GamePadTracker();
 
+//Anyone driving can latch the estop, only an admin can clear it
+function sendEstop() {
+    camPlayer.getSocket().emit('estop', 'stop button');
+}
+document.getElementById('estopButton').addEventListener('click', sendEstop);
+document.addEventListener('keydown', (event) => {
+    if(event.key == 'Escape'){
+        sendEstop();
+    }
+});
+
+//Slots of the command the car expects, sent by the car when we connect
+let channelMap = [
+    {name: "esc", type: "value"},
+    {name: "esc", type: "gear"},
+    {name: "steer", type: "value"},
+    {name: "pan", type: "value"},
+    {name: "tilt", type: "value"},
+    {name: "sound", type: "sound"},
+];
+//Where each slot is in the command the trackers build
+const trackerSlots = {"esc:value": 0, "esc:gear": 1, "steer:value": 2, "pan:value": 3, "tilt:value": 4, "sound:sound": 5, "esc:cruise": 6};
+
+camPlayer.getSocket().on('channelmap', (encodedChannelMap) => {
+    channelMap = JSON.parse(atob(encodedChannelMap));
+    console.log("Channel map: ", channelMap);
+    buildChannelInfo();
+});
+
+//One info item per channel the car has
+function buildChannelInfo() {
+    let container = document.getElementById('channelInfo');
+    container.innerHTML = '';
+    channelMap.forEach((slot, i) => {
+        let item = document.createElement('div');
+        item.className = 'infoItem';
+        let label = document.createElement('div');
+        label.innerHTML = slot.name + ' (' + slot.type + ')';
+        let value = document.createElement('div');
+        value.id = 'channel' + i;
+        item.appendChild(label);
+        item.appendChild(value);
+        container.appendChild(item);
+    });
+}
+buildChannelInfo();
+
+//Puts the tracker's command into the car's channel layout, channels the trackers don't know sit at neutral
+function mapCommand(command) {
+    return channelMap.map((slot) => {
+        let index = trackerSlots[slot.name + ':' + slot.type];
+        if (index !== undefined && command[index] !== undefined) {
+            return command[index];
+        }
+        if (slot.type == 'value') {
+            return 127;
+        }
+        return 0;
+    });
+}
+
 //Start listener loop for input commands
 setInterval(() => {
     let gamePad = gamePadTracker.getGamePad();
@@ -41,8 +173,22 @@ setInterval(() => {
     document.getElementById('steerAndTrim').innerHTML = 'Steer: ' + command[2] + ' Trim: ' + trim;
     document.getElementById('panAndTilt').innerHTML = 'Pan: ' + command[3] + ' Tilt: ' + command[4];
 
+    command = mapCommand(command);
+    command.forEach((value, i) => {
+        let channelValue = document.getElementById('channel' + i);
+        if (channelValue != null) {
+            channelValue.innerHTML = value;
+        }
+    });
+
     //Send the command we generated
     if (camPlayer.gotRemoteDescription()) {
         camPlayer.getSocket().emit('command', command);
+        keyPressTracker.getControls().forEach((control) => {
+            camPlayer.getSocket().emit('control', JSON.stringify(control));
+        });
+        gamePadTracker.getControls().forEach((control) => {
+            camPlayer.getSocket().emit('control', JSON.stringify(control));
+        });
     }
 }, 5);
\ No newline at end of file
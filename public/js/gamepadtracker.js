@@ -8,13 +8,15 @@ class GamePadTracker {
         this.tiltSpeed = 2;
 
         this.neutralGear = 0;
+        this.driveGear = 253; //Automatic transmission picks the forward gear
+        this.holdGear = 254; //Car tracks the gear, we only send upshift/downshift controls
         this.reverseGear = 255;
-        this.maxGears = 6;
 
         this.neutralCommand = [this.midPosition, this.neutralGear, this.midPosition, this.midPosition, this.midPosition, 0];
         this.panPos = this.midPosition;
         this.tiltPos = this.midPosition;
-        this.currentGear = this.neutralGear;
+        this.currentGear = "N"; //Last gear the car told us about
+        this.pendingControls = [];
         this.inManualGear = false;
 
         this.gamepadIndex = -1;
@@ -99,14 +101,19 @@ class GamePadTracker {
         return this.steeringTrim;
     }
 
+    //Controls change how the car responds and are sent separately from the command
+    getControls() {
+        let controls = this.pendingControls;
+        this.pendingControls = [];
+        return controls;
+    }
+
     getGearString() {
-        if(this.currentGear == this.neutralGear){
-            return "N";
-        }else if(this.currentGear == this.reverseGear){
-            return "R";
-        }else{
-            return ""+this.currentGear;
-        }
+        return this.currentGear;
+    }
+
+    setGear(gear) {
+        this.currentGear = gear;
     }
 
     getControllerName(myGamepad){
@@ -126,24 +133,11 @@ class GamePadTracker {
     }
 
     upShift() {
-        if(this.currentGear == this.reverseGear){
-            this.currentGear = this.neutralGear;
-        }else if(this.currentGear == this.neutralGear){
-            this.currentGear = 1;
-        }else if(this.currentGear >=0 && this.currentGear <this.maxGears){
-            this.currentGear++;
-        }
+        this.pendingControls.push({type: 'upshift', servo: 'esc', value: 0});
     }
 
     downShift() {
-        if(this.currentGear == this.neutralGear){
-            this.currentGear = this.reverseGear;
-        }else if(this.currentGear == 1){
-            this.currentGear = this.neutralGear;
-        }
-        else if(this.currentGear > 1 && this.currentGear <= this.maxGears){
-            this.currentGear--;
-        }
+        this.pendingControls.push({type: 'downshift', servo: 'esc', value: 0});
     }
 
     volumeUp() {
@@ -261,7 +255,7 @@ class GamePadTracker {
             this.downShiftPress = false;
         }
 
-        command[1] = this.currentGear;
+        command[1] = this.holdGear;
 
         //servo
         let steerCommand = command[1];
@@ -8,13 +8,19 @@ class KeyPressTracker {
         this.tiltSpeed = 1;
 
         this.neutralGear = 0;
+        this.holdGear = 254; //Car tracks the gear, we only send upshift/downshift controls
         this.reverseGear = 255;
-        this.maxGears = 6;
 
-        this.neutralCommand = [this.midPosition,this.neutralGear,this.midPosition,this.midPosition,this.midPosition,0];
+        this.neutralCommand = [this.midPosition,this.neutralGear,this.midPosition,this.midPosition,this.midPosition,0,0];
+
+        //Cruise buttons, the car acts when the byte changes
+        this.cruiseNone = 0;
+        this.cruiseToggle = 1;
+        this.cruiseUp = 2;
+        this.cruiseDown = 3;
         this.panPos = this.midPosition;
         this.tiltPos = this.midPosition;
-        this.currentGear = this.neutralGear;
+        this.currentGear = "N"; //Last gear the car told us about
 
         this.pressedKeys = {};
         this.steeringTrim = 0;
@@ -35,6 +41,19 @@ class KeyPressTracker {
         this.minTrim = -50;
         this.maxTrim = 50;
 
+        this.ratePress = false;
+        this.curvePress = false;
+        this.steerRate = 0;
+        this.steerCurve = true;
+        this.gyroPress = false;
+        this.gyroGainDownPress = false;
+        this.gyroGainUpPress = false;
+        this.gyroEnabled = true;
+        this.gyroGain = 50; //Percent, the first change replaces the car's configured gain
+        this.collisionPress = false;
+        this.collisionEnabled = true; //Only admins can switch it, the server drops it from everyone else
+        this.pendingControls = [];
+
         // Event listener for keydown event
         document.addEventListener('keydown', (event) => {
             const key = event.key;
@@ -59,14 +78,19 @@ class KeyPressTracker {
         return this.steeringTrim;
     }
 
+    //Controls change how the car responds and are sent separately from the command
+    getControls() {
+        let controls = this.pendingControls;
+        this.pendingControls = [];
+        return controls;
+    }
+
     getGearString() {
-        if(this.currentGear == this.neutralGear){
-            return "N";
-        }else if(this.currentGear == this.reverseGear){
-            return "R";
-        }else{
-            return ""+this.currentGear;
-        }
+        return this.currentGear;
+    }
+
+    setGear(gear) {
+        this.currentGear = gear;
     }
 
     volumeUp() {
@@ -123,24 +147,11 @@ class KeyPressTracker {
     }
 
     upShift() {
-        if(this.currentGear == this.reverseGear){
-            this.currentGear = this.neutralGear;
-        }else if(this.currentGear == this.neutralGear){
-            this.currentGear = 1;
-        }else if(this.currentGear >=0 && this.currentGear <this.maxGears){
-            this.currentGear ++;
-        }
+        this.pendingControls.push({type: 'upshift', servo: 'esc', value: 0});
     }
 
     downShift() {
-        if(this.currentGear == this.neutralGear){
-            this.currentGear = this.reverseGear;
-        }else if(this.currentGear == 1){
-            this.currentGear = this.neutralGear;
-        }
-        else if(this.currentGear > 1 && this.currentGear <= this.maxGears){
-            this.currentGear--;
-        }
+        this.pendingControls.push({type: 'downshift', servo: 'esc', value: 0});
     }
 
     getCommand() {
@@ -210,7 +221,70 @@ class KeyPressTracker {
             this.downShiftPress = false;
         }
 
-        command[1] = this.currentGear;
+        command[1] = this.holdGear;
+
+        //Cruise, c latches the current throttle or drops out, = and - nudge it. Braking with s also drops out
+        if(this.pressedKeys['c'] === true) {
+            command[6] = this.cruiseToggle;
+        }else if(this.pressedKeys['='] === true) {
+            command[6] = this.cruiseUp;
+        }else if(this.pressedKeys['-'] === true) {
+            command[6] = this.cruiseDown;
+        }else{
+            command[6] = this.cruiseNone;
+        }
+
+        //Cycle steering rates, the car wraps the index around its configured rates
+        if(this.pressedKeys['r'] && this.ratePress == false){ //new press
+            this.ratePress = true;
+            this.steerRate++;
+            this.pendingControls.push({type: 'rate', servo: 'steer', value: this.steerRate});
+        }else if (!this.pressedKeys['r'] && this.ratePress == true){
+            this.ratePress = false;
+        }
+
+        //Toggle steering expo/curve
+        if(this.pressedKeys['x'] && this.curvePress == false){ //new press
+            this.curvePress = true;
+            this.steerCurve = !this.steerCurve;
+            this.pendingControls.push({type: 'curve', servo: 'steer', value: this.steerCurve ? 1 : 0});
+        }else if (!this.pressedKeys['x'] && this.curvePress == true){
+            this.curvePress = false;
+        }
+
+        //Toggle the steering gyro and step its gain
+        if(this.pressedKeys['g'] && this.gyroPress == false){ //new press
+            this.gyroPress = true;
+            this.gyroEnabled = !this.gyroEnabled;
+            this.pendingControls.push({type: 'gyro', servo: 'steer', value: this.gyroEnabled ? 1 : 0});
+        }else if (!this.pressedKeys['g'] && this.gyroPress == true){
+            this.gyroPress = false;
+        }
+
+        if(this.pressedKeys['k'] && this.gyroGainDownPress == false){ //new press
+            this.gyroGainDownPress = true;
+            this.gyroGain = Math.max(0, this.gyroGain - 10);
+            this.pendingControls.push({type: 'gyro_gain', servo: 'steer', value: this.gyroGain});
+        }else if (!this.pressedKeys['k'] && this.gyroGainDownPress == true){
+            this.gyroGainDownPress = false;
+        }
+
+        if(this.pressedKeys['l'] && this.gyroGainUpPress == false){ //new press
+            this.gyroGainUpPress = true;
+            this.gyroGain = Math.min(100, this.gyroGain + 10);
+            this.pendingControls.push({type: 'gyro_gain', servo: 'steer', value: this.gyroGain});
+        }else if (!this.pressedKeys['l'] && this.gyroGainUpPress == true){
+            this.gyroGainUpPress = false;
+        }
+
+        //Toggle collision braking
+        if(this.pressedKeys['o'] && this.collisionPress == false){ //new press
+            this.collisionPress = true;
+            this.collisionEnabled = !this.collisionEnabled;
+            this.pendingControls.push({type: 'collision', value: this.collisionEnabled ? 1 : 0});
+        }else if (!this.pressedKeys['o'] && this.collisionPress == true){
+            this.collisionPress = false;
+        }
 
         //steering trim
         if(this.pressedKeys[','] && this.leftTrimPress == false){ //new press
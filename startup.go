@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/Speshl/goremotecontrol_web/internal/carcam"
 	"github.com/Speshl/goremotecontrol_web/internal/carcommand"
 	"github.com/Speshl/goremotecontrol_web/internal/carmic"
 	"github.com/Speshl/goremotecontrol_web/internal/carspeaker"
+	"github.com/Speshl/goremotecontrol_web/internal/sensors"
 	"github.com/Speshl/goremotecontrol_web/internal/server"
 )
 
@@ -44,7 +48,7 @@ func (a *App) StartMic() (*carmic.CarMic, error) {
 func (a *App) StartCam() (*carcam.CarCam, error) {
 	carCam, err := carcam.NewCarCam(a.config.CamConfig)
 	if err != nil {
-		fmt.Errorf("error creating carcam: %w\n", err)
+		return nil, fmt.Errorf("error creating carcam: %w\n", err)
 	}
 
 	go func() {
@@ -60,29 +64,93 @@ func (a *App) StartCam() (*carcam.CarCam, error) {
 
 func (a *App) StartCommand() *carcommand.CarCommand {
 	carCommand := carcommand.NewCarCommand(a.config.CommandConfig)
+	if a.config.IMUConfig.Enabled() { //Made here so steering gyros have it before servos are made, StartSensors starts it
+		a.imu = sensors.NewIMUMonitor(a.config.IMUConfig, carCommand.LimitChannel, carCommand.EventChannel, a.speaker.MemeSoundChannel, a.sensorReadings)
+		carCommand.SetYawRateSource(a.imu)
+	}
+	if a.config.WheelSpeedConfig.Enabled() { //Same for escs shifting and holding cruise speed on it
+		a.wheelSpeed = sensors.NewWheelSpeedMonitor(a.config.WheelSpeedConfig, a.sensorReadings)
+		err := carCommand.SetSpeedSource(a.config.WheelSpeedConfig.Servo, a.wheelSpeed)
+		if err != nil {
+			log.Printf("warning: wheel speed not given to %s - %s\n", a.config.WheelSpeedConfig.Servo, err.Error())
+		}
+	}
+	for _, distanceCfg := range a.config.DistanceConfigs { //Collision braking reads these each command
+		distance := sensors.NewDistanceMonitor(distanceCfg, a.sensorReadings)
+		err := carCommand.SetDistanceSource(distanceCfg.Side, distance)
+		if err != nil {
+			log.Printf("warning: %s distance not used - %s\n", distanceCfg.Side, err.Error())
+			continue
+		}
+		a.distances = append(a.distances, distance)
+	}
 	go func() {
 		err := carCommand.Start(a.ctx)
 		if err != nil {
 			log.Printf("carcommand error: %s\n", err.Error())
 		}
-		a.cancel() //stop anything else on this context because the gpio output stopped
-		log.Println("Stopping due to carcommand stopping unexpectedly")
+		//Bus errors and loop failures are handled inside carcommand, so this is shutdown or a broken config
+		//Clients were sent an actuators offline event, video, audio and signaling keep running
+		log.Println("carcommand stopped, car can no longer be driven")
 	}()
 
 	return carCommand
 }
 
+// Sensors send their limits straight to carcommand, a sensor stopping never stops the car
+func (a *App) StartSensors() {
+	if a.config.BatteryConfig.Enabled() {
+		battery := sensors.NewBatteryMonitor(a.config.BatteryConfig, a.command.LimitChannel, a.sensorReadings)
+		go func() {
+			err := battery.Start(a.ctx)
+			if err != nil {
+				log.Printf("battery monitor error: %s\n", err.Error())
+			}
+		}()
+	}
+	if a.imu != nil {
+		go func() {
+			err := a.imu.Start(a.ctx)
+			if err != nil {
+				log.Printf("imu monitor error: %s\n", err.Error())
+			}
+		}()
+	}
+	if a.wheelSpeed != nil {
+		go func() {
+			err := a.wheelSpeed.Start(a.ctx)
+			if err != nil {
+				log.Printf("wheel speed monitor error: %s\n", err.Error())
+			}
+		}()
+	}
+	for _, distance := range a.distances {
+		go func(distance *sensors.DistanceMonitor) {
+			err := distance.Start(a.ctx)
+			if err != nil {
+				log.Printf("distance monitor error: %s\n", err.Error())
+			}
+		}(distance)
+	}
+}
+
 func (a *App) StartSocketServer() *server.Server {
 	socketServer := server.NewSocketServer(
 		a.config.SocketServerConfig,
 		a.mic.AudioTrack,
 		a.cam.VideoTrack,
 		a.command.CommandChannel,
+		a.command.ControlChannel,
+		a.command.CalibrationChannel,
+		a.command.RecordingChannel,
+		a.command.EventChannel,
 		a.speaker.MemeSoundChannel,
 		a.speaker.TrackPlayer,
 	)
 	socketServer.RegisterHTTPHandlers()
 	socketServer.RegisterSocketIOHandlers()
+	go socketServer.ForwardEvents(a.ctx)
+	go socketServer.ForwardSensorReadings(a.ctx, a.sensorReadings)
 
 	go func() {
 		log.Println("Start serving socketio...")
@@ -108,3 +176,23 @@ func (a *App) StartHTTPServer() {
 		log.Println("Stopping due to http server stopping unexpectedly")
 	}()
 }
+
+// SIGUSR1 latches the estop so a local button or kill -USR1 can stop the car, clearing it still needs an admin
+func (a *App) StartEstopSignal() {
+	estopSignal := make(chan os.Signal, 1)
+	signal.Notify(estopSignal, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(estopSignal)
+		for {
+			select {
+			case <-a.ctx.Done():
+				return
+			case <-estopSignal:
+				err := a.socketServer.Estop("local signal")
+				if err != nil {
+					log.Printf("warning: %s\n", err.Error())
+				}
+			}
+		}
+	}()
+}